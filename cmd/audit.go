@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"payment-gateway/db"
+	"strconv"
+	"strings"
+)
+
+// Usage: payment-gateway verify-audit [--head id:hash]
+//
+// Walks the audit log from the first entry and checks every entry links to the one before and matches its hash.
+// Prints the last entry's ID and hash, keep them somewhere else and pass them as --head next time to also catch
+// entries removed from the end.
+func runVerifyAudit(args []string) int {
+	flags := flag.NewFlagSet("verify-audit", flag.ContinueOnError)
+	head := flags.String("head", "", "the id:hash of an entry printed by an earlier run, which must still be in the log")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: payment-gateway verify-audit [--head id:hash]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	var headID int
+	var headHash string
+	if *head != "" {
+		id, hash, ok := strings.Cut(*head, ":")
+		n, err := strconv.Atoi(id)
+		if !ok || err != nil || n < 1 || hash == "" {
+			flags.Usage()
+			return 2
+		}
+		headID, headHash = n, hash
+	}
+
+	_db, err := openDB()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	defer _db.Close()
+	audit := db.NewPostgresStore(_db).AuditLog()
+	ctx := context.Background()
+
+	verifier := &db.AuditVerifier{}
+	headFound := headID == 0
+	for {
+		last, _ := verifier.Head()
+		entries, err := audit.GetAuditEntries(ctx, db.AuditFilter{AfterID: last, Limit: 1000})
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		if len(entries) == 0 {
+			break
+		}
+		for _, entry := range entries {
+			if err := verifier.Check(entry); err != nil {
+				fmt.Printf("The audit log was tampered with: %v\n", err)
+				return 1
+			}
+			if entry.ID == headID {
+				if entry.Hash != headHash {
+					fmt.Printf("The audit log was tampered with: entry %d is not the one noted, its hash is %s\n", headID, entry.Hash)
+					return 1
+				}
+				headFound = true
+			}
+		}
+	}
+
+	id, hash := verifier.Head()
+	if !headFound {
+		fmt.Printf("The audit log was tampered with: it ends at entry %d, before the noted entry %d\n", id, headID)
+		return 1
+	}
+	if id == 0 {
+		fmt.Println("The audit log is empty.")
+		return 0
+	}
+	fmt.Printf("%d audit entries verified, the last is %d:%s\n", id, id, hash)
+	return 0
+}
@@ -1,34 +1,328 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"payment-gateway/db"
 	"payment-gateway/internal/api"
-	_ "payment-gateway/internal/services"
+	"payment-gateway/internal/config"
+	"payment-gateway/internal/kafka"
+	"payment-gateway/internal/logging"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/notify"
+	"payment-gateway/internal/publisher"
+	"payment-gateway/internal/ratelimit"
+	"payment-gateway/internal/services"
+	"payment-gateway/internal/tracing"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
 )
 
+// Opens the database described by the configuration from the file and environment. Used by the subcommands,
+// which only validate the database settings.
+func openDB() (*sql.DB, error) {
+	cfg, err := config.Load(nil, os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Database.Validate(); err != nil {
+		return nil, err
+	}
+	return db.Open(cfg.Database.URL(), connectPolicy(cfg))
+}
+
+// the database is retried with the usual backoff while it starts up
+func connectPolicy(cfg config.Config) services.RetryPolicy {
+	return services.RetryPolicy{Attempts: cfg.Database.ConnectAttempts, Backoff: cfg.Retry.Backoff}
+}
+
+func newPublisher(cfg config.PublisherConfig, logger *logging.Logger) (publisher.Publisher, error) {
+	switch cfg.Type {
+	case "kafka":
+		return kafka.NewPublisher(cfg.KafkaBrokerURL, cfg.KafkaBatchTimeout, logger), nil
+	case "file":
+		log.Printf("Publishing transactions to %s\n", cfg.File)
+		return publisher.NewFile(cfg.File)
+	default:
+		return nil, fmt.Errorf("unknown publisher %q, expected kafka or file", cfg.Type)
+	}
+}
+
+// the webhook hands the codes to the service of each merchant reaching its users, the log is for local development.
+// None is needed without confirmations.
+func newNotifier(cfg config.WithdrawalConfirmationConfig, store db.Store, cipher services.Cipher) notify.Notifier {
+	switch {
+	case !cfg.Enabled:
+		return nil
+	case cfg.Notifier == "log":
+		log.Println("Withdrawal confirmation codes are written to stderr, anyone reading it can confirm withdrawals")
+		return notify.NewLog(os.Stderr)
+	default:
+		return notify.NewWebhook(merchantEndpoints(store, cipher), 0)
+	}
+}
+
+// the webhooks set on the merchants, whose secrets are stored encrypted
+func merchantEndpoints(store db.Store, cipher services.Cipher) notify.Endpoints {
+	return func(ctx context.Context, merchantID int) (notify.Endpoint, error) {
+		merchant, err := store.Merchants().GetMerchant(ctx, merchantID)
+		if err != nil || merchant.WebhookSecret == "" {
+			return notify.Endpoint{URL: merchant.WebhookURL}, err
+		}
+		secret, err := cipher.Decrypt(merchant.WebhookSecret)
+		if err != nil {
+			return notify.Endpoint{}, fmt.Errorf("failed to decrypt the webhook secret: %v", err)
+		}
+		return notify.Endpoint{URL: merchant.WebhookURL, Secret: secret}, nil
+	}
+}
+
+// Redis shares the rate limit budgets between the instances, memory keeps them per instance
+func newLimiter(cfg config.RateLimitConfig) (ratelimit.Limiter, error) {
+	if cfg.Backend != "redis" {
+		return ratelimit.NewMemory(time.Now), nil
+	}
+	opts, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL: %v", err)
+	}
+	return ratelimit.NewRedis(redis.NewClient(opts), "ratelimit:", time.Now), nil
+}
+
+// the budgets were validated with the rest of the configuration
+func rateLimits(cfg config.RateLimitConfig) api.RateLimits {
+	limit := func(value string) ratelimit.Limit {
+		l, _ := ratelimit.ParseLimit(value)
+		return l
+	}
+	return api.RateLimits{
+		KeyReads:          limit(cfg.KeyReads),
+		KeyWrites:         limit(cfg.KeyWrites),
+		UserWrites:        limit(cfg.UserWrites),
+		IPReads:           limit(cfg.IPReads),
+		IPWrites:          limit(cfg.IPWrites),
+		TrustForwardedFor: cfg.TrustForwardedFor,
+	}
+}
+
+func apiConfig(cfg config.Config) api.Config {
+	return api.Config{
+		AdminTokens:        api.ParseAdminTokens(cfg.Security.AdminTokens),
+		AllowAnonymous:     cfg.Security.AllowAnonymous,
+		SignatureTolerance: cfg.Security.SignatureTolerance,
+		KeyRotationGrace:   cfg.Security.KeyRotationGrace,
+		Debtor: models.Beneficiary{
+			Name: cfg.ISO20022.DebtorName,
+			IBAN: cfg.ISO20022.DebtorIBAN,
+			BIC:  cfg.ISO20022.DebtorBIC,
+		},
+		RequestTimeout:      cfg.Server.RequestTimeout,
+		BatchRequestTimeout: cfg.Server.BatchRequestTimeout,
+		Retry:               services.RetryPolicy{Attempts: cfg.Retry.Attempts, Backoff: cfg.Retry.Backoff},
+		Breaker: services.BreakerSettings{
+			MaxRequests: cfg.Breaker.MaxRequests,
+			Interval:    cfg.Breaker.Interval,
+			Timeout:     cfg.Breaker.Timeout,
+		},
+		RateLimits:        rateLimits(cfg.RateLimit),
+		BatchWorkers:      cfg.Batch.Workers,
+		BatchPollInterval: cfg.Batch.PollInterval,
+		BatchRowTimeout:   cfg.Batch.RowTimeout,
+		StaleBatchJobAge:  cfg.Batch.StaleJobAge,
+
+		ConfirmWithdrawals:   cfg.WithdrawalConfirmation.Enabled,
+		ConfirmationTTL:      cfg.WithdrawalConfirmation.TTL,
+		ConfirmationAttempts: cfg.WithdrawalConfirmation.MaxAttempts,
+		DraftSweepInterval:   cfg.WithdrawalConfirmation.SweepInterval,
+	}
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "sync":
+			os.Exit(runSync(os.Args[2:]))
+		case "migrate":
+			os.Exit(runMigrate(os.Args[2:]))
+		case "verify-audit":
+			os.Exit(runVerifyAudit(os.Args[2:]))
+		}
+	}
+
+	cfg, err := config.Load(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("Could not load the configuration: %s\n", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalln(err)
+	}
+
+	// Every line is JSON from here on, including those of the standard logger
+	level, _ := logging.ParseLevel(cfg.Logging.Level)
+	logger := logging.New(os.Stderr, level, logging.DefaultPolicy())
+	log.SetFlags(0)
+	log.SetOutput(logger.StdWriter())
+	logger.Info(context.Background(), "Configuration loaded", "config", cfg.Redacted())
+
+	// The first SIGINT or SIGTERM starts a graceful shutdown, a second one kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Initialize the database connection
-	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
-	dbName := os.Getenv("DB_NAME")
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
+	_db, err := db.Open(cfg.Database.URL(), connectPolicy(cfg))
+	if err != nil {
+		log.Fatalf("Could not get the database: %s\n", err)
+	}
+
+	// Bring the schema up to date, instances starting together wait on each other
+	migrations, err := db.EmbeddedMigrations()
+	if err != nil {
+		log.Fatalf("Could not load migrations: %s\n", err)
+	}
+	if _, err := db.MigrateUp(ctx, _db, migrations); err != nil {
+		log.Fatalf("Could not migrate the database: %s\n", err)
+	}
+
+	tracer, stopTracing, err := tracing.NewProvider(ctx, cfg.Tracing)
+	if err != nil {
+		log.Fatalf("Could not set up tracing: %s\n", err)
+	}
+
+	pub, err := newPublisher(cfg.Publisher, logger)
+	if err != nil {
+		log.Fatalf("Could not create the publisher: %s\n", err)
+	}
+
+	cipher, err := services.NewAESCipherFromHex(cfg.Security.AESKey)
+	if err != nil {
+		log.Fatalf("Invalid AES key: %s\n", err)
+	}
+
+	limiter, err := newLimiter(cfg.RateLimit)
+	if err != nil {
+		log.Fatalf("Could not create the rate limiter: %s\n", err)
+	}
+
+	// Routing, limits and disabled gateways can change without a restart
+	runtime := config.NewLive(config.Runtime{}, "default")
+	if cfg.Runtime.File != "" {
+		rt, err := config.LoadRuntime(cfg.Runtime.File)
+		if err != nil {
+			log.Fatalf("Could not load the runtime config: %s\n", err)
+		}
+		runtime.Store(rt, cfg.Runtime.File)
+	}
 
-	dbURL := "postgres://" + dbUser + ":" + dbPassword + "@" + dbHost + ":" + dbPort + "/" + dbName + "?sslmode=disable"
+	server, err := api.NewServer(api.Options{
+		DB:         _db,
+		Publisher:  pub,
+		Cipher:     cipher,
+		Runtime:    runtime,
+		Migrations: migrations,
+		Logger:     logger,
+		Tracer:     tracer,
+		Limiter:    limiter,
+		Notifier:   newNotifier(cfg.WithdrawalConfirmation, db.NewPostgresStore(_db), cipher),
+		Config:     apiConfig(cfg),
+	})
+	if err != nil {
+		log.Fatalf("Could not create the server: %s\n", err)
+	}
+
+	if cfg.Runtime.File != "" {
+		if err := server.CheckRuntime(runtime.Current().Runtime); err != nil {
+			log.Fatalln(err)
+		}
+		log.Printf("Runtime config version %s loaded from %s\n", runtime.Current().Version, cfg.Runtime.File)
+
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		watcher := &config.Watcher{Path: cfg.Runtime.File, Interval: cfg.Runtime.PollInterval, Live: runtime, Check: server.CheckRuntime}
+		go watcher.Run(ctx, hup)
+	}
+
+	// Start the workers processing withdrawal batches and expiring unconfirmed withdrawals in the background. They
+	// get their own context so they keep going while the in-flight requests drain.
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	workersDone := server.Start(workersCtx)
+
+	// Start the server
+	httpServer := &http.Server{
+		Addr:         ":" + strconv.Itoa(cfg.Server.Port),
+		Handler:      server,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Starting server on %s...\n", httpServer.Addr)
+		serveErr <- httpServer.ListenAndServe()
+	}()
+
+	exitCode := 0
+	select {
+	case err := <-serveErr:
+		log.Printf("Could not start server: %s\n", err)
+		exitCode = 1
+	case <-ctx.Done():
+		stop()
+		log.Printf("Shutting down, readiness fails for %s before waiting up to %s for in-flight work...\n",
+			cfg.Server.ShutdownDelay, cfg.Server.ShutdownTimeout)
+		server.Drain()
+		time.Sleep(cfg.Server.ShutdownDelay)
+	}
+
+	if err := shutdown(httpServer, stopWorkers, workersDone, pub, _db, stopTracing, cfg.Server.ShutdownTimeout); err != nil {
+		log.Printf("Unclean shutdown: %s\n", err)
+		exitCode = 1
+	}
+	log.Println("Shutdown complete")
+	os.Exit(exitCode)
+}
 
-	db.InitializeDB(dbURL)
+// Stops in the reverse order of the dependencies: no new requests and the in-flight ones drained, then the
+// background workers, then the publisher flushing the messages it buffers, and the database as everything before uses it.
+// The spans of all of that are exported last. Every step is taken even when an earlier one fails or the deadline has
+// passed.
+func shutdown(httpServer *http.Server, stopWorkers context.CancelFunc, workersDone <-chan struct{},
+	pub publisher.Publisher, _db *sql.DB, stopTracing func(context.Context) error, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	// Set up the HTTP server and routes
-	router := api.SetupRouter()
+	var errs []error
+	if err := httpServer.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to drain requests: %v", err))
+	}
 
-	// Start the server on port 8080
-	log.Println("Starting server on port 8080...")
-	if err := http.ListenAndServe(":8080", router); err != nil {
-		log.Fatalf("Could not start server: %s\n", err)
+	stopWorkers()
+	select {
+	case <-workersDone:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("background workers did not stop in time"))
 	}
 
+	if err := pub.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to flush the publisher: %v", err))
+	}
+	if err := _db.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close the database: %v", err))
+	}
+	if err := stopTracing(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to export the remaining spans: %v", err))
+	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"payment-gateway/db"
+	"strconv"
+)
+
+// Usage: payment-gateway migrate [up | down [steps] | status]
+//
+// up is the default and is also run by the server when it starts.
+func runMigrate(args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: payment-gateway migrate [up | down [steps] | status]")
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	command := "up"
+	if flags.NArg() > 0 {
+		command = flags.Arg(0)
+	}
+	steps := 1
+	if command == "down" && flags.NArg() > 1 {
+		n, err := strconv.Atoi(flags.Arg(1))
+		if err != nil || n < 1 {
+			flags.Usage()
+			return 2
+		}
+		steps = n
+	}
+
+	migrations, err := db.EmbeddedMigrations()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	_db, err := openDB()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	defer _db.Close()
+	ctx := context.Background()
+
+	switch command {
+	case "up":
+		applied, err := db.MigrateUp(ctx, _db, migrations)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		fmt.Printf("%d migration(s) applied.\n", len(applied))
+	case "down":
+		reverted, err := db.MigrateDown(ctx, _db, migrations, steps)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		fmt.Printf("%d migration(s) reverted.\n", len(reverted))
+	case "status":
+		statuses, err := db.GetMigrationStatus(ctx, _db, migrations)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, applied)
+		}
+	default:
+		flags.Usage()
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"payment-gateway/internal/refdata"
+)
+
+// Usage: payment-gateway sync [--plan] [--prune] [--actor name] <file.yaml|file.json>
+//
+// Brings gateways, countries, currencies and their mappings in line with the file in one DB transaction.
+func runSync(args []string) int {
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	plan := flags.Bool("plan", false, "only print the changes that would be made")
+	prune := flags.Bool("prune", false, "remove gateways, countries, currencies and mappings that are not in the file")
+	actor := flags.String("actor", "sync", "name recorded in the audit log")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: payment-gateway sync [--plan] [--prune] [--actor name] <file.yaml|file.json>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	file, err := refdata.Load(flags.Arg(0))
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	_db, err := openDB()
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	defer _db.Close()
+
+	changes, err := refdata.Sync(context.Background(), _db, file, *prune, *plan, *actor)
+	if err != nil {
+		log.Printf("Sync failed, nothing was changed: %v\n", err)
+		return 1
+	}
+
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	switch {
+	case len(changes) == 0:
+		fmt.Println("Reference data is up to date.")
+	case *plan:
+		fmt.Printf("%d change(s) planned, run without --plan to apply them.\n", len(changes))
+	default:
+		fmt.Printf("%d change(s) applied.\n", len(changes))
+	}
+	return 0
+}
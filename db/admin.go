@@ -0,0 +1,243 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Reference data maintenance used by the admin API. Writes take an Execer so they can run inside the same DB
+// transaction as the audit entry that records them.
+
+var ErrNotFound = errors.New("not found")
+
+// true when err was caused by a unique constraint, e.g. a gateway name that is already taken
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// true when err was caused by a foreign key pointing at a row that doesn't exist
+func IsForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
+
+func execExpectingRow(db Execer, entity string, id int, query string, args ...interface{}) error {
+	res, err := db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update %s %d: %w", entity, id, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%s %d: %w", entity, id, ErrNotFound)
+	}
+	return nil
+}
+
+func GetGateway(ctx context.Context, db Execer, gatewayID int) (Gateway, error) {
+	var gateway Gateway
+	err := db.QueryRow(`SELECT id, name, data_format_supported, enabled, created_at, updated_at FROM gateways WHERE id = $1`, gatewayID).
+		Scan(&gateway.ID, &gateway.Name, &gateway.DataFormatSupported, &gateway.Enabled, &gateway.CreatedAt, &gateway.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return Gateway{}, fmt.Errorf("gateway %d: %w", gatewayID, ErrNotFound)
+	}
+	if err != nil {
+		return Gateway{}, fmt.Errorf("failed to get gateway %d: %v", gatewayID, err)
+	}
+
+	rows, err := db.Query(`SELECT data_format FROM gateway_data_formats WHERE gateway_id = $1 ORDER BY data_format`, gatewayID)
+	if err != nil {
+		return Gateway{}, fmt.Errorf("failed to get data formats for gateway %d: %v", gatewayID, err)
+	}
+	defer rows.Close()
+	formats := []string{gateway.DataFormatSupported}
+	for rows.Next() {
+		var format string
+		if err := rows.Scan(&format); err != nil {
+			return Gateway{}, fmt.Errorf("failed to scan data format: %v", err)
+		}
+		formats = append(formats, format)
+	}
+	gateway.DataFormats = dedupeFormats(formats)
+	return gateway, rows.Err()
+}
+
+// Updates the gateway's name and formats. The supported formats are replaced by gateway.DataFormats.
+func UpdateGateway(ctx context.Context, db Execer, gateway *Gateway) error {
+	err := execExpectingRow(db, "gateway", gateway.ID, `UPDATE gateways SET name = $1, data_format_supported = $2, updated_at = $3 WHERE id = $4`,
+		gateway.Name, gateway.DataFormatSupported, time.Now(), gateway.ID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`DELETE FROM gateway_data_formats WHERE gateway_id = $1`, gateway.ID); err != nil {
+		return fmt.Errorf("failed to clear data formats for gateway %d: %v", gateway.ID, err)
+	}
+	formats := dedupeFormats(append([]string{gateway.DataFormatSupported}, gateway.DataFormats...))
+	for _, format := range formats {
+		if _, err := db.Exec(`INSERT INTO gateway_data_formats (gateway_id, data_format) VALUES ($1, $2)`, gateway.ID, format); err != nil {
+			return fmt.Errorf("failed to insert gateway data format: %v", err)
+		}
+	}
+	gateway.DataFormats = formats
+	return nil
+}
+
+// Disabled gateways are no longer picked by routing. Transactions already sent to them are unaffected.
+func SetGatewayEnabled(ctx context.Context, db Execer, gatewayID int, enabled bool) error {
+	return execExpectingRow(db, "gateway", gatewayID, `UPDATE gateways SET enabled = $1, updated_at = $2 WHERE id = $3`, enabled, time.Now(), gatewayID)
+}
+
+func GetCountry(ctx context.Context, db Execer, countryID int) (Country, error) {
+	var country Country
+	err := db.QueryRow(`SELECT id, name, code, enabled, created_at, updated_at FROM countries WHERE id = $1`, countryID).
+		Scan(&country.ID, &country.Name, &country.Code, &country.Enabled, &country.CreatedAt, &country.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return Country{}, fmt.Errorf("country %d: %w", countryID, ErrNotFound)
+	}
+	if err != nil {
+		return Country{}, fmt.Errorf("failed to get country %d: %v", countryID, err)
+	}
+	return country, nil
+}
+
+func UpdateCountry(ctx context.Context, db Execer, country *Country) error {
+	return execExpectingRow(db, "country", country.ID, `UPDATE countries SET name = $1, code = $2, updated_at = $3 WHERE id = $4`,
+		country.Name, country.Code, time.Now(), country.ID)
+}
+
+// Users in a disabled country can't create transactions and no gateway is routed to for it
+func SetCountryEnabled(ctx context.Context, db Execer, countryID int, enabled bool) error {
+	return execExpectingRow(db, "country", countryID, `UPDATE countries SET enabled = $1, updated_at = $2 WHERE id = $3`, enabled, time.Now(), countryID)
+}
+
+func GetCurrency(ctx context.Context, db Execer, currencyID int) (Currency, error) {
+	var currency Currency
+	err := db.QueryRow(`SELECT id, symbol, enabled FROM currencies WHERE id = $1`, currencyID).Scan(&currency.ID, &currency.Symbol, &currency.Enabled)
+	if err == sql.ErrNoRows {
+		return Currency{}, fmt.Errorf("currency %d: %w", currencyID, ErrNotFound)
+	}
+	if err != nil {
+		return Currency{}, fmt.Errorf("failed to get currency %d: %v", currencyID, err)
+	}
+	return currency, nil
+}
+
+func UpdateCurrency(ctx context.Context, db Execer, currency *Currency) error {
+	return execExpectingRow(db, "currency", currency.ID, `UPDATE currencies SET symbol = $1 WHERE id = $2`, currency.Symbol, currency.ID)
+}
+
+func SetCurrencyEnabled(ctx context.Context, db Execer, currencyID int, enabled bool) error {
+	return execExpectingRow(db, "currency", currencyID, `UPDATE currencies SET enabled = $1 WHERE id = $2`, enabled, currencyID)
+}
+
+func GetGatewayCountries(ctx context.Context, db *sql.DB, gatewayID int) ([]Country, error) {
+	rows, err := db.QueryContext(ctx, `SELECT c.id, c.name, c.code, c.enabled, c.created_at, c.updated_at
+		FROM countries c JOIN gateway_countries gc ON c.id = gc.country_id WHERE gc.gateway_id = $1 ORDER BY c.id`, gatewayID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch countries for gateway %d: %v", gatewayID, err)
+	}
+	defer rows.Close()
+
+	var countries []Country
+	for rows.Next() {
+		var country Country
+		if err := rows.Scan(&country.ID, &country.Name, &country.Code, &country.Enabled, &country.CreatedAt, &country.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan country: %v", err)
+		}
+		countries = append(countries, country)
+	}
+	return countries, rows.Err()
+}
+
+func AddGatewayCountry(ctx context.Context, db Execer, gatewayID, countryID int) error {
+	if _, err := db.Exec(`INSERT INTO gateway_countries (gateway_id, country_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, gatewayID, countryID); err != nil {
+		return fmt.Errorf("failed to map gateway %d to country %d: %w", gatewayID, countryID, err)
+	}
+	return nil
+}
+
+func RemoveGatewayCountry(ctx context.Context, db Execer, gatewayID, countryID int) error {
+	res, err := db.Exec(`DELETE FROM gateway_countries WHERE gateway_id = $1 AND country_id = $2`, gatewayID, countryID)
+	if err != nil {
+		return fmt.Errorf("failed to unmap gateway %d from country %d: %v", gatewayID, countryID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("gateway %d is not mapped to country %d: %w", gatewayID, countryID, ErrNotFound)
+	}
+	return nil
+}
+
+func AddCountryCurrency(ctx context.Context, db Execer, countryID, currencyID int) error {
+	if _, err := db.Exec(`INSERT INTO country_currency (country_id, currency_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, countryID, currencyID); err != nil {
+		return fmt.Errorf("failed to map country %d to currency %d: %w", countryID, currencyID, err)
+	}
+	return nil
+}
+
+func RemoveCountryCurrency(ctx context.Context, db Execer, countryID, currencyID int) error {
+	res, err := db.Exec(`DELETE FROM country_currency WHERE country_id = $1 AND currency_id = $2`, countryID, currencyID)
+	if err != nil {
+		return fmt.Errorf("failed to unmap country %d from currency %d: %v", countryID, currencyID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("country %d is not mapped to currency %d: %w", countryID, currencyID, ErrNotFound)
+	}
+	return nil
+}
+
+// Deleting a gateway removes its country mappings and data formats. Transactions keep the gateway's ID.
+func DeleteGateway(ctx context.Context, db Execer, gatewayID int) error {
+	return deleteExpectingRow(db, "gateway", gatewayID, `DELETE FROM gateways WHERE id = $1`)
+}
+
+func DeleteCountry(ctx context.Context, db Execer, countryID int) error {
+	return deleteExpectingRow(db, "country", countryID, `DELETE FROM countries WHERE id = $1`)
+}
+
+func DeleteCurrency(ctx context.Context, db Execer, currencyID int) error {
+	return deleteExpectingRow(db, "currency", currencyID, `DELETE FROM currencies WHERE id = $1`)
+}
+
+func deleteExpectingRow(db Execer, entity string, id int, query string) error {
+	res, err := db.Exec(query, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete %s %d: %w", entity, id, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%s %d: %w", entity, id, ErrNotFound)
+	}
+	return nil
+}
+
+// Returns the IDs of the countries each gateway is mapped to, keyed by gateway ID
+func GetGatewayCountryMappings(ctx context.Context, db Execer) (map[int][]int, error) {
+	return getMappings(db, `SELECT gateway_id, country_id FROM gateway_countries ORDER BY gateway_id, country_id`)
+}
+
+// Returns the IDs of the currencies each country is mapped to, keyed by country ID
+func GetCountryCurrencyMappings(ctx context.Context, db Execer) (map[int][]int, error) {
+	return getMappings(db, `SELECT country_id, currency_id FROM country_currency ORDER BY country_id, currency_id`)
+}
+
+func getMappings(db Execer, query string) (map[int][]int, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch mappings: %v", err)
+	}
+	defer rows.Close()
+
+	mappings := map[int][]int{}
+	for rows.Next() {
+		var from, to int
+		if err := rows.Scan(&from, &to); err != nil {
+			return nil, fmt.Errorf("failed to scan mapping: %v", err)
+		}
+		mappings[from] = append(mappings[from], to)
+	}
+	return mappings, rows.Err()
+}
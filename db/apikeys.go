@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// A merchant's credential for the payment API. The secret itself is never stored, only its SHA-256 hash.
+type APIKey struct {
+	ID string
+	// the merchant the key acts for, 0 for operator keys administering the gateway or settling transactions
+	MerchantID int
+	Name       string
+	// what the key may do, e.g. deposit:create or admin
+	Scopes []string
+	// what an operator key may do in the admin API, e.g. viewer or finance
+	Roles      []string
+	SecretHash string
+	// set for keys that sign their requests instead of presenting the secret, encrypted with the service's cipher
+	SigningSecret string
+	CreatedAt     time.Time
+	// the key is refused from then on. Rotating a key revokes it in the future so clients have time to switch.
+	RevokedAt *time.Time
+}
+
+// true when the key is accepted at now
+func (k APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil || now.Before(*k.RevokedAt)
+}
+
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, key *APIKey) error
+	// wraps ErrNotFound when there is no such key
+	GetAPIKey(ctx context.Context, keyID string) (APIKey, error)
+	// every key including revoked ones, oldest first
+	GetAPIKeys(ctx context.Context) ([]APIKey, error)
+	// Refuses the key from at on, or keeps the earlier time it was already revoked for. Wraps ErrNotFound when
+	// there is no such key.
+	RevokeAPIKey(ctx context.Context, keyID string, at time.Time) error
+}
+
+const apiKeyColumns = `id, COALESCE(merchant_id, 0), name, scopes, roles, secret_hash, signing_secret, created_at, revoked_at`
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var key APIKey
+	var signingSecret sql.NullString
+	var revokedAt sql.NullTime
+	if err := row.Scan(&key.ID, &key.MerchantID, &key.Name, pq.Array(&key.Scopes), pq.Array(&key.Roles), &key.SecretHash, &signingSecret, &key.CreatedAt, &revokedAt); err != nil {
+		return APIKey{}, err
+	}
+	key.SigningSecret = signingSecret.String
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return key, nil
+}
+
+func CreateAPIKey(ctx context.Context, db Queryer, key *APIKey) error {
+	// neither array may be NULL, operator keys can have no scopes and merchant keys have no roles
+	scopes, roles := append([]string{}, key.Scopes...), append([]string{}, key.Roles...)
+	err := db.QueryRowContext(ctx, `INSERT INTO api_keys (id, merchant_id, name, scopes, roles, secret_hash, signing_secret, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING created_at`,
+		key.ID, nullInt(key.MerchantID), key.Name, pq.Array(scopes), pq.Array(roles), key.SecretHash, nullString(key.SigningSecret), time.Now()).Scan(&key.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert API key: %w", err)
+	}
+	return nil
+}
+
+func GetAPIKey(ctx context.Context, db Queryer, keyID string) (APIKey, error) {
+	key, err := scanAPIKey(db.QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = $1`, keyID))
+	if err == sql.ErrNoRows {
+		return APIKey{}, fmt.Errorf("API key %s: %w", keyID, ErrNotFound)
+	}
+	if err != nil {
+		return APIKey{}, fmt.Errorf("failed to get API key %s: %v", keyID, err)
+	}
+	return key, nil
+}
+
+func GetAPIKeys(ctx context.Context, db Queryer) ([]APIKey, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys ORDER BY created_at, id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch API keys: %v", err)
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %v", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func RevokeAPIKey(ctx context.Context, db Queryer, keyID string, at time.Time) error {
+	res, err := db.ExecContext(ctx, `UPDATE api_keys SET revoked_at = LEAST(revoked_at, $1) WHERE id = $2`, at, keyID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key %s: %v", keyID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("API key %s: %w", keyID, ErrNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The audit log records who did what to which entity through the API, the admin API and the sync command. Entries
+// are only ever appended and form a hash chain: each carries the hash of the entry before it and a hash over its own
+// fields and that previous hash. Changing, removing or reordering an entry breaks the chain from there on, which
+// AuditVerifier detects. Postgres refuses updates and deletes of the table on top of that.
+
+type AuditEntry struct {
+	// position in the chain, from 1 without gaps
+	ID    int
+	Actor string
+	// the roles the actor acted in, none for actions outside the admin API
+	Roles    []string
+	Action   string
+	Entity   string
+	EntityID string
+	// the ID of the request the action was taken in, empty outside a request
+	RequestID string
+	// the fields of the entity that changed, as they were and as they became. Before is empty for creations.
+	Before    json.RawMessage
+	After     json.RawMessage
+	CreatedAt time.Time
+	// the hash of the entry before, empty for the first
+	PrevHash string
+	Hash     string
+}
+
+type AuditFilter struct {
+	Actor     string
+	Action    string
+	Entity    string
+	EntityID  string
+	RequestID string
+	// only entries with a greater ID, to page through the log
+	AfterID int
+	// at most this many entries, all of them when 0
+	Limit int
+}
+
+type AuditRepository interface {
+	// Appends the entry after the last one, setting its ID, time and hashes. Call it with the transaction of the
+	// change it records so both commit together.
+	AppendAuditEntry(ctx context.Context, entry *AuditEntry) error
+	// the entries matching filter ordered by ID
+	GetAuditEntries(ctx context.Context, filter AuditFilter) ([]AuditEntry, error)
+}
+
+// Marshals v for Before or After, nil stays empty
+func AuditJSON(v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode audit entry: %v", err)
+	}
+	return raw, nil
+}
+
+// Makes entry the one following prev at time at. The time is kept to the microsecond like Postgres does, and the
+// JSON is compacted, so the entry hashes the same once read back.
+func (e *AuditEntry) Link(prev AuditEntry, at time.Time) error {
+	for _, raw := range []*json.RawMessage{&e.Before, &e.After} {
+		if len(*raw) == 0 {
+			*raw = nil
+			continue
+		}
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, *raw); err != nil {
+			return fmt.Errorf("failed to encode audit entry: %v", err)
+		}
+		*raw = compacted.Bytes()
+	}
+	if e.Roles == nil {
+		e.Roles = []string{}
+	}
+	e.ID = prev.ID + 1
+	e.PrevHash = prev.Hash
+	e.CreatedAt = at.UTC().Truncate(time.Microsecond)
+	e.Hash = e.ComputeHash()
+	return nil
+}
+
+// The SHA-256 of the entry's fields and the previous hash, hex encoded. Hash itself isn't part of it.
+func (e AuditEntry) ComputeHash() string {
+	roles := e.Roles
+	if roles == nil {
+		roles = []string{}
+	}
+	fields, err := json.Marshal([]interface{}{
+		e.ID, e.Actor, roles, e.Action, e.Entity, e.EntityID, e.RequestID,
+		rawOrNull(e.Before), rawOrNull(e.After), e.CreatedAt.UTC().Format(time.RFC3339Nano), e.PrevHash,
+	})
+	if err != nil {
+		// only JSON that was altered into something invalid gets here, and it can't match any hash
+		return ""
+	}
+	sum := sha256.Sum256(fields)
+	return hex.EncodeToString(sum[:])
+}
+
+func rawOrNull(raw json.RawMessage) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	return raw
+}
+
+// An entry that doesn't belong where it is in the chain
+type AuditChainError struct {
+	ID     int
+	Reason string
+}
+
+func (e *AuditChainError) Error() string {
+	return fmt.Sprintf("audit entry %d: %s", e.ID, e.Reason)
+}
+
+// Checks the chain one entry at a time, in ID order from the first entry
+type AuditVerifier struct {
+	last AuditEntry
+}
+
+func (v *AuditVerifier) Check(entry AuditEntry) error {
+	switch {
+	case entry.ID != v.last.ID+1:
+		return &AuditChainError{ID: entry.ID, Reason: fmt.Sprintf("follows entry %d, entries are missing", v.last.ID)}
+	case entry.PrevHash != v.last.Hash:
+		return &AuditChainError{ID: entry.ID, Reason: fmt.Sprintf("does not link to entry %d", v.last.ID)}
+	case entry.ComputeHash() != entry.Hash:
+		return &AuditChainError{ID: entry.ID, Reason: "does not match its hash, it was altered"}
+	}
+	v.last = entry
+	return nil
+}
+
+// The ID and hash of the last entry checked. Comparing them with ones noted earlier shows whether entries were
+// removed from the end, which the chain alone can't.
+func (v *AuditVerifier) Head() (int, string) {
+	return v.last.ID, v.last.Hash
+}
+
+const auditColumns = `id, actor, roles, action, entity, entity_id, request_id, before, after, created_at, prev_hash, hash`
+
+// Has to run in a DB transaction. Appenders wait for each other on the table lock so each links to the one before,
+// readers aren't blocked.
+func AppendAuditEntry(ctx context.Context, db Queryer, entry *AuditEntry) error {
+	if _, err := db.ExecContext(ctx, `LOCK TABLE audit_log IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return fmt.Errorf("failed to lock audit log: %v", err)
+	}
+	var prev AuditEntry
+	err := db.QueryRowContext(ctx, `SELECT id, hash FROM audit_log ORDER BY id DESC LIMIT 1`).Scan(&prev.ID, &prev.Hash)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to get last audit entry: %v", err)
+	}
+	if err := entry.Link(prev, time.Now()); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO audit_log (` + auditColumns + `) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+	_, err = db.ExecContext(ctx, query, entry.ID, entry.Actor, pq.Array(entry.Roles), entry.Action, entry.Entity, entry.EntityID,
+		entry.RequestID, nullJSON(entry.Before), nullJSON(entry.After), entry.CreatedAt, entry.PrevHash, entry.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to insert audit entry: %v", err)
+	}
+	return nil
+}
+
+func nullJSON(raw json.RawMessage) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
+
+func GetAuditEntries(ctx context.Context, db Queryer, filter AuditFilter) ([]AuditEntry, error) {
+	var conditions []string
+	var args []interface{}
+	where := func(column string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	for _, c := range []struct{ column, value string }{
+		{"actor", filter.Actor}, {"action", filter.Action}, {"entity", filter.Entity},
+		{"entity_id", filter.EntityID}, {"request_id", filter.RequestID},
+	} {
+		if c.value != "" {
+			where(c.column, c.value)
+		}
+	}
+	args = append(args, filter.AfterID)
+	conditions = append(conditions, fmt.Sprintf("id > $%d", len(args)))
+
+	query := `SELECT ` + auditColumns + ` FROM audit_log WHERE ` + strings.Join(conditions, " AND ") + ` ORDER BY id`
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch audit entries: %v", err)
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		var before, after []byte
+		err := rows.Scan(&entry.ID, &entry.Actor, pq.Array(&entry.Roles), &entry.Action, &entry.Entity, &entry.EntityID,
+			&entry.RequestID, &before, &after, &entry.CreatedAt, &entry.PrevHash, &entry.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %v", err)
+		}
+		entry.Before, entry.After = before, after
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
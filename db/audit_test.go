@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestAuditVerifierDetectsTampering(t *testing.T) {
+	var entries []AuditEntry
+	var prev AuditEntry
+	for _, action := range []string{"create", "update", "update"} {
+		entry := AuditEntry{Actor: "key_1", Action: action, Entity: "transaction", EntityID: "1", After: json.RawMessage(`{"status": "SENT"}`)}
+		if err := entry.Link(prev, time.Date(2024, 3, 1, 10, 0, 0, 123456789, time.UTC)); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+		prev = entry
+	}
+
+	check := func(entries []AuditEntry) error {
+		verifier := &AuditVerifier{}
+		for _, entry := range entries {
+			if err := verifier.Check(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := check(entries); err != nil {
+		t.Fatalf("Expected the chain to hold, got %v", err)
+	}
+
+	altered := append([]AuditEntry(nil), entries...)
+	altered[1].After = json.RawMessage(`{"status":"FAILED"}`)
+	removed := []AuditEntry{entries[0], entries[2]}
+	// rehashing the altered entry doesn't help, the next one links to the original
+	rehashed := append([]AuditEntry(nil), altered...)
+	rehashed[1].Hash = rehashed[1].ComputeHash()
+	for name, c := range map[string]struct {
+		entries []AuditEntry
+		id      int
+	}{"altered": {altered, 2}, "removed": {removed, 3}, "rehashed": {rehashed, 3}} {
+		err := check(c.entries)
+		if chainErr, ok := err.(*AuditChainError); !ok || chainErr.ID != c.id {
+			t.Errorf("%s: expected entry %d to break the chain, got %v", name, c.id, err)
+		}
+	}
+}
+
+func TestAuditLogIsAppendOnly(t *testing.T) {
+	ctx := context.Background()
+	store := NewPostgresStore(db)
+	entry := AuditEntry{Actor: "test", Action: "create", Entity: "transaction", EntityID: "1"}
+	if err := store.AppendAuditEntry(ctx, &entry); err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{
+		`UPDATE audit_log SET actor = 'someone else' WHERE id = $1`,
+		`DELETE FROM audit_log WHERE id = $1`,
+	} {
+		if _, err := db.Exec(query, entry.ID); err == nil {
+			t.Errorf("Expected %q to be refused", query)
+		}
+	}
+	if _, err := db.Exec(`TRUNCATE audit_log`); err == nil {
+		t.Errorf("Expected truncating the audit log to be refused")
+	}
+}
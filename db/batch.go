@@ -0,0 +1,246 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type BatchJobStatus string
+type BatchRowStatus string
+
+const (
+	BATCH_PENDING    BatchJobStatus = "PENDING"
+	BATCH_PROCESSING BatchJobStatus = "PROCESSING"
+	BATCH_COMPLETED  BatchJobStatus = "COMPLETED"
+)
+
+const (
+	ROW_PENDING   BatchRowStatus = "PENDING"
+	ROW_SUCCEEDED BatchRowStatus = "SUCCEEDED"
+	ROW_REJECTED  BatchRowStatus = "REJECTED" // failed validation or could not be parsed
+	ROW_FAILED    BatchRowStatus = "FAILED"   // valid but the transaction could not be created
+)
+
+type BatchJob struct {
+	ID            int
+	MerchantID    int
+	Type          TransactionType
+	Status        BatchJobStatus
+	ClientFormat  string
+	TotalRows     int
+	ProcessedRows int
+	SucceededRows int
+	FailedRows    int
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	CompletedAt   *time.Time
+	// the API key the batch was uploaded with, its transactions are recorded as created by it too
+	CreatedBy string
+}
+
+type BatchJobRow struct {
+	BatchJobID         int
+	RowNumber          int
+	Amount             decimal.NullDecimal
+	UserID             int
+	Currency           string
+	BeneficiaryName    string
+	BeneficiaryIBAN    string
+	BeneficiaryBIC     string
+	BeneficiaryCountry string
+	Status             BatchRowStatus
+	Error              string
+	TransactionID      int
+}
+
+// Creates the job and its rows. Rows that are already REJECTED, e.g. because they could not be parsed, are
+// counted as processed straight away.
+func CreateBatchJob(ctx context.Context, db Execer, job *BatchJob, rows []BatchJobRow) error {
+	rejected := 0
+	for _, row := range rows {
+		if row.Status == ROW_REJECTED {
+			rejected++
+		}
+	}
+
+	job.Status = BATCH_PENDING
+	job.TotalRows = len(rows)
+	job.ProcessedRows = rejected
+	job.FailedRows = rejected
+
+	query := `INSERT INTO batch_jobs (merchant_id, type, status, client_format, total_rows, processed_rows, failed_rows, created_at, updated_at, created_by)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id, created_at, updated_at`
+
+	now := time.Now()
+	err := db.QueryRow(query, job.MerchantID, job.Type, job.Status, job.ClientFormat, job.TotalRows, job.ProcessedRows, job.FailedRows, now, now, nullString(job.CreatedBy)).Scan(&job.ID, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert batch job: %v", err)
+	}
+
+	for i := range rows {
+		row := &rows[i]
+		row.BatchJobID = job.ID
+		if row.Status == "" {
+			row.Status = ROW_PENDING
+		}
+		_, err := db.Exec(`INSERT INTO batch_job_rows (batch_job_id, row_number, amount, user_id, currency, beneficiary_name, beneficiary_iban, beneficiary_bic, beneficiary_country, status, error)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+			row.BatchJobID, row.RowNumber, row.Amount, nullInt(row.UserID), nullString(row.Currency), nullString(row.BeneficiaryName), nullString(row.BeneficiaryIBAN), nullString(row.BeneficiaryBIC), nullString(row.BeneficiaryCountry), row.Status, nullString(row.Error))
+		if err != nil {
+			return fmt.Errorf("failed to insert batch job row %d: %v", row.RowNumber, err)
+		}
+	}
+	return nil
+}
+
+// Claims the oldest pending batch job for processing. SKIP LOCKED lets several instances claim jobs at the same
+// time without picking the same one. Returns false when there is nothing to do.
+func ClaimPendingBatchJob(ctx context.Context, db *sql.DB) (BatchJob, bool, error) {
+	row := db.QueryRowContext(ctx, `UPDATE batch_jobs SET status = $1, updated_at = $2
+		WHERE id = (SELECT id FROM batch_jobs WHERE status = $3 ORDER BY id FOR UPDATE SKIP LOCKED LIMIT 1)
+		RETURNING `+batchJobColumns,
+		BATCH_PROCESSING, time.Now(), BATCH_PENDING)
+
+	job, err := scanBatchJob(row)
+	if err == sql.ErrNoRows {
+		return BatchJob{}, false, nil
+	}
+	if err != nil {
+		return BatchJob{}, false, fmt.Errorf("failed to claim batch job: %v", err)
+	}
+	return job, true, nil
+}
+
+// Puts jobs left in PROCESSING by an instance that stopped back into the queue. Rows already processed keep their status.
+func RequeueBatchJobs(ctx context.Context, db *sql.DB, olderThan time.Duration) error {
+	_, err := db.ExecContext(ctx, `UPDATE batch_jobs SET status = $1 WHERE status = $2 AND updated_at < $3`, BATCH_PENDING, BATCH_PROCESSING, time.Now().Add(-olderThan))
+	if err != nil {
+		return fmt.Errorf("failed to requeue batch jobs: %v", err)
+	}
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+const batchJobColumns = `id, merchant_id, type, status, client_format, total_rows, processed_rows, succeeded_rows, failed_rows, created_at, updated_at, completed_at, created_by`
+
+func scanBatchJob(row rowScanner) (BatchJob, error) {
+	var job BatchJob
+	var completedAt sql.NullTime
+	var createdBy sql.NullString
+	if err := row.Scan(&job.ID, &job.MerchantID, &job.Type, &job.Status, &job.ClientFormat, &job.TotalRows, &job.ProcessedRows, &job.SucceededRows, &job.FailedRows, &job.CreatedAt, &job.UpdatedAt, &completedAt, &createdBy); err != nil {
+		return BatchJob{}, err
+	}
+	job.CreatedBy = createdBy.String
+	if completedAt.Valid {
+		job.CompletedAt = &completedAt.Time
+	}
+	return job, nil
+}
+
+// Jobs of other merchants are reported as not found
+func GetBatchJob(ctx context.Context, db *sql.DB, merchantID, jobID int) (BatchJob, error) {
+	row := db.QueryRowContext(ctx, `SELECT `+batchJobColumns+` FROM batch_jobs WHERE id = $1 AND merchant_id = $2`, jobID, merchantID)
+	job, err := scanBatchJob(row)
+	if err == sql.ErrNoRows {
+		return BatchJob{}, fmt.Errorf("no batch job found with id %d", jobID)
+	}
+	if err != nil {
+		return BatchJob{}, fmt.Errorf("failed to get batch job %d: %v", jobID, err)
+	}
+	return job, nil
+}
+
+// Returns the rows of a job in row order, optionally only those with a given status
+func GetBatchJobRows(ctx context.Context, db *sql.DB, jobID int, statuses ...BatchRowStatus) ([]BatchJobRow, error) {
+	query := `SELECT batch_job_id, row_number, amount, COALESCE(user_id, 0), COALESCE(currency, ''), COALESCE(beneficiary_name, ''), COALESCE(beneficiary_iban, ''), COALESCE(beneficiary_bic, ''), COALESCE(beneficiary_country, ''), status, COALESCE(error, ''), COALESCE(transaction_id, 0)
+		FROM batch_job_rows WHERE batch_job_id = $1`
+	args := []interface{}{jobID}
+	if len(statuses) > 0 {
+		query += " AND status IN ("
+		for i, status := range statuses {
+			if i > 0 {
+				query += ", "
+			}
+			args = append(args, status)
+			query += fmt.Sprintf("$%d", len(args))
+		}
+		query += ")"
+	}
+	query += " ORDER BY row_number"
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch batch job rows: %v", err)
+	}
+	defer rows.Close()
+
+	var jobRows []BatchJobRow
+	for rows.Next() {
+		var row BatchJobRow
+		if err := rows.Scan(&row.BatchJobID, &row.RowNumber, &row.Amount, &row.UserID, &row.Currency, &row.BeneficiaryName, &row.BeneficiaryIBAN, &row.BeneficiaryBIC, &row.BeneficiaryCountry, &row.Status, &row.Error, &row.TransactionID); err != nil {
+			return nil, fmt.Errorf("failed to scan batch job row: %v", err)
+		}
+		jobRows = append(jobRows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return jobRows, nil
+}
+
+// Records the outcome of a row and bumps the job's progress counters in one DB transaction
+func CompleteBatchJobRow(ctx context.Context, db *sql.DB, row BatchJobRow) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE batch_job_rows SET status = $1, error = $2, transaction_id = $3 WHERE batch_job_id = $4 AND row_number = $5`,
+		row.Status, nullString(row.Error), nullInt(row.TransactionID), row.BatchJobID, row.RowNumber); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to update batch job row %d: %v", row.RowNumber, err)
+	}
+
+	succeeded, failed := 0, 1
+	if row.Status == ROW_SUCCEEDED {
+		succeeded, failed = 1, 0
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE batch_jobs SET processed_rows = processed_rows + 1, succeeded_rows = succeeded_rows + $1, failed_rows = failed_rows + $2, updated_at = $3 WHERE id = $4`,
+		succeeded, failed, time.Now(), row.BatchJobID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to update batch job %d: %v", row.BatchJobID, err)
+	}
+
+	return tx.Commit()
+}
+
+func CompleteBatchJob(ctx context.Context, db *sql.DB, jobID int) error {
+	now := time.Now()
+	if _, err := db.ExecContext(ctx, `UPDATE batch_jobs SET status = $1, updated_at = $2, completed_at = $2 WHERE id = $3`, BATCH_COMPLETED, now, jobID); err != nil {
+		return fmt.Errorf("failed to complete batch job %d: %v", jobID, err)
+	}
+	return nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullInt(i int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(i), Valid: i != 0}
+}
+
+// Hands a job being processed back to the queue so the next claim resumes it from its first pending row
+func ReleaseBatchJob(ctx context.Context, db *sql.DB, jobID int) error {
+	if _, err := db.ExecContext(ctx, `UPDATE batch_jobs SET status = $1, updated_at = $2 WHERE id = $3`, BATCH_PENDING, time.Now(), jobID); err != nil {
+		return fmt.Errorf("failed to release batch job %d: %v", jobID, err)
+	}
+	return nil
+}
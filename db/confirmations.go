@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// A withdrawal waiting in DRAFT for the user to confirm it with a one-time code. It is deleted once the withdrawal
+// is confirmed or expires.
+type WithdrawalConfirmation struct {
+	TransactionID int
+	// the code and what to publish once confirmed, encrypted by the API as they include bank details
+	Payload string
+	// wrong codes sent so far
+	Attempts  int
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+type ConfirmationRepository interface {
+	CreateConfirmation(ctx context.Context, confirmation *WithdrawalConfirmation) error
+	// wraps ErrNotFound when no confirmation of the transaction is pending
+	GetConfirmation(ctx context.Context, transactionID int) (WithdrawalConfirmation, error)
+	// Counts a wrong code and returns the wrong codes sent so far. Wraps ErrNotFound like GetConfirmation.
+	AddConfirmationAttempt(ctx context.Context, transactionID int) (int, error)
+	// wraps ErrNotFound like GetConfirmation
+	DeleteConfirmation(ctx context.Context, transactionID int) error
+	// up to limit confirmations that expired by now, the longest expired first
+	GetExpiredConfirmations(ctx context.Context, now time.Time, limit int) ([]WithdrawalConfirmation, error)
+}
+
+func CreateConfirmation(ctx context.Context, db Queryer, confirmation *WithdrawalConfirmation) error {
+	err := db.QueryRowContext(ctx, `INSERT INTO withdrawal_confirmations (transaction_id, payload, attempts, expires_at, created_at)
+		VALUES ($1, $2, 0, $3, $4) RETURNING created_at`, confirmation.TransactionID, confirmation.Payload, confirmation.ExpiresAt, time.Now()).
+		Scan(&confirmation.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert confirmation of transaction %d: %w", confirmation.TransactionID, err)
+	}
+	confirmation.Attempts = 0
+	return nil
+}
+
+const confirmationColumns = `transaction_id, payload, attempts, expires_at, created_at`
+
+func scanConfirmation(row interface{ Scan(...interface{}) error }) (WithdrawalConfirmation, error) {
+	var c WithdrawalConfirmation
+	err := row.Scan(&c.TransactionID, &c.Payload, &c.Attempts, &c.ExpiresAt, &c.CreatedAt)
+	return c, err
+}
+
+func GetConfirmation(ctx context.Context, db Queryer, transactionID int) (WithdrawalConfirmation, error) {
+	confirmation, err := scanConfirmation(db.QueryRowContext(ctx, `SELECT `+confirmationColumns+` FROM withdrawal_confirmations WHERE transaction_id = $1`, transactionID))
+	if err == sql.ErrNoRows {
+		return WithdrawalConfirmation{}, fmt.Errorf("confirmation of transaction %d: %w", transactionID, ErrNotFound)
+	}
+	if err != nil {
+		return WithdrawalConfirmation{}, fmt.Errorf("failed to get confirmation of transaction %d: %v", transactionID, err)
+	}
+	return confirmation, nil
+}
+
+func AddConfirmationAttempt(ctx context.Context, db Queryer, transactionID int) (int, error) {
+	var attempts int
+	err := db.QueryRowContext(ctx, `UPDATE withdrawal_confirmations SET attempts = attempts + 1 WHERE transaction_id = $1 RETURNING attempts`, transactionID).
+		Scan(&attempts)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("confirmation of transaction %d: %w", transactionID, ErrNotFound)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to count confirmation attempt of transaction %d: %v", transactionID, err)
+	}
+	return attempts, nil
+}
+
+func DeleteConfirmation(ctx context.Context, db Queryer, transactionID int) error {
+	res, err := db.ExecContext(ctx, `DELETE FROM withdrawal_confirmations WHERE transaction_id = $1`, transactionID)
+	if err != nil {
+		return fmt.Errorf("failed to delete confirmation of transaction %d: %v", transactionID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("confirmation of transaction %d: %w", transactionID, ErrNotFound)
+	}
+	return nil
+}
+
+func GetExpiredConfirmations(ctx context.Context, db Queryer, now time.Time, limit int) ([]WithdrawalConfirmation, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+confirmationColumns+` FROM withdrawal_confirmations WHERE expires_at <= $1
+		ORDER BY expires_at, transaction_id LIMIT $2`, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch expired confirmations: %v", err)
+	}
+	defer rows.Close()
+
+	var confirmations []WithdrawalConfirmation
+	for rows.Next() {
+		confirmation, err := scanConfirmation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan confirmation: %v", err)
+		}
+		confirmations = append(confirmations, confirmation)
+	}
+	return confirmations, rows.Err()
+}
@@ -3,17 +3,16 @@ package db
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"payment-gateway/internal/services"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/shopspring/decimal"
 )
 
-var db *sql.DB
-
 type Execer interface {
 	Exec(query string, args ...interface{}) (sql.Result, error)
 	Query(query string, args ...interface{}) (*sql.Rows, error)
@@ -21,33 +20,51 @@ type Execer interface {
 }
 
 type User struct {
-	ID        int
-	Username  string
-	Email     string
-	CountryID int
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID         int
+	MerchantID int
+	Username   string
+	Email      string
+	CountryID  int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
 }
 
 type Gateway struct {
-	ID                  int
-	Name                string
+	ID   int
+	Name string
+	// the gateway's preferred data format, used when it does not support the client's format
 	DataFormatSupported string
-	CreatedAt           time.Time
-	UpdatedAt           time.Time
+	// every format the gateway accepts, including DataFormatSupported
+	DataFormats []string
+	Enabled     bool
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Picks the format a message for this gateway is encoded in. The client's format is kept when the gateway
+// supports it so nothing needs translating, otherwise the gateway's preferred format is used.
+func (g Gateway) OutboundFormat(clientFormat string) string {
+	for _, format := range g.DataFormats {
+		if services.SameDataFormat(format, clientFormat) {
+			return format
+		}
+	}
+	return g.DataFormatSupported
 }
 
 type Country struct {
 	ID        int
 	Name      string
 	Code      string
+	Enabled   bool
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
 type Currency struct {
-	ID     int
-	Symbol string
+	ID      int
+	Symbol  string
+	Enabled bool
 }
 
 type TransactionType string
@@ -56,6 +73,10 @@ type TransactionStatus string
 const (
 	DEPOSIT    TransactionType = "DEPOSIT"
 	WITHDRAWAL TransactionType = "WITHDRAWAL"
+	// money returned to the user of a successful deposit at the merchant's request, in full or in part
+	REFUND TransactionType = "REFUND"
+	// a deposit disputed through the gateway, SENT while the dispute is open
+	CHARGEBACK TransactionType = "CHARGEBACK"
 )
 
 const (
@@ -63,51 +84,54 @@ const (
 	SENT    TransactionStatus = "SENT"
 	SUCCESS TransactionStatus = "SUCCESS"
 	FAILED  TransactionStatus = "FAILED"
+	// a DRAFT the user didn't confirm in time, it was never sent
+	EXPIRED TransactionStatus = "EXPIRED"
+	// stopped by the client before the gateway settled it
+	CANCELLED TransactionStatus = "CANCELLED"
 )
 
 type Transaction struct {
-	ID        int
-	Amount    decimal.Decimal
-	Type      TransactionType
-	Status    TransactionStatus
-	UserID    int
-	GatewayID int
-	CountryID int
-	CreatedAt time.Time
+	ID         int
+	MerchantID int
+	Amount     decimal.Decimal
+	Type       TransactionType
+	Status     TransactionStatus
+	UserID     int
+	GatewayID  int
+	CountryID  int
+	// empty for transactions created before currencies were recorded
+	Currency string
+	// the deposit a refund or chargeback reverses, 0 for other types
+	OriginalID int
+	// the format the transaction is published to its gateway in, empty for those created before it was recorded
+	// and for chargebacks, which aren't published
+	DataFormat string
+	CreatedAt  time.Time
+	// the API key the transaction was created with, empty when it was created without one
+	CreatedBy string
 }
 
-// InitializeDB initializes the database connection
-func InitializeDB(dataSourceName string) {
-	var err error
-
-	err = services.RetryOperation(func() error {
-		db, err = sql.Open("postgres", dataSourceName)
-		if err != nil {
-			return err
-		}
-
-		return db.Ping()
-	}, 5)
-
+// Opens a connection pool and waits for the database to answer, retrying while it starts up
+func Open(dataSourceName string, retry services.RetryPolicy) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dataSourceName)
 	if err != nil {
-		log.Fatalf("Could not connect to the database: %v", err)
+		return nil, fmt.Errorf("failed to open database: %v", err)
 	}
 
-	log.Println("Successfully connected to the database.")
-}
-
-func GetDB() (*sql.DB, error) {
-	if db == nil {
-		return nil, fmt.Errorf("db not initialized")
+	if err := services.RetryOperation(db.Ping, retry, nil); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not connect to the database: %v", err)
 	}
+
+	log.Println("Successfully connected to the database.")
 	return db, nil
 }
 
 func CreateUser(ctx context.Context, db Execer, user *User) error {
-	query := `INSERT INTO users (username, email, country_id, created_at, updated_at) 
-			  VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	query := `INSERT INTO users (merchant_id, username, email, country_id, created_at, updated_at) 
+			  VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
 
-	err := db.QueryRow(query, user.Username, user.Email, user.CountryID, time.Now(), time.Now()).Scan(&user.ID)
+	err := db.QueryRow(query, user.MerchantID, user.Username, user.Email, user.CountryID, time.Now(), time.Now()).Scan(&user.ID)
 	if err != nil {
 		return fmt.Errorf("failed to insert user: %v", err)
 	}
@@ -115,7 +139,7 @@ func CreateUser(ctx context.Context, db Execer, user *User) error {
 }
 
 func GetUsers(ctx context.Context, db *sql.DB) ([]User, error) {
-	rows, err := db.Query(`SELECT id, username, email, country_id, created_at, updated_at FROM users`)
+	rows, err := db.Query(`SELECT id, merchant_id, username, email, country_id, created_at, updated_at FROM users`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch users: %v", err)
 	}
@@ -124,7 +148,7 @@ func GetUsers(ctx context.Context, db *sql.DB) ([]User, error) {
 	var users []User
 	for rows.Next() {
 		var user User
-		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.CountryID, &user.CreatedAt, &user.UpdatedAt); err != nil {
+		if err := rows.Scan(&user.ID, &user.MerchantID, &user.Username, &user.Email, &user.CountryID, &user.CreatedAt, &user.UpdatedAt); err != nil {
 			return nil, fmt.Errorf("failed to scan user: %v", err)
 		}
 		users = append(users, user)
@@ -141,13 +165,35 @@ func CreateGateway(ctx context.Context, db Execer, gateway *Gateway) error {
 
 	err := db.QueryRow(query, gateway.Name, gateway.DataFormatSupported, time.Now(), time.Now()).Scan(&gateway.ID)
 	if err != nil {
-		return fmt.Errorf("failed to insert gateway: %v", err)
+		return fmt.Errorf("failed to insert gateway: %w", err)
 	}
+	gateway.Enabled = true
+
+	formats := append([]string{gateway.DataFormatSupported}, gateway.DataFormats...)
+	for _, format := range formats {
+		if _, err := db.Exec(`INSERT INTO gateway_data_formats (gateway_id, data_format) VALUES ($1, $2) ON CONFLICT DO NOTHING`, gateway.ID, format); err != nil {
+			return fmt.Errorf("failed to insert gateway data format: %v", err)
+		}
+	}
+	gateway.DataFormats = dedupeFormats(formats)
 	return nil
 }
 
-func GetGateways(ctx context.Context, db *sql.DB) ([]Gateway, error) {
-	rows, err := db.Query(`SELECT id, name, data_format_supported, created_at, updated_at FROM gateways`)
+func dedupeFormats(formats []string) []string {
+	seen := map[string]bool{}
+	out := make([]string, 0, len(formats))
+	for _, format := range formats {
+		if format == "" || seen[format] {
+			continue
+		}
+		seen[format] = true
+		out = append(out, format)
+	}
+	return out
+}
+
+func GetGateways(ctx context.Context, db Execer) ([]Gateway, error) {
+	rows, err := db.Query(`SELECT id, name, data_format_supported, enabled, created_at, updated_at FROM gateways ORDER BY id`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch gateways: %v", err)
 	}
@@ -156,7 +202,7 @@ func GetGateways(ctx context.Context, db *sql.DB) ([]Gateway, error) {
 	var gateways []Gateway
 	for rows.Next() {
 		var gateway Gateway
-		if err := rows.Scan(&gateway.ID, &gateway.Name, &gateway.DataFormatSupported, &gateway.CreatedAt, &gateway.UpdatedAt); err != nil {
+		if err := rows.Scan(&gateway.ID, &gateway.Name, &gateway.DataFormatSupported, &gateway.Enabled, &gateway.CreatedAt, &gateway.UpdatedAt); err != nil {
 			return nil, fmt.Errorf("failed to scan gateway: %v", err)
 		}
 		gateways = append(gateways, gateway)
@@ -173,13 +219,14 @@ func CreateCountry(ctx context.Context, db Execer, country *Country) error {
 
 	err := db.QueryRow(query, country.Name, country.Code, time.Now(), time.Now()).Scan(&country.ID)
 	if err != nil {
-		return fmt.Errorf("failed to insert country: %v", err)
+		return fmt.Errorf("failed to insert country: %w", err)
 	}
+	country.Enabled = true
 	return nil
 }
 
-func GetCountries(ctx context.Context, db *sql.DB) ([]Country, error) {
-	rows, err := db.Query(`SELECT id, name, code, created_at, updated_at FROM countries`)
+func GetCountries(ctx context.Context, db Execer) ([]Country, error) {
+	rows, err := db.Query(`SELECT id, name, code, enabled, created_at, updated_at FROM countries ORDER BY id`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch countries: %v", err)
 	}
@@ -188,7 +235,7 @@ func GetCountries(ctx context.Context, db *sql.DB) ([]Country, error) {
 	var countries []Country
 	for rows.Next() {
 		var country Country
-		if err := rows.Scan(&country.ID, &country.Name, &country.Code, &country.CreatedAt, &country.UpdatedAt); err != nil {
+		if err := rows.Scan(&country.ID, &country.Name, &country.Code, &country.Enabled, &country.CreatedAt, &country.UpdatedAt); err != nil {
 			return nil, fmt.Errorf("failed to scan country: %v", err)
 		}
 		countries = append(countries, country)
@@ -205,13 +252,14 @@ func CreateCurrency(ctx context.Context, db Execer, currency *Currency) error {
 
 	err := db.QueryRow(query, currency.Symbol).Scan(&currency.ID)
 	if err != nil {
-		return fmt.Errorf("failed to insert currency: %v", err)
+		return fmt.Errorf("failed to insert currency: %w", err)
 	}
+	currency.Enabled = true
 	return nil
 }
 
-func GetCurrencies(ctx context.Context, db *sql.DB) ([]Currency, error) {
-	rows, err := db.Query(`SELECT id, symbol FROM currencies`)
+func GetCurrencies(ctx context.Context, db Execer) ([]Currency, error) {
+	rows, err := db.Query(`SELECT id, symbol, enabled FROM currencies ORDER BY id`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch currencies: %v", err)
 	}
@@ -220,7 +268,7 @@ func GetCurrencies(ctx context.Context, db *sql.DB) ([]Currency, error) {
 	var currencies []Currency
 	for rows.Next() {
 		var currency Currency
-		if err := rows.Scan(&currency.ID, &currency.Symbol); err != nil {
+		if err := rows.Scan(&currency.ID, &currency.Symbol, &currency.Enabled); err != nil {
 			return nil, fmt.Errorf("failed to scan country: %v", err)
 		}
 		currencies = append(currencies, currency)
@@ -232,18 +280,32 @@ func GetCurrencies(ctx context.Context, db *sql.DB) ([]Currency, error) {
 }
 
 func CreateTransaction(ctx context.Context, db Execer, transaction *Transaction) error {
-	query := `INSERT INTO transactions (amount, type, status, gateway_id, country_id, user_id, created_at) 
-			  VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+	query := `INSERT INTO transactions (merchant_id, amount, type, status, gateway_id, country_id, user_id, currency, original_id, data_format, created_at, created_by) 
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id`
 
-	err := db.QueryRow(query, transaction.Amount, transaction.Type, transaction.Status, transaction.GatewayID, transaction.CountryID, transaction.UserID, time.Now()).Scan(&transaction.ID)
+	err := db.QueryRow(query, transaction.MerchantID, transaction.Amount, transaction.Type, transaction.Status, transaction.GatewayID, transaction.CountryID, transaction.UserID,
+		transaction.Currency, nullInt(transaction.OriginalID), transaction.DataFormat, time.Now(), nullString(transaction.CreatedBy)).Scan(&transaction.ID)
 	if err != nil {
 		return fmt.Errorf("failed to insert transaction: %v", err)
 	}
 	return nil
 }
 
-func GetTransactions(ctx context.Context, db *sql.DB) ([]Transaction, error) {
-	rows, err := db.Query(`SELECT id, amount, type, status, user_id, gateway_id, country_id, created_at FROM transactions`)
+func scanTransaction(row rowScanner) (Transaction, error) {
+	var transaction Transaction
+	var createdBy sql.NullString
+	var originalID sql.NullInt64
+	err := row.Scan(&transaction.ID, &transaction.MerchantID, &transaction.Amount, &transaction.Type, &transaction.Status, &transaction.UserID, &transaction.GatewayID, &transaction.CountryID,
+		&transaction.Currency, &originalID, &transaction.DataFormat, &transaction.CreatedAt, &createdBy)
+	transaction.CreatedBy = createdBy.String
+	transaction.OriginalID = int(originalID.Int64)
+	return transaction, err
+}
+
+const transactionColumns = `id, merchant_id, amount, type, status, user_id, gateway_id, country_id, currency, original_id, data_format, created_at, created_by`
+
+func GetTransactions(ctx context.Context, db Queryer, merchantID int) ([]Transaction, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+transactionColumns+` FROM transactions WHERE `+merchantCondition(1)+` ORDER BY id`, merchantID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch transactions: %v", err)
 	}
@@ -251,8 +313,8 @@ func GetTransactions(ctx context.Context, db *sql.DB) ([]Transaction, error) {
 
 	var transactions []Transaction
 	for rows.Next() {
-		var transaction Transaction
-		if err := rows.Scan(&transaction.ID, &transaction.Amount, &transaction.Type, &transaction.Status, &transaction.UserID, &transaction.GatewayID, &transaction.CountryID, &transaction.CreatedAt); err != nil {
+		transaction, err := scanTransaction(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan transaction: %v", err)
 		}
 		transactions = append(transactions, transaction)
@@ -263,8 +325,9 @@ func GetTransactions(ctx context.Context, db *sql.DB) ([]Transaction, error) {
 	return transactions, nil
 }
 
-func GetTransaction(ctx context.Context, db *sql.DB, transactionID int, txType TransactionType) (Transaction, error) {
-	rows, err := db.Query(`SELECT id, amount, type, status, user_id, gateway_id, country_id, created_at FROM transactions WHERE id = $1 and type = $2`, transactionID, txType)
+// Transactions of other merchants are reported as not found
+func GetTransaction(ctx context.Context, db Queryer, merchantID, transactionID int, txType TransactionType) (Transaction, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+transactionColumns+` FROM transactions WHERE id = $1 and type = $2 and `+merchantCondition(3), transactionID, txType, merchantID)
 	if err != nil {
 		return Transaction{}, fmt.Errorf("failed to get transaction %d: %v", transactionID, err)
 	}
@@ -274,14 +337,40 @@ func GetTransaction(ctx context.Context, db *sql.DB, transactionID int, txType T
 		if err := rows.Err(); err != nil {
 			return Transaction{}, err
 		}
-		var transaction Transaction
-		if err := rows.Scan(&transaction.ID, &transaction.Amount, &transaction.Type, &transaction.Status, &transaction.UserID, &transaction.GatewayID, &transaction.CountryID, &transaction.CreatedAt); err != nil {
+		transaction, err := scanTransaction(rows)
+		if err != nil {
 			return Transaction{}, fmt.Errorf("failed to scan transaction: %v", err)
 		}
 		return transaction, nil
 	} else {
-		return Transaction{}, fmt.Errorf("no transaction found with id %d", transactionID)
+		return Transaction{}, fmt.Errorf("no transaction found with id %d: %w", transactionID, ErrNotFound)
+	}
+}
+
+// Sums the amounts of the transactions of types reversing the original one, leaving out those that failed, were
+// cancelled or expired. The original row is locked until the DB transaction ends, so refunds and chargebacks of the
+// same deposit checked against the sum are recorded one after the other.
+func GetReversedAmount(ctx context.Context, db Queryer, originalID int, types ...TransactionType) (decimal.Decimal, error) {
+	var id int
+	err := db.QueryRowContext(ctx, `SELECT id FROM transactions WHERE id = $1 FOR UPDATE`, originalID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return decimal.Zero, fmt.Errorf("no transaction found with id %d: %w", originalID, ErrNotFound)
+	}
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("failed to lock transaction %d: %v", originalID, err)
+	}
+
+	names := make([]string, len(types))
+	for i, typ := range types {
+		names[i] = string(typ)
+	}
+	var sum decimal.Decimal
+	err = db.QueryRowContext(ctx, `SELECT COALESCE(SUM(amount), 0) FROM transactions
+		WHERE original_id = $1 AND type::text = ANY($2) AND status NOT IN ('FAILED', 'CANCELLED', 'EXPIRED')`, originalID, pq.Array(names)).Scan(&sum)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("failed to sum reversals of transaction %d: %v", originalID, err)
 	}
+	return sum, nil
 }
 
 // func GetSupportedCountriesByGateway(db *sql.DB, gatewayID int) ([]Country, error) {
@@ -2,9 +2,10 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"log"
 	"os"
-	"path/filepath"
+	"payment-gateway/internal/services"
 	"testing"
 	"time"
 )
@@ -72,9 +73,10 @@ func AddDummyData() error {
 	}
 
 	CreateUser(ctx, tx, &User{
-		Username:  "johnsmith",
-		Email:     "john.smith@example.com",
-		CountryID: uae.ID,
+		MerchantID: DefaultMerchantID,
+		Username:   "johnsmith",
+		Email:      "john.smith@example.com",
+		CountryID:  uae.ID,
 	})
 
 	gate1 := Gateway{
@@ -84,6 +86,7 @@ func AddDummyData() error {
 	gate2 := Gateway{
 		Name:                "Gateway 2",
 		DataFormatSupported: "application/xml",
+		DataFormats:         []string{"application/x-protobuf"},
 	}
 	CreateGateway(ctx, tx, &gate1)
 	CreateGateway(ctx, tx, &gate2)
@@ -102,6 +105,14 @@ func AddDummyData() error {
 	return nil
 }
 
+// the connection TestMain opens to the test database
+var db *sql.DB
+
+// lets the external db_test package reach the test database
+func SharedTestDB() *sql.DB {
+	return db
+}
+
 func TestMain(m *testing.M) {
 	dbUser := os.Getenv("DB_USER")
 	dbPassword := os.Getenv("DB_PASSWORD")
@@ -111,7 +122,10 @@ func TestMain(m *testing.M) {
 
 	dbURL := "postgres://" + dbUser + ":" + dbPassword + "@" + dbHost + ":" + dbPort + "/postgres?sslmode=disable"
 
-	InitializeDB(dbURL)
+	var err error
+	if db, err = Open(dbURL, services.RetryPolicy{Attempts: 5, Backoff: time.Second}); err != nil {
+		log.Fatalln(err)
+	}
 	if _, err := db.Exec("DROP DATABASE IF EXISTS " + dbName); err != nil {
 		log.Fatalln("Could not drop database:", err)
 	}
@@ -121,15 +135,16 @@ func TestMain(m *testing.M) {
 
 	db.Close()
 	dbURL = "postgres://" + dbUser + ":" + dbPassword + "@" + dbHost + ":" + dbPort + "/" + dbName + "?sslmode=disable"
-	InitializeDB(dbURL)
+	if db, err = Open(dbURL, services.RetryPolicy{Attempts: 5, Backoff: time.Second}); err != nil {
+		log.Fatalln(err)
+	}
 
-	fp, _ := filepath.Abs("init.sql")
-	initScript, err := os.ReadFile(fp)
+	migrations, err := EmbeddedMigrations()
 	if err != nil {
-		log.Fatalln("Could not read init.sql:", err)
+		log.Fatalln("Could not load migrations:", err)
 	}
-	if _, err := db.Exec(string(initScript)); err != nil {
-		log.Fatalln("Could not run init.sql:", err)
+	if _, err := MigrateUp(context.Background(), db, migrations); err != nil {
+		log.Fatalln("Could not run migrations:", err)
 	}
 
 	if err := AddDummyData(); err != nil {
@@ -0,0 +1,639 @@
+// Package dbtest holds the conformance suite every db.Store implementation has to pass. The suite creates its
+// own reference data and never assumes the store is empty, so it can run against a database with other data in it.
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"payment-gateway/db"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type fixture struct {
+	merchant, other  db.Merchant
+	xxx, xts         db.Currency
+	routed, unrouted db.Country
+	json, xml        db.Gateway
+	user             db.User
+}
+
+func setup(t *testing.T, ctx context.Context, store db.Store) fixture {
+	t.Helper()
+	var f fixture
+	f.merchant = db.Merchant{Name: "Conformance"}
+	f.other = db.Merchant{Name: "Conformance Other"}
+	for _, merchant := range []*db.Merchant{&f.merchant, &f.other} {
+		if err := store.Merchants().CreateMerchant(ctx, merchant); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.xxx = db.Currency{Symbol: "XXX"}
+	f.xts = db.Currency{Symbol: "XTS"}
+	f.routed = db.Country{Name: "Conformance Routed", Code: "XA"}
+	f.unrouted = db.Country{Name: "Conformance Unrouted", Code: "XB"}
+	f.json = db.Gateway{Name: "Conformance JSON", DataFormatSupported: "application/json"}
+	f.xml = db.Gateway{Name: "Conformance XML", DataFormatSupported: "application/xml", DataFormats: []string{"application/x-protobuf", "application/xml"}}
+
+	refs := store.ReferenceData()
+	for _, currency := range []*db.Currency{&f.xxx, &f.xts} {
+		if err := refs.CreateCurrency(ctx, currency); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, country := range []*db.Country{&f.routed, &f.unrouted} {
+		if err := refs.CreateCountry(ctx, country); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := refs.AddCountryCurrency(ctx, f.routed.ID, f.xxx.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := refs.AddCountryCurrency(ctx, f.routed.ID, f.xts.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := refs.AddCountryCurrency(ctx, f.unrouted.ID, f.xxx.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	gateways := store.Gateways()
+	for _, gateway := range []*db.Gateway{&f.json, &f.xml} {
+		if err := gateways.CreateGateway(ctx, gateway); err != nil {
+			t.Fatal(err)
+		}
+		if err := gateways.AddGatewayCountry(ctx, gateway.ID, f.routed.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f.user = db.User{MerchantID: f.merchant.ID, Username: "conformance", Email: "conformance@example.com", CountryID: f.routed.ID}
+	if err := store.Users().CreateUser(ctx, &f.user); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// Runs the suite against the store returned by newStore
+func TestStore(t *testing.T, newStore func(t *testing.T) db.Store) {
+	ctx := context.Background()
+	store := newStore(t)
+	f := setup(t, ctx, store)
+
+	t.Run("Merchants", func(t *testing.T) {
+		merchant, err := store.Merchants().GetMerchant(ctx, f.merchant.ID)
+		if err != nil || merchant.Name != "Conformance" || merchant.CreatedAt.IsZero() {
+			t.Errorf("Unexpected merchant %+v and error %v", merchant, err)
+		}
+		if _, err := store.Merchants().GetMerchant(ctx, db.DefaultMerchantID); err != nil {
+			t.Errorf("Expected the default merchant to exist, got %v", err)
+		}
+		if _, err := store.Merchants().GetMerchant(ctx, -1); !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for a missing merchant, got %v", err)
+		}
+		merchants, err := store.Merchants().GetMerchants(ctx)
+		if err != nil || len(merchants) < 3 || merchants[len(merchants)-1].ID != f.other.ID {
+			t.Errorf("Expected the merchants ordered by ID, got %+v and error %v", merchants, err)
+		}
+		hooked := db.Merchant{Name: "Conformance Hooked", WebhookURL: "https://merchant.example/codes", WebhookSecret: "sealed"}
+		if err := store.Merchants().CreateMerchant(ctx, &hooked); err != nil {
+			t.Fatal(err)
+		}
+		if merchant, err := store.Merchants().GetMerchant(ctx, hooked.ID); err != nil || merchant.WebhookURL != hooked.WebhookURL || merchant.WebhookSecret != hooked.WebhookSecret {
+			t.Errorf("Expected the webhook to be stored, got %+v and error %v", merchant, err)
+		}
+		duplicate := db.Merchant{Name: f.merchant.Name}
+		if err := store.Merchants().CreateMerchant(ctx, &duplicate); err == nil {
+			t.Errorf("Expected duplicate merchant name to be rejected")
+		}
+	})
+
+	t.Run("Users", func(t *testing.T) {
+		user, err := store.Users().GetUser(ctx, f.merchant.ID, f.user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.MerchantID != f.merchant.ID || user.Username != f.user.Username || user.Email != f.user.Email || user.CountryID != f.routed.ID || user.CreatedAt.IsZero() {
+			t.Errorf("Unexpected user %+v", user)
+		}
+		if _, err := store.Users().GetUser(ctx, f.merchant.ID, -1); !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for a missing user, got %v", err)
+		}
+		if _, err := store.Users().GetUser(ctx, f.other.ID, f.user.ID); !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for another merchant's user, got %v", err)
+		}
+		duplicate := db.User{MerchantID: f.merchant.ID, Username: f.user.Username, Email: "other@example.com"}
+		if err := store.Users().CreateUser(ctx, &duplicate); err == nil {
+			t.Errorf("Expected duplicate username to be rejected")
+		}
+		// usernames and emails are only unique within a merchant
+		namesake := db.User{MerchantID: f.other.ID, Username: f.user.Username, Email: f.user.Email, CountryID: f.routed.ID}
+		if err := store.Users().CreateUser(ctx, &namesake); err != nil {
+			t.Errorf("Expected another merchant to have a user with the same name, got %v", err)
+		}
+		orphan := db.User{MerchantID: -1, Username: "orphan", Email: "orphan@example.com"}
+		if err := store.Users().CreateUser(ctx, &orphan); err == nil {
+			t.Errorf("Expected a user of a missing merchant to be rejected")
+		}
+	})
+
+	t.Run("Gateways", func(t *testing.T) {
+		gateway, err := store.Gateways().GetGateway(ctx, f.xml.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !gateway.Enabled || gateway.DataFormatSupported != "application/xml" || len(gateway.DataFormats) != 2 || !contains(gateway.DataFormats, "application/x-protobuf") {
+			t.Errorf("Unexpected gateway %+v", gateway)
+		}
+		if _, err := store.Gateways().GetGateway(ctx, -1); !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for a missing gateway, got %v", err)
+		}
+
+		gateways, err := store.Gateways().GetGateways(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := 0
+		for i, g := range gateways {
+			if i > 0 && gateways[i-1].ID >= g.ID {
+				t.Errorf("Expected gateways ordered by ID")
+			}
+			if g.ID == f.json.ID || g.ID == f.xml.ID {
+				found++
+				if len(g.DataFormats) == 0 || g.DataFormats[0] != g.DataFormatSupported {
+					t.Errorf("Expected the preferred format first, got %v", g.DataFormats)
+				}
+			}
+		}
+		if found != 2 {
+			t.Errorf("Expected both gateways to be listed, found %d", found)
+		}
+
+		duplicate := db.Gateway{Name: f.json.Name, DataFormatSupported: "application/json"}
+		if err := store.Gateways().CreateGateway(ctx, &duplicate); err == nil {
+			t.Errorf("Expected duplicate gateway name to be rejected")
+		}
+		if err := store.Gateways().AddGatewayCountry(ctx, f.json.ID, -1); err == nil {
+			t.Errorf("Expected mapping to a missing country to be rejected")
+		}
+	})
+
+	t.Run("Routing", func(t *testing.T) {
+		seen := map[int]bool{}
+		for i := 0; i < 50 && len(seen) < 2; i++ {
+			gateway, err := store.Gateways().GetRandomGateway(ctx, f.routed.ID, "XTS")
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen[gateway.ID] = true
+		}
+		if !seen[f.json.ID] || !seen[f.xml.ID] {
+			t.Errorf("Expected both gateways to be picked, got %v", seen)
+		}
+
+		gateways, err := store.Gateways().GetGatewaysFor(ctx, f.routed.ID, "XTS")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(gateways) != 2 || gateways[0].ID != f.json.ID || gateways[1].ID != f.xml.ID || len(gateways[1].DataFormats) != 2 {
+			t.Errorf("Expected both gateways ordered by ID with their formats, got %+v", gateways)
+		}
+		if gateways, err := store.Gateways().GetGatewaysFor(ctx, f.unrouted.ID, "XXX"); err != nil || len(gateways) != 0 {
+			t.Errorf("Expected no gateways for a country without gateways, got %+v and error %v", gateways, err)
+		}
+
+		if _, err := store.Gateways().GetRandomGateway(ctx, f.unrouted.ID, "XXX"); !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for a country without gateways, got %v", err)
+		}
+		if _, err := store.Gateways().GetRandomGateway(ctx, f.routed.ID, "EUR"); !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for a currency the country doesn't accept, got %v", err)
+		}
+	})
+
+	t.Run("ReferenceData", func(t *testing.T) {
+		refs := store.ReferenceData()
+		for _, c := range []struct {
+			symbol    string
+			countryID int
+			expected  bool
+		}{
+			{"XXX", f.routed.ID, true},
+			{"XTS", f.routed.ID, true},
+			{"XTS", f.unrouted.ID, false},
+			{"XXX", -1, false},
+		} {
+			if supported, err := refs.CurrencySupportedInCountry(ctx, c.symbol, c.countryID); err != nil || supported != c.expected {
+				t.Errorf("%s in %d: expected %v, got %v and error %v", c.symbol, c.countryID, c.expected, supported, err)
+			}
+		}
+
+		currencies, err := refs.GetSupportedCurrenciesFromCountry(ctx, f.routed.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(currencies) != 2 || currencies[0].ID != f.xxx.ID || currencies[1].ID != f.xts.ID {
+			t.Errorf("Expected the country's currencies ordered by ID, got %+v", currencies)
+		}
+
+		country, err := refs.GetCountry(ctx, f.routed.ID)
+		if err != nil || country.Code != "XA" || !country.Enabled {
+			t.Errorf("Unexpected country %+v and error %v", country, err)
+		}
+		if _, err := refs.GetCountry(ctx, -1); !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for a missing country, got %v", err)
+		}
+
+		countries, err := refs.GetCountries(ctx)
+		if err != nil || !containsCountry(countries, f.unrouted.ID) {
+			t.Errorf("Expected country %d to be listed, got %+v and error %v", f.unrouted.ID, countries, err)
+		}
+		all, err := refs.GetCurrencies(ctx)
+		if err != nil || len(all) < 2 {
+			t.Errorf("Expected currencies to be listed, got %+v and error %v", all, err)
+		}
+
+		duplicate := db.Currency{Symbol: "XTS"}
+		if err := refs.CreateCurrency(ctx, &duplicate); err == nil {
+			t.Errorf("Expected duplicate currency to be rejected")
+		}
+	})
+
+	t.Run("Transactions", func(t *testing.T) {
+		transactions := store.Transactions()
+		transaction := db.Transaction{
+			MerchantID: f.merchant.ID,
+			Amount:     decimal.RequireFromString("12.34"),
+			Type:       db.WITHDRAWAL,
+			Status:     db.SENT,
+			UserID:     f.user.ID,
+			GatewayID:  f.json.ID,
+			CountryID:  f.routed.ID,
+			CreatedBy:  "key_conformance",
+		}
+		if err := transactions.CreateTransaction(ctx, &transaction); err != nil {
+			t.Fatal(err)
+		}
+		if transaction.ID == 0 {
+			t.Fatalf("Expected the transaction to get an ID")
+		}
+
+		got, err := transactions.GetTransaction(ctx, f.merchant.ID, transaction.ID, db.WITHDRAWAL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.MerchantID != f.merchant.ID || !got.Amount.Equal(transaction.Amount) || got.Status != db.SENT || got.GatewayID != f.json.ID || got.CreatedAt.IsZero() || got.CreatedBy != "key_conformance" {
+			t.Errorf("Unexpected transaction %+v", got)
+		}
+		if _, err := transactions.GetTransaction(ctx, f.merchant.ID, transaction.ID, db.DEPOSIT); !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound when the type doesn't match, got %v", err)
+		}
+
+		if err := transactions.UpdateTransactionStatus(ctx, f.merchant.ID, transaction.ID, db.WITHDRAWAL, db.SENT, db.SUCCESS); err != nil {
+			t.Fatal(err)
+		}
+		if err := transactions.UpdateTransactionStatus(ctx, f.merchant.ID, transaction.ID, db.WITHDRAWAL, db.SENT, db.FAILED); !errors.Is(err, db.ErrConflict) {
+			t.Errorf("Expected ErrConflict updating a transaction that is no longer SENT, got %v", err)
+		}
+		if err := transactions.UpdateTransactionStatus(ctx, f.merchant.ID, -1, db.WITHDRAWAL, db.SENT, db.FAILED); !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound updating a missing transaction, got %v", err)
+		}
+
+		if got, _ := transactions.GetTransaction(ctx, f.merchant.ID, transaction.ID, db.WITHDRAWAL); got.Status != db.SUCCESS {
+			t.Errorf("Expected status SUCCESS, got %s", got.Status)
+		}
+
+		all, err := transactions.GetTransactions(ctx, f.merchant.ID)
+		if err != nil || len(all) == 0 || all[len(all)-1].ID != transaction.ID {
+			t.Errorf("Expected the newest transaction last, got %+v and error %v", all, err)
+		}
+
+		// a cancelled transaction can't be settled by a late callback
+		cancelled := db.Transaction{MerchantID: f.merchant.ID, Amount: decimal.NewFromInt(5), Type: db.DEPOSIT, Status: db.SENT,
+			UserID: f.user.ID, GatewayID: f.json.ID, CountryID: f.routed.ID}
+		if err := transactions.CreateTransaction(ctx, &cancelled); err != nil {
+			t.Fatal(err)
+		}
+		if err := transactions.UpdateTransactionStatus(ctx, f.merchant.ID, cancelled.ID, db.DEPOSIT, db.SENT, db.CANCELLED); err != nil {
+			t.Fatal(err)
+		}
+		if err := transactions.UpdateTransactionStatus(ctx, f.merchant.ID, cancelled.ID, db.DEPOSIT, db.SENT, db.SUCCESS); !errors.Is(err, db.ErrConflict) {
+			t.Errorf("Expected ErrConflict settling a cancelled transaction, got %v", err)
+		}
+	})
+
+	t.Run("Reversals", func(t *testing.T) {
+		transactions := store.Transactions()
+		deposit := db.Transaction{MerchantID: f.merchant.ID, Amount: decimal.NewFromInt(100), Type: db.DEPOSIT, Status: db.SUCCESS,
+			UserID: f.user.ID, GatewayID: f.json.ID, CountryID: f.routed.ID, Currency: f.xxx.Symbol, DataFormat: "application/json"}
+		if err := transactions.CreateTransaction(ctx, &deposit); err != nil {
+			t.Fatal(err)
+		}
+		reversals := []db.Transaction{
+			{Amount: decimal.RequireFromString("30.50"), Type: db.REFUND, Status: db.SUCCESS},
+			{Amount: decimal.NewFromInt(20), Type: db.REFUND, Status: db.SENT},
+			{Amount: decimal.NewFromInt(40), Type: db.REFUND, Status: db.FAILED},
+			{Amount: decimal.NewFromInt(10), Type: db.CHARGEBACK, Status: db.SENT},
+		}
+		for i := range reversals {
+			reversal := &reversals[i]
+			reversal.MerchantID, reversal.UserID, reversal.GatewayID, reversal.CountryID = f.merchant.ID, f.user.ID, f.json.ID, f.routed.ID
+			reversal.Currency, reversal.OriginalID = f.xxx.Symbol, deposit.ID
+			if err := transactions.CreateTransaction(ctx, reversal); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got, err := transactions.GetTransaction(ctx, f.merchant.ID, reversals[0].ID, db.REFUND)
+		if err != nil || got.OriginalID != deposit.ID || got.Currency != f.xxx.Symbol {
+			t.Errorf("Expected the refund to record its deposit and currency, got %+v and error %v", got, err)
+		}
+		if got, _ := transactions.GetTransaction(ctx, f.merchant.ID, deposit.ID, db.DEPOSIT); got.OriginalID != 0 || got.DataFormat != "application/json" {
+			t.Errorf("Expected the deposit to reverse nothing and record its format, got %+v", got)
+		}
+
+		if sum, err := transactions.GetReversedAmount(ctx, deposit.ID, db.REFUND); err != nil || !sum.Equal(decimal.RequireFromString("50.50")) {
+			t.Errorf("Expected the failed refund to be left out, got %s and error %v", sum, err)
+		}
+		if sum, err := transactions.GetReversedAmount(ctx, deposit.ID, db.REFUND, db.CHARGEBACK); err != nil || !sum.Equal(decimal.RequireFromString("60.50")) {
+			t.Errorf("Expected refunds and chargebacks to add up, got %s and error %v", sum, err)
+		}
+		if sum, err := transactions.GetReversedAmount(ctx, reversals[0].ID, db.REFUND); err != nil || !sum.IsZero() {
+			t.Errorf("Expected nothing reversed, got %s and error %v", sum, err)
+		}
+		if _, err := transactions.GetReversedAmount(ctx, -1, db.REFUND); !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for a missing original, got %v", err)
+		}
+		err = store.InTx(ctx, func(tx db.Store) error {
+			_, err := tx.Transactions().GetReversedAmount(ctx, deposit.ID, db.REFUND)
+			return err
+		})
+		if err != nil {
+			t.Errorf("Expected the sum inside a DB transaction, got %v", err)
+		}
+	})
+
+	t.Run("Isolation", func(t *testing.T) {
+		transactions := store.Transactions()
+		theirs := db.Transaction{MerchantID: f.other.ID, Amount: decimal.NewFromInt(5), Type: db.DEPOSIT, Status: db.SENT, UserID: f.user.ID, GatewayID: f.json.ID, CountryID: f.routed.ID}
+		if err := transactions.CreateTransaction(ctx, &theirs); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := transactions.GetTransaction(ctx, f.merchant.ID, theirs.ID, db.DEPOSIT); !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for another merchant's transaction, got %v", err)
+		}
+		if err := transactions.UpdateTransactionStatus(ctx, f.merchant.ID, theirs.ID, db.DEPOSIT, db.SENT, db.SUCCESS); !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound updating another merchant's transaction, got %v", err)
+		}
+		mine, err := transactions.GetTransactions(ctx, f.merchant.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, transaction := range mine {
+			if transaction.MerchantID != f.merchant.ID {
+				t.Errorf("Expected only the merchant's transactions, got %+v", transaction)
+			}
+		}
+		if got, err := transactions.GetTransaction(ctx, f.other.ID, theirs.ID, db.DEPOSIT); err != nil || got.Status != db.SENT {
+			t.Errorf("Expected the transaction to be unchanged for its merchant, got %+v and error %v", got, err)
+		}
+
+		// the operator sees every merchant's transactions
+		if err := transactions.UpdateTransactionStatus(ctx, db.AllMerchants, theirs.ID, db.DEPOSIT, db.SENT, db.FAILED); err != nil {
+			t.Errorf("Expected the operator to settle any transaction, got %v", err)
+		}
+		if all, err := transactions.GetTransactions(ctx, db.AllMerchants); err != nil || len(all) <= len(mine) {
+			t.Errorf("Expected the operator to see more than one merchant's transactions, got %d and error %v", len(all), err)
+		}
+	})
+
+	t.Run("APIKeys", func(t *testing.T) {
+		keys := store.APIKeys()
+		key := db.APIKey{
+			ID:         fmt.Sprintf("key_conf_%d", time.Now().UnixNano()),
+			MerchantID: f.merchant.ID,
+			Name:       "Conformance",
+			Scopes:     []string{"deposit:create", "transactions:read"},
+			SecretHash: strings.Repeat("a", 64),
+		}
+		if err := keys.CreateAPIKey(ctx, &key); err != nil {
+			t.Fatal(err)
+		}
+		if duplicate := key; keys.CreateAPIKey(ctx, &duplicate) == nil {
+			t.Errorf("Expected a duplicate key ID to be rejected")
+		}
+
+		got, err := keys.GetAPIKey(ctx, key.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.MerchantID != f.merchant.ID || got.Name != key.Name || len(got.Scopes) != 2 || got.Scopes[1] != "transactions:read" || got.SecretHash != key.SecretHash || got.SigningSecret != "" || got.CreatedAt.IsZero() || !got.Active(time.Now()) {
+			t.Errorf("Unexpected key %+v", got)
+		}
+		if _, err := keys.GetAPIKey(ctx, "key_missing"); !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for a missing key, got %v", err)
+		}
+		if len(got.Roles) != 0 {
+			t.Errorf("Expected a merchant key without roles, got %v", got.Roles)
+		}
+		operator := db.APIKey{ID: key.ID + "_op", Name: "Operator", Roles: []string{"finance", "viewer"}, SecretHash: key.SecretHash}
+		if err := keys.CreateAPIKey(ctx, &operator); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := keys.GetAPIKey(ctx, operator.ID); err != nil || got.MerchantID != 0 || len(got.Roles) != 2 || got.Roles[1] != "viewer" {
+			t.Errorf("Expected an operator key with its roles and without a merchant, got %+v and error %v", got, err)
+		}
+
+		// revoking again later keeps the earlier time
+		revokeAt := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+		if err := keys.RevokeAPIKey(ctx, key.ID, revokeAt); err != nil {
+			t.Fatal(err)
+		}
+		if err := keys.RevokeAPIKey(ctx, key.ID, revokeAt.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		got, _ = keys.GetAPIKey(ctx, key.ID)
+		if got.RevokedAt == nil || !got.RevokedAt.Equal(revokeAt) || !got.Active(time.Now()) || got.Active(revokeAt) {
+			t.Errorf("Expected the key to stay active until %s, got %+v", revokeAt, got)
+		}
+		if err := keys.RevokeAPIKey(ctx, "key_missing", revokeAt); !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound revoking a missing key, got %v", err)
+		}
+
+		all, err := keys.GetAPIKeys(ctx)
+		if err != nil || len(all) < 2 || all[len(all)-2].ID != key.ID {
+			t.Errorf("Expected the keys oldest first, got %+v and error %v", all, err)
+		}
+	})
+
+	t.Run("InTx", func(t *testing.T) {
+		var committed, rolledBack db.Transaction
+		err := store.InTx(ctx, func(tx db.Store) error {
+			committed = db.Transaction{MerchantID: f.merchant.ID, Amount: decimal.NewFromInt(1), Type: db.DEPOSIT, Status: db.SENT, UserID: f.user.ID, GatewayID: f.xml.ID, CountryID: f.routed.ID}
+			return tx.Transactions().CreateTransaction(ctx, &committed)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Transactions().GetTransaction(ctx, f.merchant.ID, committed.ID, db.DEPOSIT); err != nil {
+			t.Errorf("Expected committed transaction to exist, got %v", err)
+		}
+
+		failure := errors.New("publish failed")
+		err = store.InTx(ctx, func(tx db.Store) error {
+			rolledBack = db.Transaction{MerchantID: f.merchant.ID, Amount: decimal.NewFromInt(2), Type: db.DEPOSIT, Status: db.SENT, UserID: f.user.ID, GatewayID: f.xml.ID, CountryID: f.routed.ID}
+			if err := tx.Transactions().CreateTransaction(ctx, &rolledBack); err != nil {
+				return err
+			}
+			if _, err := tx.Transactions().GetTransaction(ctx, f.merchant.ID, rolledBack.ID, db.DEPOSIT); err != nil {
+				t.Errorf("Expected the transaction to be visible inside InTx, got %v", err)
+			}
+			return failure
+		})
+		if !errors.Is(err, failure) {
+			t.Errorf("Expected InTx to return fn's error, got %v", err)
+		}
+		if _, err := store.Transactions().GetTransaction(ctx, f.merchant.ID, rolledBack.ID, db.DEPOSIT); !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("Expected rolled back transaction to be gone, got %v", err)
+		}
+	})
+
+	t.Run("Confirmations", func(t *testing.T) {
+		confirmations := store.Confirmations()
+		now := time.Now().UTC().Truncate(time.Second)
+		var drafts []db.Transaction
+		for i := 0; i < 2; i++ {
+			draft := db.Transaction{MerchantID: f.merchant.ID, Amount: decimal.NewFromInt(3), Type: db.WITHDRAWAL, Status: db.DRAFT, UserID: f.user.ID, GatewayID: f.json.ID, CountryID: f.routed.ID}
+			if err := store.Transactions().CreateTransaction(ctx, &draft); err != nil {
+				t.Fatal(err)
+			}
+			drafts = append(drafts, draft)
+		}
+		// the first one expired long ago, far enough to come before whatever else the store holds
+		expired := db.WithdrawalConfirmation{TransactionID: drafts[0].ID, Payload: "sealed", ExpiresAt: now.Add(-100 * 365 * 24 * time.Hour)}
+		pending := db.WithdrawalConfirmation{TransactionID: drafts[1].ID, Payload: "sealed", ExpiresAt: now.Add(time.Hour)}
+		for _, confirmation := range []*db.WithdrawalConfirmation{&expired, &pending} {
+			if err := confirmations.CreateConfirmation(ctx, confirmation); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if duplicate := pending; confirmations.CreateConfirmation(ctx, &duplicate) == nil {
+			t.Errorf("Expected a second confirmation of the same transaction to be rejected")
+		}
+
+		got, err := confirmations.GetConfirmation(ctx, pending.TransactionID)
+		if err != nil || got.Payload != "sealed" || got.Attempts != 0 || !got.ExpiresAt.Equal(pending.ExpiresAt) || got.CreatedAt.IsZero() {
+			t.Errorf("Unexpected confirmation %+v and error %v", got, err)
+		}
+		if _, err := confirmations.GetConfirmation(ctx, -1); !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for a missing confirmation, got %v", err)
+		}
+		for want := 1; want <= 2; want++ {
+			if attempts, err := confirmations.AddConfirmationAttempt(ctx, pending.TransactionID); err != nil || attempts != want {
+				t.Errorf("Expected attempt %d, got %d and error %v", want, attempts, err)
+			}
+		}
+
+		due, err := confirmations.GetExpiredConfirmations(ctx, now, 1)
+		if err != nil || len(due) != 1 || due[0].TransactionID != expired.TransactionID {
+			t.Errorf("Expected only the expired confirmation, got %+v and error %v", due, err)
+		}
+
+		if err := confirmations.DeleteConfirmation(ctx, expired.TransactionID); err != nil {
+			t.Fatal(err)
+		}
+		if err := confirmations.DeleteConfirmation(ctx, expired.TransactionID); !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound deleting twice, got %v", err)
+		}
+		if _, err := confirmations.AddConfirmationAttempt(ctx, expired.TransactionID); !errors.Is(err, db.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound counting an attempt of a deleted confirmation, got %v", err)
+		}
+
+		// expiring moves the draft out of DRAFT for good
+		if err := store.Transactions().UpdateTransactionStatus(ctx, f.merchant.ID, drafts[0].ID, db.WITHDRAWAL, db.DRAFT, db.EXPIRED); err != nil {
+			t.Errorf("Expected the draft to expire, got %v", err)
+		}
+	})
+
+	t.Run("AuditLog", func(t *testing.T) {
+		audit := store.AuditLog()
+		entityID := fmt.Sprintf("conf_%d", time.Now().UnixNano())
+		first := db.AuditEntry{Actor: "key_conf", Action: "create", Entity: "transaction", EntityID: entityID, RequestID: "req-1",
+			After: []byte(`{"status": "SENT", "amount": "10"}`)}
+		if err := audit.AppendAuditEntry(ctx, &first); err != nil {
+			t.Fatal(err)
+		}
+		second := db.AuditEntry{Actor: "alice", Roles: []string{"ops"}, Action: "update", Entity: "transaction", EntityID: entityID,
+			Before: []byte(`{"status":"SENT"}`), After: []byte(`{"status":"SUCCESS"}`)}
+		err := store.InTx(ctx, func(tx db.Store) error {
+			return tx.AuditLog().AppendAuditEntry(ctx, &second)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if second.ID != first.ID+1 || second.PrevHash != first.Hash || second.Hash == "" {
+			t.Errorf("Expected the second entry to follow the first, got %+v after %+v", second, first)
+		}
+
+		failure := errors.New("rolled back")
+		err = store.InTx(ctx, func(tx db.Store) error {
+			discarded := db.AuditEntry{Actor: "alice", Action: "delete", Entity: "transaction", EntityID: entityID}
+			if err := tx.AuditLog().AppendAuditEntry(ctx, &discarded); err != nil {
+				return err
+			}
+			return failure
+		})
+		if !errors.Is(err, failure) {
+			t.Errorf("Expected InTx to return fn's error, got %v", err)
+		}
+
+		entries, err := audit.GetAuditEntries(ctx, db.AuditFilter{Entity: "transaction", EntityID: entityID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 2 || entries[0].ID != first.ID || entries[1].ID != second.ID {
+			t.Fatalf("Expected both committed entries, got %+v", entries)
+		}
+		got := entries[0]
+		if got.Actor != "key_conf" || got.RequestID != "req-1" || len(got.Roles) != 0 || got.Before != nil || string(got.After) != `{"status":"SENT","amount":"10"}` || !got.CreatedAt.Equal(first.CreatedAt) {
+			t.Errorf("Unexpected entry %+v", got)
+		}
+		if got := entries[1]; len(got.Roles) != 1 || string(got.Before) != `{"status":"SENT"}` {
+			t.Errorf("Unexpected entry %+v", got)
+		}
+		// the entries read back hash the same as when they were written
+		for i, written := range []db.AuditEntry{first, second} {
+			if hash := entries[i].ComputeHash(); hash != written.Hash || entries[i].Hash != written.Hash || entries[i].PrevHash != written.PrevHash {
+				t.Errorf("Expected entry %d to match its hash %s, got %s for %+v", written.ID, written.Hash, hash, entries[i])
+			}
+		}
+
+		if page, err := audit.GetAuditEntries(ctx, db.AuditFilter{EntityID: entityID, AfterID: first.ID, Limit: 1}); err != nil || len(page) != 1 || page[0].ID != second.ID {
+			t.Errorf("Expected the page after the first entry, got %+v and error %v", page, err)
+		}
+		if none, err := audit.GetAuditEntries(ctx, db.AuditFilter{EntityID: entityID, Actor: "nobody"}); err != nil || len(none) != 0 {
+			t.Errorf("Expected no entries for another actor, got %+v and error %v", none, err)
+		}
+	})
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsCountry(countries []db.Country, id int) bool {
+	for _, country := range countries {
+		if country.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -2,12 +2,11 @@ package db
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 )
 
-func CurrencySupportedInCountry(ctx context.Context, db *sql.DB, currencySymbol string, countryID int) (bool, error) {
-	row := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM country_currency cc LEFT JOIN currencies cu on cc.currency_id = cu.id WHERE cc.country_id = $1 AND cu.symbol = $2", countryID, currencySymbol)
+func CurrencySupportedInCountry(ctx context.Context, db Queryer, currencySymbol string, countryID int) (bool, error) {
+	row := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM country_currency cc LEFT JOIN currencies cu on cc.currency_id = cu.id JOIN countries co on cc.country_id = co.id WHERE cc.country_id = $1 AND cu.symbol = $2 AND cu.enabled AND co.enabled", countryID, currencySymbol)
 	if row.Err() != nil {
 		return false, row.Err()
 	}
@@ -19,8 +18,8 @@ func CurrencySupportedInCountry(ctx context.Context, db *sql.DB, currencySymbol
 	return cnt > 0, nil
 }
 
-func GetSupportedCurrenciesFromCountry(ctx context.Context, db *sql.DB, countryID int) ([]Currency, error) {
-	rows, err := db.QueryContext(ctx, "select cu.id, cu.symbol from country_currency cc join currencies cu on cc.currency_id = cu.id where cc.country_id = $1", countryID)
+func GetSupportedCurrenciesFromCountry(ctx context.Context, db Queryer, countryID int) ([]Currency, error) {
+	rows, err := db.QueryContext(ctx, "select cu.id, cu.symbol, cu.enabled from country_currency cc join currencies cu on cc.currency_id = cu.id where cc.country_id = $1 order by cu.id", countryID)
 	if err != nil {
 		return nil, err
 	}
@@ -29,7 +28,7 @@ func GetSupportedCurrenciesFromCountry(ctx context.Context, db *sql.DB, countryI
 	var currencies []Currency
 	for rows.Next() {
 		var currency Currency
-		if err := rows.Scan(&currency.ID, &currency.Symbol); err != nil {
+		if err := rows.Scan(&currency.ID, &currency.Symbol, &currency.Enabled); err != nil {
 			return nil, err
 		}
 		currencies = append(currencies, currency)
@@ -38,18 +37,79 @@ func GetSupportedCurrenciesFromCountry(ctx context.Context, db *sql.DB, countryI
 	return currencies, nil
 }
 
-func GetRandomGateway(ctx context.Context, db *sql.DB, countryID int, currency, dataformat string) (Gateway, error) {
-	rows, err := db.Query("SELECT g.id, g.name, g.data_format_supported, g.created_at, g.updated_at FROM gateway_country_currency g WHERE g.country_id = $1 and g.currency_symbol = $2 and g.data_format_supported = $3 ORDER BY random() LIMIT 1", countryID, currency, dataformat)
+// Picks a random gateway serving the country and currency. The client's data format plays no part in routing,
+// messages are translated into a format the gateway supports before they are published.
+func GetRandomGateway(ctx context.Context, db Queryer, countryID int, currency string) (Gateway, error) {
+	rows, err := db.QueryContext(ctx, "SELECT g.id, g.name, g.data_format_supported, g.enabled, g.created_at, g.updated_at FROM gateway_country_currency g WHERE g.country_id = $1 and g.currency_symbol = $2 and g.enabled and g.country_enabled and g.currency_enabled ORDER BY random() LIMIT 1", countryID, currency)
 	if err != nil {
 		return Gateway{}, fmt.Errorf("failed to get gateway: %v", err)
 	}
 	defer rows.Close()
 	if !rows.Next() {
-		return Gateway{}, fmt.Errorf("no gateway found for country %d, currency %s and format %s", countryID, currency, dataformat)
+		return Gateway{}, fmt.Errorf("no gateway found for country %d and currency %s: %w", countryID, currency, ErrNotFound)
 	}
 	var gateway Gateway
-	if err := rows.Scan(&gateway.ID, &gateway.Name, &gateway.DataFormatSupported, &gateway.CreatedAt, &gateway.UpdatedAt); err != nil {
+	if err := rows.Scan(&gateway.ID, &gateway.Name, &gateway.DataFormatSupported, &gateway.Enabled, &gateway.CreatedAt, &gateway.UpdatedAt); err != nil {
 		return Gateway{}, fmt.Errorf("failed to scan gateway: %v", err)
 	}
+	rows.Close()
+
+	formats, err := GetGatewayDataFormats(ctx, db, gateway.ID)
+	if err != nil {
+		return Gateway{}, err
+	}
+	gateway.DataFormats = dedupeFormats(append([]string{gateway.DataFormatSupported}, formats...))
 	return gateway, nil
 }
+
+// every enabled gateway serving the country and currency, ordered by ID
+func GetGatewaysFor(ctx context.Context, db Queryer, countryID int, currency string) ([]Gateway, error) {
+	rows, err := db.QueryContext(ctx, "SELECT g.id, g.name, g.data_format_supported, g.enabled, g.created_at, g.updated_at FROM gateway_country_currency g WHERE g.country_id = $1 and g.currency_symbol = $2 and g.enabled and g.country_enabled and g.currency_enabled ORDER BY g.id", countryID, currency)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get gateways: %v", err)
+	}
+	defer rows.Close()
+
+	var gateways []Gateway
+	for rows.Next() {
+		var gateway Gateway
+		if err := rows.Scan(&gateway.ID, &gateway.Name, &gateway.DataFormatSupported, &gateway.Enabled, &gateway.CreatedAt, &gateway.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan gateway: %v", err)
+		}
+		gateways = append(gateways, gateway)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range gateways {
+		formats, err := GetGatewayDataFormats(ctx, db, gateways[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		gateways[i].DataFormats = dedupeFormats(append([]string{gateways[i].DataFormatSupported}, formats...))
+	}
+	return gateways, nil
+}
+
+func GetGatewayDataFormats(ctx context.Context, db Queryer, gatewayID int) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT data_format FROM gateway_data_formats WHERE gateway_id = $1 ORDER BY data_format", gatewayID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data formats for gateway %d: %v", gatewayID, err)
+	}
+	defer rows.Close()
+
+	var formats []string
+	for rows.Next() {
+		var format string
+		if err := rows.Scan(&format); err != nil {
+			return nil, fmt.Errorf("failed to scan data format: %v", err)
+		}
+		formats = append(formats, format)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return formats, nil
+}
@@ -34,18 +34,43 @@ func TestGetSupportedCurrenciesFromCountry(t *testing.T) {
 }
 
 func TestGetRandomGateway(t *testing.T) {
-	gateway1, err := GetRandomGateway(context.Background(), db, 1, "USD", "application/json")
-	if err != nil {
-		t.Fatalf("Error getting random gateway: %v", err)
+	seen := map[string]Gateway{}
+	for i := 0; i < 50 && len(seen) < 2; i++ {
+		gateway, err := GetRandomGateway(context.Background(), db, 1, "USD")
+		if err != nil {
+			t.Fatalf("Error getting random gateway: %v", err)
+		}
+		seen[gateway.Name] = gateway
 	}
-	gateway2, err := GetRandomGateway(context.Background(), db, 1, "USD", "application/xml")
-	if err != nil {
-		t.Fatalf("Error getting random gateway: %v", err)
+
+	gateway1, ok := seen["Gateway 1"]
+	if !ok {
+		t.Fatalf("Expected Gateway 1 to be routable regardless of data format")
+	}
+	gateway2, ok := seen["Gateway 2"]
+	if !ok {
+		t.Fatalf("Expected Gateway 2 to be routable regardless of data format")
+	}
+	if len(gateway1.DataFormats) != 1 || gateway1.DataFormats[0] != "application/json" {
+		t.Errorf("Expected Gateway 1 to support only application/json, got %v", gateway1.DataFormats)
+	}
+	if len(gateway2.DataFormats) != 2 {
+		t.Errorf("Expected Gateway 2 to support two formats, got %v", gateway2.DataFormats)
+	}
+}
+
+func TestGatewayOutboundFormat(t *testing.T) {
+	gateway := Gateway{
+		DataFormatSupported: "application/xml",
+		DataFormats:         []string{"application/xml", "application/x-protobuf"},
+	}
+	if got := gateway.OutboundFormat("application/json"); got != "application/xml" {
+		t.Errorf("Expected the preferred format application/xml, got %s", got)
 	}
-	if gateway1.Name != "Gateway 1" {
-		t.Fatalf("Expected Gateway 1, got %s", gateway1.Name)
+	if got := gateway.OutboundFormat("text/xml"); got != "application/xml" {
+		t.Errorf("Expected text/xml to match application/xml, got %s", got)
 	}
-	if gateway2.Name != "Gateway 2" {
-		t.Fatalf("Expected Gateway 2, got %s", gateway2.Name)
+	if got := gateway.OutboundFormat("application/x-protobuf"); got != "application/x-protobuf" {
+		t.Errorf("Expected the client's format to be kept, got %s", got)
 	}
 }
@@ -0,0 +1,654 @@
+// Package memory is an in-memory db.Store for tests and local development. It behaves like the Postgres store,
+// which the conformance suite in db/dbtest checks, but keeps nothing once the process exits.
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"payment-gateway/db"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type data struct {
+	merchants    map[int]db.Merchant
+	users        map[int]db.User
+	gateways     map[int]db.Gateway
+	countries    map[int]db.Country
+	currencies   map[int]db.Currency
+	transactions map[int]db.Transaction
+	apiKeys      map[string]db.APIKey
+	// in ID order, the chain is kept as in Postgres
+	audit []db.AuditEntry
+	// by transaction ID
+	confirmations map[int]db.WithdrawalConfirmation
+	// country IDs by gateway ID
+	gatewayCountries map[int]map[int]bool
+	// currency IDs by country ID
+	countryCurrencies map[int]map[int]bool
+	// last ID handed out per table, IDs start at 1 like a SERIAL column
+	lastID map[string]int
+}
+
+func newData() *data {
+	return &data{
+		merchants:         map[int]db.Merchant{},
+		users:             map[int]db.User{},
+		gateways:          map[int]db.Gateway{},
+		countries:         map[int]db.Country{},
+		currencies:        map[int]db.Currency{},
+		transactions:      map[int]db.Transaction{},
+		apiKeys:           map[string]db.APIKey{},
+		confirmations:     map[int]db.WithdrawalConfirmation{},
+		gatewayCountries:  map[int]map[int]bool{},
+		countryCurrencies: map[int]map[int]bool{},
+		lastID:            map[string]int{},
+	}
+}
+
+func (d *data) clone() *data {
+	c := newData()
+	for k, v := range d.merchants {
+		c.merchants[k] = v
+	}
+	for k, v := range d.users {
+		c.users[k] = v
+	}
+	for k, v := range d.gateways {
+		v.DataFormats = append([]string(nil), v.DataFormats...)
+		c.gateways[k] = v
+	}
+	for k, v := range d.countries {
+		c.countries[k] = v
+	}
+	for k, v := range d.currencies {
+		c.currencies[k] = v
+	}
+	for k, v := range d.transactions {
+		c.transactions[k] = v
+	}
+	for k, v := range d.apiKeys {
+		c.apiKeys[k] = copyAPIKey(v)
+	}
+	for k, v := range d.gatewayCountries {
+		c.gatewayCountries[k] = map[int]bool{}
+		for id := range v {
+			c.gatewayCountries[k][id] = true
+		}
+	}
+	for k, v := range d.countryCurrencies {
+		c.countryCurrencies[k] = map[int]bool{}
+		for id := range v {
+			c.countryCurrencies[k][id] = true
+		}
+	}
+	for k, v := range d.lastID {
+		c.lastID[k] = v
+	}
+	for k, v := range d.confirmations {
+		c.confirmations[k] = v
+	}
+	// entries are never changed, sharing them is safe
+	c.audit = append([]db.AuditEntry(nil), d.audit...)
+	return c
+}
+
+func (d *data) nextID(table string) int {
+	d.lastID[table]++
+	return d.lastID[table]
+}
+
+type Store struct {
+	mu   *sync.Mutex
+	data *data
+	inTx bool
+}
+
+// The store starts out with the default merchant, like a migrated database
+func NewStore() *Store {
+	d := newData()
+	d.merchants[db.DefaultMerchantID] = db.Merchant{ID: db.DefaultMerchantID, Name: "default", CreatedAt: time.Now()}
+	d.lastID["merchants"] = db.DefaultMerchantID
+	return &Store{mu: &sync.Mutex{}, data: d}
+}
+
+func (s *Store) Users() db.UserRepository                  { return s }
+func (s *Store) Gateways() db.GatewayRepository            { return s }
+func (s *Store) ReferenceData() db.ReferenceDataRepository { return s }
+func (s *Store) Transactions() db.TransactionRepository    { return s }
+func (s *Store) APIKeys() db.APIKeyRepository              { return s }
+func (s *Store) Merchants() db.MerchantRepository          { return s }
+func (s *Store) AuditLog() db.AuditRepository              { return s }
+func (s *Store) Confirmations() db.ConfirmationRepository  { return s }
+
+// Inside InTx the store lock is already held
+func (s *Store) lock() func() {
+	if s.inTx {
+		return func() {}
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+// fn works on a copy that replaces the store's data when it succeeds. Transactions are serialized, which is
+// stricter than Postgres but gives the same all-or-nothing result.
+func (s *Store) InTx(ctx context.Context, fn func(store db.Store) error) error {
+	unlock := s.lock()
+	defer unlock()
+
+	tx := &Store{mu: s.mu, data: s.data.clone(), inTx: true}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	*s.data = *tx.data
+	return nil
+}
+
+func (s *Store) CreateUser(ctx context.Context, user *db.User) error {
+	defer s.lock()()
+	if _, ok := s.data.merchants[user.MerchantID]; !ok {
+		return fmt.Errorf("failed to insert user: merchant %d %w", user.MerchantID, db.ErrNotFound)
+	}
+	for _, existing := range s.data.users {
+		if existing.MerchantID == user.MerchantID && (existing.Username == user.Username || existing.Email == user.Email) {
+			return fmt.Errorf("failed to insert user: username or email already exists")
+		}
+	}
+	now := time.Now()
+	user.ID = s.data.nextID("users")
+	user.CreatedAt, user.UpdatedAt = now, now
+	s.data.users[user.ID] = *user
+	return nil
+}
+
+func (s *Store) GetUser(ctx context.Context, merchantID, userID int) (db.User, error) {
+	defer s.lock()()
+	user, ok := s.data.users[userID]
+	if !ok || user.MerchantID != merchantID {
+		return db.User{}, fmt.Errorf("user %d: %w", userID, db.ErrNotFound)
+	}
+	return user, nil
+}
+
+func (s *Store) CreateGateway(ctx context.Context, gateway *db.Gateway) error {
+	defer s.lock()()
+	for _, existing := range s.data.gateways {
+		if existing.Name == gateway.Name {
+			return fmt.Errorf("failed to insert gateway: %q already exists", gateway.Name)
+		}
+	}
+	now := time.Now()
+	gateway.ID = s.data.nextID("gateways")
+	gateway.Enabled = true
+	gateway.CreatedAt, gateway.UpdatedAt = now, now
+	gateway.DataFormats = dedupe(append([]string{gateway.DataFormatSupported}, gateway.DataFormats...))
+	s.data.gateways[gateway.ID] = *gateway
+	return nil
+}
+
+func (s *Store) GetGateway(ctx context.Context, gatewayID int) (db.Gateway, error) {
+	defer s.lock()()
+	gateway, ok := s.data.gateways[gatewayID]
+	if !ok {
+		return db.Gateway{}, fmt.Errorf("gateway %d: %w", gatewayID, db.ErrNotFound)
+	}
+	gateway.DataFormats = append([]string(nil), gateway.DataFormats...)
+	return gateway, nil
+}
+
+func (s *Store) GetGateways(ctx context.Context) ([]db.Gateway, error) {
+	defer s.lock()()
+	var gateways []db.Gateway
+	for _, id := range sortedIDs(s.data.gateways) {
+		gateway := s.data.gateways[id]
+		gateway.DataFormats = append([]string(nil), gateway.DataFormats...)
+		gateways = append(gateways, gateway)
+	}
+	return gateways, nil
+}
+
+func (s *Store) GetRandomGateway(ctx context.Context, countryID int, currency string) (db.Gateway, error) {
+	defer s.lock()()
+	candidates := s.gatewaysFor(countryID, currency)
+	if len(candidates) == 0 {
+		return db.Gateway{}, fmt.Errorf("no gateway found for country %d and currency %s: %w", countryID, currency, db.ErrNotFound)
+	}
+	return candidates[rand.Intn(len(candidates))], nil
+}
+
+func (s *Store) GetGatewaysFor(ctx context.Context, countryID int, currency string) ([]db.Gateway, error) {
+	defer s.lock()()
+	return s.gatewaysFor(countryID, currency), nil
+}
+
+func (s *Store) gatewaysFor(countryID int, currency string) []db.Gateway {
+	var candidates []db.Gateway
+	country, ok := s.data.countries[countryID]
+	if ok && country.Enabled && s.currencyMapped(countryID, currency, false) {
+		for _, id := range sortedIDs(s.data.gateways) {
+			gateway := s.data.gateways[id]
+			if gateway.Enabled && s.data.gatewayCountries[id][countryID] {
+				gateway.DataFormats = append([]string(nil), gateway.DataFormats...)
+				candidates = append(candidates, gateway)
+			}
+		}
+	}
+	return candidates
+}
+
+func (s *Store) AddGatewayCountry(ctx context.Context, gatewayID, countryID int) error {
+	defer s.lock()()
+	if _, ok := s.data.gateways[gatewayID]; !ok {
+		return fmt.Errorf("failed to map gateway %d to country %d: gateway %w", gatewayID, countryID, db.ErrNotFound)
+	}
+	if _, ok := s.data.countries[countryID]; !ok {
+		return fmt.Errorf("failed to map gateway %d to country %d: country %w", gatewayID, countryID, db.ErrNotFound)
+	}
+	if s.data.gatewayCountries[gatewayID] == nil {
+		s.data.gatewayCountries[gatewayID] = map[int]bool{}
+	}
+	s.data.gatewayCountries[gatewayID][countryID] = true
+	return nil
+}
+
+func (s *Store) CreateCountry(ctx context.Context, country *db.Country) error {
+	defer s.lock()()
+	for _, existing := range s.data.countries {
+		if existing.Name == country.Name || existing.Code == country.Code {
+			return fmt.Errorf("failed to insert country: name or code already exists")
+		}
+	}
+	now := time.Now()
+	country.ID = s.data.nextID("countries")
+	country.Enabled = true
+	country.CreatedAt, country.UpdatedAt = now, now
+	s.data.countries[country.ID] = *country
+	return nil
+}
+
+func (s *Store) GetCountry(ctx context.Context, countryID int) (db.Country, error) {
+	defer s.lock()()
+	country, ok := s.data.countries[countryID]
+	if !ok {
+		return db.Country{}, fmt.Errorf("country %d: %w", countryID, db.ErrNotFound)
+	}
+	return country, nil
+}
+
+func (s *Store) GetCountries(ctx context.Context) ([]db.Country, error) {
+	defer s.lock()()
+	var countries []db.Country
+	for _, id := range sortedIDs(s.data.countries) {
+		countries = append(countries, s.data.countries[id])
+	}
+	return countries, nil
+}
+
+func (s *Store) CreateCurrency(ctx context.Context, currency *db.Currency) error {
+	defer s.lock()()
+	for _, existing := range s.data.currencies {
+		if existing.Symbol == currency.Symbol {
+			return fmt.Errorf("failed to insert currency: %s already exists", currency.Symbol)
+		}
+	}
+	currency.ID = s.data.nextID("currencies")
+	currency.Enabled = true
+	s.data.currencies[currency.ID] = *currency
+	return nil
+}
+
+func (s *Store) GetCurrencies(ctx context.Context) ([]db.Currency, error) {
+	defer s.lock()()
+	var currencies []db.Currency
+	for _, id := range sortedIDs(s.data.currencies) {
+		currencies = append(currencies, s.data.currencies[id])
+	}
+	return currencies, nil
+}
+
+func (s *Store) AddCountryCurrency(ctx context.Context, countryID, currencyID int) error {
+	defer s.lock()()
+	if _, ok := s.data.countries[countryID]; !ok {
+		return fmt.Errorf("failed to map country %d to currency %d: country %w", countryID, currencyID, db.ErrNotFound)
+	}
+	if _, ok := s.data.currencies[currencyID]; !ok {
+		return fmt.Errorf("failed to map country %d to currency %d: currency %w", countryID, currencyID, db.ErrNotFound)
+	}
+	if s.data.countryCurrencies[countryID] == nil {
+		s.data.countryCurrencies[countryID] = map[int]bool{}
+	}
+	s.data.countryCurrencies[countryID][currencyID] = true
+	return nil
+}
+
+// true when the country accepts the currency and, unless includeDisabled, the currency is enabled
+func (s *Store) currencyMapped(countryID int, symbol string, includeDisabled bool) bool {
+	for currencyID := range s.data.countryCurrencies[countryID] {
+		currency := s.data.currencies[currencyID]
+		if currency.Symbol == symbol && (includeDisabled || currency.Enabled) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) CurrencySupportedInCountry(ctx context.Context, currencySymbol string, countryID int) (bool, error) {
+	defer s.lock()()
+	country, ok := s.data.countries[countryID]
+	return ok && country.Enabled && s.currencyMapped(countryID, currencySymbol, false), nil
+}
+
+func (s *Store) GetSupportedCurrenciesFromCountry(ctx context.Context, countryID int) ([]db.Currency, error) {
+	defer s.lock()()
+	var currencies []db.Currency
+	for _, id := range sortedIDs(s.data.currencies) {
+		if s.data.countryCurrencies[countryID][id] {
+			currencies = append(currencies, s.data.currencies[id])
+		}
+	}
+	return currencies, nil
+}
+
+func (s *Store) CreateTransaction(ctx context.Context, transaction *db.Transaction) error {
+	defer s.lock()()
+	if _, ok := s.data.merchants[transaction.MerchantID]; !ok {
+		return fmt.Errorf("failed to insert transaction: merchant %d %w", transaction.MerchantID, db.ErrNotFound)
+	}
+	transaction.ID = s.data.nextID("transactions")
+	transaction.CreatedAt = time.Now()
+	s.data.transactions[transaction.ID] = *transaction
+	return nil
+}
+
+func (s *Store) GetTransaction(ctx context.Context, merchantID, transactionID int, txType db.TransactionType) (db.Transaction, error) {
+	defer s.lock()()
+	transaction, ok := s.data.transactions[transactionID]
+	if !ok || transaction.Type != txType || !merchantMatches(merchantID, transaction.MerchantID) {
+		return db.Transaction{}, fmt.Errorf("no transaction found with id %d: %w", transactionID, db.ErrNotFound)
+	}
+	return transaction, nil
+}
+
+func (s *Store) GetTransactions(ctx context.Context, merchantID int) ([]db.Transaction, error) {
+	defer s.lock()()
+	var transactions []db.Transaction
+	for _, id := range sortedIDs(s.data.transactions) {
+		if transaction := s.data.transactions[id]; merchantMatches(merchantID, transaction.MerchantID) {
+			transactions = append(transactions, transaction)
+		}
+	}
+	return transactions, nil
+}
+
+func (s *Store) UpdateTransactionStatus(ctx context.Context, merchantID, transactionID int, txType db.TransactionType, from, to db.TransactionStatus) error {
+	defer s.lock()()
+	transaction, ok := s.data.transactions[transactionID]
+	if !ok || transaction.Type != txType || !merchantMatches(merchantID, transaction.MerchantID) {
+		return fmt.Errorf("no transaction found with id %d: %w", transactionID, db.ErrNotFound)
+	}
+	if transaction.Status != from {
+		return fmt.Errorf("transaction %d is no longer %s: %w", transactionID, from, db.ErrConflict)
+	}
+	transaction.Status = to
+	s.data.transactions[transactionID] = transaction
+	return nil
+}
+
+// Callers for the same original are serialized like every other write
+func (s *Store) GetReversedAmount(ctx context.Context, originalID int, types ...db.TransactionType) (decimal.Decimal, error) {
+	defer s.lock()()
+	if _, ok := s.data.transactions[originalID]; !ok {
+		return decimal.Zero, fmt.Errorf("no transaction found with id %d: %w", originalID, db.ErrNotFound)
+	}
+	sum := decimal.Zero
+	for _, transaction := range s.data.transactions {
+		if transaction.OriginalID != originalID || !hasType(types, transaction.Type) {
+			continue
+		}
+		if transaction.Status == db.FAILED || transaction.Status == db.CANCELLED || transaction.Status == db.EXPIRED {
+			continue
+		}
+		sum = sum.Add(transaction.Amount)
+	}
+	return sum, nil
+}
+
+func hasType(types []db.TransactionType, typ db.TransactionType) bool {
+	for _, t := range types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) CreateAPIKey(ctx context.Context, key *db.APIKey) error {
+	defer s.lock()()
+	if _, ok := s.data.apiKeys[key.ID]; ok {
+		return fmt.Errorf("failed to insert API key: %s already exists", key.ID)
+	}
+	if _, ok := s.data.merchants[key.MerchantID]; key.MerchantID != 0 && !ok {
+		return fmt.Errorf("failed to insert API key: merchant %d %w", key.MerchantID, db.ErrNotFound)
+	}
+	key.CreatedAt = time.Now()
+	key.RevokedAt = nil
+	s.data.apiKeys[key.ID] = copyAPIKey(*key)
+	return nil
+}
+
+func (s *Store) GetAPIKey(ctx context.Context, keyID string) (db.APIKey, error) {
+	defer s.lock()()
+	key, ok := s.data.apiKeys[keyID]
+	if !ok {
+		return db.APIKey{}, fmt.Errorf("API key %s: %w", keyID, db.ErrNotFound)
+	}
+	return copyAPIKey(key), nil
+}
+
+func (s *Store) GetAPIKeys(ctx context.Context) ([]db.APIKey, error) {
+	defer s.lock()()
+	var keys []db.APIKey
+	for _, key := range s.data.apiKeys {
+		keys = append(keys, copyAPIKey(key))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, nil
+}
+
+func (s *Store) RevokeAPIKey(ctx context.Context, keyID string, at time.Time) error {
+	defer s.lock()()
+	key, ok := s.data.apiKeys[keyID]
+	if !ok {
+		return fmt.Errorf("API key %s: %w", keyID, db.ErrNotFound)
+	}
+	if key.RevokedAt == nil || at.Before(*key.RevokedAt) {
+		key.RevokedAt = &at
+	}
+	s.data.apiKeys[keyID] = key
+	return nil
+}
+
+func (s *Store) CreateMerchant(ctx context.Context, merchant *db.Merchant) error {
+	defer s.lock()()
+	for _, existing := range s.data.merchants {
+		if existing.Name == merchant.Name {
+			return fmt.Errorf("failed to insert merchant: %q already exists", merchant.Name)
+		}
+	}
+	merchant.ID = s.data.nextID("merchants")
+	merchant.CreatedAt = time.Now()
+	s.data.merchants[merchant.ID] = *merchant
+	return nil
+}
+
+func (s *Store) GetMerchant(ctx context.Context, merchantID int) (db.Merchant, error) {
+	defer s.lock()()
+	merchant, ok := s.data.merchants[merchantID]
+	if !ok {
+		return db.Merchant{}, fmt.Errorf("merchant %d: %w", merchantID, db.ErrNotFound)
+	}
+	return merchant, nil
+}
+
+func (s *Store) GetMerchants(ctx context.Context) ([]db.Merchant, error) {
+	defer s.lock()()
+	var merchants []db.Merchant
+	for _, id := range sortedIDs(s.data.merchants) {
+		merchants = append(merchants, s.data.merchants[id])
+	}
+	return merchants, nil
+}
+
+func (s *Store) AppendAuditEntry(ctx context.Context, entry *db.AuditEntry) error {
+	defer s.lock()()
+	var prev db.AuditEntry
+	if n := len(s.data.audit); n > 0 {
+		prev = s.data.audit[n-1]
+	}
+	if err := entry.Link(prev, time.Now()); err != nil {
+		return err
+	}
+	s.data.audit = append(s.data.audit, copyAuditEntry(*entry))
+	return nil
+}
+
+func (s *Store) GetAuditEntries(ctx context.Context, filter db.AuditFilter) ([]db.AuditEntry, error) {
+	defer s.lock()()
+	var entries []db.AuditEntry
+	for _, entry := range s.data.audit {
+		if filter.Limit > 0 && len(entries) == filter.Limit {
+			break
+		}
+		if entry.ID > filter.AfterID && auditMatches(entry, filter) {
+			entries = append(entries, copyAuditEntry(entry))
+		}
+	}
+	return entries, nil
+}
+
+func auditMatches(entry db.AuditEntry, filter db.AuditFilter) bool {
+	for _, c := range [][2]string{
+		{filter.Actor, entry.Actor}, {filter.Action, entry.Action}, {filter.Entity, entry.Entity},
+		{filter.EntityID, entry.EntityID}, {filter.RequestID, entry.RequestID},
+	} {
+		if c[0] != "" && c[0] != c[1] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Store) CreateConfirmation(ctx context.Context, confirmation *db.WithdrawalConfirmation) error {
+	defer s.lock()()
+	if _, ok := s.data.transactions[confirmation.TransactionID]; !ok {
+		return fmt.Errorf("failed to insert confirmation: transaction %d %w", confirmation.TransactionID, db.ErrNotFound)
+	}
+	if _, ok := s.data.confirmations[confirmation.TransactionID]; ok {
+		return fmt.Errorf("failed to insert confirmation: transaction %d already has one", confirmation.TransactionID)
+	}
+	confirmation.Attempts = 0
+	confirmation.CreatedAt = time.Now()
+	s.data.confirmations[confirmation.TransactionID] = *confirmation
+	return nil
+}
+
+func (s *Store) GetConfirmation(ctx context.Context, transactionID int) (db.WithdrawalConfirmation, error) {
+	defer s.lock()()
+	confirmation, ok := s.data.confirmations[transactionID]
+	if !ok {
+		return db.WithdrawalConfirmation{}, fmt.Errorf("confirmation of transaction %d: %w", transactionID, db.ErrNotFound)
+	}
+	return confirmation, nil
+}
+
+func (s *Store) AddConfirmationAttempt(ctx context.Context, transactionID int) (int, error) {
+	defer s.lock()()
+	confirmation, ok := s.data.confirmations[transactionID]
+	if !ok {
+		return 0, fmt.Errorf("confirmation of transaction %d: %w", transactionID, db.ErrNotFound)
+	}
+	confirmation.Attempts++
+	s.data.confirmations[transactionID] = confirmation
+	return confirmation.Attempts, nil
+}
+
+func (s *Store) DeleteConfirmation(ctx context.Context, transactionID int) error {
+	defer s.lock()()
+	if _, ok := s.data.confirmations[transactionID]; !ok {
+		return fmt.Errorf("confirmation of transaction %d: %w", transactionID, db.ErrNotFound)
+	}
+	delete(s.data.confirmations, transactionID)
+	return nil
+}
+
+func (s *Store) GetExpiredConfirmations(ctx context.Context, now time.Time, limit int) ([]db.WithdrawalConfirmation, error) {
+	defer s.lock()()
+	var expired []db.WithdrawalConfirmation
+	for _, confirmation := range s.data.confirmations {
+		if !confirmation.ExpiresAt.After(now) {
+			expired = append(expired, confirmation)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		if !expired[i].ExpiresAt.Equal(expired[j].ExpiresAt) {
+			return expired[i].ExpiresAt.Before(expired[j].ExpiresAt)
+		}
+		return expired[i].TransactionID < expired[j].TransactionID
+	})
+	if len(expired) > limit {
+		expired = expired[:limit]
+	}
+	return expired, nil
+}
+
+func merchantMatches(merchantID, rowMerchantID int) bool {
+	return merchantID == db.AllMerchants || merchantID == rowMerchantID
+}
+
+func copyAPIKey(key db.APIKey) db.APIKey {
+	key.Scopes = append([]string(nil), key.Scopes...)
+	key.Roles = append([]string(nil), key.Roles...)
+	if key.RevokedAt != nil {
+		revokedAt := *key.RevokedAt
+		key.RevokedAt = &revokedAt
+	}
+	return key
+}
+
+func copyAuditEntry(entry db.AuditEntry) db.AuditEntry {
+	entry.Roles = append([]string{}, entry.Roles...)
+	entry.Before = append(json.RawMessage(nil), entry.Before...)
+	entry.After = append(json.RawMessage(nil), entry.After...)
+	return entry
+}
+
+func sortedIDs[V any](m map[int]V) []int {
+	ids := make([]int, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func dedupe(formats []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, format := range formats {
+		if format != "" && !seen[format] {
+			seen[format] = true
+			out = append(out, format)
+		}
+	}
+	return out
+}
@@ -0,0 +1,13 @@
+package memory
+
+import (
+	"payment-gateway/db"
+	"payment-gateway/db/dbtest"
+	"testing"
+)
+
+func TestStoreConformance(t *testing.T) {
+	dbtest.TestStore(t, func(t *testing.T) db.Store {
+		return NewStore()
+	})
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// A brand operating on the gateway. Users, transactions, batches and API keys belong to one merchant, and the
+// queries reading them take the merchant's ID so a merchant never sees another's data.
+type Merchant struct {
+	ID   int
+	Name string
+	// where the codes confirming the merchant's withdrawals are posted, empty when it has no webhook
+	WebhookURL string
+	// signs what is posted to the webhook, encrypted by the service and never returned by the API
+	WebhookSecret string `json:"-" xml:"-"`
+	CreatedAt     time.Time
+}
+
+// The merchant data created before there were merchants was moved to. Requests made without an API key act for it.
+const DefaultMerchantID = 1
+
+// Passed as the merchant ID by callers acting for the operator rather than a merchant, i.e. gateways settling
+// transactions. Only the transaction lookups and updates accept it.
+const AllMerchants = -1
+
+type MerchantRepository interface {
+	CreateMerchant(ctx context.Context, merchant *Merchant) error
+	// wraps ErrNotFound when there is no such merchant
+	GetMerchant(ctx context.Context, merchantID int) (Merchant, error)
+	// ordered by ID
+	GetMerchants(ctx context.Context) ([]Merchant, error)
+}
+
+func CreateMerchant(ctx context.Context, db Queryer, merchant *Merchant) error {
+	err := db.QueryRowContext(ctx, `INSERT INTO merchants (name, webhook_url, webhook_secret, created_at) VALUES ($1, $2, $3, $4) RETURNING id, created_at`,
+		merchant.Name, merchant.WebhookURL, merchant.WebhookSecret, time.Now()).
+		Scan(&merchant.ID, &merchant.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert merchant: %w", err)
+	}
+	return nil
+}
+
+func GetMerchant(ctx context.Context, db Queryer, merchantID int) (Merchant, error) {
+	var merchant Merchant
+	err := db.QueryRowContext(ctx, `SELECT id, name, webhook_url, webhook_secret, created_at FROM merchants WHERE id = $1`, merchantID).
+		Scan(&merchant.ID, &merchant.Name, &merchant.WebhookURL, &merchant.WebhookSecret, &merchant.CreatedAt)
+	if err == sql.ErrNoRows {
+		return Merchant{}, fmt.Errorf("merchant %d: %w", merchantID, ErrNotFound)
+	}
+	if err != nil {
+		return Merchant{}, fmt.Errorf("failed to get merchant %d: %v", merchantID, err)
+	}
+	return merchant, nil
+}
+
+func GetMerchants(ctx context.Context, db Queryer) ([]Merchant, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id, name, webhook_url, webhook_secret, created_at FROM merchants ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch merchants: %v", err)
+	}
+	defer rows.Close()
+
+	var merchants []Merchant
+	for rows.Next() {
+		var merchant Merchant
+		if err := rows.Scan(&merchant.ID, &merchant.Name, &merchant.WebhookURL, &merchant.WebhookSecret, &merchant.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan merchant: %v", err)
+		}
+		merchants = append(merchants, merchant)
+	}
+	return merchants, rows.Err()
+}
+
+// Sets where the merchant's codes are posted and the encrypted secret signing them, both empty to remove the
+// webhook. Wraps ErrNotFound when there is no such merchant.
+func SetMerchantWebhook(ctx context.Context, db Execer, merchantID int, url, secret string) error {
+	return execExpectingRow(db, "merchant", merchantID, `UPDATE merchants SET webhook_url = $1, webhook_secret = $2 WHERE id = $3`, url, secret, merchantID)
+}
+
+// the condition restricting a query to the merchant passed as argument n, or to no merchant in particular for
+// AllMerchants
+func merchantCondition(n int) string {
+	return fmt.Sprintf("($%d = %d OR merchant_id = $%d)", n, AllMerchants, n)
+}
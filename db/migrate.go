@@ -0,0 +1,297 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Schema migrations are embedded from migrations/ and named <version>_<name>.up.sql, with an optional matching
+// .down.sql. Each migration runs in its own DB transaction and is recorded in schema_migrations together with
+// a checksum of its up script, so editing a migration after it has been applied is caught instead of ignored.
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// key for pg_advisory_lock shared by every instance of the service, "paym" in ASCII
+const migrationLockKey = 0x7061796d
+
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Reads the migrations in fsys, ordered by version
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, file := range files {
+		match := migrationFilePattern.FindStringSubmatch(path.Base(file))
+		if match == nil {
+			return nil, fmt.Errorf("migration %s is not named <version>_<name>.up.sql or .down.sql", file)
+		}
+		version, _ := strconv.Atoi(match[1])
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %v", file, err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(data)
+			sum := sha256.Sum256(data)
+			migration.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			migration.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrations bundled with the binary
+func EmbeddedMigrations() ([]Migration, error) {
+	sub, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return LoadMigrations(sub)
+}
+
+type appliedMigration struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+// Runs fn on a single connection holding the migration advisory lock, so instances starting at the same time
+// take turns rather than applying the same migration twice
+func withMigrationLock(ctx context.Context, db *sql.DB, fn func(conn *sql.Conn, applied map[int]appliedMigration) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		return fmt.Errorf("failed to take migration lock: %v", err)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		checksum CHAR(64) NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT version, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return fmt.Errorf("failed to read schema_migrations: %v", err)
+	}
+	applied := map[int]appliedMigration{}
+	for rows.Next() {
+		var version int
+		var m appliedMigration
+		if err := rows.Scan(&version, &m.checksum, &m.appliedAt); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan schema migration: %v", err)
+		}
+		applied[version] = m
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return fn(conn, applied)
+}
+
+// Checks that every applied migration is known and unchanged
+func verifyApplied(migrations []Migration, applied map[int]appliedMigration) error {
+	known := map[int]Migration{}
+	for _, migration := range migrations {
+		known[migration.Version] = migration
+	}
+	for version, m := range applied {
+		migration, ok := known[version]
+		if !ok {
+			return fmt.Errorf("migration %d is applied but unknown to this binary, it was probably applied by a newer version", version)
+		}
+		if migration.Checksum != m.checksum {
+			return fmt.Errorf("migration %d_%s was changed after it was applied", version, migration.Name)
+		}
+	}
+	return nil
+}
+
+func runMigration(ctx context.Context, conn *sql.Conn, script string, record func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := record(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Applies every migration that hasn't been applied yet, in version order, and returns them
+func MigrateUp(ctx context.Context, db *sql.DB, migrations []Migration) ([]Migration, error) {
+	var done []Migration
+	err := withMigrationLock(ctx, db, func(conn *sql.Conn, applied map[int]appliedMigration) error {
+		if err := verifyApplied(migrations, applied); err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			err := runMigration(ctx, conn, migration.Up, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES ($1, $2, $3, $4)`,
+					migration.Version, migration.Name, migration.Checksum, time.Now())
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("failed to apply migration %d_%s: %v", migration.Version, migration.Name, err)
+			}
+			log.Printf("Applied migration %d_%s\n", migration.Version, migration.Name)
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Reverts the latest steps applied migrations, newest first, and returns them
+func MigrateDown(ctx context.Context, db *sql.DB, migrations []Migration, steps int) ([]Migration, error) {
+	var done []Migration
+	err := withMigrationLock(ctx, db, func(conn *sql.Conn, applied map[int]appliedMigration) error {
+		if err := verifyApplied(migrations, applied); err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			migration := migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down script", migration.Version, migration.Name)
+			}
+			err := runMigration(ctx, conn, migration.Down, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("failed to revert migration %d_%s: %v", migration.Version, migration.Name, err)
+			}
+			log.Printf("Reverted migration %d_%s\n", migration.Version, migration.Name)
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Lists every known migration and when it was applied, nil for pending ones
+func GetMigrationStatus(ctx context.Context, db *sql.DB, migrations []Migration) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	err := withMigrationLock(ctx, db, func(conn *sql.Conn, applied map[int]appliedMigration) error {
+		if err := verifyApplied(migrations, applied); err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			status := MigrationStatus{Migration: migration}
+			if m, ok := applied[migration.Version]; ok {
+				appliedAt := m.appliedAt
+				status.AppliedAt = &appliedAt
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// Checks without taking the migration lock that every migration is applied and unchanged. Migrations applied by a
+// newer version of the service are ignored so instances still running the old version stay ready during a deploy.
+func CheckMigrations(ctx context.Context, db *sql.DB, migrations []Migration) error {
+	rows, err := db.QueryContext(ctx, `SELECT version, checksum FROM schema_migrations`)
+	if err != nil {
+		return fmt.Errorf("failed to read schema_migrations: %v", err)
+	}
+	defer rows.Close()
+	applied := map[int]string{}
+	for rows.Next() {
+		var version int
+		var checksum string
+		if err := rows.Scan(&version, &checksum); err != nil {
+			return fmt.Errorf("failed to scan schema migration: %v", err)
+		}
+		applied[version] = checksum
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	pending := 0
+	for _, migration := range migrations {
+		checksum, ok := applied[migration.Version]
+		if !ok {
+			pending++
+			continue
+		}
+		if checksum != migration.Checksum {
+			return fmt.Errorf("migration %d_%s was changed after it was applied", migration.Version, migration.Name)
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("%d migration(s) pending", pending)
+	}
+	return nil
+}
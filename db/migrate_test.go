@@ -0,0 +1,155 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"payment-gateway/internal/services"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := LoadMigrations(fstest.MapFS{
+		"0002_add_index.up.sql":   {Data: []byte("CREATE INDEX ...")},
+		"0001_init.up.sql":        {Data: []byte("CREATE TABLE ...")},
+		"0001_init.down.sql":      {Data: []byte("DROP TABLE ...")},
+		"0002_add_index.down.sql": {Data: []byte("DROP INDEX ...")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[0].Version != 1 || migrations[1].Name != "add_index" {
+		t.Fatalf("Unexpected migrations %+v", migrations)
+	}
+	if migrations[0].Down != "DROP TABLE ..." || len(migrations[0].Checksum) != 64 {
+		t.Errorf("Unexpected migration %+v", migrations[0])
+	}
+
+	for name, fsys := range map[string]fstest.MapFS{
+		"bad name":  {"init.sql": {Data: []byte("")}},
+		"down only": {"0001_init.down.sql": {Data: []byte("")}},
+		"two names": {"0001_init.up.sql": {Data: []byte("")}, "0001_other.down.sql": {Data: []byte("")}},
+	} {
+		if _, err := LoadMigrations(fsys); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestMigrationsAreRecorded(t *testing.T) {
+	migrations, err := EmbeddedMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := GetMigrationStatus(context.Background(), db, migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			t.Errorf("Expected migration %d_%s to be applied", status.Version, status.Name)
+		}
+	}
+
+	// running again is a no-op
+	applied, err := MigrateUp(context.Background(), db, migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("Expected no migrations to be applied, got %d", len(applied))
+	}
+	if err := CheckMigrations(context.Background(), db, migrations); err != nil {
+		t.Errorf("Expected the schema to be up to date, got %v", err)
+	}
+	pending := append(append([]Migration(nil), migrations...), Migration{Version: 9999, Name: "pending"})
+	if err := CheckMigrations(context.Background(), db, pending); err == nil {
+		t.Errorf("Expected a pending migration to be reported")
+	}
+
+	changed := append([]Migration(nil), migrations...)
+	changed[0].Checksum = "changed"
+	if _, err := MigrateUp(context.Background(), db, changed); err == nil {
+		t.Errorf("Expected a changed migration to be rejected")
+	}
+	if err := CheckMigrations(context.Background(), db, changed); err == nil {
+		t.Errorf("Expected a changed migration to be reported")
+	}
+}
+
+// A database created by the old init.sql, before migrations, is brought up to date by them
+func TestMigrateBaselineDatabase(t *testing.T) {
+	baseline, err := os.ReadFile("testdata/baseline_init.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := openTestDatabase(t, os.Getenv("DB_NAME")+"_baseline")
+	ctx := context.Background()
+
+	if _, err := conn.ExecContext(ctx, string(baseline)); err != nil {
+		t.Fatalf("Could not create the baseline schema: %v", err)
+	}
+	var gatewayID, countryID, currencyID int
+	if err := conn.QueryRowContext(ctx, "INSERT INTO gateways (name, data_format_supported) VALUES ('Gateway 1', 'application/json') RETURNING id").Scan(&gatewayID); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.QueryRowContext(ctx, "INSERT INTO countries (name, code) VALUES ('United States of America', 'US') RETURNING id").Scan(&countryID); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.QueryRowContext(ctx, "INSERT INTO currencies (symbol) VALUES ('USD') RETURNING id").Scan(&currencyID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.ExecContext(ctx, "INSERT INTO gateway_countries (gateway_id, country_id) VALUES ($1, $2)", gatewayID, countryID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.ExecContext(ctx, "INSERT INTO country_currency (country_id, currency_id) VALUES ($1, $2)", countryID, currencyID); err != nil {
+		t.Fatal(err)
+	}
+
+	migrations, err := EmbeddedMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateUp(ctx, conn, migrations); err != nil {
+		t.Fatalf("Could not migrate the baseline database: %v", err)
+	}
+
+	gateways, err := GetGatewaysFor(ctx, conn, countryID, "USD")
+	if err != nil || len(gateways) != 1 || gateways[0].ID != gatewayID || !gateways[0].Enabled {
+		t.Errorf("Expected the existing gateway to be routed to, got %+v (%v)", gateways, err)
+	}
+	if _, err := GetRandomGateway(ctx, conn, countryID, "USD"); err != nil {
+		t.Errorf("Expected a gateway, got %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, "INSERT INTO currencies (symbol) VALUES ('USD')"); err == nil {
+		t.Errorf("Expected currency symbols to be unique")
+	}
+	if _, err := conn.ExecContext(ctx, "INSERT INTO admin_audit_log (actor, action, entity, entity_id) VALUES ('sync', 'create', 'gateway', $1)", strings.Repeat("g", 255)); err != nil {
+		t.Errorf("Expected long entity IDs to be audited, got %v", err)
+	}
+}
+
+// Creates a fresh database next to the one TestMain uses and connects to it
+func openTestDatabase(t *testing.T, name string) *sql.DB {
+	t.Helper()
+	server := "postgres://" + os.Getenv("DB_USER") + ":" + os.Getenv("DB_PASSWORD") + "@" + os.Getenv("DB_HOST") + ":" + os.Getenv("DB_PORT")
+	if _, err := db.Exec("DROP DATABASE IF EXISTS " + name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("CREATE DATABASE " + name); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := Open(server+"/"+name+"?sslmode=disable", services.RetryPolicy{Attempts: 5, Backoff: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		db.Exec("DROP DATABASE IF EXISTS " + name)
+	})
+	return conn
+}
@@ -0,0 +1,10 @@
+DROP VIEW IF EXISTS gateway_country_currency;
+DROP TABLE IF EXISTS country_currency;
+DROP TABLE IF EXISTS currencies;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS transactions;
+DROP TABLE IF EXISTS gateway_countries;
+DROP TABLE IF EXISTS countries;
+DROP TABLE IF EXISTS gateways;
+DROP TYPE IF EXISTS transaction_type;
+DROP TYPE IF EXISTS transaction_status;
@@ -0,0 +1,26 @@
+-- a view can't lose columns through CREATE OR REPLACE, and g.* would pick up gateways.enabled while it exists
+DROP VIEW IF EXISTS gateway_country_currency;
+
+ALTER TABLE currencies DROP CONSTRAINT IF EXISTS currencies_symbol_key;
+ALTER TABLE currencies DROP COLUMN IF EXISTS enabled;
+ALTER TABLE countries DROP COLUMN IF EXISTS enabled;
+ALTER TABLE gateways DROP COLUMN IF EXISTS enabled;
+
+CREATE VIEW gateway_country_currency AS
+SELECT
+    g.*,
+    co.id AS country_id,
+    co.name AS country_name,
+    cu.id AS currency_id,
+    cu.symbol AS currency_symbol
+FROM
+    gateways g
+    JOIN gateway_countries gc ON gc.gateway_id = g.id
+    JOIN countries co ON gc.country_id = co.id
+    JOIN country_currency cc ON co.id = cc.country_id
+    JOIN currencies cu ON cc.currency_id = cu.id;
+
+DROP TABLE IF EXISTS admin_audit_log;
+DROP TABLE IF EXISTS batch_job_rows;
+DROP TABLE IF EXISTS batch_jobs;
+DROP TABLE IF EXISTS gateway_data_formats;
@@ -0,0 +1,92 @@
+-- What the schema gained in init.sql after the baseline, written to also apply to the tables a database created
+-- by init.sql already has.
+
+-- the formats a gateway takes besides data_format_supported
+CREATE TABLE IF NOT EXISTS gateway_data_formats (
+    gateway_id INT NOT NULL,
+    data_format VARCHAR(50) NOT NULL,
+    PRIMARY KEY (gateway_id, data_format),
+    CONSTRAINT fk_gateway FOREIGN KEY (gateway_id) REFERENCES gateways (id) ON DELETE CASCADE
+);
+
+-- bulk withdrawal uploads, processed asynchronously row by row
+CREATE TABLE IF NOT EXISTS batch_jobs (
+    id SERIAL PRIMARY KEY,
+    type transaction_type NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'PENDING',
+    client_format VARCHAR(50) NOT NULL,
+    total_rows INT NOT NULL DEFAULT 0,
+    processed_rows INT NOT NULL DEFAULT 0,
+    succeeded_rows INT NOT NULL DEFAULT 0,
+    failed_rows INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS batch_job_rows (
+    batch_job_id INT NOT NULL,
+    row_number INT NOT NULL,
+    amount DECIMAL(10, 2),
+    user_id INT,
+    currency CHAR(3),
+    beneficiary_name VARCHAR(255),
+    beneficiary_iban VARCHAR(34),
+    beneficiary_bic VARCHAR(11),
+    beneficiary_country CHAR(2),
+    status VARCHAR(20) NOT NULL DEFAULT 'PENDING',
+    error TEXT,
+    transaction_id INT,
+    PRIMARY KEY (batch_job_id, row_number),
+    CONSTRAINT fk_batch_job FOREIGN KEY (batch_job_id) REFERENCES batch_jobs (id) ON DELETE CASCADE
+);
+
+-- changes made through the admin API and the sync command
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+    id SERIAL PRIMARY KEY,
+    actor VARCHAR(255) NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    entity VARCHAR(50) NOT NULL,
+    entity_id VARCHAR(50) NOT NULL,
+    payload JSONB,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Gateways, countries and currencies can be disabled instead of deleted, which takes them out of routing. The
+-- columns are added to the tables as init.sql created them, existing rows stay enabled.
+ALTER TABLE gateways ADD COLUMN IF NOT EXISTS enabled BOOLEAN NOT NULL DEFAULT true;
+ALTER TABLE countries ADD COLUMN IF NOT EXISTS enabled BOOLEAN NOT NULL DEFAULT true;
+ALTER TABLE currencies ADD COLUMN IF NOT EXISTS enabled BOOLEAN NOT NULL DEFAULT true;
+
+-- currencies are looked up by symbol
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'currencies_symbol_key') THEN
+        ALTER TABLE currencies ADD CONSTRAINT currencies_symbol_key UNIQUE (symbol);
+    END IF;
+END $$;
+
+-- the columns of the view as init.sql created it, in the same order, then those telling what is enabled
+CREATE OR REPLACE VIEW gateway_country_currency AS
+SELECT
+    g.id,
+    g.name,
+    g.data_format_supported,
+    g.created_at,
+    g.updated_at,
+    co.id AS country_id,
+    co.name AS country_name,
+    cu.id AS currency_id,
+    cu.symbol AS currency_symbol,
+    g.enabled,
+    co.enabled AS country_enabled,
+    cu.enabled AS currency_enabled
+FROM
+    gateways g
+    JOIN gateway_countries gc ON gc.gateway_id = g.id
+    JOIN countries co ON gc.country_id = co.id
+    JOIN country_currency cc ON co.id = cc.country_id
+    JOIN currencies cu ON cc.currency_id = cu.id;
+
+-- the sync command records entities by their key, like a gateway name of up to 255 characters
+ALTER TABLE admin_audit_log ALTER COLUMN entity_id TYPE TEXT;
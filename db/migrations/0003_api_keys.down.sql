@@ -0,0 +1,3 @@
+ALTER TABLE batch_jobs DROP COLUMN IF EXISTS created_by;
+ALTER TABLE transactions DROP COLUMN IF EXISTS created_by;
+DROP TABLE IF EXISTS api_keys;
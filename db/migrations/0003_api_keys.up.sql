@@ -0,0 +1,15 @@
+-- Merchant API keys. Only a SHA-256 hash of the secret is stored. signing_secret is set for keys that sign their
+-- requests, encrypted by the service as verifying a signature needs the secret itself.
+CREATE TABLE api_keys (
+    id VARCHAR(32) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    scopes TEXT[] NOT NULL,
+    secret_hash CHAR(64) NOT NULL,
+    signing_secret TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
+-- the key a transaction or batch was created with, NULL for those created before keys existed
+ALTER TABLE transactions ADD COLUMN created_by VARCHAR(32);
+ALTER TABLE batch_jobs ADD COLUMN created_by VARCHAR(32);
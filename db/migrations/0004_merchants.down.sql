@@ -0,0 +1,10 @@
+ALTER TABLE api_keys DROP COLUMN IF EXISTS merchant_id;
+ALTER TABLE batch_jobs DROP COLUMN IF EXISTS merchant_id;
+DROP INDEX IF EXISTS transactions_merchant_id_idx;
+ALTER TABLE transactions DROP COLUMN IF EXISTS merchant_id;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_merchant_username_key;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_merchant_email_key;
+ALTER TABLE users DROP COLUMN IF EXISTS merchant_id;
+ALTER TABLE users ADD CONSTRAINT users_username_key UNIQUE (username);
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+DROP TABLE IF EXISTS merchants;
@@ -0,0 +1,27 @@
+-- Merchants, the brands operating on the gateway. Users, transactions, batches and API keys each belong to one.
+-- Existing data is moved to a default merchant, which also serves requests made without an API key.
+CREATE TABLE merchants (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+INSERT INTO merchants (id, name) VALUES (1, 'default');
+SELECT setval('merchants_id_seq', 1);
+
+ALTER TABLE users ADD COLUMN merchant_id INT NOT NULL DEFAULT 1 REFERENCES merchants (id);
+ALTER TABLE users ALTER COLUMN merchant_id DROP DEFAULT;
+-- usernames and emails only need to be unique within a merchant
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_username_key;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+ALTER TABLE users ADD CONSTRAINT users_merchant_username_key UNIQUE (merchant_id, username);
+ALTER TABLE users ADD CONSTRAINT users_merchant_email_key UNIQUE (merchant_id, email);
+
+ALTER TABLE transactions ADD COLUMN merchant_id INT NOT NULL DEFAULT 1 REFERENCES merchants (id);
+ALTER TABLE transactions ALTER COLUMN merchant_id DROP DEFAULT;
+CREATE INDEX transactions_merchant_id_idx ON transactions (merchant_id, id);
+
+ALTER TABLE batch_jobs ADD COLUMN merchant_id INT NOT NULL DEFAULT 1 REFERENCES merchants (id);
+ALTER TABLE batch_jobs ALTER COLUMN merchant_id DROP DEFAULT;
+
+-- NULL for operator keys, which administer the gateway or settle transactions for every merchant
+ALTER TABLE api_keys ADD COLUMN merchant_id INT REFERENCES merchants (id);
@@ -0,0 +1,2 @@
+ALTER TABLE admin_audit_log DROP COLUMN IF EXISTS roles;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS roles;
@@ -0,0 +1,4 @@
+-- Roles of operator API keys in the admin API, and the roles an audited admin action was taken in. Keys with the
+-- admin scope have the admin role without it being listed.
+ALTER TABLE api_keys ADD COLUMN roles TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE admin_audit_log ADD COLUMN roles TEXT[];
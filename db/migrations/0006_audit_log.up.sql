@@ -0,0 +1,37 @@
+-- Append-only audit log of API and admin actions. Each entry carries the hash of the one before it and a hash over
+-- its own fields, see db/audit.go, and IDs are handed out by the application without gaps. before and after are
+-- JSON rather than JSONB so they are read back exactly as they were hashed.
+--
+-- admin_audit_log is kept as it was for the entries written before this table existed.
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGINT PRIMARY KEY,
+    actor VARCHAR(255) NOT NULL,
+    roles TEXT[] NOT NULL DEFAULT '{}',
+    action VARCHAR(255) NOT NULL,
+    entity VARCHAR(255) NOT NULL,
+    entity_id VARCHAR(255) NOT NULL,
+    request_id VARCHAR(255) NOT NULL DEFAULT '',
+    before JSON,
+    after JSON,
+    created_at TIMESTAMP NOT NULL,
+    prev_hash VARCHAR(64) NOT NULL DEFAULT '',
+    hash VARCHAR(64) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_entity_idx ON audit_log (entity, entity_id);
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log (actor);
+CREATE INDEX IF NOT EXISTS audit_log_request_id_idx ON audit_log (request_id);
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only, % is not allowed', TG_OP;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_no_update ON audit_log;
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+DROP TRIGGER IF EXISTS audit_log_no_truncate ON audit_log;
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS withdrawal_confirmations;
+-- Postgres can't drop an enum value, expired drafts are marked FAILED so they remain readable
+UPDATE transactions SET status = 'FAILED' WHERE status = 'EXPIRED';
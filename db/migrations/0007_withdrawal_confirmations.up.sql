@@ -0,0 +1,14 @@
+-- Withdrawals are created as DRAFT and only sent to the gateway once the user confirms them with a one-time code.
+-- payload holds the code and the request to publish, encrypted by the application as it includes bank details.
+-- Drafts not confirmed by expires_at become EXPIRED.
+ALTER TYPE transaction_status ADD VALUE IF NOT EXISTS 'EXPIRED';
+
+CREATE TABLE IF NOT EXISTS withdrawal_confirmations (
+    transaction_id INT PRIMARY KEY REFERENCES transactions (id) ON DELETE CASCADE,
+    payload TEXT NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS withdrawal_confirmations_expires_at_idx ON withdrawal_confirmations (expires_at);
@@ -0,0 +1,2 @@
+-- Postgres can't drop an enum value, cancelled transactions are marked FAILED so they remain readable
+UPDATE transactions SET status = 'FAILED' WHERE status = 'CANCELLED';
@@ -0,0 +1,3 @@
+-- Transactions can be cancelled until the gateway settles them, see POST /deposit/{id}/cancel and
+-- POST /withdrawal/{id}/cancel.
+ALTER TYPE transaction_status ADD VALUE IF NOT EXISTS 'CANCELLED';
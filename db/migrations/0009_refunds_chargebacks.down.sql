@@ -0,0 +1,5 @@
+-- Postgres can't drop an enum value, refunds and chargebacks remain as transactions of their type but lose the
+-- link to their deposit
+DROP INDEX IF EXISTS transactions_original_id_idx;
+ALTER TABLE transactions DROP COLUMN IF EXISTS original_id;
+ALTER TABLE transactions DROP COLUMN IF EXISTS currency;
@@ -0,0 +1,10 @@
+-- Refunds and chargebacks reverse a successful deposit, in full or in part. original_id links them to the deposit
+-- so their total can be checked against its amount. Transactions now record their currency, empty for those
+-- created before.
+ALTER TYPE transaction_type ADD VALUE IF NOT EXISTS 'REFUND';
+ALTER TYPE transaction_type ADD VALUE IF NOT EXISTS 'CHARGEBACK';
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT '';
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS original_id INT REFERENCES transactions (id);
+
+CREATE INDEX IF NOT EXISTS transactions_original_id_idx ON transactions (original_id) WHERE original_id IS NOT NULL;
@@ -0,0 +1,3 @@
+-- The format a transaction was published to its gateway in, so what is published about it later, like its
+-- cancellation, goes to the same topic. Empty for transactions created before.
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS data_format VARCHAR(50) NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+-- Where the one-time codes confirming a merchant's withdrawals are posted, and the secret signing them, encrypted
+-- by the service. Empty for merchants without a webhook.
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS webhook_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS webhook_secret TEXT NOT NULL DEFAULT '';
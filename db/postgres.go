@@ -0,0 +1,259 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type dbtx interface {
+	Execer
+	Queryer
+}
+
+// Store backed by Postgres through the package's query functions
+type PostgresStore struct {
+	db   *sql.DB
+	q    dbtx
+	inTx bool
+	// set by WithTracer, nil when DB calls aren't traced
+	tracer trace.Tracer
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db, q: db}
+}
+
+func (s *PostgresStore) Users() UserRepository                  { return s }
+func (s *PostgresStore) Gateways() GatewayRepository            { return s }
+func (s *PostgresStore) ReferenceData() ReferenceDataRepository { return s }
+func (s *PostgresStore) Transactions() TransactionRepository    { return s }
+func (s *PostgresStore) APIKeys() APIKeyRepository              { return s }
+func (s *PostgresStore) Merchants() MerchantRepository          { return s }
+func (s *PostgresStore) AuditLog() AuditRepository              { return s }
+func (s *PostgresStore) Confirmations() ConfirmationRepository  { return s }
+
+// Nested calls join the outer DB transaction
+func (s *PostgresStore) InTx(ctx context.Context, fn func(store Store) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+
+	if s.tracer == nil {
+		return s.runInTx(ctx, fn)
+	}
+	ctx, span := s.tracer.Start(ctx, "InTx", trace.WithAttributes(attribute.String("db.system", "postgresql")))
+	err := s.runInTx(ctx, fn)
+	endSpan(span, err)
+	return err
+}
+
+func (s *PostgresStore) runInTx(ctx context.Context, fn func(store Store) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	var q dbtx = tx
+	if s.tracer != nil {
+		q = tracedDBTX{dbtx: tx, tracer: s.tracer}
+	}
+	if err := fn(&PostgresStore{db: s.db, q: q, inTx: true, tracer: s.tracer}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) CreateUser(ctx context.Context, user *User) error {
+	return CreateUser(ctx, s.q, user)
+}
+
+func (s *PostgresStore) GetUser(ctx context.Context, merchantID, userID int) (User, error) {
+	return GetUser(ctx, s.q, merchantID, userID)
+}
+
+func (s *PostgresStore) CreateGateway(ctx context.Context, gateway *Gateway) error {
+	return CreateGateway(ctx, s.q, gateway)
+}
+
+func (s *PostgresStore) GetGateway(ctx context.Context, gatewayID int) (Gateway, error) {
+	return GetGateway(ctx, s.q, gatewayID)
+}
+
+func (s *PostgresStore) GetGateways(ctx context.Context) ([]Gateway, error) {
+	gateways, err := GetGateways(ctx, s.q)
+	if err != nil {
+		return nil, err
+	}
+	for i := range gateways {
+		formats, err := GetGatewayDataFormats(ctx, s.q, gateways[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		gateways[i].DataFormats = dedupeFormats(append([]string{gateways[i].DataFormatSupported}, formats...))
+	}
+	return gateways, nil
+}
+
+func (s *PostgresStore) GetRandomGateway(ctx context.Context, countryID int, currency string) (Gateway, error) {
+	return GetRandomGateway(ctx, s.q, countryID, currency)
+}
+
+func (s *PostgresStore) GetGatewaysFor(ctx context.Context, countryID int, currency string) ([]Gateway, error) {
+	return GetGatewaysFor(ctx, s.q, countryID, currency)
+}
+
+func (s *PostgresStore) AddGatewayCountry(ctx context.Context, gatewayID, countryID int) error {
+	return AddGatewayCountry(ctx, s.q, gatewayID, countryID)
+}
+
+func (s *PostgresStore) CreateCountry(ctx context.Context, country *Country) error {
+	return CreateCountry(ctx, s.q, country)
+}
+
+func (s *PostgresStore) GetCountry(ctx context.Context, countryID int) (Country, error) {
+	return GetCountry(ctx, s.q, countryID)
+}
+
+func (s *PostgresStore) GetCountries(ctx context.Context) ([]Country, error) {
+	return GetCountries(ctx, s.q)
+}
+
+func (s *PostgresStore) CreateCurrency(ctx context.Context, currency *Currency) error {
+	return CreateCurrency(ctx, s.q, currency)
+}
+
+func (s *PostgresStore) GetCurrencies(ctx context.Context) ([]Currency, error) {
+	return GetCurrencies(ctx, s.q)
+}
+
+func (s *PostgresStore) AddCountryCurrency(ctx context.Context, countryID, currencyID int) error {
+	return AddCountryCurrency(ctx, s.q, countryID, currencyID)
+}
+
+func (s *PostgresStore) CurrencySupportedInCountry(ctx context.Context, currencySymbol string, countryID int) (bool, error) {
+	return CurrencySupportedInCountry(ctx, s.q, currencySymbol, countryID)
+}
+
+func (s *PostgresStore) GetSupportedCurrenciesFromCountry(ctx context.Context, countryID int) ([]Currency, error) {
+	return GetSupportedCurrenciesFromCountry(ctx, s.q, countryID)
+}
+
+func (s *PostgresStore) CreateTransaction(ctx context.Context, transaction *Transaction) error {
+	return CreateTransaction(ctx, s.q, transaction)
+}
+
+func (s *PostgresStore) GetTransaction(ctx context.Context, merchantID, transactionID int, txType TransactionType) (Transaction, error) {
+	return GetTransaction(ctx, s.q, merchantID, transactionID, txType)
+}
+
+func (s *PostgresStore) GetTransactions(ctx context.Context, merchantID int) ([]Transaction, error) {
+	return GetTransactions(ctx, s.q, merchantID)
+}
+
+func (s *PostgresStore) UpdateTransactionStatus(ctx context.Context, merchantID, transactionID int, txType TransactionType, from, to TransactionStatus) error {
+	return UpdateTransactionStatus(ctx, s.q, merchantID, transactionID, txType, from, to)
+}
+
+// Only locks the original while a DB transaction is open, see InTx
+func (s *PostgresStore) GetReversedAmount(ctx context.Context, originalID int, types ...TransactionType) (decimal.Decimal, error) {
+	return GetReversedAmount(ctx, s.q, originalID, types...)
+}
+
+// Conditional on the current status so two callbacks racing for the same transaction can't both apply
+func UpdateTransactionStatus(ctx context.Context, db dbtx, merchantID, transactionID int, txType TransactionType, from, to TransactionStatus) error {
+	res, err := db.ExecContext(ctx, "UPDATE transactions SET status = $1 WHERE id = $2 and type = $3 and status = $4 and "+merchantCondition(5), to, transactionID, txType, from, merchantID)
+	if err != nil {
+		return fmt.Errorf("failed to update transaction %d: %v", transactionID, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	if _, err := GetTransaction(ctx, db, merchantID, transactionID, txType); err != nil {
+		return err
+	}
+	return fmt.Errorf("transaction %d is no longer %s: %w", transactionID, from, ErrConflict)
+}
+
+// Users of other merchants are reported as not found
+func GetUser(ctx context.Context, db Queryer, merchantID, userID int) (User, error) {
+	var user User
+	err := db.QueryRowContext(ctx, `SELECT id, merchant_id, username, email, COALESCE(country_id, 0), created_at, updated_at FROM users WHERE id = $1 AND merchant_id = $2`, userID, merchantID).
+		Scan(&user.ID, &user.MerchantID, &user.Username, &user.Email, &user.CountryID, &user.CreatedAt, &user.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, fmt.Errorf("user %d: %w", userID, ErrNotFound)
+	}
+	if err != nil {
+		return User{}, fmt.Errorf("failed to get user %d: %v", userID, err)
+	}
+	return user, nil
+}
+
+func (s *PostgresStore) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	return CreateAPIKey(ctx, s.q, key)
+}
+
+func (s *PostgresStore) GetAPIKey(ctx context.Context, keyID string) (APIKey, error) {
+	return GetAPIKey(ctx, s.q, keyID)
+}
+
+func (s *PostgresStore) GetAPIKeys(ctx context.Context) ([]APIKey, error) {
+	return GetAPIKeys(ctx, s.q)
+}
+
+func (s *PostgresStore) RevokeAPIKey(ctx context.Context, keyID string, at time.Time) error {
+	return RevokeAPIKey(ctx, s.q, keyID, at)
+}
+
+func (s *PostgresStore) CreateMerchant(ctx context.Context, merchant *Merchant) error {
+	return CreateMerchant(ctx, s.q, merchant)
+}
+
+func (s *PostgresStore) GetMerchant(ctx context.Context, merchantID int) (Merchant, error) {
+	return GetMerchant(ctx, s.q, merchantID)
+}
+
+func (s *PostgresStore) GetMerchants(ctx context.Context) ([]Merchant, error) {
+	return GetMerchants(ctx, s.q)
+}
+
+// Appending outside InTx runs in a transaction of its own, the chain has to be locked while the entry is added
+func (s *PostgresStore) AppendAuditEntry(ctx context.Context, entry *AuditEntry) error {
+	if !s.inTx {
+		return s.InTx(ctx, func(store Store) error {
+			return store.AuditLog().AppendAuditEntry(ctx, entry)
+		})
+	}
+	return AppendAuditEntry(ctx, s.q, entry)
+}
+
+func (s *PostgresStore) GetAuditEntries(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	return GetAuditEntries(ctx, s.q, filter)
+}
+
+func (s *PostgresStore) CreateConfirmation(ctx context.Context, confirmation *WithdrawalConfirmation) error {
+	return CreateConfirmation(ctx, s.q, confirmation)
+}
+
+func (s *PostgresStore) GetConfirmation(ctx context.Context, transactionID int) (WithdrawalConfirmation, error) {
+	return GetConfirmation(ctx, s.q, transactionID)
+}
+
+func (s *PostgresStore) AddConfirmationAttempt(ctx context.Context, transactionID int) (int, error) {
+	return AddConfirmationAttempt(ctx, s.q, transactionID)
+}
+
+func (s *PostgresStore) DeleteConfirmation(ctx context.Context, transactionID int) error {
+	return DeleteConfirmation(ctx, s.q, transactionID)
+}
+
+func (s *PostgresStore) GetExpiredConfirmations(ctx context.Context, now time.Time, limit int) ([]WithdrawalConfirmation, error) {
+	return GetExpiredConfirmations(ctx, s.q, now, limit)
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/shopspring/decimal"
+)
+
+// Repositories for the data the payment API works with. Handlers depend on these instead of *sql.DB so they can
+// run against Postgres or the in-memory implementation in db/memory. Both are held to the same behavior by the
+// conformance suite in db/dbtest.
+//
+// Users and transactions belong to a merchant. Reading or changing them takes the ID of the merchant the caller
+// acts for, and rows of other merchants are treated as if they didn't exist.
+
+// returned when a write is refused because the row is no longer in the expected state
+var ErrConflict = errors.New("conflict")
+
+// Satisfied by both *sql.DB and *sql.Tx
+type Queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type UserRepository interface {
+	CreateUser(ctx context.Context, user *User) error
+	// wraps ErrNotFound when the merchant has no such user
+	GetUser(ctx context.Context, merchantID, userID int) (User, error)
+}
+
+type GatewayRepository interface {
+	CreateGateway(ctx context.Context, gateway *Gateway) error
+	// wraps ErrNotFound when there is no such gateway
+	GetGateway(ctx context.Context, gatewayID int) (Gateway, error)
+	GetGateways(ctx context.Context) ([]Gateway, error)
+	// picks an enabled gateway serving the country and currency, wraps ErrNotFound when there is none
+	GetRandomGateway(ctx context.Context, countryID int, currency string) (Gateway, error)
+	// every enabled gateway serving the country and currency, ordered by ID
+	GetGatewaysFor(ctx context.Context, countryID int, currency string) ([]Gateway, error)
+	AddGatewayCountry(ctx context.Context, gatewayID, countryID int) error
+}
+
+type ReferenceDataRepository interface {
+	CreateCountry(ctx context.Context, country *Country) error
+	// wraps ErrNotFound when there is no such country
+	GetCountry(ctx context.Context, countryID int) (Country, error)
+	GetCountries(ctx context.Context) ([]Country, error)
+	CreateCurrency(ctx context.Context, currency *Currency) error
+	GetCurrencies(ctx context.Context) ([]Currency, error)
+	AddCountryCurrency(ctx context.Context, countryID, currencyID int) error
+	CurrencySupportedInCountry(ctx context.Context, currencySymbol string, countryID int) (bool, error)
+	GetSupportedCurrenciesFromCountry(ctx context.Context, countryID int) ([]Currency, error)
+}
+
+type TransactionRepository interface {
+	CreateTransaction(ctx context.Context, transaction *Transaction) error
+	// wraps ErrNotFound when the merchant has no transaction of txType with the ID
+	GetTransaction(ctx context.Context, merchantID, transactionID int, txType TransactionType) (Transaction, error)
+	// the merchant's transactions ordered by ID
+	GetTransactions(ctx context.Context, merchantID int) ([]Transaction, error)
+	// Moves a transaction from one status to another. Wraps ErrConflict when it isn't in status from anymore.
+	UpdateTransactionStatus(ctx context.Context, merchantID, transactionID int, txType TransactionType, from, to TransactionStatus) error
+	// Sums the amounts of the refunds or chargebacks of types recorded against the original transaction, leaving out
+	// those that failed, were cancelled or expired. Inside InTx it holds back other callers for the same original
+	// until the DB transaction ends. Wraps ErrNotFound when there is no such transaction.
+	GetReversedAmount(ctx context.Context, originalID int, types ...TransactionType) (decimal.Decimal, error)
+}
+
+type Store interface {
+	Users() UserRepository
+	Gateways() GatewayRepository
+	ReferenceData() ReferenceDataRepository
+	Transactions() TransactionRepository
+	APIKeys() APIKeyRepository
+	Merchants() MerchantRepository
+	AuditLog() AuditRepository
+	Confirmations() ConfirmationRepository
+	// Runs fn with a Store whose writes are committed together when fn returns nil and discarded otherwise
+	InTx(ctx context.Context, fn func(store Store) error) error
+}
@@ -0,0 +1,13 @@
+package db_test
+
+import (
+	"payment-gateway/db"
+	"payment-gateway/db/dbtest"
+	"testing"
+)
+
+func TestPostgresStoreConformance(t *testing.T) {
+	dbtest.TestStore(t, func(t *testing.T) db.Store {
+		return db.NewPostgresStore(db.SharedTestDB())
+	})
+}
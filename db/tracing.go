@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Returns a store recording a span for every query and DB transaction it runs, as a child of the span in the
+// query's context
+func (s *PostgresStore) WithTracer(tp trace.TracerProvider) *PostgresStore {
+	tracer := tp.Tracer("payment-gateway/db")
+	return &PostgresStore{db: s.db, q: tracedDBTX{dbtx: s.q, tracer: tracer}, inTx: s.inTx, tracer: tracer}
+}
+
+type tracedDBTX struct {
+	dbtx
+	tracer trace.Tracer
+}
+
+// spans are named after the operation and the statement is recorded as written, the arguments are left out as they
+// may hold personal data
+func (t tracedDBTX) start(ctx context.Context, query string) (context.Context, trace.Span) {
+	operation := "query"
+	if fields := strings.Fields(query); len(fields) > 0 {
+		operation = strings.ToUpper(fields[0])
+	}
+	return t.tracer.Start(ctx, operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.operation", operation),
+		attribute.String("db.statement", query),
+	))
+}
+
+func (t tracedDBTX) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := t.start(ctx, query)
+	result, err := t.dbtx.ExecContext(ctx, query, args...)
+	endSpan(span, err)
+	return result, err
+}
+
+func (t tracedDBTX) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := t.start(ctx, query)
+	rows, err := t.dbtx.QueryContext(ctx, query, args...)
+	endSpan(span, err)
+	return rows, err
+}
+
+func (t tracedDBTX) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, span := t.start(ctx, query)
+	row := t.dbtx.QueryRowContext(ctx, query, args...)
+	// no rows isn't a failure of the query, the callers turn it into ErrNotFound
+	if err := row.Err(); err != sql.ErrNoRows {
+		endSpan(span, err)
+	} else {
+		span.End()
+	}
+	return row
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
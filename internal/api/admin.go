@@ -0,0 +1,603 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"payment-gateway/db"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/services"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Admin API for the reference data driving routing: gateways, countries, currencies and the mappings between
+// them. Every write is validated and recorded in the audit log in the same DB transaction.
+
+var (
+	countryCodePattern    = regexp.MustCompile(`^[A-Z]{2}$`)
+	currencySymbolPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+)
+
+// a bearer token for the admin API. The actor is recorded in the audit log, the roles decide what the token may do.
+type AdminToken struct {
+	Actor string
+	Token string
+	Roles []string
+}
+
+// Parses a comma separated list of actor:token pairs, the format of ADMIN_API_TOKENS. A pair may be followed by
+// :role+role naming the token's roles, without them it has the admin role. Tokens with an unknown role are left out.
+func ParseAdminTokens(value string) []AdminToken {
+	var tokens []AdminToken
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 3)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			continue
+		}
+		roles := []string{RoleAdmin}
+		if len(parts) == 3 {
+			roles = strings.Split(parts[2], "+")
+		}
+		if err := validateRoles(roles); err != nil {
+			log.Printf("Ignoring the admin token of %s: %v", parts[0], err)
+			continue
+		}
+		tokens = append(tokens, AdminToken{Actor: parts[0], Token: parts[1], Roles: roles})
+	}
+	return tokens
+}
+
+// Authenticates admin requests with a bearer token and puts the actor and its roles into the context. Any other
+// credentials are passed on to keys, which accepts operator API keys with a role. Their ID is recorded as the actor.
+func AdminAuth(tokens []AdminToken, keys func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	if len(tokens) == 0 {
+		log.Println("No admin tokens are configured, the admin API only accepts operator API keys with a role.")
+	}
+	return func(next http.Handler) http.Handler {
+		var byKey http.Handler
+		if keys != nil {
+			byKey = keys(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				principal, _ := PrincipalFrom(r.Context())
+				next.ServeHTTP(w, r.WithContext(withAdmin(r.Context(), principal.ID, principalRoles(principal))))
+			}))
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			presented, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			for _, t := range tokens {
+				if presented != "" && subtle.ConstantTimeCompare([]byte(presented), []byte(t.Token)) == 1 {
+					next.ServeHTTP(w, r.WithContext(withAdmin(r.Context(), t.Actor, t.Roles)))
+					return
+				}
+			}
+			if byKey != nil {
+				byKey.ServeHTTP(w, r)
+				return
+			}
+			returnUnauthorized(w, "")
+		})
+	}
+}
+
+func adminActor(ctx context.Context) string {
+	actor, _ := ctx.Value("adminActor").(string)
+	return actor
+}
+
+func responseContentType(r *http.Request) ContentType {
+	if ct, ok := r.Context().Value("contentType").(ContentType); ok {
+		return ct
+	}
+	if ct := r.Header.Get("Content-Type"); ct == "application/xml" || ct == "text/xml" {
+		return XML
+	}
+	return JSON
+}
+
+func pathID(w http.ResponseWriter, r *http.Request, name string, contentType ContentType) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)[name])
+	if err != nil {
+		returnError("ID not a number", "", http.StatusBadRequest, w, contentType)
+		return 0, false
+	}
+	return id, true
+}
+
+// Runs write inside a DB transaction together with the audit entry recording it, with payload as what the entity
+// became. write returns the ID of the entity it touched, which isn't known up front for creates.
+func auditedWrite(ctx context.Context, _db *sql.DB, action, entity string, payload interface{}, write func(tx *sql.Tx) (string, error)) error {
+	tx, err := _db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	entityID, err := write(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	entry, err := newAuditEntry(ctx, action, entity, entityID, nil, payload)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := db.AppendAuditEntry(ctx, tx, &entry); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func returnAdminWriteError(err error, w http.ResponseWriter, contentType ContentType) {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		returnError("Not found", err.Error(), http.StatusNotFound, w, contentType)
+	case errors.Is(err, db.ErrConflict):
+		returnError("Conflict", err.Error(), http.StatusConflict, w, contentType)
+	case db.IsUniqueViolation(err):
+		returnError("Already exists", err.Error(), http.StatusConflict, w, contentType)
+	case db.IsForeignKeyViolation(err):
+		returnError("Referenced entity not found", err.Error(), http.StatusNotFound, w, contentType)
+	default:
+		returnError("unable to save", err.Error(), http.StatusInternalServerError, w, contentType)
+	}
+}
+
+func (s *Server) adminDB(w http.ResponseWriter, contentType ContentType) (*sql.DB, bool) {
+	if s.db == nil {
+		returnError("unable to connect to DB", "", http.StatusInternalServerError, w, contentType)
+		return nil, false
+	}
+	return s.db, true
+}
+
+func validateGatewayRequest(request models.AdminGatewayRequest) *requestError {
+	if name := strings.TrimSpace(request.Name); name == "" || len(name) > 255 {
+		return &requestError{StatusCode: http.StatusBadRequest, Message: "Invalid name", DetailedMessage: "Name must be between 1 and 255 characters"}
+	}
+	for _, format := range append([]string{request.DataFormatSupported}, request.DataFormats...) {
+		if !services.IsSupportedDataFormat(format) {
+			return &requestError{StatusCode: http.StatusBadRequest, Message: "Invalid data format", DetailedMessage: fmt.Sprintf("%q is not one of %s", format, strings.Join(services.SupportedDataFormats, ", "))}
+		}
+	}
+	return nil
+}
+
+func validateCountryRequest(request models.AdminCountryRequest) *requestError {
+	if name := strings.TrimSpace(request.Name); name == "" || len(name) > 255 {
+		return &requestError{StatusCode: http.StatusBadRequest, Message: "Invalid name", DetailedMessage: "Name must be between 1 and 255 characters"}
+	}
+	if !countryCodePattern.MatchString(request.Code) {
+		return &requestError{StatusCode: http.StatusBadRequest, Message: "Invalid code", DetailedMessage: "Code must be an ISO 3166 alpha-2 code such as AE"}
+	}
+	return nil
+}
+
+func validateCurrencyRequest(request models.AdminCurrencyRequest) *requestError {
+	if !currencySymbolPattern.MatchString(request.Symbol) {
+		return &requestError{StatusCode: http.StatusBadRequest, Message: "Invalid symbol", DetailedMessage: "Symbol must be an ISO 4217 code such as USD"}
+	}
+	return nil
+}
+
+func (s *Server) AdminListGatewaysHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := NewHandlerContext(r.Context(), s.config.RequestTimeout)
+	defer cancel()
+	contentType := responseContentType(r)
+	_db, ok := s.adminDB(w, contentType)
+	if !ok {
+		return
+	}
+
+	gateways, err := db.GetGateways(ctx, _db)
+	if err != nil {
+		returnError("unable to get gateways", err.Error(), http.StatusInternalServerError, w, contentType)
+		return
+	}
+	for i := range gateways {
+		formats, err := db.GetGatewayDataFormats(ctx, _db, gateways[i].ID)
+		if err != nil {
+			returnError("unable to get gateways", err.Error(), http.StatusInternalServerError, w, contentType)
+			return
+		}
+		gateways[i].DataFormats = formats
+	}
+	returnResponse(gateways, http.StatusOK, w, contentType)
+}
+
+func (s *Server) AdminGetGatewayHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := NewHandlerContext(r.Context(), s.config.RequestTimeout)
+	defer cancel()
+	contentType := responseContentType(r)
+	id, ok := pathID(w, r, "id", contentType)
+	if !ok {
+		return
+	}
+	_db, ok := s.adminDB(w, contentType)
+	if !ok {
+		return
+	}
+
+	gateway, err := db.GetGateway(ctx, _db, id)
+	if err != nil {
+		returnAdminWriteError(err, w, contentType)
+		return
+	}
+	returnResponse(gateway, http.StatusOK, w, contentType)
+}
+
+func (s *Server) AdminCreateGatewayHandler(w http.ResponseWriter, r *http.Request) {
+	contentType := responseContentType(r)
+	request := r.Context().Value("request").(models.AdminGatewayRequest)
+	if reqErr := validateGatewayRequest(request); reqErr != nil {
+		returnError(reqErr.Message, reqErr.DetailedMessage, reqErr.StatusCode, w, contentType)
+		return
+	}
+	_db, ok := s.adminDB(w, contentType)
+	if !ok {
+		return
+	}
+
+	gateway := db.Gateway{Name: strings.TrimSpace(request.Name), DataFormatSupported: request.DataFormatSupported, DataFormats: request.DataFormats}
+	err := auditedWrite(r.Context(), _db, "create", "gateway", request, func(tx *sql.Tx) (string, error) {
+		err := db.CreateGateway(r.Context(), tx, &gateway)
+		return strconv.Itoa(gateway.ID), err
+	})
+	if err != nil {
+		returnAdminWriteError(err, w, contentType)
+		return
+	}
+	returnResponse(gateway, http.StatusCreated, w, contentType)
+}
+
+func (s *Server) AdminUpdateGatewayHandler(w http.ResponseWriter, r *http.Request) {
+	contentType := responseContentType(r)
+	request := r.Context().Value("request").(models.AdminGatewayRequest)
+	id, ok := pathID(w, r, "id", contentType)
+	if !ok {
+		return
+	}
+	if reqErr := validateGatewayRequest(request); reqErr != nil {
+		returnError(reqErr.Message, reqErr.DetailedMessage, reqErr.StatusCode, w, contentType)
+		return
+	}
+	_db, ok := s.adminDB(w, contentType)
+	if !ok {
+		return
+	}
+
+	gateway := db.Gateway{ID: id, Name: strings.TrimSpace(request.Name), DataFormatSupported: request.DataFormatSupported, DataFormats: request.DataFormats}
+	err := auditedWrite(r.Context(), _db, "update", "gateway", request, func(tx *sql.Tx) (string, error) {
+		if err := db.UpdateGateway(r.Context(), tx, &gateway); err != nil {
+			return "", err
+		}
+		updated, err := db.GetGateway(r.Context(), tx, id)
+		gateway = updated
+		return strconv.Itoa(id), err
+	})
+	if err != nil {
+		returnAdminWriteError(err, w, contentType)
+		return
+	}
+	returnResponse(gateway, http.StatusOK, w, contentType)
+}
+
+// returns a handler enabling or disabling an entity through set and reading it back through get
+func adminSetEnabledHandler[T any](s *Server, entity string, enabled bool, set func(context.Context, db.Execer, int, bool) error, get func(context.Context, db.Execer, int) (T, error)) http.HandlerFunc {
+	action := "disable"
+	if enabled {
+		action = "enable"
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := NewHandlerContext(r.Context(), s.config.RequestTimeout)
+		defer cancel()
+		ctx = withAdmin(ctx, adminActor(r.Context()), adminRoles(r.Context()))
+		contentType := responseContentType(r)
+		id, ok := pathID(w, r, "id", contentType)
+		if !ok {
+			return
+		}
+		_db, ok := s.adminDB(w, contentType)
+		if !ok {
+			return
+		}
+
+		var result T
+		err := auditedWrite(ctx, _db, action, entity, nil, func(tx *sql.Tx) (string, error) {
+			if err := set(ctx, tx, id, enabled); err != nil {
+				return "", err
+			}
+			updated, err := get(ctx, tx, id)
+			result = updated
+			return strconv.Itoa(id), err
+		})
+		if err != nil {
+			returnAdminWriteError(err, w, contentType)
+			return
+		}
+		returnResponse(result, http.StatusOK, w, contentType)
+	}
+}
+
+func (s *Server) AdminListCountriesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := NewHandlerContext(r.Context(), s.config.RequestTimeout)
+	defer cancel()
+	contentType := responseContentType(r)
+	_db, ok := s.adminDB(w, contentType)
+	if !ok {
+		return
+	}
+
+	countries, err := db.GetCountries(ctx, _db)
+	if err != nil {
+		returnError("unable to get countries", err.Error(), http.StatusInternalServerError, w, contentType)
+		return
+	}
+	returnResponse(countries, http.StatusOK, w, contentType)
+}
+
+func (s *Server) AdminCreateCountryHandler(w http.ResponseWriter, r *http.Request) {
+	contentType := responseContentType(r)
+	request := r.Context().Value("request").(models.AdminCountryRequest)
+	if reqErr := validateCountryRequest(request); reqErr != nil {
+		returnError(reqErr.Message, reqErr.DetailedMessage, reqErr.StatusCode, w, contentType)
+		return
+	}
+	_db, ok := s.adminDB(w, contentType)
+	if !ok {
+		return
+	}
+
+	country := db.Country{Name: strings.TrimSpace(request.Name), Code: request.Code}
+	err := auditedWrite(r.Context(), _db, "create", "country", request, func(tx *sql.Tx) (string, error) {
+		err := db.CreateCountry(r.Context(), tx, &country)
+		return strconv.Itoa(country.ID), err
+	})
+	if err != nil {
+		returnAdminWriteError(err, w, contentType)
+		return
+	}
+	returnResponse(country, http.StatusCreated, w, contentType)
+}
+
+func (s *Server) AdminUpdateCountryHandler(w http.ResponseWriter, r *http.Request) {
+	contentType := responseContentType(r)
+	request := r.Context().Value("request").(models.AdminCountryRequest)
+	id, ok := pathID(w, r, "id", contentType)
+	if !ok {
+		return
+	}
+	if reqErr := validateCountryRequest(request); reqErr != nil {
+		returnError(reqErr.Message, reqErr.DetailedMessage, reqErr.StatusCode, w, contentType)
+		return
+	}
+	_db, ok := s.adminDB(w, contentType)
+	if !ok {
+		return
+	}
+
+	country := db.Country{ID: id, Name: strings.TrimSpace(request.Name), Code: request.Code}
+	err := auditedWrite(r.Context(), _db, "update", "country", request, func(tx *sql.Tx) (string, error) {
+		if err := db.UpdateCountry(r.Context(), tx, &country); err != nil {
+			return "", err
+		}
+		updated, err := db.GetCountry(r.Context(), tx, id)
+		country = updated
+		return strconv.Itoa(id), err
+	})
+	if err != nil {
+		returnAdminWriteError(err, w, contentType)
+		return
+	}
+	returnResponse(country, http.StatusOK, w, contentType)
+}
+
+func (s *Server) AdminListCurrenciesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := NewHandlerContext(r.Context(), s.config.RequestTimeout)
+	defer cancel()
+	contentType := responseContentType(r)
+	_db, ok := s.adminDB(w, contentType)
+	if !ok {
+		return
+	}
+
+	currencies, err := db.GetCurrencies(ctx, _db)
+	if err != nil {
+		returnError("unable to get currencies", err.Error(), http.StatusInternalServerError, w, contentType)
+		return
+	}
+	returnResponse(currencies, http.StatusOK, w, contentType)
+}
+
+func (s *Server) AdminCreateCurrencyHandler(w http.ResponseWriter, r *http.Request) {
+	contentType := responseContentType(r)
+	request := r.Context().Value("request").(models.AdminCurrencyRequest)
+	if reqErr := validateCurrencyRequest(request); reqErr != nil {
+		returnError(reqErr.Message, reqErr.DetailedMessage, reqErr.StatusCode, w, contentType)
+		return
+	}
+	_db, ok := s.adminDB(w, contentType)
+	if !ok {
+		return
+	}
+
+	currency := db.Currency{Symbol: request.Symbol}
+	err := auditedWrite(r.Context(), _db, "create", "currency", request, func(tx *sql.Tx) (string, error) {
+		err := db.CreateCurrency(r.Context(), tx, &currency)
+		return strconv.Itoa(currency.ID), err
+	})
+	if err != nil {
+		returnAdminWriteError(err, w, contentType)
+		return
+	}
+	returnResponse(currency, http.StatusCreated, w, contentType)
+}
+
+func (s *Server) AdminUpdateCurrencyHandler(w http.ResponseWriter, r *http.Request) {
+	contentType := responseContentType(r)
+	request := r.Context().Value("request").(models.AdminCurrencyRequest)
+	id, ok := pathID(w, r, "id", contentType)
+	if !ok {
+		return
+	}
+	if reqErr := validateCurrencyRequest(request); reqErr != nil {
+		returnError(reqErr.Message, reqErr.DetailedMessage, reqErr.StatusCode, w, contentType)
+		return
+	}
+	_db, ok := s.adminDB(w, contentType)
+	if !ok {
+		return
+	}
+
+	currency := db.Currency{ID: id, Symbol: request.Symbol}
+	err := auditedWrite(r.Context(), _db, "update", "currency", request, func(tx *sql.Tx) (string, error) {
+		if err := db.UpdateCurrency(r.Context(), tx, &currency); err != nil {
+			return "", err
+		}
+		updated, err := db.GetCurrency(r.Context(), tx, id)
+		currency = updated
+		return strconv.Itoa(id), err
+	})
+	if err != nil {
+		returnAdminWriteError(err, w, contentType)
+		return
+	}
+	returnResponse(currency, http.StatusOK, w, contentType)
+}
+
+func (s *Server) AdminListGatewayCountriesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := NewHandlerContext(r.Context(), s.config.RequestTimeout)
+	defer cancel()
+	contentType := responseContentType(r)
+	id, ok := pathID(w, r, "id", contentType)
+	if !ok {
+		return
+	}
+	_db, ok := s.adminDB(w, contentType)
+	if !ok {
+		return
+	}
+
+	countries, err := db.GetGatewayCountries(ctx, _db, id)
+	if err != nil {
+		returnError("unable to get countries", err.Error(), http.StatusInternalServerError, w, contentType)
+		return
+	}
+	returnResponse(countries, http.StatusOK, w, contentType)
+}
+
+func (s *Server) AdminListCountryCurrenciesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := NewHandlerContext(r.Context(), s.config.RequestTimeout)
+	defer cancel()
+	contentType := responseContentType(r)
+	id, ok := pathID(w, r, "id", contentType)
+	if !ok {
+		return
+	}
+	_db, ok := s.adminDB(w, contentType)
+	if !ok {
+		return
+	}
+
+	currencies, err := db.GetSupportedCurrenciesFromCountry(ctx, _db, id)
+	if err != nil {
+		returnError("unable to get currencies", err.Error(), http.StatusInternalServerError, w, contentType)
+		return
+	}
+	returnResponse(currencies, http.StatusOK, w, contentType)
+}
+
+// returns a handler adding or removing the mapping between the {id} and {targetID} path parameters
+func (s *Server) adminMappingHandler(entity, action string, write func(context.Context, db.Execer, int, int) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := NewHandlerContext(r.Context(), s.config.RequestTimeout)
+		defer cancel()
+		ctx = withAdmin(ctx, adminActor(r.Context()), adminRoles(r.Context()))
+		contentType := responseContentType(r)
+		id, ok := pathID(w, r, "id", contentType)
+		if !ok {
+			return
+		}
+		targetID, ok := pathID(w, r, "targetID", contentType)
+		if !ok {
+			return
+		}
+		_db, ok := s.adminDB(w, contentType)
+		if !ok {
+			return
+		}
+
+		entityID := fmt.Sprintf("%d:%d", id, targetID)
+		err := auditedWrite(ctx, _db, action, entity, nil, func(tx *sql.Tx) (string, error) {
+			return entityID, write(ctx, tx, id, targetID)
+		})
+		if err != nil {
+			returnAdminWriteError(err, w, contentType)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Returns the runtime config in effect with its version, which changes whenever a reload changes a setting
+func (s *Server) AdminRuntimeConfigHandler(w http.ResponseWriter, r *http.Request) {
+	returnResponse(s.runtime.Current(), http.StatusOK, w, responseContentType(r))
+}
+
+func (s *Server) setupAdminRoutes(router *mux.Router) {
+	admin := router.PathPrefix("/admin").Subrouter()
+	admin.Use(AdminAuth(s.config.AdminTokens, s.authorize(false, operatorRefusal)))
+	allow := func(permission string, handler http.Handler) http.Handler {
+		return s.requirePermission(permission)(handler)
+	}
+
+	admin.Handle("/gateways", allow(PermissionReferenceRead, http.HandlerFunc(s.AdminListGatewaysHandler))).Methods(http.MethodGet)
+	admin.Handle("/gateways", allow(PermissionReferenceWrite, BodyParseAndTimeout[models.AdminGatewayRequest](s.config.RequestTimeout)(http.HandlerFunc(s.AdminCreateGatewayHandler)))).Methods(http.MethodPost)
+	admin.Handle("/gateways/{id}", allow(PermissionReferenceRead, http.HandlerFunc(s.AdminGetGatewayHandler))).Methods(http.MethodGet)
+	admin.Handle("/gateways/{id}", allow(PermissionReferenceWrite, BodyParseAndTimeout[models.AdminGatewayRequest](s.config.RequestTimeout)(http.HandlerFunc(s.AdminUpdateGatewayHandler)))).Methods(http.MethodPut)
+	admin.Handle("/gateways/{id}/disable", allow(PermissionReferenceWrite, adminSetEnabledHandler(s, "gateway", false, db.SetGatewayEnabled, db.GetGateway))).Methods(http.MethodPost)
+	admin.Handle("/gateways/{id}/enable", allow(PermissionReferenceWrite, adminSetEnabledHandler(s, "gateway", true, db.SetGatewayEnabled, db.GetGateway))).Methods(http.MethodPost)
+	admin.Handle("/gateways/{id}/countries", allow(PermissionReferenceRead, http.HandlerFunc(s.AdminListGatewayCountriesHandler))).Methods(http.MethodGet)
+	admin.Handle("/gateways/{id}/countries/{targetID}", allow(PermissionReferenceWrite, s.adminMappingHandler("gateway_country", "map", db.AddGatewayCountry))).Methods(http.MethodPut)
+	admin.Handle("/gateways/{id}/countries/{targetID}", allow(PermissionReferenceWrite, s.adminMappingHandler("gateway_country", "unmap", db.RemoveGatewayCountry))).Methods(http.MethodDelete)
+
+	admin.Handle("/countries", allow(PermissionReferenceRead, http.HandlerFunc(s.AdminListCountriesHandler))).Methods(http.MethodGet)
+	admin.Handle("/countries", allow(PermissionReferenceWrite, BodyParseAndTimeout[models.AdminCountryRequest](s.config.RequestTimeout)(http.HandlerFunc(s.AdminCreateCountryHandler)))).Methods(http.MethodPost)
+	admin.Handle("/countries/{id}", allow(PermissionReferenceWrite, BodyParseAndTimeout[models.AdminCountryRequest](s.config.RequestTimeout)(http.HandlerFunc(s.AdminUpdateCountryHandler)))).Methods(http.MethodPut)
+	admin.Handle("/countries/{id}/disable", allow(PermissionReferenceWrite, adminSetEnabledHandler(s, "country", false, db.SetCountryEnabled, db.GetCountry))).Methods(http.MethodPost)
+	admin.Handle("/countries/{id}/enable", allow(PermissionReferenceWrite, adminSetEnabledHandler(s, "country", true, db.SetCountryEnabled, db.GetCountry))).Methods(http.MethodPost)
+	admin.Handle("/countries/{id}/currencies", allow(PermissionReferenceRead, http.HandlerFunc(s.AdminListCountryCurrenciesHandler))).Methods(http.MethodGet)
+	admin.Handle("/countries/{id}/currencies/{targetID}", allow(PermissionReferenceWrite, s.adminMappingHandler("country_currency", "map", db.AddCountryCurrency))).Methods(http.MethodPut)
+	admin.Handle("/countries/{id}/currencies/{targetID}", allow(PermissionReferenceWrite, s.adminMappingHandler("country_currency", "unmap", db.RemoveCountryCurrency))).Methods(http.MethodDelete)
+
+	admin.Handle("/currencies", allow(PermissionReferenceRead, http.HandlerFunc(s.AdminListCurrenciesHandler))).Methods(http.MethodGet)
+	admin.Handle("/currencies", allow(PermissionReferenceWrite, BodyParseAndTimeout[models.AdminCurrencyRequest](s.config.RequestTimeout)(http.HandlerFunc(s.AdminCreateCurrencyHandler)))).Methods(http.MethodPost)
+	admin.Handle("/currencies/{id}", allow(PermissionReferenceWrite, BodyParseAndTimeout[models.AdminCurrencyRequest](s.config.RequestTimeout)(http.HandlerFunc(s.AdminUpdateCurrencyHandler)))).Methods(http.MethodPut)
+	admin.Handle("/currencies/{id}/disable", allow(PermissionReferenceWrite, adminSetEnabledHandler(s, "currency", false, db.SetCurrencyEnabled, db.GetCurrency))).Methods(http.MethodPost)
+	admin.Handle("/currencies/{id}/enable", allow(PermissionReferenceWrite, adminSetEnabledHandler(s, "currency", true, db.SetCurrencyEnabled, db.GetCurrency))).Methods(http.MethodPost)
+
+	admin.Handle("/runtime-config", allow(PermissionRuntimeConfigRead, http.HandlerFunc(s.AdminRuntimeConfigHandler))).Methods(http.MethodGet)
+
+	admin.Handle("/merchants", allow(PermissionMerchantsRead, http.HandlerFunc(s.AdminListMerchantsHandler))).Methods(http.MethodGet)
+	admin.Handle("/merchants", allow(PermissionMerchantsWrite, BodyParseAndTimeout[models.AdminMerchantRequest](s.config.RequestTimeout)(http.HandlerFunc(s.AdminCreateMerchantHandler)))).Methods(http.MethodPost)
+	admin.Handle("/merchants/{id}", allow(PermissionMerchantsRead, http.HandlerFunc(s.AdminGetMerchantHandler))).Methods(http.MethodGet)
+	admin.Handle("/merchants/{id}/webhook", allow(PermissionMerchantsWrite, BodyParseAndTimeout[models.AdminMerchantWebhookRequest](s.config.RequestTimeout)(http.HandlerFunc(s.AdminSetMerchantWebhookHandler)))).Methods(http.MethodPut)
+	admin.Handle("/merchants/{id}/transactions", allow(PermissionMerchantTransactionsRead, http.HandlerFunc(s.AdminListMerchantTransactionsHandler))).Methods(http.MethodGet)
+
+	admin.Handle("/api-keys", allow(PermissionAPIKeysRead, http.HandlerFunc(s.AdminListAPIKeysHandler))).Methods(http.MethodGet)
+	admin.Handle("/api-keys", allow(PermissionAPIKeysWrite, BodyParseAndTimeout[models.AdminAPIKeyRequest](s.config.RequestTimeout)(http.HandlerFunc(s.AdminCreateAPIKeyHandler)))).Methods(http.MethodPost)
+	admin.Handle("/api-keys/{id}/rotate", allow(PermissionAPIKeysWrite, http.HandlerFunc(s.AdminRotateAPIKeyHandler))).Methods(http.MethodPost)
+	admin.Handle("/api-keys/{id}", allow(PermissionAPIKeysWrite, http.HandlerFunc(s.AdminRevokeAPIKeyHandler))).Methods(http.MethodDelete)
+
+	admin.Handle("/audit", allow(PermissionAuditRead, http.HandlerFunc(s.AdminAuditHandler))).Methods(http.MethodGet)
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/internal/models"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestAdminAuth(t *testing.T) {
+	var actor string
+	handler := AdminAuth([]AdminToken{{Actor: "alice", Token: "secret"}}, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor = adminActor(r.Context())
+	}))
+
+	for header, expected := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"Basic secret":  http.StatusUnauthorized,
+		"Bearer secret": http.StatusOK,
+	} {
+		actor = ""
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/admin/gateways", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		handler.ServeHTTP(rr, req)
+		if rr.Code != expected {
+			t.Errorf("%q: expected %d received %d", header, expected, rr.Code)
+		}
+		if expected == http.StatusOK && actor != "alice" {
+			t.Errorf("Expected actor alice in context, got %q", actor)
+		}
+	}
+}
+
+func TestParseAdminTokens(t *testing.T) {
+	tokens := ParseAdminTokens("alice:secret, bob:other:viewer+finance,broken,:missing,eve:third:root")
+	expected := []AdminToken{
+		{Actor: "alice", Token: "secret", Roles: []string{RoleAdmin}},
+		{Actor: "bob", Token: "other", Roles: []string{RoleViewer, RoleFinance}},
+	}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("unexpected tokens %+v", tokens)
+	}
+}
+
+func TestAdminValidation(t *testing.T) {
+	if err := validateGatewayRequest(models.AdminGatewayRequest{Name: "Gateway 3", DataFormatSupported: "application/json", DataFormats: []string{"application/x-protobuf"}}); err != nil {
+		t.Errorf("Expected gateway to be valid, got %v", err)
+	}
+	if err := validateGatewayRequest(models.AdminGatewayRequest{Name: "Gateway 3", DataFormatSupported: "text/plain"}); err == nil {
+		t.Errorf("Expected unsupported data format to be rejected")
+	}
+	if err := validateGatewayRequest(models.AdminGatewayRequest{Name: " ", DataFormatSupported: "application/json"}); err == nil {
+		t.Errorf("Expected blank name to be rejected")
+	}
+	if err := validateCountryRequest(models.AdminCountryRequest{Name: "United Kingdom", Code: "gb"}); err == nil {
+		t.Errorf("Expected lower case country code to be rejected")
+	}
+	if err := validateCurrencyRequest(models.AdminCurrencyRequest{Symbol: "GBPX"}); err == nil {
+		t.Errorf("Expected four letter currency to be rejected")
+	}
+	if err := validateMerchantWebhookRequest(models.AdminMerchantWebhookRequest{}); err != nil {
+		t.Errorf("Expected an empty webhook URL to remove the webhook, got %v", err)
+	}
+	if err := validateMerchantWebhookRequest(models.AdminMerchantWebhookRequest{WebhookURL: "/codes"}); err == nil {
+		t.Errorf("Expected relative webhook URL to be rejected")
+	}
+}
+
+func TestAuditedWriteRecordsEntryInSameTransaction(t *testing.T) {
+	_db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE gateways SET enabled").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("LOCK TABLE audit_log").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT id, hash FROM audit_log").WillReturnRows(sqlmock.NewRows([]string{"id", "hash"}).AddRow(41, "abc"))
+	mock.ExpectExec("INSERT INTO audit_log").
+		WithArgs(42, "alice", sqlmock.AnyArg(), "disable", "gateway", "3", "", nil, nil, sqlmock.AnyArg(), "abc", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	ctx := context.WithValue(context.Background(), "adminActor", "alice")
+	err = auditedWrite(ctx, _db, "disable", "gateway", nil, func(tx *sql.Tx) (string, error) {
+		_, err := tx.Exec("UPDATE gateways SET enabled = false WHERE id = 3")
+		return "3", err
+	})
+	if err == nil {
+		err = mock.ExpectationsWereMet()
+	}
+	if err != nil {
+		t.Error(err)
+	}
+}
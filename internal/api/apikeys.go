@@ -0,0 +1,184 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"payment-gateway/db"
+	"payment-gateway/internal/models"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Admin endpoints issuing, rotating and revoking API keys. A key's secrets are only returned in the
+// response creating it, what is stored can't be turned back into them.
+
+func validateAPIKeyRequest(request models.AdminAPIKeyRequest) *requestError {
+	if name := strings.TrimSpace(request.Name); name == "" || len(name) > 255 {
+		return &requestError{StatusCode: http.StatusBadRequest, Message: "Invalid name", DetailedMessage: "Name must be between 1 and 255 characters"}
+	}
+	if len(request.Scopes) == 0 && len(request.Roles) == 0 {
+		return &requestError{StatusCode: http.StatusBadRequest, Message: "Invalid scopes", DetailedMessage: "A key needs at least one scope or role"}
+	}
+	for _, scope := range request.Scopes {
+		if !containsString(Scopes, scope) {
+			return &requestError{StatusCode: http.StatusBadRequest, Message: "Invalid scopes", DetailedMessage: fmt.Sprintf("%q is not one of %s", scope, strings.Join(Scopes, ", "))}
+		}
+		// administering the gateway isn't something a merchant does, nor settling transactions, which only gateways
+		// report. Operator keys don't act for a merchant.
+		if request.MerchantID != 0 && containsString(operatorScopes, scope) {
+			return &requestError{StatusCode: http.StatusBadRequest, Message: "Invalid scopes", DetailedMessage: fmt.Sprintf("Keys of a merchant can't have the %s scope", scope)}
+		}
+		if request.MerchantID == 0 && !containsString(operatorScopes, scope) {
+			return &requestError{StatusCode: http.StatusBadRequest, Message: "Invalid scopes", DetailedMessage: fmt.Sprintf("Keys without a merchant can only have %s", strings.Join(operatorScopes, ", "))}
+		}
+	}
+	if err := validateRoles(request.Roles); err != nil {
+		return &requestError{StatusCode: http.StatusBadRequest, Message: "Invalid roles", DetailedMessage: err.Error()}
+	}
+	if request.MerchantID != 0 && len(request.Roles) > 0 {
+		return &requestError{StatusCode: http.StatusBadRequest, Message: "Invalid roles", DetailedMessage: "Keys of a merchant can't have roles"}
+	}
+	if request.MerchantID < 0 {
+		return &requestError{StatusCode: http.StatusBadRequest, Message: "Invalid merchant", DetailedMessage: "merchant_id must be positive"}
+	}
+	return nil
+}
+
+func apiKeyResponse(key db.APIKey) models.APIKeyResponse {
+	return models.APIKeyResponse{
+		ID:         key.ID,
+		MerchantID: key.MerchantID,
+		Name:       key.Name,
+		Scopes:     key.Scopes,
+		Roles:      key.Roles,
+		Signed:     key.SigningSecret != "",
+		CreatedAt:  key.CreatedAt,
+		RevokedAt:  key.RevokedAt,
+	}
+}
+
+// the response handing out a new key, the only one carrying its secrets
+func issuedKeyResponse(issued issuedKey) models.APIKeyResponse {
+	response := apiKeyResponse(issued.Key)
+	response.Key = issued.Token
+	response.SigningSecret = issued.SigningSecret
+	return response
+}
+
+func (s *Server) AdminListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := NewHandlerContext(r.Context(), s.config.RequestTimeout)
+	defer cancel()
+	contentType := responseContentType(r)
+	_db, ok := s.adminDB(w, contentType)
+	if !ok {
+		return
+	}
+
+	keys, err := db.GetAPIKeys(ctx, _db)
+	if err != nil {
+		returnError("unable to get API keys", err.Error(), http.StatusInternalServerError, w, contentType)
+		return
+	}
+	response := []models.APIKeyResponse{}
+	for _, key := range keys {
+		response = append(response, apiKeyResponse(key))
+	}
+	returnResponse(response, http.StatusOK, w, contentType)
+}
+
+func (s *Server) AdminCreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	contentType := responseContentType(r)
+	request := r.Context().Value("request").(models.AdminAPIKeyRequest)
+	if reqErr := validateAPIKeyRequest(request); reqErr != nil {
+		returnError(reqErr.Message, reqErr.DetailedMessage, reqErr.StatusCode, w, contentType)
+		return
+	}
+	_db, ok := s.adminDB(w, contentType)
+	if !ok {
+		return
+	}
+
+	issued, err := s.issueAPIKey(request.MerchantID, strings.TrimSpace(request.Name), request.Scopes, request.Signed)
+	if err != nil {
+		returnError("unable to create API key", err.Error(), http.StatusInternalServerError, w, contentType)
+		return
+	}
+	issued.Key.Roles = request.Roles
+	err = auditedWrite(r.Context(), _db, "create", "api_key", request, func(tx *sql.Tx) (string, error) {
+		return issued.Key.ID, db.CreateAPIKey(r.Context(), tx, &issued.Key)
+	})
+	if err != nil {
+		returnAdminWriteError(err, w, contentType)
+		return
+	}
+	returnResponse(issuedKeyResponse(issued), http.StatusCreated, w, contentType)
+}
+
+// Issues a replacement for the same merchant with the same name, scopes and roles. The old key keeps working for the configured grace period
+// so clients can switch without downtime.
+func (s *Server) AdminRotateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := NewHandlerContext(r.Context(), s.config.RequestTimeout)
+	defer cancel()
+	ctx = withAdmin(ctx, adminActor(r.Context()), adminRoles(r.Context()))
+	contentType := responseContentType(r)
+	keyID := mux.Vars(r)["id"]
+	_db, ok := s.adminDB(w, contentType)
+	if !ok {
+		return
+	}
+
+	now := s.clock()
+	var issued issuedKey
+	err := auditedWrite(ctx, _db, "rotate", "api_key", nil, func(tx *sql.Tx) (string, error) {
+		old, err := db.GetAPIKey(ctx, tx, keyID)
+		if err != nil {
+			return "", err
+		}
+		if !old.Active(now) {
+			return "", fmt.Errorf("API key %s is revoked: %w", keyID, db.ErrConflict)
+		}
+		if issued, err = s.issueAPIKey(old.MerchantID, old.Name, old.Scopes, old.SigningSecret != ""); err != nil {
+			return "", err
+		}
+		issued.Key.Roles = old.Roles
+		if err := db.CreateAPIKey(ctx, tx, &issued.Key); err != nil {
+			return "", err
+		}
+		return keyID, db.RevokeAPIKey(ctx, tx, keyID, now.Add(s.config.KeyRotationGrace))
+	})
+	if err != nil {
+		returnAdminWriteError(err, w, contentType)
+		return
+	}
+	returnResponse(issuedKeyResponse(issued), http.StatusCreated, w, contentType)
+}
+
+// Refuses the key from now on, also when it was rotated and still in its grace period
+func (s *Server) AdminRevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := NewHandlerContext(r.Context(), s.config.RequestTimeout)
+	defer cancel()
+	ctx = withAdmin(ctx, adminActor(r.Context()), adminRoles(r.Context()))
+	contentType := responseContentType(r)
+	keyID := mux.Vars(r)["id"]
+	_db, ok := s.adminDB(w, contentType)
+	if !ok {
+		return
+	}
+
+	var key db.APIKey
+	err := auditedWrite(ctx, _db, "revoke", "api_key", nil, func(tx *sql.Tx) (string, error) {
+		if err := db.RevokeAPIKey(ctx, tx, keyID, s.clock()); err != nil {
+			return "", err
+		}
+		revoked, err := db.GetAPIKey(ctx, tx, keyID)
+		key = revoked
+		return keyID, err
+	})
+	if err != nil {
+		returnAdminWriteError(err, w, contentType)
+		return
+	}
+	returnResponse(apiKeyResponse(key), http.StatusOK, w, contentType)
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"payment-gateway/db"
+	"payment-gateway/internal/logging"
+	"payment-gateway/internal/models"
+	"strconv"
+)
+
+// Transactions created and settled through the payment API, admin writes, refused admin requests and reference data
+// syncs are recorded in the audit log, see db/audit.go. Each entry is written in the DB transaction of the change it
+// records. The log can be read through GET /admin/audit and checked with the verify-audit command.
+
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+)
+
+// who the audit log names for the request in ctx: the admin actor, the API key or anonymous
+func auditActor(ctx context.Context) string {
+	if actor := adminActor(ctx); actor != "" {
+		return actor
+	}
+	if id := createdBy(ctx); id != "" {
+		return id
+	}
+	return "anonymous"
+}
+
+// An entry for action on the entity taken by the request in ctx. before and after are encoded as JSON, nil leaves
+// them empty.
+func newAuditEntry(ctx context.Context, action, entity, entityID string, before, after interface{}) (db.AuditEntry, error) {
+	entry := db.AuditEntry{
+		Actor:     auditActor(ctx),
+		Roles:     adminRoles(ctx),
+		Action:    action,
+		Entity:    entity,
+		EntityID:  entityID,
+		RequestID: logging.RequestID(ctx),
+	}
+	var err error
+	if entry.Before, err = db.AuditJSON(before); err != nil {
+		return db.AuditEntry{}, err
+	}
+	if entry.After, err = db.AuditJSON(after); err != nil {
+		return db.AuditEntry{}, err
+	}
+	return entry, nil
+}
+
+// Appends the entry to the audit log through store, call it with the store of the DB transaction making the change
+func appendAuditEntry(ctx context.Context, store db.Store, action, entity, entityID string, before, after interface{}) error {
+	entry, err := newAuditEntry(ctx, action, entity, entityID, before, after)
+	if err != nil {
+		return err
+	}
+	return store.AuditLog().AppendAuditEntry(ctx, &entry)
+}
+
+// Records the creation of transaction through store, the store of the DB transaction creating it
+func auditTransactionCreated(ctx context.Context, store db.Store, transaction db.Transaction) error {
+	return appendAuditEntry(ctx, store, "create", "transaction", strconv.Itoa(transaction.ID), nil, createdTransaction(transaction))
+}
+
+// what the audit log records of a new transaction
+func createdTransaction(transaction db.Transaction) map[string]interface{} {
+	created := map[string]interface{}{
+		"type": transaction.Type, "amount": transaction.Amount, "user_id": transaction.UserID,
+		"gateway_id": transaction.GatewayID, "status": transaction.Status,
+	}
+	if transaction.OriginalID != 0 {
+		created["original_id"] = transaction.OriginalID
+	}
+	return created
+}
+
+// Settles a transaction sent to a gateway, recording who changed its status in the same DB transaction. Wraps
+// db.ErrConflict when it was settled meanwhile.
+func (s *Server) settleTransaction(ctx context.Context, transaction db.Transaction, status db.TransactionStatus) error {
+	return s.store.InTx(ctx, func(tx db.Store) error {
+		if err := tx.Transactions().UpdateTransactionStatus(ctx, merchantID(ctx), transaction.ID, transaction.Type, db.SENT, status); err != nil {
+			return err
+		}
+		return appendAuditEntry(ctx, tx, "update", "transaction", strconv.Itoa(transaction.ID),
+			map[string]db.TransactionStatus{"status": db.SENT}, map[string]db.TransactionStatus{"status": status})
+	})
+}
+
+// Entries of the audit log oldest first, filtered by the actor, action, entity, entity_id and request_id query
+// parameters. Pages hold limit entries, 100 by default, and the next page starts after the after_id parameter.
+func (s *Server) AdminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := NewHandlerContext(r.Context(), s.config.RequestTimeout)
+	defer cancel()
+	contentType := responseContentType(r)
+	query := r.URL.Query()
+
+	filter := db.AuditFilter{
+		Actor:     query.Get("actor"),
+		Action:    query.Get("action"),
+		Entity:    query.Get("entity"),
+		EntityID:  query.Get("entity_id"),
+		RequestID: query.Get("request_id"),
+		Limit:     defaultAuditPageSize,
+	}
+	for _, param := range []struct {
+		name  string
+		value *int
+		max   int
+	}{{"after_id", &filter.AfterID, 0}, {"limit", &filter.Limit, maxAuditPageSize}} {
+		raw := query.Get(param.name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 || (param.max > 0 && (n == 0 || n > param.max)) {
+			returnError("Invalid "+param.name, "", http.StatusBadRequest, w, contentType)
+			return
+		}
+		*param.value = n
+	}
+
+	entries, err := s.store.AuditLog().GetAuditEntries(ctx, filter)
+	if err != nil {
+		returnError("unable to get audit entries", err.Error(), http.StatusInternalServerError, w, contentType)
+		return
+	}
+	response := models.AuditEntriesResponse{Entries: []models.AuditEntryResponse{}}
+	for _, entry := range entries {
+		response.Entries = append(response.Entries, auditEntryResponse(entry))
+	}
+	if len(entries) == filter.Limit {
+		response.NextAfterID = entries[len(entries)-1].ID
+	}
+	returnResponse(response, http.StatusOK, w, contentType)
+}
+
+func auditEntryResponse(entry db.AuditEntry) models.AuditEntryResponse {
+	return models.AuditEntryResponse{
+		ID:        entry.ID,
+		Actor:     entry.Actor,
+		Roles:     entry.Roles,
+		Action:    entry.Action,
+		Entity:    entry.Entity,
+		EntityID:  entry.EntityID,
+		RequestID: entry.RequestID,
+		Before:    entry.Before,
+		After:     entry.After,
+		CreatedAt: entry.CreatedAt,
+		PrevHash:  entry.PrevHash,
+		Hash:      entry.Hash,
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/db"
+	"payment-gateway/internal/logging"
+	"payment-gateway/internal/models"
+	"strings"
+	"testing"
+)
+
+func TestTransactionChangesAreAudited(t *testing.T) {
+	s, store := newAuthTestServer(t, Config{AdminTokens: []AdminToken{
+		{Actor: "fay", Token: "finance-token", Roles: []string{RoleFinance}},
+		{Actor: "val", Token: "viewer-token", Roles: []string{RoleViewer}},
+	}})
+	key := createTestKey(t, s, store, false, ScopeDepositCreate, ScopeTransactionsSettle)
+
+	rr := httptest.NewRecorder()
+	req := newDepositRequest()
+	req.Header.Set("Authorization", "Bearer "+key.Token)
+	s.ServeHTTP(rr, req)
+	if code := responseStatus(t, rr); code != http.StatusCreated {
+		t.Fatalf("Expected the deposit to be created, got %d: %s", code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPut, "/deposit", strings.NewReader(`{"transaction_id": 1, "status": "success"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+key.Token)
+	s.ServeHTTP(rr, req)
+	if code := responseStatus(t, rr); code != http.StatusOK {
+		t.Fatalf("Expected the deposit to be settled, got %d: %s", code, rr.Body.String())
+	}
+	settledBy := rr.Header().Get(logging.RequestIDHeader)
+
+	get := func(token, query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/admin/audit"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		s.ServeHTTP(rr, req)
+		return rr
+	}
+	rr = get("finance-token", "?entity=transaction&entity_id=1")
+	var response models.APIResponse[models.AuditEntriesResponse]
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the audit entries, got %s", rr.Body.String())
+	}
+	entries := response.Data.Entries
+	if len(entries) != 2 || response.Data.NextAfterID != 0 {
+		t.Fatalf("Expected the creation and the settlement, got %+v", response.Data)
+	}
+	if created := entries[0]; created.Actor != key.Key.ID || created.Action != "create" || created.Before != nil || !strings.Contains(string(created.After), `"status":"SENT"`) {
+		t.Errorf("Unexpected creation entry %+v", created)
+	}
+	settled := entries[1]
+	if settled.Actor != key.Key.ID || settled.Action != "update" || string(settled.Before) != `{"status":"SENT"}` || string(settled.After) != `{"status":"SUCCESS"}` ||
+		settled.RequestID == "" || (settledBy != "" && settled.RequestID != settledBy) {
+		t.Errorf("Unexpected settlement entry %+v", settled)
+	}
+
+	// the whole log forms an unbroken chain
+	all, err := store.AuditLog().GetAuditEntries(context.Background(), db.AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := &db.AuditVerifier{}
+	for _, entry := range all {
+		if err := verifier.Check(entry); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if rr := get("finance-token", "?limit=1"); !strings.Contains(rr.Body.String(), `"next_after_id":1`) {
+		t.Errorf("Expected a page of one entry pointing at the next, got %s", rr.Body.String())
+	}
+	if code := responseStatus(t, get("finance-token", "?limit=0")); code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid limit to be rejected, got %d", code)
+	}
+	if rr := get("viewer-token", ""); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected viewers to be refused the audit log, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,306 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"payment-gateway/db"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Merchants authenticate with API keys. A key is sent as a bearer token, "Authorization: Bearer <id>.<secret>",
+// unless it was created to sign its requests. Signed requests carry the key ID in X-API-Key, the Unix time in
+// X-Timestamp and in X-Signature the hex encoded HMAC-SHA256, keyed with the signing secret, of
+//
+//	<timestamp>\n<method>\n<path and query>\n<hex encoded SHA-256 of the body>
+//
+// Only a hash of a key's secret is stored. The signing secret is stored encrypted, checking a signature needs it.
+//
+// A key acts for one merchant and only ever sees that merchant's data. Keys without a merchant belong to the
+// operator and may only administer the gateway or settle transactions, which gateways do for every merchant.
+
+// What an API key may be used for. admin includes every other scope.
+const (
+	ScopeDepositCreate    = "deposit:create"
+	ScopeWithdrawalCreate = "withdrawal:create"
+	ScopeRefundCreate     = "refund:create"
+	ScopeTransactionsRead = "transactions:read"
+	// settling transactions, granted to the gateways calling back
+	ScopeTransactionsSettle = "transactions:settle"
+	ScopeAdmin              = "admin"
+)
+
+var Scopes = []string{ScopeDepositCreate, ScopeWithdrawalCreate, ScopeRefundCreate, ScopeTransactionsRead, ScopeTransactionsSettle, ScopeAdmin}
+
+// the scopes of operator keys, which act for no merchant in particular
+var operatorScopes = []string{ScopeTransactionsSettle, ScopeAdmin}
+
+const (
+	APIKeyHeader    = "X-API-Key"
+	TimestampHeader = "X-Timestamp"
+	SignatureHeader = "X-Signature"
+)
+
+// Who a request was made by, put into the context of authenticated requests
+type Principal struct {
+	// the ID of the API key
+	ID string
+	// 0 for operator keys
+	MerchantID int
+	Name       string
+	Scopes     []string
+	// what an operator key may do in the admin API, see rbac.go
+	Roles []string
+}
+
+func (p Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+func withPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, "principal", principal)
+}
+
+// Returns who the request was made by, false for requests accepted without an API key
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value("principal").(Principal)
+	return principal, ok
+}
+
+// the key ID recorded as the creator of what the request creates, empty without an API key
+func createdBy(ctx context.Context) string {
+	principal, _ := PrincipalFrom(ctx)
+	return principal.ID
+}
+
+// The merchant the request acts for: the API key's, db.AllMerchants for operator keys and the default merchant for
+// requests accepted without a key
+func merchantID(ctx context.Context) int {
+	principal, ok := PrincipalFrom(ctx)
+	switch {
+	case !ok:
+		return db.DefaultMerchantID
+	case principal.MerchantID == 0:
+		return db.AllMerchants
+	}
+	return principal.MerchantID
+}
+
+func principalOf(key db.APIKey) Principal {
+	return Principal{ID: key.ID, MerchantID: key.MerchantID, Name: key.Name, Scopes: key.Scopes, Roles: key.Roles}
+}
+
+// A newly issued key: the token handed to the merchant once and what is stored of it
+type issuedKey struct {
+	Token         string
+	SigningSecret string
+	Key           db.APIKey
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Generates a key for the merchant with its secret, and with a signing secret encrypted by s.cipher if it is to
+// sign requests. merchantID is 0 for an operator key.
+func (s *Server) issueAPIKey(merchantID int, name string, scopes []string, signed bool) (issuedKey, error) {
+	idBytes := make([]byte, 8)
+	rand.Read(idBytes)
+	secret := randomString(32)
+	issued := issuedKey{
+		Key: db.APIKey{
+			ID:         "key_" + hex.EncodeToString(idBytes),
+			MerchantID: merchantID,
+			Name:       name,
+			Scopes:     scopes,
+			SecretHash: hashSecret(secret),
+		},
+	}
+	issued.Token = issued.Key.ID + "." + secret
+	if signed {
+		issued.SigningSecret = randomString(32)
+		encrypted, err := s.cipher.Encrypt([]byte(issued.SigningSecret))
+		if err != nil {
+			return issuedKey{}, fmt.Errorf("failed to encrypt the signing secret: %v", err)
+		}
+		issued.Key.SigningSecret = encrypted
+	}
+	return issued, nil
+}
+
+// the string a signed request's signature is computed over
+func signingPayload(timestamp, method, requestURI string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	return timestamp + "\n" + method + "\n" + requestURI + "\n" + hex.EncodeToString(bodyHash[:])
+}
+
+// Signs a request the way clients have to, with the timestamp given
+func SignRequest(r *http.Request, keyID, signingSecret string, body []byte, at time.Time) {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	mac.Write([]byte(signingPayload(timestamp, r.Method, r.URL.RequestURI(), body)))
+	r.Header.Set(APIKeyHeader, keyID)
+	r.Header.Set(TimestampHeader, timestamp)
+	r.Header.Set(SignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+}
+
+var (
+	errNoCredentials      = errors.New("no API key presented")
+	errInvalidCredentials = errors.New("invalid API key")
+)
+
+// Authenticates the request by its bearer token or signature. Returns errNoCredentials when it carries neither,
+// errInvalidCredentials when they don't check out and any other error when the key couldn't be looked up.
+func (s *Server) authenticateRequest(r *http.Request) (Principal, error) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && token != "" {
+		keyID, secret, ok := strings.Cut(token, ".")
+		if !ok {
+			return Principal{}, errInvalidCredentials
+		}
+		key, err := s.activeAPIKey(r.Context(), keyID)
+		if err != nil {
+			return Principal{}, err
+		}
+		// a key meant to sign must not also be usable by sending its secret
+		if key.SigningSecret != "" || subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(key.SecretHash)) != 1 {
+			return Principal{}, errInvalidCredentials
+		}
+		return principalOf(key), nil
+	}
+
+	if signature := r.Header.Get(SignatureHeader); signature != "" {
+		return s.verifySignature(r, r.Header.Get(APIKeyHeader), r.Header.Get(TimestampHeader), signature)
+	}
+	return Principal{}, errNoCredentials
+}
+
+func (s *Server) verifySignature(r *http.Request, keyID, timestamp, signature string) (Principal, error) {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return Principal{}, errInvalidCredentials
+	}
+	if skew := s.clock().Sub(time.Unix(seconds, 0)); skew > s.config.SignatureTolerance || skew < -s.config.SignatureTolerance {
+		return Principal{}, errInvalidCredentials
+	}
+	presented, err := hex.DecodeString(signature)
+	if err != nil {
+		return Principal{}, errInvalidCredentials
+	}
+
+	key, err := s.activeAPIKey(r.Context(), keyID)
+	if err != nil {
+		return Principal{}, err
+	}
+	if key.SigningSecret == "" {
+		return Principal{}, errInvalidCredentials
+	}
+	signingSecret, err := s.cipher.Decrypt(key.SigningSecret)
+	if err != nil {
+		return Principal{}, fmt.Errorf("failed to decrypt the signing secret of %s: %v", key.ID, err)
+	}
+
+	// the body is read to check it and put back for the handler
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBatchBodyBytes+1))
+	if err != nil {
+		return Principal{}, fmt.Errorf("failed to read the body: %v", err)
+	}
+	if len(body) > maxBatchBodyBytes {
+		return Principal{}, errInvalidCredentials
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	mac.Write([]byte(signingPayload(timestamp, r.Method, r.URL.RequestURI(), body)))
+	if !hmac.Equal(presented, mac.Sum(nil)) {
+		return Principal{}, errInvalidCredentials
+	}
+	return principalOf(key), nil
+}
+
+// looks the key up, unknown and revoked keys are invalid credentials
+func (s *Server) activeAPIKey(ctx context.Context, keyID string) (db.APIKey, error) {
+	key, err := s.store.APIKeys().GetAPIKey(ctx, keyID)
+	if errors.Is(err, db.ErrNotFound) {
+		return db.APIKey{}, errInvalidCredentials
+	}
+	if err != nil {
+		return db.APIKey{}, err
+	}
+	if !key.Active(s.clock()) {
+		return db.APIKey{}, errInvalidCredentials
+	}
+	return key, nil
+}
+
+func returnUnauthorized(w http.ResponseWriter, detail string) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	w.WriteHeader(http.StatusUnauthorized)
+	returnError("Unauthorized", detail, http.StatusUnauthorized, w, JSON)
+}
+
+// Requires an API key with scope and puts its principal into the context. Requests without a key are let through
+// anonymously when the server allows it.
+func (s *Server) requireScope(scope string) func(http.Handler) http.Handler {
+	return s.authorize(s.config.AllowAnonymous, func(principal Principal) string {
+		if !principal.HasScope(scope) {
+			return fmt.Sprintf("The API key lacks the %s scope", scope)
+		}
+		if principal.MerchantID == 0 && !containsString(operatorScopes, scope) {
+			return "The API key belongs to no merchant"
+		}
+		return ""
+	})
+}
+
+// Authenticates the request and refuses it with the reason refusal gives for the key, if any. The principal is put
+// into the context of the requests let through.
+func (s *Server) authorize(allowAnonymous bool, refusal func(Principal) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, err := s.authenticateRequest(r)
+			switch {
+			case errors.Is(err, errNoCredentials) && allowAnonymous:
+				next.ServeHTTP(w, r)
+				return
+			case errors.Is(err, errNoCredentials), errors.Is(err, errInvalidCredentials):
+				returnUnauthorized(w, err.Error())
+				return
+			case err != nil:
+				s.logger.Error(r.Context(), "Unable to authenticate request", "error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				returnError("unable to authenticate", "", http.StatusInternalServerError, w, JSON)
+				return
+			}
+
+			if reason := refusal(principal); reason != "" {
+				w.WriteHeader(http.StatusForbidden)
+				returnError("Forbidden", reason, http.StatusForbidden, w, JSON)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), principal)))
+		})
+	}
+}
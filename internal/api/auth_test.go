@@ -0,0 +1,206 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/db"
+	"payment-gateway/db/memory"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/publisher"
+	"payment-gateway/internal/services"
+	"strings"
+	"testing"
+	"time"
+)
+
+var authNow = time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+// a server requiring API keys, with a user able to deposit USD
+func newAuthTestServer(t *testing.T, config Config) (*Server, *memory.Store) {
+	t.Helper()
+	ctx := context.Background()
+	store := memory.NewStore()
+	currency := db.Currency{Symbol: "USD"}
+	country := db.Country{Name: "United States", Code: "US"}
+	gateway := db.Gateway{Name: "Gateway 1", DataFormatSupported: "application/json"}
+	store.CreateCurrency(ctx, &currency)
+	store.CreateCountry(ctx, &country)
+	store.CreateGateway(ctx, &gateway)
+	store.AddCountryCurrency(ctx, country.ID, currency.ID)
+	store.AddGatewayCountry(ctx, gateway.ID, country.ID)
+	store.CreateUser(ctx, &db.User{MerchantID: db.DefaultMerchantID, Username: "johnsmith", Email: "john.smith@example.com", CountryID: country.ID})
+
+	cipher, _ := services.NewAESCipher(make([]byte, 32))
+	s, err := NewServer(Options{
+		Store:     store,
+		Publisher: publisher.NewMemory(),
+		Cipher:    cipher,
+		Config:    config,
+		Clock:     func() time.Time { return authNow },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, store
+}
+
+func createTestKey(t *testing.T, s *Server, store *memory.Store, signed bool, scopes ...string) issuedKey {
+	t.Helper()
+	issued, err := s.issueAPIKey(db.DefaultMerchantID, "merchant", scopes, signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.APIKeys().CreateAPIKey(context.Background(), &issued.Key); err != nil {
+		t.Fatal(err)
+	}
+	return issued
+}
+
+// the status of the response, successful ones only carry it in the body
+func responseStatus(t *testing.T, rr *httptest.ResponseRecorder) int {
+	t.Helper()
+	var response struct {
+		StatusCode int `json:"status_code"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("unable to decode %s: %v", rr.Body.String(), err)
+	}
+	return response.StatusCode
+}
+
+const depositBody = `{"amount": 20, "user_id": 1, "currency": "USD"}`
+
+func newDepositRequest() *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/deposit", strings.NewReader(depositBody))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestBearerKeyAuthenticatesAndRecordsCreator(t *testing.T) {
+	s, store := newAuthTestServer(t, Config{})
+	key := createTestKey(t, s, store, false, ScopeDepositCreate)
+
+	rr := httptest.NewRecorder()
+	req := newDepositRequest()
+	req.Header.Set("Authorization", "Bearer "+key.Token)
+	s.ServeHTTP(rr, req)
+
+	var response models.APIResponse[db.Transaction]
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected response %+v", response)
+	}
+	stored, err := store.GetTransaction(context.Background(), db.DefaultMerchantID, response.Data.ID, db.DEPOSIT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.CreatedBy != key.Key.ID {
+		t.Errorf("Expected the deposit to be created by %s, got %q", key.Key.ID, stored.CreatedBy)
+	}
+}
+
+func TestRequestsAreRefusedWithoutValidKey(t *testing.T) {
+	s, store := newAuthTestServer(t, Config{})
+	key := createTestKey(t, s, store, false, ScopeDepositCreate)
+	readOnly := createTestKey(t, s, store, false, ScopeTransactionsRead)
+	revoked := createTestKey(t, s, store, false, ScopeDepositCreate)
+	store.APIKeys().RevokeAPIKey(context.Background(), revoked.Key.ID, authNow.Add(-time.Second))
+	rotated := createTestKey(t, s, store, false, ScopeDepositCreate)
+	store.APIKeys().RevokeAPIKey(context.Background(), rotated.Key.ID, authNow.Add(time.Hour))
+
+	for name, tc := range map[string]struct {
+		authorization string
+		code          int
+	}{
+		"no key":            {"", http.StatusUnauthorized},
+		"malformed":         {"Bearer nodot", http.StatusUnauthorized},
+		"unknown key":       {"Bearer key_0000000000000000.secret", http.StatusUnauthorized},
+		"wrong secret":      {"Bearer " + key.Key.ID + ".wrong", http.StatusUnauthorized},
+		"revoked":           {"Bearer " + revoked.Token, http.StatusUnauthorized},
+		"missing scope":     {"Bearer " + readOnly.Token, http.StatusForbidden},
+		"in rotation grace": {"Bearer " + rotated.Token, http.StatusCreated},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req := newDepositRequest()
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			s.ServeHTTP(rr, req)
+			if code := responseStatus(t, rr); code != tc.code {
+				t.Errorf("Expected %d, got %d: %s", tc.code, code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestSignedRequests(t *testing.T) {
+	s, store := newAuthTestServer(t, Config{})
+	key := createTestKey(t, s, store, true, ScopeDepositCreate)
+
+	for name, tc := range map[string]struct {
+		sign func(r *http.Request)
+		code int
+	}{
+		"valid": {func(r *http.Request) {
+			SignRequest(r, key.Key.ID, key.SigningSecret, []byte(depositBody), authNow)
+		}, http.StatusCreated},
+		"tampered body": {func(r *http.Request) {
+			SignRequest(r, key.Key.ID, key.SigningSecret, []byte(`{"amount": 2}`), authNow)
+		}, http.StatusUnauthorized},
+		"wrong secret": {func(r *http.Request) {
+			SignRequest(r, key.Key.ID, "other", []byte(depositBody), authNow)
+		}, http.StatusUnauthorized},
+		"stale": {func(r *http.Request) {
+			SignRequest(r, key.Key.ID, key.SigningSecret, []byte(depositBody), authNow.Add(-10*time.Minute))
+		}, http.StatusUnauthorized},
+		"bearer secret": {func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+key.Token)
+		}, http.StatusUnauthorized},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req := newDepositRequest()
+			tc.sign(req)
+			s.ServeHTTP(rr, req)
+			if code := responseStatus(t, rr); code != tc.code {
+				t.Errorf("Expected %d, got %d: %s", tc.code, code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestAnonymousRequestsWhenAllowed(t *testing.T) {
+	s, store := newAuthTestServer(t, Config{AllowAnonymous: true})
+	readOnly := createTestKey(t, s, store, false, ScopeTransactionsRead)
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, newDepositRequest())
+	if code := responseStatus(t, rr); code != http.StatusCreated {
+		t.Errorf("Expected the anonymous deposit to be accepted, got %d: %s", code, rr.Body.String())
+	}
+
+	// a key that is presented still has to be valid and carry the scope
+	rr = httptest.NewRecorder()
+	req := newDepositRequest()
+	req.Header.Set("Authorization", "Bearer "+readOnly.Token)
+	s.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestPrincipalHasScope(t *testing.T) {
+	admin := Principal{Scopes: []string{ScopeAdmin}}
+	reader := Principal{Scopes: []string{ScopeTransactionsRead}}
+	if !admin.HasScope(ScopeDepositCreate) {
+		t.Errorf("Expected admin to include every scope")
+	}
+	if !reader.HasScope(ScopeTransactionsRead) || reader.HasScope(ScopeTransactionsSettle) {
+		t.Errorf("Expected the reader to only have its own scope")
+	}
+}
@@ -0,0 +1,459 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"payment-gateway/db"
+	"payment-gateway/internal/logging"
+	"payment-gateway/internal/models"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	maxBatchRows      = 5000
+	maxBatchBodyBytes = 10 << 20
+)
+
+// the columns a withdrawal batch CSV may have, in any order. The first line must be a header.
+var withdrawalBatchColumns = []string{"amount", "user_id", "currency", "beneficiary_name", "beneficiary_iban", "beneficiary_bic", "beneficiary_country"}
+
+func rejectedRow(rowNumber int, err error) db.BatchJobRow {
+	return db.BatchJobRow{RowNumber: rowNumber, Status: db.ROW_REJECTED, Error: err.Error()}
+}
+
+func withdrawalRequestToRow(rowNumber int, request models.WithdrawalRequest) db.BatchJobRow {
+	row := db.BatchJobRow{
+		RowNumber: rowNumber,
+		Amount:    decimal.NewNullDecimal(request.Amount),
+		UserID:    request.UserID,
+		Currency:  request.Currency,
+	}
+	if b := request.Beneficiary; b != nil {
+		row.BeneficiaryName = b.Name
+		row.BeneficiaryIBAN = b.IBAN
+		row.BeneficiaryBIC = b.BIC
+		row.BeneficiaryCountry = b.Country
+	}
+	return row
+}
+
+func rowToWithdrawalRequest(row db.BatchJobRow) models.WithdrawalRequest {
+	request := models.WithdrawalRequest{
+		Amount:   row.Amount.Decimal,
+		UserID:   row.UserID,
+		Currency: row.Currency,
+	}
+	if row.BeneficiaryName != "" || row.BeneficiaryIBAN != "" {
+		request.Beneficiary = &models.Beneficiary{
+			Name:    row.BeneficiaryName,
+			IBAN:    row.BeneficiaryIBAN,
+			BIC:     row.BeneficiaryBIC,
+			Country: row.BeneficiaryCountry,
+		}
+	}
+	return request
+}
+
+// Parses a CSV withdrawal batch. A row that can't be parsed is kept as a REJECTED row so the client sees
+// every row of their file in the job.
+func parseWithdrawalBatchCSV(body io.Reader) ([]db.BatchJobRow, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CSV header: %v", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range withdrawalBatchColumns[:3] {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header is missing the %s column", required)
+		}
+	}
+
+	var rows []db.BatchJobRow
+	for rowNumber := 1; ; rowNumber++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, err
+			}
+			rows = append(rows, rejectedRow(rowNumber, err))
+			continue
+		}
+		if len(rows) >= maxBatchRows {
+			return nil, fmt.Errorf("a batch may have at most %d rows", maxBatchRows)
+		}
+
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		amount, err := decimal.NewFromString(field("amount"))
+		if err != nil {
+			rows = append(rows, rejectedRow(rowNumber, fmt.Errorf("Invalid amount: %q", field("amount"))))
+			continue
+		}
+		userID, err := strconv.Atoi(field("user_id"))
+		if err != nil {
+			rows = append(rows, rejectedRow(rowNumber, fmt.Errorf("Invalid user_id: %q", field("user_id"))))
+			continue
+		}
+
+		request := models.WithdrawalRequest{Amount: amount, UserID: userID, Currency: field("currency")}
+		if name, iban := field("beneficiary_name"), field("beneficiary_iban"); name != "" || iban != "" {
+			request.Beneficiary = &models.Beneficiary{Name: name, IBAN: iban, BIC: field("beneficiary_bic"), Country: field("beneficiary_country")}
+		}
+		rows = append(rows, withdrawalRequestToRow(rowNumber, request))
+	}
+	return rows, nil
+}
+
+// Parses a JSON array of withdrawal requests. Each element is decoded on its own so one bad element
+// only rejects that row.
+func parseWithdrawalBatchJSON(body io.Reader) ([]db.BatchJobRow, error) {
+	var elements []json.RawMessage
+	if err := json.NewDecoder(body).Decode(&elements); err != nil {
+		return nil, fmt.Errorf("body must be a JSON array of withdrawals: %v", err)
+	}
+	if len(elements) > maxBatchRows {
+		return nil, fmt.Errorf("a batch may have at most %d rows", maxBatchRows)
+	}
+
+	rows := make([]db.BatchJobRow, 0, len(elements))
+	for i, element := range elements {
+		var request models.WithdrawalRequest
+		if err := json.Unmarshal(element, &request); err != nil {
+			rows = append(rows, rejectedRow(i+1, err))
+			continue
+		}
+		rows = append(rows, withdrawalRequestToRow(i+1, request))
+	}
+	return rows, nil
+}
+
+func batchJobResponse(job db.BatchJob, failedRows []db.BatchJobRow) models.BatchJobResponse {
+	response := models.BatchJobResponse{
+		BatchID:       job.ID,
+		Status:        string(job.Status),
+		TotalRows:     job.TotalRows,
+		ProcessedRows: job.ProcessedRows,
+		SucceededRows: job.SucceededRows,
+		FailedRows:    job.FailedRows,
+		Created:       job.CreatedAt,
+		Completed:     job.CompletedAt,
+		ResultURL:     fmt.Sprintf("/withdrawals/batch/%d/result", job.ID),
+	}
+	for _, row := range failedRows {
+		response.Errors = append(response.Errors, models.BatchRowError{Row: row.RowNumber, Status: string(row.Status), Error: row.Error})
+	}
+	return response
+}
+
+// Takes a batch of withdrawals as CSV (text/csv) or a JSON array (application/json). The batch is stored and
+// processed asynchronously, the response points at the job resource to poll for progress.
+func (s *Server) WithdrawalBatchPostHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := NewHandlerContext(r.Context(), s.config.BatchRequestTimeout)
+	defer cancel()
+
+	clientFormat := strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0])
+	body := http.MaxBytesReader(w, r.Body, maxBatchBodyBytes)
+
+	var rows []db.BatchJobRow
+	var err error
+	switch clientFormat {
+	case "text/csv":
+		rows, err = parseWithdrawalBatchCSV(body)
+	case "application/json":
+		rows, err = parseWithdrawalBatchJSON(body)
+	default:
+		returnError("Unsupported content type", "Batches must be text/csv or application/json", http.StatusBadRequest, w, JSON)
+		return
+	}
+	if err != nil {
+		returnError("Invalid request", err.Error(), http.StatusBadRequest, w, JSON)
+		return
+	}
+	if len(rows) == 0 {
+		returnError("Invalid request", "The batch has no rows", http.StatusBadRequest, w, JSON)
+		return
+	}
+
+	if s.db == nil {
+		returnError("unable to connect to DB", "", http.StatusInternalServerError, w, JSON)
+		return
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		returnError("unable to create batch", err.Error(), http.StatusInternalServerError, w, JSON)
+		return
+	}
+	job := db.BatchJob{MerchantID: merchantID(ctx), Type: db.WITHDRAWAL, ClientFormat: clientFormat, CreatedBy: createdBy(ctx)}
+	if err := db.CreateBatchJob(ctx, tx, &job, rows); err != nil {
+		tx.Rollback()
+		returnError("unable to create batch", err.Error(), http.StatusInternalServerError, w, JSON)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		returnError("unable to create batch", err.Error(), http.StatusInternalServerError, w, JSON)
+		return
+	}
+	s.batches.notify()
+
+	var rejected []db.BatchJobRow
+	for _, row := range rows {
+		if row.Status == db.ROW_REJECTED {
+			rejected = append(rejected, row)
+		}
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/withdrawals/batch/%d", job.ID))
+	returnResponse(batchJobResponse(job, rejected), http.StatusAccepted, w, JSON)
+}
+
+func (s *Server) batchJobFromRequest(ctx context.Context, w http.ResponseWriter, r *http.Request, contentType ContentType) (*sql.DB, db.BatchJob, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		returnError("ID not a number", "", http.StatusBadRequest, w, contentType)
+		return nil, db.BatchJob{}, false
+	}
+	if s.db == nil {
+		returnError("unable to connect to DB", "", http.StatusInternalServerError, w, contentType)
+		return nil, db.BatchJob{}, false
+	}
+	job, err := db.GetBatchJob(ctx, s.db, merchantID(ctx), id)
+	if err != nil || job.Type != db.WITHDRAWAL {
+		returnError("unable to get batch", fmt.Sprintf("no batch found with id %d", id), http.StatusNotFound, w, contentType)
+		return nil, db.BatchJob{}, false
+	}
+	return s.db, job, true
+}
+
+// Returns the progress of a batch and the errors of the rows that failed so far
+func (s *Server) WithdrawalBatchGetHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := NewHandlerContext(r.Context(), s.config.RequestTimeout)
+	defer cancel()
+	contentType := JSON
+	if ct := r.Header.Get("Content-Type"); ct == "application/xml" || ct == "text/xml" {
+		contentType = XML
+	}
+
+	_db, job, ok := s.batchJobFromRequest(ctx, w, r, contentType)
+	if !ok {
+		return
+	}
+	failed, err := db.GetBatchJobRows(ctx, _db, job.ID, db.ROW_REJECTED, db.ROW_FAILED)
+	if err != nil {
+		returnError("unable to get batch rows", err.Error(), http.StatusInternalServerError, w, contentType)
+		return
+	}
+
+	returnResponse(batchJobResponse(job, failed), http.StatusOK, w, contentType)
+}
+
+// Downloads the outcome of every row of a batch as CSV
+func (s *Server) WithdrawalBatchResultHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := NewHandlerContext(r.Context(), s.config.BatchRequestTimeout)
+	defer cancel()
+
+	_db, job, ok := s.batchJobFromRequest(ctx, w, r, JSON)
+	if !ok {
+		return
+	}
+	rows, err := db.GetBatchJobRows(ctx, _db, job.ID)
+	if err != nil {
+		returnError("unable to get batch rows", err.Error(), http.StatusInternalServerError, w, JSON)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="withdrawal-batch-%d.csv"`, job.ID))
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"row", "user_id", "amount", "currency", "status", "transaction_id", "error"})
+	for _, row := range rows {
+		amount, transactionID, userID := "", "", ""
+		if row.Amount.Valid {
+			amount = row.Amount.Decimal.StringFixed(2)
+		}
+		if row.TransactionID != 0 {
+			transactionID = strconv.Itoa(row.TransactionID)
+		}
+		if row.UserID != 0 {
+			userID = strconv.Itoa(row.UserID)
+		}
+		writer.Write([]string{strconv.Itoa(row.RowNumber), userID, amount, row.Currency, string(row.Status), transactionID, row.Error})
+	}
+	writer.Flush()
+}
+
+// Works through pending batch jobs in the background. Jobs live in the DB so any instance can pick them up and
+// a job interrupted by a restart carries on from the rows it had not processed yet.
+type batchProcessor struct {
+	wake chan struct{}
+}
+
+// wakes an idle worker so a new job doesn't wait for the next poll
+func (b *batchProcessor) notify() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Starts the batch workers. The returned channel is closed once every worker has stopped after ctx is cancelled.
+func (s *Server) startBatchProcessor(ctx context.Context, workers int, pollInterval time.Duration) <-chan struct{} {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runBatchWorker(ctx, pollInterval)
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+func (s *Server) runBatchWorker(ctx context.Context, pollInterval time.Duration) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := db.RequeueBatchJobs(ctx, s.db, s.config.StaleBatchJobAge); err != nil && ctx.Err() == nil {
+			s.logger.Error(ctx, "Unable to requeue stale batch jobs", "error", err)
+		}
+
+		for ctx.Err() == nil {
+			job, ok, err := db.ClaimPendingBatchJob(ctx, s.db)
+			if err != nil {
+				if ctx.Err() == nil {
+					s.logger.Error(ctx, "Unable to claim batch job", "error", err)
+				}
+				break
+			}
+			if !ok {
+				break
+			}
+			s.processBatchJob(ctx, job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.batches.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) processBatchJob(ctx context.Context, job db.BatchJob) {
+	logger := s.logger.With("batch_job_id", job.ID)
+	logger.Info(ctx, "Processing batch job")
+
+	rows, err := db.GetBatchJobRows(ctx, s.db, job.ID, db.ROW_PENDING)
+	if err != nil {
+		logger.Error(ctx, "Unable to get rows of batch job", "error", err)
+		return
+	}
+
+	for _, row := range rows {
+		if ctx.Err() != nil {
+			// hand the job back so it is resumed from the next pending row
+			if err := db.ReleaseBatchJob(context.Background(), s.db, job.ID); err != nil {
+				logger.Error(ctx, "Unable to requeue batch job", "error", err)
+			}
+			return
+		}
+
+		// a row that has started is finished and recorded even when the workers are stopped, otherwise a
+		// withdrawal that was created could be created again when the job is resumed
+		// each row gets an ID of its own to follow it through the logs and into the published message, and its
+		// withdrawal is made for the batch's merchant and recorded as created by the key that uploaded the batch
+		rowCtx := logging.WithRequestID(context.Background(), fmt.Sprintf("batch-%d-row-%d", job.ID, row.RowNumber))
+		rowCtx = withPrincipal(rowCtx, Principal{ID: job.CreatedBy, MerchantID: job.MerchantID})
+		rowCtx, cancel := context.WithTimeout(rowCtx, s.config.BatchRowTimeout)
+		rowCtx, span := s.tracer.Start(rowCtx, "batch withdrawal row", trace.WithAttributes(
+			attribute.Int("batch.job_id", job.ID),
+			attribute.Int("batch.row", row.RowNumber),
+		))
+		row.TransactionID, row.Status, row.Error = s.processWithdrawalRow(rowCtx, row, job.ClientFormat)
+		span.SetAttributes(attribute.String("batch.row_status", string(row.Status)))
+		err := db.CompleteBatchJobRow(rowCtx, s.db, row)
+		endSpan(span, err)
+		cancel()
+		if err != nil {
+			logger.Error(rowCtx, "Unable to record row of batch job", "row", row.RowNumber, "error", err)
+			return
+		}
+	}
+
+	if err := db.CompleteBatchJob(ctx, s.db, job.ID); err != nil {
+		logger.Error(ctx, "Unable to complete batch job", "error", err)
+		return
+	}
+	logger.Info(ctx, "Batch job completed")
+}
+
+// runs one row through the same validation and creation as POST /withdrawal, with confirmations enabled the
+// withdrawal waits in DRAFT for the code sent to its user like any other
+func (s *Server) processWithdrawalRow(ctx context.Context, row db.BatchJobRow, clientFormat string) (int, db.BatchRowStatus, string) {
+	request := rowToWithdrawalRequest(row)
+
+	rt := s.runtime.Current().Runtime
+	countryID, reqErr := s.validateWithdrawal(ctx, rt, request)
+	if reqErr != nil {
+		return 0, db.ROW_REJECTED, reqErr.Error()
+	}
+	// each row counts against its user's budget like a withdrawal of its own
+	if reqErr := s.allowUserWrite(ctx, request.UserID); reqErr != nil {
+		return 0, db.ROW_REJECTED, reqErr.Error()
+	}
+
+	var txID int
+	if s.config.ConfirmWithdrawals {
+		var challenge models.WithdrawalChallengeResponse
+		challenge, reqErr = s.draftWithdrawal(ctx, rt, request, countryID, clientFormat)
+		txID = challenge.TransactionID
+	} else {
+		txID, reqErr = s.createWithdrawal(ctx, rt, request, countryID, clientFormat)
+	}
+	if reqErr != nil {
+		if reqErr.StatusCode < http.StatusInternalServerError {
+			return 0, db.ROW_REJECTED, reqErr.Error()
+		}
+		return 0, db.ROW_FAILED, reqErr.Error()
+	}
+	return txID, db.ROW_SUCCEEDED, ""
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"payment-gateway/db"
+	"payment-gateway/db/memory"
+	"payment-gateway/internal/publisher"
+	"strings"
+	"testing"
+)
+
+func TestParseWithdrawalBatchCSV(t *testing.T) {
+	body := `user_id,amount,currency,beneficiary_name,beneficiary_iban
+1,20.50,USD,John Smith,AE460090000000123456789
+2,abc,USD,,
+"3,10,USD
+4,15,AED,,
+`
+	rows, err := parseWithdrawalBatchCSV(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("unable to parse batch: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("Expected 3 rows Received %d", len(rows))
+	}
+
+	if rows[0].Status != "" || rows[0].UserID != 1 || rows[0].Amount.Decimal.StringFixed(2) != "20.50" || rows[0].BeneficiaryIBAN != "AE460090000000123456789" {
+		t.Errorf("unexpected first row %+v", rows[0])
+	}
+	if rows[1].Status != db.ROW_REJECTED || !strings.Contains(rows[1].Error, "Invalid amount") {
+		t.Errorf("Expected the second row to be rejected, got %+v", rows[1])
+	}
+	// the unterminated quote swallows the rest of the file into one malformed row
+	if rows[2].RowNumber != 3 || rows[2].Status != db.ROW_REJECTED {
+		t.Errorf("Expected the third row to be rejected, got %+v", rows[2])
+	}
+}
+
+func TestParseWithdrawalBatchCSVRequiresHeader(t *testing.T) {
+	if _, err := parseWithdrawalBatchCSV(strings.NewReader("1,20,USD\n")); err == nil {
+		t.Errorf("Expected a CSV without the required columns to be rejected")
+	}
+}
+
+func TestParseWithdrawalBatchJSON(t *testing.T) {
+	rows, err := parseWithdrawalBatchJSON(strings.NewReader(`[
+		{"amount": 20, "user_id": 1, "currency": "USD"},
+		{"amount": "twenty", "user_id": 1, "currency": "USD"},
+		{"amount": 5.25, "user_id": 2, "currency": "AED", "beneficiary": {"name": "Jane", "iban": "AE070331234567890123456"}}
+	]`))
+	if err != nil {
+		t.Fatalf("unable to parse batch: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("Expected 3 rows Received %d", len(rows))
+	}
+	if rows[1].Status != db.ROW_REJECTED || rows[1].RowNumber != 2 {
+		t.Errorf("Expected the second row to be rejected, got %+v", rows[1])
+	}
+	request := rowToWithdrawalRequest(rows[2])
+	if request.Beneficiary == nil || request.Beneficiary.Name != "Jane" || request.Amount.String() != "5.25" {
+		t.Errorf("unexpected request %+v", request)
+	}
+
+	if _, err := parseWithdrawalBatchJSON(strings.NewReader(`{"amount": 20}`)); err == nil {
+		t.Errorf("Expected a body that isn't an array to be rejected")
+	}
+}
+
+func TestProcessWithdrawalRowRejectsUnknownUser(t *testing.T) {
+	rows, _ := parseWithdrawalBatchJSON(strings.NewReader(`[{"amount": 20, "user_id": 9, "currency": "USD"}]`))
+	txID, status, message := newTestServer(t, memory.NewStore(), publisher.NewMemory()).processWithdrawalRow(context.Background(), rows[0], "application/json")
+	if txID != 0 || status != db.ROW_REJECTED || message != "User not found" {
+		t.Errorf("Expected the row to be rejected, got %d %s %q", txID, status, message)
+	}
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"payment-gateway/db"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Transactions can be cancelled until the gateway settles them. A SENT transaction moves to CANCELLED and a
+// cancellation goes out on the gateway's topic in the same DB transaction, so it is never cancelled without the
+// gateway being told. The status only changes from SENT, whichever of the cancellation and the gateway's callback
+// commits first wins and the other is refused. A withdrawal still in DRAFT was never published, it is cancelled
+// without telling the gateway.
+
+func (s *Server) DepositCancelHandler(w http.ResponseWriter, r *http.Request) {
+	s.cancelHandler(w, r, db.DEPOSIT)
+}
+
+func (s *Server) WithdrawalCancelHandler(w http.ResponseWriter, r *http.Request) {
+	s.cancelHandler(w, r, db.WITHDRAWAL)
+}
+
+func (s *Server) cancelHandler(w http.ResponseWriter, r *http.Request, txType db.TransactionType) {
+	ctx, cancel := NewHandlerContext(r.Context(), s.config.RequestTimeout)
+	defer cancel()
+	contentType := responseContentType(r)
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		returnError("ID not a number", "", http.StatusBadRequest, w, contentType)
+		return
+	}
+	tx, err := s.store.Transactions().GetTransaction(ctx, merchantID(ctx), id, txType)
+	if errors.Is(err, db.ErrNotFound) {
+		returnError("Transaction not found", err.Error(), http.StatusNotFound, w, contentType)
+		return
+	}
+	if err != nil {
+		returnError("unable to get transaction", err.Error(), http.StatusInternalServerError, w, contentType)
+		return
+	}
+	if tx.Status != db.SENT && tx.Status != db.DRAFT {
+		returnError("Transaction already processed", "", http.StatusBadRequest, w, contentType)
+		return
+	}
+
+	// the cancellation follows the transaction to its topic, those published before the format was recorded get the
+	// gateway's own
+	dataFormat := tx.DataFormat
+	if dataFormat == "" {
+		gateway, err := s.store.Gateways().GetGateway(ctx, tx.GatewayID)
+		if err != nil {
+			returnError("unable to get gateway", err.Error(), http.StatusInternalServerError, w, contentType)
+			return
+		}
+		dataFormat = gateway.DataFormatSupported
+	}
+
+	// a transaction settled meanwhile isn't retried
+	var conflict error
+	if err := s.retry(func() error {
+		err := s.cancelTransaction(ctx, tx, dataFormat)
+		if errors.Is(err, db.ErrConflict) {
+			conflict = err
+			return nil
+		}
+		return err
+	}); err != nil {
+		s.logger.Error(ctx, "Unable to cancel transaction", "transaction_id", tx.ID, "type", tx.Type, "gateway_id", tx.GatewayID, "error", err)
+		returnError("unable to cancel transaction", err.Error(), http.StatusInternalServerError, w, contentType)
+		return
+	}
+	if conflict != nil {
+		returnError("Transaction already processed", "", http.StatusBadRequest, w, contentType)
+		return
+	}
+	s.logger.Info(ctx, "Transaction cancelled", "transaction_id", tx.ID, "type", tx.Type, "previous_status", tx.Status)
+	s.countTransaction(ctx, string(tx.Type), string(db.CANCELLED), tx.GatewayID, tx.CountryID, tx.Currency)
+
+	returnTransaction(ctx, http.StatusOK, w, contentType, s.store, strconv.Itoa(tx.ID), txType)
+}
+
+// Moves the transaction to CANCELLED and publishes the cancellation in dataFormat, rolled back when
+// publishing fails. Wraps db.ErrConflict when the transaction left its status meanwhile.
+func (s *Server) cancelTransaction(ctx context.Context, transaction db.Transaction, dataFormat string) error {
+	return s.store.InTx(ctx, func(tx db.Store) error {
+		if err := tx.Transactions().UpdateTransactionStatus(ctx, merchantID(ctx), transaction.ID, transaction.Type, transaction.Status, db.CANCELLED); err != nil {
+			return err
+		}
+		if transaction.Status == db.DRAFT {
+			if err := tx.Confirmations().DeleteConfirmation(ctx, transaction.ID); err != nil && !errors.Is(err, db.ErrNotFound) {
+				return err
+			}
+		} else if err := s.publishCancellation(ctx, transaction, dataFormat); err != nil {
+			return err
+		}
+		return appendAuditEntry(ctx, tx, "cancel", "transaction", strconv.Itoa(transaction.ID),
+			map[string]db.TransactionStatus{"status": transaction.Status}, map[string]db.TransactionStatus{"status": db.CANCELLED})
+	})
+}
+
+// Explains to a gateway reporting status why the transaction is no longer SENT. A cancelled transaction reported
+// as successful lost the race with its cancellation, the money may have moved anyway so it is logged for following
+// up with the gateway.
+func (s *Server) settlementRefusal(ctx context.Context, transaction db.Transaction, reported string) *requestError {
+	if current, err := s.store.Transactions().GetTransaction(ctx, merchantID(ctx), transaction.ID, transaction.Type); err == nil {
+		transaction = current
+	}
+	if transaction.Status != db.CANCELLED {
+		return &requestError{StatusCode: http.StatusBadRequest, Message: "Transaction already processed"}
+	}
+	if strings.EqualFold(reported, string(db.SUCCESS)) {
+		s.logger.Warn(ctx, "Gateway settled a cancelled transaction", "transaction_id", transaction.ID, "type", transaction.Type,
+			"gateway_id", transaction.GatewayID)
+	}
+	return &requestError{StatusCode: http.StatusConflict, Message: "Transaction cancelled"}
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/db"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/publisher"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// creates a deposit through the API and returns its ID
+func (c *confirmationTest) deposit(t *testing.T) int {
+	t.Helper()
+	rr := c.post(t, "/deposit", depositBody)
+	var response models.APIResponse[db.Transaction]
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || response.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected the deposit to be created, got %s", rr.Body.String())
+	}
+	return response.Data.ID
+}
+
+func (c *confirmationTest) settle(t *testing.T, path string, id int, status string) *httptest.ResponseRecorder {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, path, strings.NewReader(`{"transaction_id": `+strconv.Itoa(id)+`, "status": "`+status+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	c.s.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCancelSentDeposit(t *testing.T) {
+	c := newConfirmationTest(t)
+	id := c.deposit(t)
+
+	rr := c.post(t, "/deposit/"+strconv.Itoa(id)+"/cancel", "")
+	if code := responseStatus(t, rr); code != http.StatusOK || !strings.Contains(rr.Body.String(), `"Status":"CANCELLED"`) {
+		t.Fatalf("Expected the deposit to be cancelled, got %d: %s", code, rr.Body.String())
+	}
+	messages := c.pub.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("Expected the deposit and its cancellation to be published, got %+v", messages)
+	}
+	cancellation := messages[1]
+	var event models.TransactionStatusEvent
+	if err := json.Unmarshal(cancellation.Value, &event); err != nil {
+		t.Fatal(err)
+	}
+	if cancellation.Topic != "transactions.json" || cancellation.Key != strconv.Itoa(id) || cancellation.Headers[publisher.MessageTypeHeader] != publisher.MessageTypeCancellation ||
+		event.TransactionID != id || event.Status != "CANCELLED" || event.Type != "DEPOSIT" {
+		t.Errorf("Unexpected cancellation %+v carrying %+v", cancellation, event)
+	}
+	if _, ok := messages[0].Headers[publisher.MessageTypeHeader]; ok {
+		t.Errorf("Expected the deposit itself to carry no message type, got %+v", messages[0].Headers)
+	}
+
+	// the gateway's callback lost the race
+	rr = c.settle(t, "/deposit", id, "success")
+	if code := responseStatus(t, rr); code != http.StatusConflict || !strings.Contains(rr.Body.String(), "Transaction cancelled") {
+		t.Errorf("Expected the late callback to be refused, got %d: %s", code, rr.Body.String())
+	}
+	if code := responseStatus(t, c.post(t, "/deposit/"+strconv.Itoa(id)+"/cancel", "")); code != http.StatusBadRequest {
+		t.Errorf("Expected a second cancellation to be refused, got %d", code)
+	}
+
+	entries, _ := c.store.AuditLog().GetAuditEntries(context.Background(), db.AuditFilter{Action: "cancel"})
+	if len(entries) != 1 || string(entries[0].Before) != `{"status":"SENT"}` || string(entries[0].After) != `{"status":"CANCELLED"}` {
+		t.Errorf("Expected the cancellation to be audited, got %+v", entries)
+	}
+}
+
+func TestCancellationFollowsTheTransactionsFormat(t *testing.T) {
+	c := newConfirmationTest(t)
+	ctx := context.Background()
+	if deposit, _ := c.store.GetTransaction(ctx, db.DefaultMerchantID, c.deposit(t), db.DEPOSIT); deposit.DataFormat != "application/json" {
+		t.Errorf("Expected the deposit to record the format it was published in, got %+v", deposit)
+	}
+
+	// published as XML, whatever the cancellation request is sent as
+	deposit := db.Transaction{MerchantID: db.DefaultMerchantID, Amount: decimal.NewFromInt(20), Type: db.DEPOSIT, Status: db.SENT,
+		UserID: 1, GatewayID: 1, CountryID: 1, Currency: "USD", DataFormat: "application/xml"}
+	legacy := db.Transaction{MerchantID: db.DefaultMerchantID, Amount: decimal.NewFromInt(20), Type: db.DEPOSIT, Status: db.SENT,
+		UserID: 1, GatewayID: 1, CountryID: 1, Currency: "USD"}
+	c.store.CreateTransaction(ctx, &deposit)
+	c.store.CreateTransaction(ctx, &legacy)
+
+	for id, topic := range map[int]string{deposit.ID: "transactions.soap", legacy.ID: "transactions.json"} {
+		published := len(c.pub.Messages())
+		if code := responseStatus(t, c.post(t, "/deposit/"+strconv.Itoa(id)+"/cancel", "")); code != http.StatusOK {
+			t.Fatalf("Expected deposit %d to be cancelled, got %d", id, code)
+		}
+		if messages := c.pub.Messages(); len(messages) != published+1 || messages[published].Topic != topic {
+			t.Errorf("Expected the cancellation of %d on %s, got %+v", id, topic, messages[published:])
+		}
+	}
+}
+
+func TestSettledTransactionCantBeCancelled(t *testing.T) {
+	c := newConfirmationTest(t)
+	id := c.deposit(t)
+	if code := responseStatus(t, c.settle(t, "/deposit", id, "success")); code != http.StatusOK {
+		t.Fatalf("Expected the deposit to be settled, got %d", code)
+	}
+
+	if code := responseStatus(t, c.post(t, "/deposit/"+strconv.Itoa(id)+"/cancel", "")); code != http.StatusBadRequest {
+		t.Errorf("Expected the settled deposit to stay settled, got %d", code)
+	}
+	if code := responseStatus(t, c.post(t, "/withdrawal/"+strconv.Itoa(id)+"/cancel", "")); code != http.StatusNotFound {
+		t.Errorf("Expected the deposit not to be found as a withdrawal, got %d", code)
+	}
+	if messages := c.pub.Messages(); len(messages) != 1 {
+		t.Errorf("Expected no cancellation to be published, got %+v", messages)
+	}
+}
+
+func TestFailedCancellationKeepsTransactionSent(t *testing.T) {
+	c := newConfirmationTest(t)
+	id := c.deposit(t)
+
+	c.pub.FailWith(errors.New("broker down"))
+	if code := responseStatus(t, c.post(t, "/deposit/"+strconv.Itoa(id)+"/cancel", "")); code != http.StatusInternalServerError {
+		t.Errorf("Expected the cancellation to fail, got %d", code)
+	}
+	tx, err := c.store.GetTransaction(context.Background(), db.DefaultMerchantID, id, db.DEPOSIT)
+	if err != nil || tx.Status != db.SENT {
+		t.Errorf("Expected the deposit to stay SENT, got %+v (%v)", tx, err)
+	}
+}
+
+func TestCancelDraftWithdrawal(t *testing.T) {
+	c := newConfirmationTest(t)
+	challenge := c.draft(t)
+
+	if code := responseStatus(t, c.post(t, "/withdrawal/"+strconv.Itoa(challenge.TransactionID)+"/cancel", "")); code != http.StatusOK {
+		t.Fatalf("Expected the draft to be cancelled, got %d", code)
+	}
+	if status := c.status(t, challenge.TransactionID); status != db.CANCELLED {
+		t.Errorf("Expected the draft to be CANCELLED, got %s", status)
+	}
+	if messages := c.pub.Messages(); len(messages) != 0 {
+		t.Errorf("Expected nothing published for a draft, got %+v", messages)
+	}
+	if code := responseStatus(t, c.post(t, confirmPath(challenge), `{"code": "`+challenge.Code+`"}`)); code != http.StatusBadRequest {
+		t.Errorf("Expected the cancelled draft not to be confirmed, got %d", code)
+	}
+}
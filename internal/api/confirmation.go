@@ -0,0 +1,325 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"payment-gateway/db"
+	"payment-gateway/internal/config"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/notify"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// With confirmations enabled POST /withdrawal creates the withdrawal in DRAFT and sends the user a one-time code
+// through the Notifier. Nothing is published until the code comes back through POST /withdrawal/{id}/confirm.
+// Drafts expire, moving to EXPIRED, once the code runs out or too many wrong codes were sent.
+
+// digits of the confirmation codes
+const confirmationCodeLength = 6
+
+// drafts expired per query of the sweeper
+const draftExpiryBatchSize = 100
+
+// the audit log names background work after this actor
+const systemActor = "system"
+
+// What is kept of a draft until it is confirmed, stored encrypted as it holds the code and the beneficiary
+type draftPayload struct {
+	Code string `json:"code"`
+	// the format of the gateway the draft was routed to
+	DataFormat string                    `json:"data_format"`
+	Request    models.TransactionRequest `json:"request"`
+}
+
+func newConfirmationCode() (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(confirmationCodeLength), nil)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate confirmation code: %v", err)
+	}
+	return fmt.Sprintf("%0*d", confirmationCodeLength, n), nil
+}
+
+func (s *Server) sealDraft(payload draftPayload) (string, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode draft: %v", err)
+	}
+	return s.cipher.Encrypt(raw)
+}
+
+func (s *Server) openDraft(sealed string) (draftPayload, error) {
+	raw, err := s.cipher.Decrypt(sealed)
+	if err != nil {
+		return draftPayload{}, fmt.Errorf("failed to decrypt draft: %v", err)
+	}
+	var payload draftPayload
+	if err := json.Unmarshal([]byte(raw), &payload); err != nil {
+		return draftPayload{}, fmt.Errorf("failed to decode draft: %v", err)
+	}
+	return payload, nil
+}
+
+// Routes a validated withdrawal to a gateway and records it in DRAFT, then sends the user the code confirming it.
+// The code goes out once the draft is committed, so no code is sent for a withdrawal that doesn't exist and the DB
+// transaction isn't held open while it is delivered. The draft expires when the code can't be sent.
+func (s *Server) draftWithdrawal(ctx context.Context, rt config.Runtime, request models.WithdrawalRequest, countryID int, clientFormat string) (models.WithdrawalChallengeResponse, *requestError) {
+	txReq, dataFormat, reqErr := s.routeWithdrawal(ctx, rt, request, countryID, clientFormat)
+	if reqErr != nil {
+		return models.WithdrawalChallengeResponse{}, reqErr
+	}
+	code, err := newConfirmationCode()
+	if err != nil {
+		return models.WithdrawalChallengeResponse{}, &requestError{StatusCode: http.StatusInternalServerError, Message: "unable to create transaction", DetailedMessage: err.Error()}
+	}
+	expiresAt := s.clock().Add(s.config.ConfirmationTTL)
+
+	var transaction db.Transaction
+	err = s.store.InTx(ctx, func(tx db.Store) error {
+		transaction = db.Transaction{
+			MerchantID: merchantID(ctx),
+			Amount:     txReq.Amount,
+			Type:       db.WITHDRAWAL,
+			UserID:     txReq.UserID,
+			CountryID:  txReq.CountryID,
+			Currency:   txReq.Currency,
+			Status:     db.DRAFT,
+			GatewayID:  txReq.GatewayID,
+			DataFormat: dataFormat,
+			CreatedBy:  createdBy(ctx),
+		}
+		if err := tx.Transactions().CreateTransaction(ctx, &transaction); err != nil {
+			return err
+		}
+		txReq.TransactionID = transaction.ID
+
+		payload, err := s.sealDraft(draftPayload{Code: code, DataFormat: dataFormat, Request: txReq})
+		if err != nil {
+			return err
+		}
+		if err := tx.Confirmations().CreateConfirmation(ctx, &db.WithdrawalConfirmation{
+			TransactionID: transaction.ID,
+			Payload:       payload,
+			ExpiresAt:     expiresAt,
+		}); err != nil {
+			return err
+		}
+		return auditTransactionCreated(ctx, tx, transaction)
+	})
+	if err != nil {
+		s.logger.Error(ctx, "Unable to create transaction", "type", txReq.Type, "user_id", txReq.UserID, "gateway_id", txReq.GatewayID, "error", err)
+		return models.WithdrawalChallengeResponse{}, &requestError{StatusCode: http.StatusInternalServerError, Message: "unable to create transaction", DetailedMessage: err.Error()}
+	}
+	s.logTransactionCreated(ctx, &txReq)
+	s.countTransaction(ctx, txReq.Type, string(db.DRAFT), txReq.GatewayID, countryID, request.Currency)
+
+	if err := s.notifier.Send(ctx, notify.Challenge{
+		TransactionID: transaction.ID,
+		MerchantID:    transaction.MerchantID,
+		UserID:        transaction.UserID,
+		Code:          code,
+		ExpiresAt:     expiresAt,
+	}); err != nil {
+		s.logger.Error(ctx, "Unable to send confirmation code", "transaction_id", transaction.ID, "user_id", transaction.UserID, "error", err)
+		// the sweeper expires it once the code runs out if this fails
+		_ = s.expireDraft(ctx, transaction, "undelivered")
+		return models.WithdrawalChallengeResponse{}, &requestError{StatusCode: http.StatusInternalServerError, Message: "unable to send confirmation code", DetailedMessage: err.Error()}
+	}
+
+	return models.WithdrawalChallengeResponse{
+		TransactionID: txReq.TransactionID,
+		Status:        string(db.DRAFT),
+		ExpiresAt:     expiresAt,
+		ConfirmURL:    fmt.Sprintf("/withdrawal/%d/confirm", txReq.TransactionID),
+	}, nil
+}
+
+// Takes the code confirming a withdrawal in DRAFT and publishes the withdrawal. Wrong codes are counted, the
+// withdrawal expires once they reach the configured attempts or the code runs out.
+func (s *Server) WithdrawalConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	contentType := ctx.Value("contentType").(ContentType)
+	request := ctx.Value("request").(models.WithdrawalConfirmRequest)
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		returnError("ID not a number", "", http.StatusBadRequest, w, contentType)
+		return
+	}
+	tx, err := s.store.Transactions().GetTransaction(ctx, merchantID(ctx), id, db.WITHDRAWAL)
+	if errors.Is(err, db.ErrNotFound) {
+		returnError("Transaction not found", err.Error(), http.StatusNotFound, w, contentType)
+		return
+	}
+	if err != nil {
+		returnError("unable to get transaction", err.Error(), http.StatusInternalServerError, w, contentType)
+		return
+	}
+	switch tx.Status {
+	case db.DRAFT:
+	case db.EXPIRED:
+		returnError("Confirmation expired", "", http.StatusGone, w, contentType)
+		return
+	default:
+		returnError("Transaction already processed", "", http.StatusBadRequest, w, contentType)
+		return
+	}
+
+	confirmation, err := s.store.Confirmations().GetConfirmation(ctx, id)
+	if errors.Is(err, db.ErrNotFound) {
+		// confirmed or expired meanwhile
+		returnError("Transaction already processed", "", http.StatusBadRequest, w, contentType)
+		return
+	}
+	if err != nil {
+		returnError("unable to get confirmation", err.Error(), http.StatusInternalServerError, w, contentType)
+		return
+	}
+	if !s.clock().Before(confirmation.ExpiresAt) {
+		// the sweeper tries again if this fails
+		_ = s.expireDraft(ctx, tx, "timeout")
+		returnError("Confirmation expired", "", http.StatusGone, w, contentType)
+		return
+	}
+
+	payload, err := s.openDraft(confirmation.Payload)
+	if err != nil {
+		returnError("unable to confirm transaction", err.Error(), http.StatusInternalServerError, w, contentType)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(request.Code), []byte(payload.Code)) != 1 {
+		attempts, err := s.store.Confirmations().AddConfirmationAttempt(ctx, id)
+		if err != nil {
+			returnError("unable to confirm transaction", err.Error(), http.StatusInternalServerError, w, contentType)
+			return
+		}
+		if left := s.config.ConfirmationAttempts - attempts; left > 0 {
+			returnError("Invalid confirmation code", fmt.Sprintf("%d attempts left", left), http.StatusForbidden, w, contentType)
+			return
+		}
+		if err := s.expireDraft(ctx, tx, "attempts"); err != nil {
+			returnError("unable to confirm transaction", err.Error(), http.StatusInternalServerError, w, contentType)
+			return
+		}
+		returnError("Invalid confirmation code", "No attempts left, the withdrawal expired", http.StatusForbidden, w, contentType)
+		return
+	}
+
+	// a draft confirmed or expired meanwhile isn't retried
+	var conflict error
+	if err := s.retry(func() error {
+		err := s.confirmWithdrawal(ctx, tx, &payload)
+		if errors.Is(err, db.ErrConflict) || errors.Is(err, db.ErrNotFound) {
+			conflict = err
+			return nil
+		}
+		return err
+	}); err != nil {
+		s.logger.Error(ctx, "Unable to confirm transaction", "transaction_id", tx.ID, "gateway_id", tx.GatewayID, "error", err)
+		returnError("unable to confirm transaction", err.Error(), http.StatusInternalServerError, w, contentType)
+		return
+	}
+	if conflict != nil {
+		returnError("Transaction already processed", "", http.StatusBadRequest, w, contentType)
+		return
+	}
+	s.logger.Info(ctx, "Transaction confirmed", "transaction_id", tx.ID, "type", tx.Type)
+	s.countTransaction(ctx, string(tx.Type), string(db.SENT), tx.GatewayID, tx.CountryID, payload.Request.Currency)
+
+	returnTransaction(ctx, http.StatusOK, w, contentType, s.store, strconv.Itoa(tx.ID), db.WITHDRAWAL)
+}
+
+// Moves the draft to SENT and publishes it, rolled back when publishing fails. Wraps db.ErrConflict when the draft
+// was confirmed or expired meanwhile.
+func (s *Server) confirmWithdrawal(ctx context.Context, transaction db.Transaction, payload *draftPayload) error {
+	return s.store.InTx(ctx, func(tx db.Store) error {
+		if err := tx.Transactions().UpdateTransactionStatus(ctx, db.AllMerchants, transaction.ID, db.WITHDRAWAL, db.DRAFT, db.SENT); err != nil {
+			return err
+		}
+		if err := tx.Confirmations().DeleteConfirmation(ctx, transaction.ID); err != nil {
+			return err
+		}
+		if err := s.publishTransaction(ctx, &payload.Request, payload.DataFormat); err != nil {
+			return err
+		}
+		return appendAuditEntry(ctx, tx, "confirm", "transaction", strconv.Itoa(transaction.ID),
+			map[string]db.TransactionStatus{"status": db.DRAFT}, map[string]db.TransactionStatus{"status": db.SENT})
+	})
+}
+
+// Moves the draft to EXPIRED and forgets its code, reason is recorded in the audit log. A draft confirmed or
+// expired meanwhile is left alone. Failures are logged as well as returned.
+func (s *Server) expireDraft(ctx context.Context, transaction db.Transaction, reason string) error {
+	err := s.store.InTx(ctx, func(tx db.Store) error {
+		if err := tx.Transactions().UpdateTransactionStatus(ctx, db.AllMerchants, transaction.ID, db.WITHDRAWAL, db.DRAFT, db.EXPIRED); err != nil {
+			return err
+		}
+		if err := tx.Confirmations().DeleteConfirmation(ctx, transaction.ID); err != nil && !errors.Is(err, db.ErrNotFound) {
+			return err
+		}
+		return appendAuditEntry(ctx, tx, "expire", "transaction", strconv.Itoa(transaction.ID),
+			map[string]db.TransactionStatus{"status": db.DRAFT}, map[string]string{"status": string(db.EXPIRED), "reason": reason})
+	})
+	if errors.Is(err, db.ErrConflict) {
+		return nil
+	}
+	if err != nil {
+		s.logger.Error(ctx, "Unable to expire transaction", "transaction_id", transaction.ID, "error", err)
+		return err
+	}
+	s.logger.Info(ctx, "Transaction expired", "transaction_id", transaction.ID, "type", transaction.Type, "reason", reason)
+	s.countTransaction(ctx, string(transaction.Type), string(db.EXPIRED), transaction.GatewayID, transaction.CountryID, transaction.Currency)
+	return nil
+}
+
+// Expires the drafts whose code ran out, every interval until ctx is cancelled
+func (s *Server) runDraftExpiry(ctx context.Context, interval time.Duration) {
+	ctx = withAdmin(ctx, systemActor, nil)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.expireDrafts(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) expireDrafts(ctx context.Context) {
+	for ctx.Err() == nil {
+		confirmations, err := s.store.Confirmations().GetExpiredConfirmations(ctx, s.clock(), draftExpiryBatchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.logger.Error(ctx, "Unable to get expired confirmations", "error", err)
+			}
+			return
+		}
+		for _, confirmation := range confirmations {
+			tx, err := s.store.Transactions().GetTransaction(ctx, db.AllMerchants, confirmation.TransactionID, db.WITHDRAWAL)
+			if err != nil {
+				// tried again on the next tick rather than fetching the same page over and over
+				s.logger.Error(ctx, "Unable to get expired transaction", "transaction_id", confirmation.TransactionID, "error", err)
+				return
+			}
+			if err := s.expireDraft(ctx, tx, "timeout"); err != nil {
+				return
+			}
+		}
+		if len(confirmations) < draftExpiryBatchSize {
+			return
+		}
+	}
+}
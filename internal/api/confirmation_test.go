@@ -0,0 +1,260 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/db"
+	"payment-gateway/db/memory"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/notify"
+	"payment-gateway/internal/publisher"
+	"payment-gateway/internal/services"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type confirmationTest struct {
+	s        *Server
+	store    *memory.Store
+	pub      *publisher.Memory
+	notifier *notify.Memory
+	now      time.Time
+}
+
+func newConfirmationTest(t *testing.T) *confirmationTest {
+	t.Helper()
+	ctx := context.Background()
+	c := &confirmationTest{store: memory.NewStore(), pub: publisher.NewMemory(), notifier: notify.NewMemory(), now: authNow}
+	currency := db.Currency{Symbol: "USD"}
+	country := db.Country{Name: "United States", Code: "US"}
+	gateway := db.Gateway{Name: "Gateway 1", DataFormatSupported: "application/json"}
+	c.store.CreateCurrency(ctx, &currency)
+	c.store.CreateCountry(ctx, &country)
+	c.store.CreateGateway(ctx, &gateway)
+	c.store.AddCountryCurrency(ctx, country.ID, currency.ID)
+	c.store.AddGatewayCountry(ctx, gateway.ID, country.ID)
+	c.store.CreateUser(ctx, &db.User{MerchantID: db.DefaultMerchantID, Username: "johnsmith", Email: "john.smith@example.com", CountryID: country.ID})
+
+	cipher, _ := services.NewAESCipher(make([]byte, 32))
+	s, err := NewServer(Options{
+		Store:     c.store,
+		Publisher: c.pub,
+		Cipher:    cipher,
+		Notifier:  c.notifier,
+		Clock:     func() time.Time { return c.now },
+		Config: Config{
+			AllowAnonymous:     true,
+			ConfirmWithdrawals: true,
+			ConfirmationTTL:    10 * time.Minute,
+			Retry:              services.RetryPolicy{Attempts: 1},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.s = s
+	return c
+}
+
+func (c *confirmationTest) post(t *testing.T, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	c.s.ServeHTTP(rr, req)
+	return rr
+}
+
+// creates a draft and returns the challenge sent for it
+func (c *confirmationTest) draft(t *testing.T) notify.Challenge {
+	t.Helper()
+	rr := c.post(t, "/withdrawal", `{"amount": 20, "user_id": 1, "currency": "USD"}`)
+	var response models.APIResponse[models.WithdrawalChallengeResponse]
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || response.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected the withdrawal to wait for confirmation, got %s", rr.Body.String())
+	}
+	challenges := c.notifier.Challenges()
+	if len(challenges) == 0 {
+		t.Fatal("Expected a confirmation code to be sent")
+	}
+	challenge := challenges[len(challenges)-1]
+	if challenge.TransactionID != response.Data.TransactionID || len(challenge.Code) != confirmationCodeLength ||
+		!challenge.ExpiresAt.Equal(c.now.Add(10*time.Minute)) || !response.Data.ExpiresAt.Equal(challenge.ExpiresAt) {
+		t.Fatalf("Unexpected challenge %+v for %+v", challenge, response.Data)
+	}
+	return challenge
+}
+
+func (c *confirmationTest) status(t *testing.T, id int) db.TransactionStatus {
+	t.Helper()
+	tx, err := c.store.GetTransaction(context.Background(), db.DefaultMerchantID, id, db.WITHDRAWAL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx.Status
+}
+
+func confirmPath(challenge notify.Challenge) string {
+	return "/withdrawal/" + strconv.Itoa(challenge.TransactionID) + "/confirm"
+}
+
+func TestWithdrawalIsPublishedOnceConfirmed(t *testing.T) {
+	c := newConfirmationTest(t)
+	challenge := c.draft(t)
+	if status := c.status(t, challenge.TransactionID); status != db.DRAFT {
+		t.Errorf("Expected the withdrawal in DRAFT, got %s", status)
+	}
+	if messages := c.pub.Messages(); len(messages) != 0 {
+		t.Fatalf("Expected nothing published before the confirmation, got %+v", messages)
+	}
+
+	rr := c.post(t, confirmPath(challenge), `{"code": "not-the-code"}`)
+	if code := responseStatus(t, rr); code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "4 attempts left") {
+		t.Errorf("Expected a wrong code to be refused, got %d: %s", code, rr.Body.String())
+	}
+
+	rr = c.post(t, confirmPath(challenge), `{"code": "`+challenge.Code+`"}`)
+	if code := responseStatus(t, rr); code != http.StatusOK {
+		t.Fatalf("Expected the withdrawal to be confirmed, got %d: %s", code, rr.Body.String())
+	}
+	if status := c.status(t, challenge.TransactionID); status != db.SENT {
+		t.Errorf("Expected the confirmed withdrawal to be SENT, got %s", status)
+	}
+	messages := c.pub.Messages()
+	if len(messages) != 1 || messages[0].Key != strconv.Itoa(challenge.TransactionID) {
+		t.Fatalf("Expected the withdrawal to be published once, got %+v", messages)
+	}
+
+	if code := responseStatus(t, c.post(t, confirmPath(challenge), `{"code": "`+challenge.Code+`"}`)); code != http.StatusBadRequest {
+		t.Errorf("Expected a second confirmation to be refused, got %d", code)
+	}
+
+	entries, _ := c.store.AuditLog().GetAuditEntries(context.Background(), db.AuditFilter{Entity: "transaction", EntityID: strconv.Itoa(challenge.TransactionID)})
+	if len(entries) != 2 || entries[0].Action != "create" || entries[1].Action != "confirm" || string(entries[1].After) != `{"status":"SENT"}` {
+		t.Errorf("Expected the creation and the confirmation to be audited, got %+v", entries)
+	}
+}
+
+func TestFailedPublishKeepsWithdrawalInDraft(t *testing.T) {
+	c := newConfirmationTest(t)
+	challenge := c.draft(t)
+
+	c.pub.FailWith(errors.New("broker down"))
+	if code := responseStatus(t, c.post(t, confirmPath(challenge), `{"code": "`+challenge.Code+`"}`)); code != http.StatusInternalServerError {
+		t.Errorf("Expected the confirmation to fail, got %d", code)
+	}
+	if status := c.status(t, challenge.TransactionID); status != db.DRAFT {
+		t.Errorf("Expected the withdrawal to stay in DRAFT, got %s", status)
+	}
+
+	c.pub.FailWith(nil)
+	if code := responseStatus(t, c.post(t, confirmPath(challenge), `{"code": "`+challenge.Code+`"}`)); code != http.StatusOK {
+		t.Errorf("Expected the confirmation to be retried, got %d", code)
+	}
+}
+
+func TestWithdrawalExpiresAfterTooManyWrongCodes(t *testing.T) {
+	c := newConfirmationTest(t)
+	challenge := c.draft(t)
+
+	var rr *httptest.ResponseRecorder
+	for i := 0; i < 5; i++ {
+		rr = c.post(t, confirmPath(challenge), `{"code": "000000x"}`)
+	}
+	if !strings.Contains(rr.Body.String(), "No attempts left") {
+		t.Errorf("Expected the last wrong code to expire the withdrawal, got %s", rr.Body.String())
+	}
+	if status := c.status(t, challenge.TransactionID); status != db.EXPIRED {
+		t.Errorf("Expected the withdrawal to be EXPIRED, got %s", status)
+	}
+	if code := responseStatus(t, c.post(t, confirmPath(challenge), `{"code": "`+challenge.Code+`"}`)); code != http.StatusGone {
+		t.Errorf("Expected the right code to come too late, got %d", code)
+	}
+	if messages := c.pub.Messages(); len(messages) != 0 {
+		t.Errorf("Expected nothing published, got %+v", messages)
+	}
+}
+
+func TestUnconfirmedWithdrawalsExpire(t *testing.T) {
+	c := newConfirmationTest(t)
+	stale := c.draft(t)
+	c.now = c.now.Add(5 * time.Minute)
+	fresh := c.draft(t)
+
+	c.now = c.now.Add(6 * time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := c.s.Start(ctx)
+	deadline := time.Now().Add(time.Second)
+	for c.status(t, stale.TransactionID) != db.EXPIRED && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if status := c.status(t, stale.TransactionID); status != db.EXPIRED {
+		t.Errorf("Expected the sweeper to expire the stale withdrawal, got %s", status)
+	}
+	if status := c.status(t, fresh.TransactionID); status != db.DRAFT {
+		t.Errorf("Expected the fresh withdrawal to wait for its code, got %s", status)
+	}
+	entries, _ := c.store.AuditLog().GetAuditEntries(context.Background(), db.AuditFilter{Action: "expire"})
+	if len(entries) != 1 || entries[0].Actor != systemActor || !strings.Contains(string(entries[0].After), `"reason":"timeout"`) {
+		t.Errorf("Expected the expiry to be audited as the system, got %+v", entries)
+	}
+	if code := responseStatus(t, c.post(t, confirmPath(stale), `{"code": "`+stale.Code+`"}`)); code != http.StatusGone {
+		t.Errorf("Expected the expired withdrawal to be refused, got %d", code)
+	}
+
+	// a code that ran out before the sweeper got to it is refused too
+	c.now = c.now.Add(5 * time.Minute)
+	if code := responseStatus(t, c.post(t, confirmPath(fresh), `{"code": "`+fresh.Code+`"}`)); code != http.StatusGone {
+		t.Errorf("Expected the code to have run out, got %d", code)
+	}
+	if status := c.status(t, fresh.TransactionID); status != db.EXPIRED {
+		t.Errorf("Expected the withdrawal to be expired on the spot, got %s", status)
+	}
+}
+
+func TestDraftExpiresWhenTheCodeCantBeSent(t *testing.T) {
+	c := newConfirmationTest(t)
+	c.notifier.FailWith(errors.New("SMS provider down"))
+
+	if code := responseStatus(t, c.post(t, "/withdrawal", `{"amount": 20, "user_id": 1, "currency": "USD"}`)); code != http.StatusInternalServerError {
+		t.Errorf("Expected the withdrawal to fail, got %d", code)
+	}
+	transactions, _ := c.store.GetTransactions(context.Background(), db.AllMerchants)
+	if len(transactions) != 1 || transactions[0].Status != db.EXPIRED {
+		t.Fatalf("Expected the draft to be expired, got %+v", transactions)
+	}
+	if _, err := c.store.Confirmations().GetConfirmation(context.Background(), transactions[0].ID); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("Expected the draft's code to be forgotten, got %v", err)
+	}
+	entries, _ := c.store.AuditLog().GetAuditEntries(context.Background(), db.AuditFilter{Action: "expire"})
+	if len(entries) != 1 || !strings.Contains(string(entries[0].After), `"reason":"undelivered"`) {
+		t.Errorf("Expected the expiry to be audited, got %+v", entries)
+	}
+}
+
+func TestBatchRowsWaitForConfirmation(t *testing.T) {
+	c := newConfirmationTest(t)
+	rows, _ := parseWithdrawalBatchJSON(strings.NewReader(`[{"amount": 20, "user_id": 1, "currency": "USD"}]`))
+
+	txID, status, message := c.s.processWithdrawalRow(context.Background(), rows[0], "application/json")
+	if status != db.ROW_SUCCEEDED || txID == 0 {
+		t.Fatalf("Expected the row to be accepted, got %s %q", status, message)
+	}
+	if got := c.status(t, txID); got != db.DRAFT {
+		t.Errorf("Expected the row's withdrawal to wait in DRAFT, got %s", got)
+	}
+	if challenges := c.notifier.Challenges(); len(challenges) != 1 || challenges[0].TransactionID != txID {
+		t.Errorf("Expected the user to be sent a code, got %+v", challenges)
+	}
+	if messages := c.pub.Messages(); len(messages) != 0 {
+		t.Errorf("Expected nothing published before the code comes back, got %+v", messages)
+	}
+}
@@ -2,16 +2,18 @@ package api
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"payment-gateway/db"
+	"payment-gateway/internal/config"
+	"payment-gateway/internal/logging"
 	"payment-gateway/internal/models"
 	"payment-gateway/internal/services"
 	"strings"
-	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -24,9 +26,11 @@ const (
 )
 
 func returnJSONError(message, detailedmessage string, statusCode int, w http.ResponseWriter) {
+	noteStatus(w, statusCode)
 	enc := json.NewEncoder(w)
 	enc.Encode(models.APIResponse[any]{
 		StatusCode: statusCode,
+		RequestID:  w.Header().Get(logging.RequestIDHeader),
 		Error: &models.Error{
 			Message:         message,
 			DetailedMessage: detailedmessage,
@@ -35,9 +39,11 @@ func returnJSONError(message, detailedmessage string, statusCode int, w http.Res
 }
 
 func returnXMLError(message, detailedmessage string, statusCode int, w http.ResponseWriter) {
+	noteStatus(w, statusCode)
 	enc := xml.NewEncoder(w)
 	enc.Encode(models.APIResponse[any]{
 		StatusCode: statusCode,
+		RequestID:  w.Header().Get(logging.RequestIDHeader),
 		Error: &models.Error{
 			Message:         message,
 			DetailedMessage: detailedmessage,
@@ -57,21 +63,25 @@ func returnError(message, detailedmessage string, statusCode int, w http.Respons
 }
 
 func returnResponse[T any](response T, statusCode int, w http.ResponseWriter, typ ContentType) {
+	noteStatus(w, statusCode)
 	switch typ {
 	case XML:
 		w.Header().Add("Content-Type", "application/xml")
 		enc := xml.NewEncoder(w)
-		err := enc.Encode(models.APIResponse[T]{
+		if err := enc.Encode(models.APIResponse[T]{
 			StatusCode: statusCode,
 			Data:       response,
-		})
-		fmt.Println(err)
+			RequestID:  w.Header().Get(logging.RequestIDHeader),
+		}); err != nil {
+			log.Printf("Unable to encode response: %v", err)
+		}
 	case JSON:
 		w.Header().Add("Content-Type", "application/json")
 		enc := json.NewEncoder(w)
 		enc.Encode(models.APIResponse[T]{
 			StatusCode: statusCode,
 			Data:       response,
+			RequestID:  w.Header().Get(logging.RequestIDHeader),
 		})
 	default:
 		w.Header().Add("Content-Type", "application/json")
@@ -79,26 +89,17 @@ func returnResponse[T any](response T, statusCode int, w http.ResponseWriter, ty
 		enc.Encode(models.APIResponse[T]{
 			StatusCode: statusCode,
 			Data:       response,
+			RequestID:  w.Header().Get(logging.RequestIDHeader),
 		})
 	}
 }
 
 // Takes a deposit request via POST HTTP verb. Sanity checks the request. Then creates a transaction in the DB and sends a message to Kafka.
-func DepositPostHandler(w http.ResponseWriter, r *http.Request) {
-	contentType, ok := r.Context().Value("contentType").(ContentType)
-	if !ok {
-		returnJSONError("unsupported context type", "", http.StatusBadRequest, w)
-		return
-	}
-	_db, err := db.GetDB()
-	if err != nil {
-		returnError("unable to connect to DB", "", http.StatusInternalServerError, w, contentType)
-		return
-	}
-	depositPostHandler(_db, r.Context(), w)
+func (s *Server) DepositPostHandler(w http.ResponseWriter, r *http.Request) {
+	s.depositPostHandler(r.Context(), w)
 }
 
-func depositPostHandler(_db *sql.DB, ctx context.Context, w http.ResponseWriter) {
+func (s *Server) depositPostHandler(ctx context.Context, w http.ResponseWriter) {
 	contentType, ok := ctx.Value("contentType").(ContentType)
 	if !ok {
 		returnJSONError("unsupported context type", "", http.StatusBadRequest, w)
@@ -110,20 +111,15 @@ func depositPostHandler(_db *sql.DB, ctx context.Context, w http.ResponseWriter)
 		return
 	}
 
-	rows, err := _db.QueryContext(ctx, "SELECT country_id from users where id = $1", request.UserID)
-	if err != nil || !rows.Next() {
-		returnError("User not found", "", http.StatusNotFound, w, contentType)
-		return
-	}
-	defer rows.Close()
-	countryID := 0
-	if err := rows.Scan(&countryID); err != nil {
+	user, err := s.store.Users().GetUser(ctx, merchantID(ctx), request.UserID)
+	if err != nil {
 		returnError("User not found", "", http.StatusNotFound, w, contentType)
 		return
 	}
+	countryID := user.CountryID
 
 	//Validate that the currency requested is supported in the region
-	if supported, err := db.CurrencySupportedInCountry(ctx, _db, request.Currency, countryID); !supported || err != nil {
+	if supported, err := s.store.ReferenceData().CurrencySupportedInCountry(ctx, request.Currency, countryID); !supported || err != nil {
 		returnError("Currency not supported in country", "", http.StatusBadRequest, w, contentType)
 		return
 	}
@@ -134,11 +130,20 @@ func depositPostHandler(_db *sql.DB, ctx context.Context, w http.ResponseWriter)
 		return
 	}
 
-	gateway, err := db.GetRandomGateway(ctx, _db, countryID, request.Currency, string(contentType))
+	// routing and limits come from the same version of the runtime config even if it is reloaded meanwhile
+	rt := s.runtime.Current().Runtime
+	if err := rt.CheckLimit(merchantID(ctx), "deposit", request.Currency, request.Amount); err != nil {
+		returnError("Amount outside limits", err.Error(), http.StatusBadRequest, w, contentType)
+		return
+	}
+
+	gateway, err := s.pickGateway(ctx, rt, db.DEPOSIT, countryID, request.Currency)
 	if err != nil {
 		returnError("unable to get gateway", err.Error(), http.StatusInternalServerError, w, contentType)
 		return
 	}
+	// only gateways taking deposits in some format are picked
+	dataFormat, _ := outboundFormat(gateway, string(contentType), db.DEPOSIT)
 
 	txReq := models.TransactionRequest{
 		Type:      "deposit",
@@ -149,32 +154,27 @@ func depositPostHandler(_db *sql.DB, ctx context.Context, w http.ResponseWriter)
 		GatewayID: gateway.ID,
 	}
 
-	if err := services.RetryOperation(func() error {
-		return SendKafkaMessageAndDB(ctx, _db, &txReq, string(contentType))
-	}, 3); err != nil {
+	if err := s.retry(func() error {
+		return s.SendKafkaMessageAndDB(ctx, &txReq, dataFormat)
+	}); err != nil {
+		s.logger.Error(ctx, "Unable to create transaction", "type", txReq.Type, "user_id", txReq.UserID, "gateway_id", gateway.ID, "error", err)
 		returnError("unable to create transaction", err.Error(), http.StatusInternalServerError, w, contentType)
 		return
 	}
+	s.logTransactionCreated(ctx, &txReq)
+	s.countTransaction(ctx, txReq.Type, string(db.SENT), gateway.ID, countryID, request.Currency)
 
-	returnTransaction(ctx, http.StatusCreated, w, contentType, _db, fmt.Sprint(txReq.TransactionID), db.DEPOSIT)
+	returnTransaction(ctx, http.StatusCreated, w, contentType, s.store, fmt.Sprint(txReq.TransactionID), db.DEPOSIT)
 }
 
 // Takes a withdrawal request via POST HTTP verb. Sanity checks the request. Then creates a transaction in the DB and sends a message to Kafka.
-func WithdrawalPostHandler(w http.ResponseWriter, r *http.Request) {
-	contentType, ok := r.Context().Value("contentType").(ContentType)
-	if !ok {
-		returnJSONError("unsupported context type", "", http.StatusBadRequest, w)
-		return
-	}
-	_db, err := db.GetDB()
-	if err != nil {
-		returnError("unable to connect to DB", "", http.StatusInternalServerError, w, contentType)
-		return
-	}
-	withdrawalPostHandler(_db, r.Context(), w)
+// With confirmations enabled the transaction is created in DRAFT instead and only published once the user confirms
+// it, see WithdrawalConfirmHandler.
+func (s *Server) WithdrawalPostHandler(w http.ResponseWriter, r *http.Request) {
+	s.withdrawalPostHandler(r.Context(), w)
 }
 
-func withdrawalPostHandler(_db *sql.DB, ctx context.Context, w http.ResponseWriter) {
+func (s *Server) withdrawalPostHandler(ctx context.Context, w http.ResponseWriter) {
 	contentType, ok := ctx.Value("contentType").(ContentType)
 	if !ok {
 		returnJSONError("unsupported context type", "", http.StatusBadRequest, w)
@@ -186,135 +186,159 @@ func withdrawalPostHandler(_db *sql.DB, ctx context.Context, w http.ResponseWrit
 		return
 	}
 
-	rows, err := _db.QueryContext(ctx, "SELECT country_id from users where id = $1", request.UserID)
-	if err != nil || !rows.Next() {
-		returnError("User not found", "", http.StatusNotFound, w, contentType)
-		return
-	}
-	defer rows.Close()
-	countryID := 0
-	if err := rows.Scan(&countryID); err != nil {
-		returnError("User not found", "", http.StatusNotFound, w, contentType)
+	rt := s.runtime.Current().Runtime
+	countryID, reqErr := s.validateWithdrawal(ctx, rt, request)
+	if reqErr != nil {
+		returnError(reqErr.Message, reqErr.DetailedMessage, reqErr.StatusCode, w, contentType)
 		return
 	}
 
-	//Validate that the currency requested is supported in the region
-	if supported, err := db.CurrencySupportedInCountry(ctx, _db, request.Currency, countryID); !supported || err != nil {
-		returnError("Currency not supported in country", "", http.StatusBadRequest, w, contentType)
+	if s.config.ConfirmWithdrawals {
+		challenge, reqErr := s.draftWithdrawal(ctx, rt, request, countryID, string(contentType))
+		if reqErr != nil {
+			returnError(reqErr.Message, reqErr.DetailedMessage, reqErr.StatusCode, w, contentType)
+			return
+		}
+		returnResponse(challenge, http.StatusAccepted, w, contentType)
 		return
 	}
 
-	//Validate the amount requested is no more than 2 decimal places and non negative (or less an 0.01)
-	if !services.CurrencyAmountIsValid(request.Amount) {
-		returnError("Invalid amount", "Amount must be not be more than 2 decimal places", http.StatusBadRequest, w, contentType)
+	txID, reqErr := s.createWithdrawal(ctx, rt, request, countryID, string(contentType))
+	if reqErr != nil {
+		returnError(reqErr.Message, reqErr.DetailedMessage, reqErr.StatusCode, w, contentType)
 		return
 	}
 
-	gateway, err := db.GetRandomGateway(ctx, _db, countryID, request.Currency, string(contentType))
+	returnTransaction(ctx, http.StatusCreated, w, contentType, s.store, fmt.Sprint(txID), db.WITHDRAWAL)
+}
+
+// Sanity checks a withdrawal request. Returns the user's country on success.
+// Shared by single withdrawals and the rows of a withdrawal batch.
+func (s *Server) validateWithdrawal(ctx context.Context, rt config.Runtime, request models.WithdrawalRequest) (int, *requestError) {
+	user, err := s.store.Users().GetUser(ctx, merchantID(ctx), request.UserID)
 	if err != nil {
-		returnError("unable to get gateway", err.Error(), http.StatusInternalServerError, w, contentType)
-		return
+		return 0, &requestError{StatusCode: http.StatusNotFound, Message: "User not found"}
 	}
+	countryID := user.CountryID
 
-	txReq := models.TransactionRequest{
-		Type:      "withdrawal",
-		Amount:    request.Amount,
-		UserID:    request.UserID,
-		CountryID: countryID,
-		Currency:  request.Currency,
-		GatewayID: gateway.ID,
+	//Validate that the currency requested is supported in the region
+	if supported, err := s.store.ReferenceData().CurrencySupportedInCountry(ctx, request.Currency, countryID); !supported || err != nil {
+		return 0, &requestError{StatusCode: http.StatusBadRequest, Message: "Currency not supported in country"}
 	}
 
-	if err := services.RetryOperation(func() error {
-		return SendKafkaMessageAndDB(ctx, _db, &txReq, string(contentType))
-	}, 3); err != nil {
-		returnError("unable to create transaction", err.Error(), http.StatusInternalServerError, w, contentType)
-		return
+	//Validate the amount requested is no more than 2 decimal places and non negative (or less an 0.01)
+	if !services.CurrencyAmountIsValid(request.Amount) {
+		return 0, &requestError{StatusCode: http.StatusBadRequest, Message: "Invalid amount", DetailedMessage: "Amount must be not be more than 2 decimal places"}
 	}
 
-	returnTransaction(ctx, http.StatusCreated, w, contentType, _db, fmt.Sprint(txReq.TransactionID), db.WITHDRAWAL)
-}
+	if err := rt.CheckLimit(merchantID(ctx), "withdrawal", request.Currency, request.Amount); err != nil {
+		return 0, &requestError{StatusCode: http.StatusBadRequest, Message: "Amount outside limits", DetailedMessage: err.Error()}
+	}
 
-func DepositPutHandler(w http.ResponseWriter, r *http.Request) {
-	request := r.Context().Value("request").(models.DepositPutRequest)
-	contentType := r.Context().Value("contentType").(ContentType)
+	return countryID, nil
+}
 
-	_db, err := db.GetDB()
+// Routes a validated withdrawal to a gateway. Returns what to publish and the format the gateway takes it in.
+func (s *Server) routeWithdrawal(ctx context.Context, rt config.Runtime, request models.WithdrawalRequest, countryID int, clientFormat string) (models.TransactionRequest, string, *requestError) {
+	gateway, err := s.pickGateway(ctx, rt, db.WITHDRAWAL, countryID, request.Currency)
 	if err != nil {
-		returnError("unable to connect to DB", "", http.StatusInternalServerError, w, contentType)
+		return models.TransactionRequest{}, "", &requestError{StatusCode: http.StatusInternalServerError, Message: "unable to get gateway", DetailedMessage: err.Error()}
 	}
-	tx, err := db.GetTransaction(r.Context(), _db, request.TransactionID, db.DEPOSIT)
-	if err != nil {
-		returnError("unable to get transaction", err.Error(), http.StatusInternalServerError, w, contentType)
-		return
+
+	txReq := models.TransactionRequest{
+		Type:        "withdrawal",
+		Amount:      request.Amount,
+		UserID:      request.UserID,
+		CountryID:   countryID,
+		Currency:    request.Currency,
+		GatewayID:   gateway.ID,
+		Beneficiary: request.Beneficiary,
 	}
 
-	if tx.Status != db.SENT {
-		returnError("Transaction already processed", "", http.StatusBadRequest, w, contentType)
-		return
+	dataFormat := gateway.OutboundFormat(clientFormat)
+	if dataFormat == iso20022Format && (request.Beneficiary == nil || request.Beneficiary.Name == "" || request.Beneficiary.IBAN == "") {
+		return models.TransactionRequest{}, "", &requestError{StatusCode: http.StatusBadRequest, Message: "Beneficiary required", DetailedMessage: "The gateway pays out by bank transfer and needs a beneficiary name and IBAN"}
 	}
+	return txReq, dataFormat, nil
+}
 
-	if strings.ToLower(request.Status) == "success" {
-		_, err := _db.ExecContext(r.Context(), "UPDATE transactions SET status = $1 WHERE id = $2 and type = $3", db.SUCCESS, request.TransactionID, db.DEPOSIT)
-		if err != nil {
-			returnError("unable to update transaction", err.Error(), http.StatusInternalServerError, w, contentType)
-			return
-		}
-	} else if strings.ToLower(request.Status) == "failed" {
-		_, err := _db.ExecContext(r.Context(), "UPDATE transactions SET status = $1 WHERE id = $2 and type = $3", db.FAILED, request.TransactionID, db.DEPOSIT)
-		if err != nil {
-			returnError("unable to update transaction", err.Error(), http.StatusInternalServerError, w, contentType)
-			return
-		}
-	} else {
-		returnError("Invalid status", "", http.StatusBadRequest, w, contentType)
-		return
+// Routes a validated withdrawal to a gateway, records it and publishes it to Kafka. Returns the transaction ID.
+func (s *Server) createWithdrawal(ctx context.Context, rt config.Runtime, request models.WithdrawalRequest, countryID int, clientFormat string) (int, *requestError) {
+	txReq, dataFormat, reqErr := s.routeWithdrawal(ctx, rt, request, countryID, clientFormat)
+	if reqErr != nil {
+		return 0, reqErr
 	}
 
-	returnTransaction(r.Context(), http.StatusOK, w, contentType, _db, fmt.Sprint(request.TransactionID), db.DEPOSIT)
+	if err := s.retry(func() error {
+		return s.SendKafkaMessageAndDB(ctx, &txReq, dataFormat)
+	}); err != nil {
+		s.logger.Error(ctx, "Unable to create transaction", "type", txReq.Type, "user_id", txReq.UserID, "gateway_id", txReq.GatewayID, "error", err)
+		return 0, &requestError{StatusCode: http.StatusInternalServerError, Message: "unable to create transaction", DetailedMessage: err.Error()}
+	}
+	s.logTransactionCreated(ctx, &txReq)
+	s.countTransaction(ctx, txReq.Type, string(db.SENT), txReq.GatewayID, countryID, request.Currency)
+
+	return txReq.TransactionID, nil
+}
+
+func (s *Server) DepositPutHandler(w http.ResponseWriter, r *http.Request) {
+	request := r.Context().Value("request").(models.DepositPutRequest)
+	s.settleHandler(w, r, db.DEPOSIT, request.TransactionID, request.Status)
 }
 
-func WithdrawalPutHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) WithdrawalPutHandler(w http.ResponseWriter, r *http.Request) {
 	request := r.Context().Value("request").(models.WithdrawalPutRequest)
+	s.settleHandler(w, r, db.WITHDRAWAL, request.TransactionID, request.Status)
+}
+
+// Applies the status a gateway reported for a SENT transaction of txType, success or failed
+func (s *Server) settleHandler(w http.ResponseWriter, r *http.Request, txType db.TransactionType, transactionID int, reported string) {
 	contentType := r.Context().Value("contentType").(ContentType)
 
-	_db, err := db.GetDB()
-	if err != nil {
-		returnError("unable to connect to DB", "", http.StatusInternalServerError, w, contentType)
+	tx, err := s.store.Transactions().GetTransaction(r.Context(), merchantID(r.Context()), transactionID, txType)
+	if errors.Is(err, db.ErrNotFound) {
+		returnError("Transaction not found", err.Error(), http.StatusNotFound, w, contentType)
+		return
 	}
-	tx, err := db.GetTransaction(r.Context(), _db, request.TransactionID, db.WITHDRAWAL)
 	if err != nil {
 		returnError("unable to get transaction", err.Error(), http.StatusInternalServerError, w, contentType)
 		return
 	}
 
 	if tx.Status != db.SENT {
-		returnError("Transaction already processed", "", http.StatusBadRequest, w, contentType)
+		refusal := s.settlementRefusal(r.Context(), tx, reported)
+		returnError(refusal.Message, refusal.DetailedMessage, refusal.StatusCode, w, contentType)
 		return
 	}
 
-	if strings.ToLower(request.Status) == "success" {
-		_, err := _db.ExecContext(r.Context(), "UPDATE transactions SET status = $1 WHERE id = $2 and type = $3", db.SUCCESS, request.TransactionID, db.WITHDRAWAL)
-		if err != nil {
-			returnError("unable to update transaction", err.Error(), http.StatusInternalServerError, w, contentType)
-			return
-		}
-	} else if strings.ToLower(request.Status) == "failed" {
-		_, err := _db.ExecContext(r.Context(), "UPDATE transactions SET status = $1 WHERE id = $2 and type = $3", db.FAILED, request.TransactionID, db.WITHDRAWAL)
-		if err != nil {
-			returnError("unable to update transaction", err.Error(), http.StatusInternalServerError, w, contentType)
+	var status db.TransactionStatus
+	switch strings.ToLower(reported) {
+	case "success":
+		status = db.SUCCESS
+	case "failed":
+		status = db.FAILED
+	default:
+		returnError("Invalid status", "", http.StatusBadRequest, w, contentType)
+		return
+	}
+
+	if err := s.settleTransaction(r.Context(), tx, status); err != nil {
+		if errors.Is(err, db.ErrConflict) {
+			refusal := s.settlementRefusal(r.Context(), tx, reported)
+			returnError(refusal.Message, refusal.DetailedMessage, refusal.StatusCode, w, contentType)
 			return
 		}
-	} else {
-		returnError("Invalid status", "", http.StatusBadRequest, w, contentType)
+		returnError("unable to update transaction", err.Error(), http.StatusInternalServerError, w, contentType)
 		return
 	}
+	s.logger.Info(r.Context(), "Transaction settled", "transaction_id", tx.ID, "type", tx.Type, "status", status)
+	s.countTransaction(r.Context(), string(tx.Type), string(status), tx.GatewayID, tx.CountryID, tx.Currency)
 
-	returnTransaction(r.Context(), http.StatusOK, w, contentType, _db, fmt.Sprint(request.TransactionID), db.WITHDRAWAL)
+	returnTransaction(r.Context(), http.StatusOK, w, contentType, s.store, fmt.Sprint(transactionID), txType)
 }
 
-func DepositGetHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := NewHandlerContext(time.Second * 5)
+func (s *Server) DepositGetHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := NewHandlerContext(r.Context(), s.config.RequestTimeout)
 	defer cancel()
 	contentType := JSON
 	if ct := r.Header.Get("Content-Type"); ct == "application/xml" || ct == "text/xml" {
@@ -322,18 +346,13 @@ func DepositGetHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	idstr := mux.Vars(r)["id"]
-	_db, err := db.GetDB()
-	if err != nil {
-		returnError("unable to connect to DB", "", http.StatusInternalServerError, w, contentType)
-		return
-	}
 
-	returnTransaction(ctx, http.StatusOK, w, contentType, _db, idstr, db.DEPOSIT)
+	returnTransaction(ctx, http.StatusOK, w, contentType, s.store, idstr, db.DEPOSIT)
 }
 
 // TODO should return type based on "Accept" header?
-func WithdrawalGetHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := NewHandlerContext(time.Second * 5)
+func (s *Server) WithdrawalGetHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := NewHandlerContext(r.Context(), s.config.RequestTimeout)
 	defer cancel()
 	contentType := JSON
 	if ct := r.Header.Get("Content-Type"); ct == "application/xml" || ct == "text/xml" {
@@ -341,11 +360,6 @@ func WithdrawalGetHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	idstr := mux.Vars(r)["id"]
-	_db, err := db.GetDB()
-	if err != nil {
-		returnError("unable to connect to DB", "", http.StatusInternalServerError, w, contentType)
-		return
-	}
 
-	returnTransaction(ctx, http.StatusOK, w, contentType, _db, idstr, db.WITHDRAWAL)
+	returnTransaction(ctx, http.StatusOK, w, contentType, s.store, idstr, db.WITHDRAWAL)
 }
@@ -9,9 +9,13 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"payment-gateway/db"
+	"payment-gateway/db/memory"
 	"payment-gateway/internal/models"
+	"payment-gateway/internal/publisher"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/shopspring/decimal"
@@ -78,7 +82,7 @@ func TestDepositPostHandler(t *testing.T) {
 		UserID:   1,
 		Currency: "GBP",
 	}, "POST", "/withdrawal", JSON)
-	depositPostHandler(_db, req.Context(), rr)
+	newTestServer(t, db.NewPostgresStore(_db), publisher.NewMemory()).depositPostHandler(req.Context(), rr)
 	assertResponse([]byte(`{"status_code":404,"error":{"message":"User not found"}}`), rr, t)
 
 	//Test Currency Unsupported
@@ -87,11 +91,38 @@ func TestDepositPostHandler(t *testing.T) {
 		UserID:   1,
 		Currency: "GBP",
 	}, "POST", "/withdrawal", JSON)
-	mock.ExpectQuery("SELECT country_id from users where id = (.+)").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"country_id"}).AddRow(1))
-	depositPostHandler(_db, req.Context(), rr)
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE id = (.+)").WithArgs(1, db.DefaultMerchantID).WillReturnRows(sqlmock.NewRows([]string{"id", "merchant_id", "username", "email", "country_id", "created_at", "updated_at"}).AddRow(1, db.DefaultMerchantID, "johnsmith", "john.smith@example.com", 1, time.Now(), time.Now()))
+	newTestServer(t, db.NewPostgresStore(_db), publisher.NewMemory()).depositPostHandler(req.Context(), rr)
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Error(err)
 	}
 	assertResponse([]byte(`{"status_code":400,"error":{"message":"Currency not supported in country"}}`), rr, t)
 
 }
+
+func TestSendKafkaMessageAndDBRollsBackWhenPublishFails(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	pub := publisher.NewMemory()
+	s := newTestServer(t, store, pub)
+	txReq := models.TransactionRequest{Type: "deposit", Amount: decimal.NewFromInt(20), UserID: 1, CountryID: 1, Currency: "USD", GatewayID: 1}
+
+	if err := s.SendKafkaMessageAndDB(ctx, &txReq, "application/json"); err != nil {
+		t.Fatal(err)
+	}
+	if messages := pub.Messages(); len(messages) != 1 || messages[0].Key != fmt.Sprint(txReq.TransactionID) {
+		t.Errorf("Expected one message keyed by the transaction ID, got %+v", messages)
+	}
+
+	pub.FailWith(fmt.Errorf("broker down"))
+	failed := txReq
+	if err := s.SendKafkaMessageAndDB(ctx, &failed, "application/json"); err == nil {
+		t.Fatal("Expected the publish failure to be returned")
+	}
+	if _, err := store.GetTransaction(ctx, db.DefaultMerchantID, failed.TransactionID, db.DEPOSIT); err == nil {
+		t.Errorf("Expected the transaction to be rolled back when publishing fails")
+	}
+	if transactions, _ := store.GetTransactions(ctx, db.DefaultMerchantID); len(transactions) != 1 {
+		t.Errorf("Expected only the published transaction to be stored, got %d", len(transactions))
+	}
+}
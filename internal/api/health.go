@@ -0,0 +1,163 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"payment-gateway/db"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/publisher"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+// how long a single dependency check may take, probes usually give up after a second
+const dependencyCheckTimeout = time.Second
+
+type dependencyCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// Remembers the latest outcome of every dependency check so /status can report the last failure after recovery
+type healthTracker struct {
+	mu       sync.Mutex
+	statuses map[string]models.DependencyStatus
+}
+
+func (h *healthTracker) record(status models.DependencyStatus) models.DependencyStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.statuses == nil {
+		h.statuses = map[string]models.DependencyStatus{}
+	}
+
+	previous := h.statuses[status.Name]
+	status.LastError, status.LastErrorAt = previous.LastError, previous.LastErrorAt
+	if status.Error != "" {
+		checkedAt := status.CheckedAt
+		status.LastError, status.LastErrorAt = status.Error, &checkedAt
+	}
+	h.statuses[status.Name] = status
+	return status
+}
+
+// The dependencies readiness depends on. Without a DB the store is assumed to be in memory and always available.
+func (s *Server) dependencyChecks() []dependencyCheck {
+	var checks []dependencyCheck
+	if s.db != nil {
+		checks = append(checks, dependencyCheck{"database", s.db.PingContext})
+		if len(s.migrations) > 0 {
+			checks = append(checks, dependencyCheck{"migrations", func(ctx context.Context) error {
+				return db.CheckMigrations(ctx, s.db, s.migrations)
+			}})
+		}
+	}
+	if pinger, ok := s.pub.(publisher.Pinger); ok {
+		checks = append(checks, dependencyCheck{"publisher", pinger.Ping})
+	}
+	checks = append(checks, dependencyCheck{"circuit_breaker", func(ctx context.Context) error {
+		if state := s.breaker.State(); state == gobreaker.StateOpen {
+			return fmt.Errorf("%s is %s", s.breaker.Name(), state)
+		}
+		return nil
+	}})
+	return checks
+}
+
+// Runs every dependency check concurrently and returns the outcomes ordered by name
+func (s *Server) checkDependencies(ctx context.Context) []models.DependencyStatus {
+	checks := s.dependencyChecks()
+	statuses := make([]models.DependencyStatus, len(checks))
+
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c dependencyCheck) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, dependencyCheckTimeout)
+			defer cancel()
+
+			started := time.Now()
+			err := c.check(checkCtx)
+			status := models.DependencyStatus{
+				Name:      c.name,
+				Healthy:   err == nil,
+				LatencyMS: float64(time.Since(started).Microseconds()) / 1000,
+				CheckedAt: s.clock(),
+			}
+			if err != nil {
+				status.Error = err.Error()
+			}
+			statuses[i] = s.health.record(status)
+		}(i, c)
+	}
+	wg.Wait()
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+func allHealthy(statuses []models.DependencyStatus) bool {
+	for _, status := range statuses {
+		if !status.Healthy {
+			return false
+		}
+	}
+	return true
+}
+
+// Makes readiness fail from now on so load balancers stop sending requests before the server stops accepting them
+func (s *Server) Drain() {
+	s.draining.Store(true)
+}
+
+// writes the response with its status code, unlike returnResponse, as probes only look at the code
+func returnProbeResponse[T any](response T, statusCode int, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	returnResponse(response, statusCode, w, JSON)
+}
+
+// Liveness: the process is up and serving HTTP, dependencies are left to readiness so an outage doesn't get every
+// instance restarted
+func (s *Server) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	returnProbeResponse(models.ReadinessResponse{Status: "ok"}, http.StatusOK, w)
+}
+
+func (s *Server) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	if s.draining.Load() {
+		returnProbeResponse(models.ReadinessResponse{Status: "shutting down"}, http.StatusServiceUnavailable, w)
+		return
+	}
+
+	statuses := s.checkDependencies(r.Context())
+	if !allHealthy(statuses) {
+		returnProbeResponse(models.ReadinessResponse{Status: "not ready", Dependencies: statuses}, http.StatusServiceUnavailable, w)
+		return
+	}
+	returnProbeResponse(models.ReadinessResponse{Status: "ready", Dependencies: statuses}, http.StatusOK, w)
+}
+
+// Reports every dependency with the latency of its check and its latest failure. Always answers 200, the status
+// field says whether the instance is ready.
+func (s *Server) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	statuses := s.checkDependencies(r.Context())
+	now := s.clock()
+	response := models.StatusResponse{
+		Status:               "ready",
+		StartedAt:            s.startedAt,
+		UptimeSeconds:        int64(now.Sub(s.startedAt).Seconds()),
+		RuntimeConfigVersion: s.runtime.Current().Version,
+		Dependencies:         statuses,
+	}
+	if s.draining.Load() {
+		response.Status = "shutting down"
+	} else if !allHealthy(statuses) {
+		response.Status = "not ready"
+	}
+	returnProbeResponse(response, http.StatusOK, w)
+}
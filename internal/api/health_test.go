@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/db"
+	"payment-gateway/db/memory"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/publisher"
+	"payment-gateway/internal/services"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func probe[T any](t *testing.T, s *Server, path string) (int, T) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var response models.APIResponse[T]
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	return rr.Code, response.Data
+}
+
+func TestReadinessFollowsPublisher(t *testing.T) {
+	pub := publisher.NewMemory()
+	s := newTestServer(t, memory.NewStore(), pub)
+
+	if code, _ := probe[models.ReadinessResponse](t, s, "/healthz"); code != http.StatusOK {
+		t.Errorf("Expected liveness to pass, got %d", code)
+	}
+	if code, response := probe[models.ReadinessResponse](t, s, "/readyz"); code != http.StatusOK || response.Status != "ready" {
+		t.Errorf("Expected the server to be ready, got %d %+v", code, response)
+	}
+
+	pub.FailWith(errors.New("broker unreachable"))
+	code, response := probe[models.ReadinessResponse](t, s, "/readyz")
+	if code != http.StatusServiceUnavailable || response.Status != "not ready" {
+		t.Errorf("Expected the server not to be ready, got %d %+v", code, response)
+	}
+	if code, _ := probe[models.ReadinessResponse](t, s, "/healthz"); code != http.StatusOK {
+		t.Errorf("Expected liveness to ignore dependencies, got %d", code)
+	}
+
+	pub.FailWith(nil)
+	code, status := probe[models.StatusResponse](t, s, "/status")
+	if code != http.StatusOK || status.Status != "ready" {
+		t.Fatalf("unexpected status %d %+v", code, status)
+	}
+	for _, dependency := range status.Dependencies {
+		if dependency.Name == "publisher" {
+			if !dependency.Healthy || dependency.LastError != "broker unreachable" || dependency.LastErrorAt == nil {
+				t.Errorf("Expected the publisher to have recovered from its last error, got %+v", dependency)
+			}
+			return
+		}
+	}
+	t.Errorf("Expected the publisher to be reported, got %+v", status.Dependencies)
+}
+
+func TestReadinessFailsWhileDraining(t *testing.T) {
+	s := newTestServer(t, memory.NewStore(), publisher.NewMemory())
+	s.Drain()
+
+	if code, response := probe[models.ReadinessResponse](t, s, "/readyz"); code != http.StatusServiceUnavailable || response.Status != "shutting down" {
+		t.Errorf("Expected readiness to fail during shutdown, got %d %+v", code, response)
+	}
+	if code, _ := probe[models.ReadinessResponse](t, s, "/healthz"); code != http.StatusOK {
+		t.Errorf("Expected liveness to pass during shutdown, got %d", code)
+	}
+}
+
+func TestReadinessChecksDatabaseAndMigrations(t *testing.T) {
+	_db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer _db.Close()
+	mock.MatchExpectationsInOrder(false)
+
+	cipher, _ := services.NewAESCipher(make([]byte, 32))
+	s, err := NewServer(Options{
+		DB:         _db,
+		Store:      memory.NewStore(),
+		Publisher:  publisher.NewMemory(),
+		Cipher:     cipher,
+		Migrations: []db.Migration{{Version: 1, Name: "init", Checksum: "a"}, {Version: 2, Name: "batches", Checksum: "b"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectPing()
+	mock.ExpectQuery("SELECT version, checksum FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "checksum"}).AddRow(1, "a"))
+	code, response := probe[models.ReadinessResponse](t, s, "/readyz")
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("Expected a pending migration to fail readiness, got %d %+v", code, response)
+	}
+	for _, dependency := range response.Dependencies {
+		if dependency.Healthy != (dependency.Name != "migrations") {
+			t.Errorf("unexpected dependency %+v", dependency)
+		}
+	}
+
+	mock.ExpectPing()
+	mock.ExpectQuery("SELECT version, checksum FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "checksum"}).AddRow(1, "a").AddRow(2, "b").AddRow(3, "newer"))
+	if code, response := probe[models.ReadinessResponse](t, s, "/readyz"); code != http.StatusOK {
+		t.Errorf("Expected the server to be ready with a newer migration applied, got %d %+v", code, response)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
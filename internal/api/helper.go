@@ -2,19 +2,49 @@ package api
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 	"net/http"
 	"payment-gateway/db"
-	"payment-gateway/internal/kafka"
+	"payment-gateway/internal/logging"
 	"payment-gateway/internal/models"
+	"payment-gateway/internal/publisher"
 	"payment-gateway/internal/services"
+	"payment-gateway/internal/tracing"
 	"strconv"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func NewHandlerContext(duration time.Duration) (context.Context, context.CancelFunc) {
-	return context.WithTimeout(context.Background(), duration)
+// a request that can't be processed, carrying what to return to the client
+type requestError struct {
+	StatusCode      int
+	Message         string
+	DetailedMessage string
+}
+
+func (e *requestError) Error() string {
+	if e.DetailedMessage == "" {
+		return e.Message
+	}
+	return e.Message + ": " + e.DetailedMessage
+}
+
+// Returns the context a handler works in. Only the request ID, span and principal are taken from parent, the work
+// isn't abandoned when the client goes away.
+func NewHandlerContext(parent context.Context, duration time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(detach(parent), duration)
+}
+
+// carries the request ID, span and principal of parent over to a context that is never cancelled
+func detach(parent context.Context) context.Context {
+	ctx := logging.WithRequestID(tracing.Detach(parent), logging.RequestID(parent))
+	if principal, ok := PrincipalFrom(parent); ok {
+		ctx = withPrincipal(ctx, principal)
+	}
+	return ctx
 }
 
 // Encrypts the kafka message
@@ -22,14 +52,8 @@ func NewHandlerContext(duration time.Duration) (context.Context, context.CancelF
 // Uses a circuit breaker to publish the message to Kafka
 // If there are any failures the DB tx rollsback otherwise commits
 // Returns the transaction ID and error (if any)
-func SendKafkaMessageAndDB(ctx context.Context, _db *sql.DB, txReq *models.TransactionRequest, requestContentType string) error {
-
-	// Create a sql transaction from the txReq and write a transaction to the DB which will be committed on successful completion of the rest of the code
-	tx, err := _db.Begin()
-	if err != nil {
-		return err
-	}
-
+// dataFormat is the format of the gateway receiving the message, which may differ from the client's format
+func (s *Server) SendKafkaMessageAndDB(ctx context.Context, txReq *models.TransactionRequest, dataFormat string) error {
 	var typ db.TransactionType
 	switch txReq.Type {
 	case "deposit":
@@ -40,44 +64,111 @@ func SendKafkaMessageAndDB(ctx context.Context, _db *sql.DB, txReq *models.Trans
 		return fmt.Errorf("invalid transaction type")
 	}
 
-	transaction := db.Transaction{
-		Amount:    txReq.Amount,
-		Type:      typ,
-		UserID:    txReq.UserID,
-		CountryID: txReq.CountryID,
-		Status:    db.SENT,
-		GatewayID: txReq.GatewayID,
-	}
+	// Write the transaction in a DB transaction which is only committed on successful completion of the rest of the code
+	return s.store.InTx(ctx, func(tx db.Store) error {
+		transaction := db.Transaction{
+			MerchantID: merchantID(ctx),
+			Amount:     txReq.Amount,
+			Type:       typ,
+			UserID:     txReq.UserID,
+			CountryID:  txReq.CountryID,
+			Currency:   txReq.Currency,
+			Status:     db.SENT,
+			GatewayID:  txReq.GatewayID,
+			DataFormat: dataFormat,
+			CreatedBy:  createdBy(ctx),
+		}
+
+		if err := tx.Transactions().CreateTransaction(ctx, &transaction); err != nil {
+			return err
+		}
+
+		// Formats such as ISO 20022 reference the transaction ID in the message itself
+		txReq.TransactionID = transaction.ID
+
+		if err := s.publishTransaction(ctx, txReq, dataFormat); err != nil {
+			return err
+		}
+
+		// recorded last, the audit log stays locked from here until the commit
+		return auditTransactionCreated(ctx, tx, transaction)
+	})
+}
 
-	if err := db.CreateTransaction(ctx, tx, &transaction); err != nil {
-		tx.Rollback()
+// Encodes and encrypts the transaction in the gateway's dataFormat and publishes it
+func (s *Server) publishTransaction(ctx context.Context, txReq *models.TransactionRequest, dataFormat string) error {
+	// Encode the kafka txReq to xml/json and then AES encrypt it.
+	encryptedKafkaMessage, err := services.EncodeAndEncryptKafkaTransaction(txReq, dataFormat, s.encodeOptions())
+	if err != nil {
 		return err
 	}
+	return s.publish(ctx, "", txReq.TransactionID, encryptedKafkaMessage, dataFormat)
+}
 
-	// Encode the kafka txReq to xml/json and then AES encrypt it.
-	encryptedKafkaMessage, err := services.EncodeAndEncryptKafkaTransaction(txReq, requestContentType)
+// Asks the gateway to drop the transaction published to it before, in the gateway's dataFormat
+func (s *Server) publishCancellation(ctx context.Context, transaction db.Transaction, dataFormat string) error {
+	message, err := services.EncodeCancellation(&models.TransactionStatusEvent{
+		TransactionID: transaction.ID,
+		Type:          string(transaction.Type),
+		Status:        string(db.CANCELLED),
+		GatewayID:     transaction.GatewayID,
+	}, dataFormat, s.encodeOptions())
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
+	return s.publish(ctx, publisher.MessageTypeCancellation, transaction.ID, message, dataFormat)
+}
 
-	if err := kafka.PublishTransaction(context.Background(), fmt.Sprint(transaction.ID), encryptedKafkaMessage, requestContentType); err != nil {
-		tx.Rollback()
-		return err
+// Publishes an encoded message about the transaction to the topic of dataFormat, messageType is empty for the
+// transactions themselves
+func (s *Server) publish(ctx context.Context, messageType string, transactionID int, message []byte, dataFormat string) error {
+	started := time.Now()
+	// the message is published even if the request is cancelled meanwhile, it only carries the request ID and
+	// trace along. The traceparent of the publish span goes out with the message so the gateway's callback
+	// continues the trace.
+	topic, _ := publisher.Topic(dataFormat)
+	pubCtx, span := s.tracer.Start(detach(ctx), "publish "+topic, trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.destination.name", topic),
+			attribute.String("messaging.message.id", fmt.Sprint(transactionID)),
+			attribute.String("data_format", dataFormat),
+		))
+	if messageType != "" {
+		span.SetAttributes(attribute.String("message_type", messageType))
+		pubCtx = publisher.WithMessageType(pubCtx, messageType)
 	}
+	err := s.pub.PublishTransaction(pubCtx, fmt.Sprint(transactionID), message, dataFormat)
+	endSpan(span, err)
+	s.metrics.ObservePublish(dataFormat, time.Since(started), err)
+	return err
+}
 
-	txReq.TransactionID = transaction.ID
+// records err on the span, if any, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
 
-	return tx.Commit()
+// user IDs, amounts and beneficiaries are masked by the logger's policy
+func (s *Server) logTransactionCreated(ctx context.Context, txReq *models.TransactionRequest) {
+	keyvals := []any{"transaction_id", txReq.TransactionID, "type", txReq.Type, "user_id", txReq.UserID,
+		"amount", txReq.Amount, "currency", txReq.Currency, "gateway_id", txReq.GatewayID}
+	if txReq.Beneficiary != nil {
+		keyvals = append(keyvals, "beneficiary_name", txReq.Beneficiary.Name, "iban", txReq.Beneficiary.IBAN)
+	}
+	s.logger.Info(ctx, "Transaction created", keyvals...)
 }
 
-func returnTransaction(ctx context.Context, statusCode int, w http.ResponseWriter, contentType ContentType, _db *sql.DB, txid string, txType db.TransactionType) {
+func returnTransaction(ctx context.Context, statusCode int, w http.ResponseWriter, contentType ContentType, store db.Store, txid string, txType db.TransactionType) {
 	if txid == "" {
 		returnError("ID not passed", "", http.StatusBadRequest, w, contentType)
 		return
 	}
 
-	if _db == nil {
+	if store == nil {
 		returnError("unable to connect to DB", "", http.StatusInternalServerError, w, contentType)
 		return
 	}
@@ -88,7 +179,7 @@ func returnTransaction(ctx context.Context, statusCode int, w http.ResponseWrite
 		return
 	}
 
-	tx, err := db.GetTransaction(ctx, _db, int(id), txType)
+	tx, err := store.Transactions().GetTransaction(ctx, merchantID(ctx), int(id), txType)
 	if err != nil {
 		returnError("unable to get transaction", err.Error(), http.StatusNotFound, w, contentType)
 		return
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"payment-gateway/db"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/services"
+)
+
+// Maps an ISO 20022 payment status code onto a transaction status. Codes that mean the payment is still
+// being processed map to SENT so the transaction stays open.
+func transactionStatusFromPain002(code string) (db.TransactionStatus, error) {
+	switch code {
+	case "ACSC", "ACCC":
+		return db.SUCCESS, nil
+	case "RJCT", "CANC":
+		return db.FAILED, nil
+	case "RCVD", "PDNG", "ACCP", "ACTC", "ACSP", "ACWC", "ACWP", "PART":
+		return db.SENT, nil
+	default:
+		return "", fmt.Errorf("unknown ISO 20022 status %q", code)
+	}
+}
+
+// Takes a pain.002 payment status report from a bank payout gateway and settles the withdrawals it reports on.
+// Each entry is applied on its own so one bad entry doesn't hold back the rest of the report.
+func (s *Server) WithdrawalStatusReportHandler(w http.ResponseWriter, r *http.Request) {
+	contentType, ok := r.Context().Value("contentType").(ContentType)
+	if !ok {
+		returnJSONError("unsupported context type", "", http.StatusBadRequest, w)
+		return
+	}
+	report, ok := r.Context().Value("request").(services.Pain002Document)
+	if !ok {
+		returnError("unable to parse body", "", http.StatusBadRequest, w, contentType)
+		return
+	}
+
+	statuses, err := report.Statuses()
+	if err != nil {
+		returnError("Invalid status report", err.Error(), http.StatusBadRequest, w, contentType)
+		return
+	}
+
+	results := make([]models.StatusReportResult, 0, len(statuses))
+	for _, entry := range statuses {
+		result := models.StatusReportResult{TransactionID: entry.TransactionID}
+
+		status, err := transactionStatusFromPain002(entry.Status)
+		if err != nil {
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+
+		tx, err := s.store.Transactions().GetTransaction(r.Context(), merchantID(r.Context()), entry.TransactionID, db.WITHDRAWAL)
+		if err != nil {
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+		result.Status = string(tx.Status)
+
+		if status == db.SENT || tx.Status == status {
+			results = append(results, result)
+			continue
+		}
+		if tx.Status != db.SENT {
+			result.Error = s.settlementRefusal(r.Context(), tx, string(status)).Message
+			results = append(results, result)
+			continue
+		}
+
+		if err := s.settleTransaction(r.Context(), tx, status); err != nil {
+			if errors.Is(err, db.ErrConflict) {
+				result.Error = s.settlementRefusal(r.Context(), tx, string(status)).Message
+			} else {
+				result.Error = err.Error()
+			}
+			results = append(results, result)
+			continue
+		}
+		s.logger.Info(r.Context(), "Transaction settled", "transaction_id", tx.ID, "type", tx.Type, "status", status, "source", "pain.002")
+		s.countTransaction(r.Context(), string(tx.Type), string(status), tx.GatewayID, tx.CountryID, tx.Currency)
+		result.Status = string(status)
+		results = append(results, result)
+	}
+
+	returnResponse(results, http.StatusOK, w, contentType)
+}
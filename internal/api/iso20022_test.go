@@ -0,0 +1,23 @@
+package api
+
+import (
+	"payment-gateway/db"
+	"testing"
+)
+
+func TestTransactionStatusFromPain002(t *testing.T) {
+	expected := map[string]db.TransactionStatus{
+		"ACSC": db.SUCCESS,
+		"RJCT": db.FAILED,
+		"PDNG": db.SENT,
+		"ACSP": db.SENT,
+	}
+	for code, status := range expected {
+		if got, err := transactionStatusFromPain002(code); err != nil || got != status {
+			t.Errorf("Expected %s for %s Received %s (%v)", status, code, got, err)
+		}
+	}
+	if _, err := transactionStatusFromPain002("XXXX"); err == nil {
+		t.Errorf("Expected an unknown status code to be rejected")
+	}
+}
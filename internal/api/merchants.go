@@ -0,0 +1,173 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"payment-gateway/db"
+	"payment-gateway/internal/models"
+	"strconv"
+	"strings"
+)
+
+// Admin endpoints managing merchants, and the transaction report merchants read with their own keys
+
+func validateMerchantRequest(request models.AdminMerchantRequest) *requestError {
+	if name := strings.TrimSpace(request.Name); name == "" || len(name) > 255 {
+		return &requestError{StatusCode: http.StatusBadRequest, Message: "Invalid name", DetailedMessage: "Name must be between 1 and 255 characters"}
+	}
+	return nil
+}
+
+func validateMerchantWebhookRequest(request models.AdminMerchantWebhookRequest) *requestError {
+	if request.WebhookURL == "" {
+		return nil
+	}
+	if u, err := url.Parse(request.WebhookURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return &requestError{StatusCode: http.StatusBadRequest, Message: "Invalid webhook URL", DetailedMessage: "The webhook URL must be an absolute http or https URL"}
+	}
+	return nil
+}
+
+func (s *Server) AdminListMerchantsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := NewHandlerContext(r.Context(), s.config.RequestTimeout)
+	defer cancel()
+	contentType := responseContentType(r)
+	_db, ok := s.adminDB(w, contentType)
+	if !ok {
+		return
+	}
+
+	merchants, err := db.GetMerchants(ctx, _db)
+	if err != nil {
+		returnError("unable to get merchants", err.Error(), http.StatusInternalServerError, w, contentType)
+		return
+	}
+	returnResponse(merchants, http.StatusOK, w, contentType)
+}
+
+func (s *Server) AdminCreateMerchantHandler(w http.ResponseWriter, r *http.Request) {
+	contentType := responseContentType(r)
+	request := r.Context().Value("request").(models.AdminMerchantRequest)
+	if reqErr := validateMerchantRequest(request); reqErr != nil {
+		returnError(reqErr.Message, reqErr.DetailedMessage, reqErr.StatusCode, w, contentType)
+		return
+	}
+	_db, ok := s.adminDB(w, contentType)
+	if !ok {
+		return
+	}
+
+	merchant := db.Merchant{Name: strings.TrimSpace(request.Name)}
+	err := auditedWrite(r.Context(), _db, "create", "merchant", request, func(tx *sql.Tx) (string, error) {
+		err := db.CreateMerchant(r.Context(), tx, &merchant)
+		return strconv.Itoa(merchant.ID), err
+	})
+	if err != nil {
+		returnAdminWriteError(err, w, contentType)
+		return
+	}
+	returnResponse(merchant, http.StatusCreated, w, contentType)
+}
+
+func (s *Server) AdminGetMerchantHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := NewHandlerContext(r.Context(), s.config.RequestTimeout)
+	defer cancel()
+	contentType := responseContentType(r)
+	id, ok := pathID(w, r, "id", contentType)
+	if !ok {
+		return
+	}
+	_db, ok := s.adminDB(w, contentType)
+	if !ok {
+		return
+	}
+
+	merchant, err := db.GetMerchant(ctx, _db, id)
+	if err != nil {
+		returnAdminWriteError(err, w, contentType)
+		return
+	}
+	returnResponse(merchant, http.StatusOK, w, contentType)
+}
+
+// Sets where the codes confirming the merchant's withdrawals are posted, with a new secret signing them that is
+// returned only once. An empty URL removes the webhook and its secret.
+func (s *Server) AdminSetMerchantWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	contentType := responseContentType(r)
+	request := r.Context().Value("request").(models.AdminMerchantWebhookRequest)
+	id, ok := pathID(w, r, "id", contentType)
+	if !ok {
+		return
+	}
+	if reqErr := validateMerchantWebhookRequest(request); reqErr != nil {
+		returnError(reqErr.Message, reqErr.DetailedMessage, reqErr.StatusCode, w, contentType)
+		return
+	}
+	_db, ok := s.adminDB(w, contentType)
+	if !ok {
+		return
+	}
+
+	response := models.MerchantWebhookResponse{MerchantID: id, WebhookURL: request.WebhookURL}
+	var encrypted string
+	if request.WebhookURL != "" {
+		response.WebhookSecret = randomString(32)
+		var err error
+		if encrypted, err = s.cipher.Encrypt([]byte(response.WebhookSecret)); err != nil {
+			returnError("unable to set webhook", fmt.Sprintf("failed to encrypt the webhook secret: %v", err), http.StatusInternalServerError, w, contentType)
+			return
+		}
+	}
+	err := auditedWrite(r.Context(), _db, "update", "merchant_webhook", request, func(tx *sql.Tx) (string, error) {
+		return strconv.Itoa(id), db.SetMerchantWebhook(r.Context(), tx, id, request.WebhookURL, encrypted)
+	})
+	if err != nil {
+		returnAdminWriteError(err, w, contentType)
+		return
+	}
+	returnResponse(response, http.StatusOK, w, contentType)
+}
+
+// The transactions of one merchant, for the operator
+func (s *Server) AdminListMerchantTransactionsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := NewHandlerContext(r.Context(), s.config.RequestTimeout)
+	defer cancel()
+	contentType := responseContentType(r)
+	id, ok := pathID(w, r, "id", contentType)
+	if !ok {
+		return
+	}
+	if _, err := s.store.Merchants().GetMerchant(ctx, id); err != nil {
+		returnAdminWriteError(err, w, contentType)
+		return
+	}
+
+	transactions, err := s.store.Transactions().GetTransactions(ctx, id)
+	if err != nil {
+		returnError("unable to get transactions", err.Error(), http.StatusInternalServerError, w, contentType)
+		return
+	}
+	if transactions == nil {
+		transactions = []db.Transaction{}
+	}
+	returnResponse(transactions, http.StatusOK, w, contentType)
+}
+
+// The transactions of the request's merchant, oldest first
+func (s *Server) TransactionsGetHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := NewHandlerContext(r.Context(), s.config.RequestTimeout)
+	defer cancel()
+	contentType := responseContentType(r)
+
+	transactions, err := s.store.Transactions().GetTransactions(ctx, merchantID(ctx))
+	if err != nil {
+		returnError("unable to get transactions", err.Error(), http.StatusInternalServerError, w, contentType)
+		return
+	}
+	if transactions == nil {
+		transactions = []db.Transaction{}
+	}
+	returnResponse(transactions, http.StatusOK, w, contentType)
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/db"
+	"payment-gateway/db/memory"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/publisher"
+	"payment-gateway/internal/services"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// Two merchants sharing the gateway: each only sees and changes its own users and transactions, and only the
+// operator's gateway key settles across them.
+func TestMerchantsAreIsolated(t *testing.T) {
+	ctx := context.Background()
+	s, store := newAuthTestServer(t, Config{})
+	brand := db.Merchant{Name: "Brand"}
+	if err := store.CreateMerchant(ctx, &brand); err != nil {
+		t.Fatal(err)
+	}
+	brandUser := db.User{MerchantID: brand.ID, Username: "johnsmith", Email: "john.smith@example.com", CountryID: 1}
+	if err := store.CreateUser(ctx, &brandUser); err != nil {
+		t.Fatalf("Expected the same username to be free for another merchant, got %v", err)
+	}
+
+	issue := func(merchantID int, scopes ...string) string {
+		issued, err := s.issueAPIKey(merchantID, "test", scopes, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.APIKeys().CreateAPIKey(ctx, &issued.Key); err != nil {
+			t.Fatal(err)
+		}
+		return issued.Token
+	}
+	defaultKey := issue(db.DefaultMerchantID, ScopeDepositCreate, ScopeTransactionsRead)
+	brandKey := issue(brand.ID, ScopeDepositCreate, ScopeTransactionsRead, ScopeTransactionsSettle)
+	gatewayKey := issue(0, ScopeTransactionsSettle)
+
+	do := func(token, method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		s.ServeHTTP(rr, req)
+		return rr
+	}
+	deposit := func(token string, userID int) (int, models.APIResponse[db.Transaction]) {
+		rr := do(token, http.MethodPost, "/deposit", fmt.Sprintf(`{"amount": 20, "user_id": %d, "currency": "USD"}`, userID))
+		var response models.APIResponse[db.Transaction]
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return response.StatusCode, response
+	}
+
+	code, mine := deposit(defaultKey, 1)
+	if code != http.StatusCreated || mine.Data.MerchantID != db.DefaultMerchantID {
+		t.Fatalf("Expected the deposit to be created for the default merchant, got %+v", mine)
+	}
+	if code, _ := deposit(brandKey, 1); code != http.StatusNotFound {
+		t.Errorf("Expected another merchant's user not to be found, got %d", code)
+	}
+	code, theirs := deposit(brandKey, brandUser.ID)
+	if code != http.StatusCreated || theirs.Data.MerchantID != brand.ID {
+		t.Fatalf("Expected the deposit to be created for the brand, got %+v", theirs)
+	}
+
+	if code := responseStatus(t, do(brandKey, http.MethodGet, fmt.Sprintf("/deposit/%d", mine.Data.ID), "")); code != http.StatusNotFound {
+		t.Errorf("Expected another merchant's deposit not to be found, got %d", code)
+	}
+	if code := responseStatus(t, do(defaultKey, http.MethodGet, fmt.Sprintf("/deposit/%d", mine.Data.ID), "")); code != http.StatusOK {
+		t.Errorf("Expected the merchant to read its own deposit, got %d", code)
+	}
+
+	for token, expected := range map[string]int{defaultKey: mine.Data.ID, brandKey: theirs.Data.ID} {
+		var report models.APIResponse[[]db.Transaction]
+		json.Unmarshal(do(token, http.MethodGet, "/transactions", "").Body.Bytes(), &report)
+		if len(report.Data) != 1 || report.Data[0].ID != expected {
+			t.Errorf("Expected the report to only hold transaction %d, got %+v", expected, report.Data)
+		}
+	}
+
+	settle := fmt.Sprintf(`{"transaction_id": %d, "status": "success"}`, mine.Data.ID)
+	if code := responseStatus(t, do(brandKey, http.MethodPut, "/deposit", settle)); code != http.StatusNotFound {
+		t.Errorf("Expected settling another merchant's deposit to fail, got %d", code)
+	}
+	if tx, _ := store.GetTransaction(ctx, db.DefaultMerchantID, mine.Data.ID, db.DEPOSIT); tx.Status != db.SENT {
+		t.Errorf("Expected the deposit to be unchanged, got %s", tx.Status)
+	}
+	if code := responseStatus(t, do(gatewayKey, http.MethodPut, "/deposit", settle)); code != http.StatusOK {
+		t.Errorf("Expected the gateway to settle any merchant's deposit, got %d", code)
+	}
+
+	// operator keys act for no merchant, so they can't create or read transactions
+	if code := responseStatus(t, do(gatewayKey, http.MethodGet, "/transactions", "")); code != http.StatusForbidden {
+		t.Errorf("Expected an operator key to be refused, got %d", code)
+	}
+}
+
+func TestValidateAPIKeyRequestSeparatesOperatorKeys(t *testing.T) {
+	for name, c := range map[string]struct {
+		request models.AdminAPIKeyRequest
+		valid   bool
+	}{
+		"merchant key":        {models.AdminAPIKeyRequest{MerchantID: 2, Name: "Brand", Scopes: []string{ScopeDepositCreate}}, true},
+		"operator key":        {models.AdminAPIKeyRequest{Name: "Gateway 1", Scopes: []string{ScopeTransactionsSettle}}, true},
+		"admin for merchant":  {models.AdminAPIKeyRequest{MerchantID: 2, Name: "Brand", Scopes: []string{ScopeAdmin}}, false},
+		"merchant settling":   {models.AdminAPIKeyRequest{MerchantID: 2, Name: "Brand", Scopes: []string{ScopeDepositCreate, ScopeTransactionsSettle}}, false},
+		"operator depositing": {models.AdminAPIKeyRequest{Name: "Ops", Scopes: []string{ScopeDepositCreate}}, false},
+		"operator with roles": {models.AdminAPIKeyRequest{Name: "Finance", Roles: []string{RoleFinance, RoleRisk}}, true},
+		"unknown role":        {models.AdminAPIKeyRequest{Name: "Root", Roles: []string{"root"}}, false},
+		"merchant with roles": {models.AdminAPIKeyRequest{MerchantID: 2, Name: "Brand", Scopes: []string{ScopeDepositCreate}, Roles: []string{RoleViewer}}, false},
+	} {
+		if err := validateAPIKeyRequest(c.request); (err == nil) != c.valid {
+			t.Errorf("%s: expected valid %v, got %v", name, c.valid, err)
+		}
+	}
+}
+
+// captures the encrypted secret the handler stores
+type capturedArg struct{ value string }
+
+func (a *capturedArg) Match(v driver.Value) bool {
+	a.value, _ = v.(string)
+	return true
+}
+
+func TestAdminSetsMerchantWebhook(t *testing.T) {
+	_db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer _db.Close()
+	cipher, _ := services.NewAESCipher(make([]byte, 32))
+	s, err := NewServer(Options{
+		DB:        _db,
+		Store:     memory.NewStore(),
+		Publisher: publisher.NewMemory(),
+		Cipher:    cipher,
+		Config:    Config{AdminTokens: []AdminToken{{Actor: "alice", Token: "admin-token", Roles: []string{RoleAdmin}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := &capturedArg{}
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE merchants SET webhook_url").WithArgs("https://brand.example/codes", secret, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("LOCK TABLE audit_log").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT id, hash FROM audit_log").WillReturnRows(sqlmock.NewRows([]string{"id", "hash"}))
+	mock.ExpectExec("INSERT INTO audit_log").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	set := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/admin/merchants/2/webhook", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer admin-token")
+		s.ServeHTTP(rr, req)
+		return rr
+	}
+	rr := set(`{"webhook_url": "https://brand.example/codes"}`)
+	var response models.APIResponse[models.MerchantWebhookResponse]
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if rr.Code != http.StatusOK || response.Data.MerchantID != 2 || response.Data.WebhookSecret == "" {
+		t.Fatalf("Expected the webhook to be set, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if stored, err := cipher.Decrypt(secret.value); err != nil || stored != response.Data.WebhookSecret {
+		t.Errorf("Expected the returned secret to be stored encrypted, got %q and error %v", stored, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	if code := responseStatus(t, set(`{"webhook_url": "ftp://brand.example/codes"}`)); code != http.StatusBadRequest {
+		t.Errorf("Expected a webhook URL that isn't http to be refused, got %d", code)
+	}
+}
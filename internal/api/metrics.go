@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"payment-gateway/internal/tracing"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Remembers the status code of a response for the request metrics. Most handlers only put their status code in
+// the body, so it is taken from there when the HTTP status was left at 200.
+type statusRecorder struct {
+	http.ResponseWriter
+	status    int
+	apiStatus int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) code() int {
+	if r.status != 0 && r.status != http.StatusOK {
+		return r.status
+	}
+	if r.apiStatus != 0 {
+		return r.apiStatus
+	}
+	return http.StatusOK
+}
+
+// records the status code returned in the body of a response
+func noteStatus(w http.ResponseWriter, statusCode int) {
+	if recorder, ok := w.(*statusRecorder); ok {
+		recorder.apiStatus = statusCode
+	}
+}
+
+// Counts, times, traces and logs every request by route template and status code. A traceparent header sent
+// along, by a gateway calling back or a service upstream, makes the request's span part of that trace.
+func (s *Server) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if template, err := mux.CurrentRoute(r).GetPathTemplate(); err == nil {
+			route = template
+		}
+
+		ctx := tracing.Propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := s.tracer.Start(ctx, r.Method+" "+route, trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+			))
+		defer span.End()
+
+		started := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		duration := time.Since(started)
+
+		span.SetAttributes(attribute.Int("http.response.status_code", recorder.code()))
+		if recorder.code() >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.code()))
+		}
+		s.metrics.ObserveRequest(r.Method, route, recorder.code(), duration)
+		s.logger.Info(ctx, "request", "method", r.Method, "route", route, "status_code", recorder.code(),
+			"duration_ms", float64(duration.Microseconds())/1000)
+	})
+}
+
+// Counts a transaction with the gateway's name and the country's code, falling back to the IDs if the lookup fails
+func (s *Server) countTransaction(ctx context.Context, txType, status string, gatewayID, countryID int, currency string) {
+	gateway := strconv.Itoa(gatewayID)
+	if g, err := s.store.Gateways().GetGateway(ctx, gatewayID); err == nil {
+		gateway = g.Name
+	}
+	country := strconv.Itoa(countryID)
+	if c, err := s.store.ReferenceData().GetCountry(ctx, countryID); err == nil {
+		country = c.Code
+	}
+	s.metrics.CountTransaction(strings.ToLower(txType), status, gateway, country, currency)
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/internal/publisher"
+	"payment-gateway/internal/services"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scrape(t *testing.T, s *Server) string {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(rr.Body)
+	return string(body)
+}
+
+func TestMetricsCountRequestsAndTransactions(t *testing.T) {
+	store, _, _ := newRoutingStore(t)
+	pub := publisher.NewMemory()
+	cipher, _ := services.NewAESCipher(make([]byte, 32))
+	s, err := NewServer(Options{
+		Store:     store,
+		Publisher: pub,
+		Cipher:    cipher,
+		Config:    Config{AllowAnonymous: true, Retry: services.RetryPolicy{Attempts: 2, Backoff: time.Millisecond}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deposit := func() {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/deposit", strings.NewReader(`{"amount": 20, "user_id": 1, "currency": "USD"}`))
+		req.Header.Set("Content-Type", "application/json")
+		s.ServeHTTP(rr, req)
+	}
+	deposit()
+	pub.FailWith(errors.New("broker unreachable"))
+	deposit()
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/deposit/1", nil))
+
+	out := scrape(t, s)
+	for _, expected := range []string{
+		`payment_gateway_http_requests_total{code="201",method="POST",route="/deposit"} 1`,
+		`payment_gateway_http_requests_total{code="500",method="POST",route="/deposit"} 1`,
+		`payment_gateway_http_requests_total{code="200",method="GET",route="/deposit/{id}"} 1`,
+		`payment_gateway_transactions_total{country="US",currency="USD",gateway="Gateway`,
+		`status="SENT",type="deposit"} 1`,
+		`payment_gateway_retry_attempts_total{attempt="1",outcome="success"} 1`,
+		`payment_gateway_retry_attempts_total{attempt="2",outcome="failure"} 1`,
+		`payment_gateway_publish_duration_seconds_count{data_format="application/json"} 3`,
+		`payment_gateway_publish_errors_total{data_format="application/json"} 2`,
+		`payment_gateway_circuit_breaker_state{name="KafkaPublisher",state="closed"} 1`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected %s in the metrics", expected)
+		}
+	}
+	if t.Failed() {
+		t.Log(out)
+	}
+}
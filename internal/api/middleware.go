@@ -5,9 +5,23 @@ import (
 	"encoding/json"
 	"encoding/xml"
 	"net/http"
+	"payment-gateway/internal/logging"
 	"time"
 )
 
+// Takes the request ID from the X-Request-ID header or assigns one, and returns it in the response. It is put in
+// the context so it appears in every log line and message of the request.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(logging.RequestIDHeader)
+		if !logging.ValidRequestID(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set(logging.RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
 func BodyParseAndTimeout[T any](deadline time.Duration) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
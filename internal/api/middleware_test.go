@@ -0,0 +1,62 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/db"
+	"payment-gateway/internal/logging"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/publisher"
+	"payment-gateway/internal/services"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDIsPropagated(t *testing.T) {
+	store, _, _ := newRoutingStore(t)
+	pub := publisher.NewMemory()
+	var logs bytes.Buffer
+	cipher, _ := services.NewAESCipher(make([]byte, 32))
+	s, err := NewServer(Options{Store: store, Publisher: pub, Cipher: cipher, Config: Config{AllowAnonymous: true}, Logger: logging.New(&logs, logging.LevelInfo, logging.DefaultPolicy())})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/deposit", strings.NewReader(`{"amount": 20, "user_id": 1, "currency": "USD"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(logging.RequestIDHeader, "client-id-1")
+	s.ServeHTTP(rr, req)
+
+	var response models.APIResponse[db.Transaction]
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Header().Get(logging.RequestIDHeader) != "client-id-1" || response.RequestID != "client-id-1" {
+		t.Errorf("Expected the request ID to be returned, got %q and %+v", rr.Header().Get(logging.RequestIDHeader), response)
+	}
+	if messages := pub.Messages(); len(messages) != 1 || messages[0].Headers[logging.RequestIDHeader] != "client-id-1" {
+		t.Errorf("Expected the request ID on the published message, got %+v", messages)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		if !strings.Contains(line, `"request_id":"client-id-1"`) {
+			t.Errorf("Expected every log line to carry the request ID, got %s", line)
+		}
+	}
+	if !strings.Contains(logs.String(), "Transaction created") || strings.Contains(logs.String(), `"amount":"20"`) {
+		t.Errorf("Expected the creation to be logged with the amount masked, got %s", logs.String())
+	}
+	if !strings.Contains(logs.String(), `"status_code":201`) {
+		t.Errorf("Expected the request to be logged with its status, got %s", logs.String())
+	}
+
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/unknown", nil)
+	req.Header.Set(logging.RequestIDHeader, "bad\tid")
+	s.ServeHTTP(rr, req)
+	if id := rr.Header().Get(logging.RequestIDHeader); id == "" || id == "bad\tid" {
+		t.Errorf("Expected an invalid request ID to be replaced, got %q", id)
+	}
+}
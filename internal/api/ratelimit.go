@@ -0,0 +1,166 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/ratelimit"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The payment API is rate limited per client IP, per API key and, for deposits and withdrawals, per user. Each has
+// its own budget for reads, i.e. GET requests, and writes. Every response carries the X-RateLimit headers of the
+// budget closest to running out, and requests over a budget are refused with 429 and Retry-After. When the limiter
+// fails the request is let through, losing the limits is better than losing the payments.
+
+const (
+	RateLimitLimitHeader     = "X-RateLimit-Limit"
+	RateLimitRemainingHeader = "X-RateLimit-Remaining"
+	RateLimitResetHeader     = "X-RateLimit-Reset"
+)
+
+// Zero limits aren't enforced
+type RateLimits struct {
+	KeyReads   ratelimit.Limit
+	KeyWrites  ratelimit.Limit
+	UserWrites ratelimit.Limit
+	IPReads    ratelimit.Limit
+	IPWrites   ratelimit.Limit
+	// take the client IP from the last X-Forwarded-For entry, the one added by the proxy in front of the service
+	TrustForwardedFor bool
+}
+
+func isRead(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
+}
+
+// the budget of the request out of the read and write one, and its name for the metrics
+func budget(r *http.Request, of string, reads, writes ratelimit.Limit) (string, ratelimit.Limit) {
+	if isRead(r) {
+		return of + "_reads", reads
+	}
+	return of + "_writes", writes
+}
+
+func (s *Server) clientIP(r *http.Request) string {
+	if s.config.RateLimits.TrustForwardedFor {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			entries := strings.Split(forwarded, ",")
+			return strings.TrimSpace(entries[len(entries)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Limits the requests of each client IP. It goes before authentication so floods of bad keys are limited as well.
+func (s *Server) limitByIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, limit := budget(r, "ip", s.config.RateLimits.IPReads, s.config.RateLimits.IPWrites)
+		if s.allow(w, r, name, "ip:"+s.clientIP(r), limit) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// Limits the requests of each API key, requests accepted without one only count against their IP's budget
+func (s *Server) limitByKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFrom(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		name, limit := budget(r, "key", s.config.RateLimits.KeyReads, s.config.RateLimits.KeyWrites)
+		if s.allow(w, r, name, "key:"+principal.ID, limit) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// Limits the deposits and withdrawals made for each user of a merchant. It goes after the body is parsed, which
+// names the user. Withdrawals of batch rows take from the same budget, see allowUserWrite.
+func (s *Server) limitByUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var userID int
+		switch request := r.Context().Value("request").(type) {
+		case models.DepositRequest:
+			userID = request.UserID
+		case models.WithdrawalRequest:
+			userID = request.UserID
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if s.allow(w, r, "user_writes", userBudgetKey(r.Context(), userID), s.config.RateLimits.UserWrites) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func userBudgetKey(ctx context.Context, userID int) string {
+	return fmt.Sprintf("user:%d:%d", merchantID(ctx), userID)
+}
+
+// Takes a token from the user's write budget for a withdrawal made outside a request of its own, like a batch row.
+// Returns the refusal once the budget is used up.
+func (s *Server) allowUserWrite(ctx context.Context, userID int) *requestError {
+	limit := s.config.RateLimits.UserWrites
+	if limit.Unlimited() {
+		return nil
+	}
+	res, err := s.limiter.Allow(ctx, userBudgetKey(ctx, userID), limit)
+	if err != nil {
+		s.logger.Error(ctx, "Unable to check the rate limit", "budget", "user_writes", "error", err)
+		return nil
+	}
+	if res.Allowed {
+		return nil
+	}
+	s.metrics.CountRateLimited("user_writes")
+	return &requestError{StatusCode: http.StatusTooManyRequests, Message: "Too many requests", DetailedMessage: fmt.Sprintf("The user writes budget of %d requests is used up", res.Limit)}
+}
+
+// Takes a token from key's bucket and sets the rate limit headers. Returns false once the request was refused.
+func (s *Server) allow(w http.ResponseWriter, r *http.Request, name, key string, limit ratelimit.Limit) bool {
+	if limit.Unlimited() {
+		return true
+	}
+	res, err := s.limiter.Allow(r.Context(), key, limit)
+	if err != nil {
+		s.logger.Error(r.Context(), "Unable to check the rate limit", "budget", name, "error", err)
+		return true
+	}
+	setRateLimitHeaders(w, res)
+	if res.Allowed {
+		return true
+	}
+
+	s.metrics.CountRateLimited(name)
+	w.Header().Set("Retry-After", strconv.Itoa(wholeSeconds(res.RetryAfter)))
+	w.WriteHeader(http.StatusTooManyRequests)
+	returnError("Too many requests", fmt.Sprintf("The %s budget of %d requests is used up", strings.Replace(name, "_", " ", 1), res.Limit), http.StatusTooManyRequests, w, JSON)
+	return false
+}
+
+// Keeps the headers of the budget with the fewest requests left, that is the one the client runs into first
+func setRateLimitHeaders(w http.ResponseWriter, res ratelimit.Result) {
+	if current, err := strconv.Atoi(w.Header().Get(RateLimitRemainingHeader)); err == nil && current < res.Remaining {
+		return
+	}
+	w.Header().Set(RateLimitLimitHeader, strconv.Itoa(res.Limit))
+	w.Header().Set(RateLimitRemainingHeader, strconv.Itoa(res.Remaining))
+	w.Header().Set(RateLimitResetHeader, strconv.Itoa(wholeSeconds(res.Reset)))
+}
+
+// rounded up, so clients waiting that long find a token
+func wholeSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/db"
+	"payment-gateway/internal/ratelimit"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimitPerKey(t *testing.T) {
+	s, store := newAuthTestServer(t, Config{RateLimits: RateLimits{
+		KeyWrites: ratelimit.Limit{Requests: 2, Period: time.Minute},
+		IPWrites:  ratelimit.Limit{Requests: 100, Period: time.Minute},
+	}})
+	key := createTestKey(t, s, store, false, ScopeDepositCreate)
+	other := createTestKey(t, s, store, false, ScopeDepositCreate)
+
+	deposit := func(token string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := newDepositRequest()
+		req.Header.Set("Authorization", "Bearer "+token)
+		s.ServeHTTP(rr, req)
+		return rr
+	}
+	for remaining := 1; remaining >= 0; remaining-- {
+		rr := deposit(key.Token)
+		if code := responseStatus(t, rr); code != http.StatusCreated {
+			t.Fatalf("Expected the deposit to be created, got %d: %s", code, rr.Body.String())
+		}
+		// the key's budget is tighter than the IP's, so its headers are the ones returned
+		if rr.Header().Get(RateLimitLimitHeader) != "2" || rr.Header().Get(RateLimitRemainingHeader) != strconv.Itoa(remaining) {
+			t.Errorf("Expected %d of 2 requests left, got headers %v", remaining, rr.Header())
+		}
+	}
+
+	rr := deposit(key.Token)
+	if rr.Code != http.StatusTooManyRequests || responseStatus(t, rr) != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429 over the budget, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Retry-After") != "30" || rr.Header().Get(RateLimitResetHeader) != "60" {
+		t.Errorf("Expected a token in 30s and a full bucket in 60s, got headers %v", rr.Header())
+	}
+
+	if code := responseStatus(t, deposit(other.Token)); code != http.StatusCreated {
+		t.Errorf("Expected another key to have its own budget, got %d", code)
+	}
+}
+
+// The user's budget is shared by every key of the merchant
+func TestRateLimitPerUser(t *testing.T) {
+	s, store := newAuthTestServer(t, Config{RateLimits: RateLimits{UserWrites: ratelimit.Limit{Requests: 1, Period: time.Minute}}})
+	first := createTestKey(t, s, store, false, ScopeDepositCreate)
+	second := createTestKey(t, s, store, false, ScopeDepositCreate)
+
+	for _, c := range []struct {
+		token string
+		code  int
+	}{{first.Token, http.StatusCreated}, {second.Token, http.StatusTooManyRequests}} {
+		rr := httptest.NewRecorder()
+		req := newDepositRequest()
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		s.ServeHTTP(rr, req)
+		if code := responseStatus(t, rr); code != c.code {
+			t.Errorf("Expected %d, got %d: %s", c.code, code, rr.Body.String())
+		}
+	}
+}
+
+func TestBatchRowsCountAgainstTheUser(t *testing.T) {
+	s, store := newAuthTestServer(t, Config{RateLimits: RateLimits{UserWrites: ratelimit.Limit{Requests: 1, Period: time.Minute}}})
+	key := createTestKey(t, s, store, false, ScopeDepositCreate)
+	rows, _ := parseWithdrawalBatchJSON(strings.NewReader(`[{"amount": 20, "user_id": 1, "currency": "USD"}, {"amount": 20, "user_id": 1, "currency": "USD"}]`))
+
+	if _, status, message := s.processWithdrawalRow(context.Background(), rows[0], "application/json"); status != db.ROW_SUCCEEDED {
+		t.Fatalf("Expected the first row to be accepted, got %s %q", status, message)
+	}
+	if _, status, message := s.processWithdrawalRow(context.Background(), rows[1], "application/json"); status != db.ROW_REJECTED || !strings.Contains(message, "Too many requests") {
+		t.Errorf("Expected the second row to be over the user's budget, got %s %q", status, message)
+	}
+	rr := httptest.NewRecorder()
+	req := newDepositRequest()
+	req.Header.Set("Authorization", "Bearer "+key.Token)
+	s.ServeHTTP(rr, req)
+	if code := responseStatus(t, rr); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the rows to use up the budget of the user's own requests, got %d", code)
+	}
+}
+
+// Unauthenticated floods run into the IP budget before any key is looked up, and each IP has its own budget
+func TestRateLimitPerIP(t *testing.T) {
+	s, _ := newAuthTestServer(t, Config{RateLimits: RateLimits{
+		IPReads:           ratelimit.Limit{Requests: 1, Period: time.Minute},
+		TrustForwardedFor: true,
+	}})
+
+	get := func(forwardedFor string) int {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/deposit/1", nil)
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		s.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if code := get("203.0.113.9, 10.0.0.1"); code != http.StatusUnauthorized {
+		t.Errorf("Expected the first request to reach authentication, got %d", code)
+	}
+	if code := get("198.51.100.7, 10.0.0.1"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the proxy's entry to identify the client, got %d", code)
+	}
+	if code := get("10.0.0.2"); code != http.StatusUnauthorized {
+		t.Errorf("Expected another client IP to have its own budget, got %d", code)
+	}
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// The operator's staff reach the admin API with a role, each granting a set of permissions. Every admin route
+// requires one permission. Admin tokens are configured with their roles, operator API keys are issued with them and
+// keys with the admin scope have the admin role. Refused requests are recorded in the audit log next to the writes.
+
+const (
+	// reads the reference data, runtime config and merchants
+	RoleViewer = "viewer"
+	// maintains the reference data routing depends on
+	RoleOps = "ops"
+	// looks into merchants' transactions
+	RoleFinance = "finance"
+	// looks into merchants' transactions for fraud
+	RoleRisk = "risk"
+	// may do everything, including onboarding merchants and issuing keys
+	RoleAdmin = "admin"
+)
+
+var Roles = []string{RoleViewer, RoleOps, RoleFinance, RoleRisk, RoleAdmin}
+
+const (
+	PermissionReferenceRead            = "reference:read"
+	PermissionReferenceWrite           = "reference:write"
+	PermissionRuntimeConfigRead        = "runtime-config:read"
+	PermissionMerchantsRead            = "merchants:read"
+	PermissionMerchantsWrite           = "merchants:write"
+	PermissionMerchantTransactionsRead = "merchant-transactions:read"
+	PermissionAPIKeysRead              = "api-keys:read"
+	PermissionAPIKeysWrite             = "api-keys:write"
+	PermissionAuditRead                = "audit:read"
+)
+
+var viewerPermissions = []string{PermissionReferenceRead, PermissionRuntimeConfigRead, PermissionMerchantsRead}
+
+var rolePermissions = map[string][]string{
+	RoleViewer:  viewerPermissions,
+	RoleOps:     append([]string{PermissionReferenceWrite}, viewerPermissions...),
+	RoleFinance: append([]string{PermissionMerchantTransactionsRead, PermissionAuditRead}, viewerPermissions...),
+	RoleRisk:    append([]string{PermissionMerchantTransactionsRead, PermissionAuditRead}, viewerPermissions...),
+	RoleAdmin: append([]string{PermissionReferenceWrite, PermissionMerchantsWrite, PermissionMerchantTransactionsRead,
+		PermissionAPIKeysRead, PermissionAPIKeysWrite, PermissionAuditRead}, viewerPermissions...),
+}
+
+// true when one of roles grants permission
+func rolesPermit(roles []string, permission string) bool {
+	for _, role := range roles {
+		if containsString(rolePermissions[role], permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the roles of an operator key. Keys with the admin scope predate roles and have the admin role.
+func principalRoles(principal Principal) []string {
+	roles := append([]string(nil), principal.Roles...)
+	if containsString(principal.Scopes, ScopeAdmin) && !containsString(roles, RoleAdmin) {
+		roles = append(roles, RoleAdmin)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// only operator keys with a role are let into the admin API
+func operatorRefusal(principal Principal) string {
+	switch {
+	case principal.MerchantID != 0:
+		return "The API key belongs to a merchant"
+	case len(principalRoles(principal)) == 0:
+		return "The API key has no role"
+	}
+	return ""
+}
+
+// returns an error naming the first role that isn't one of Roles
+func validateRoles(roles []string) error {
+	for _, role := range roles {
+		if !containsString(Roles, role) {
+			return fmt.Errorf("%q is not one of %s", role, strings.Join(Roles, ", "))
+		}
+	}
+	return nil
+}
+
+// puts who makes an admin request and in which roles into the context
+func withAdmin(ctx context.Context, actor string, roles []string) context.Context {
+	ctx = context.WithValue(ctx, "adminActor", actor)
+	return context.WithValue(ctx, "adminRoles", roles)
+}
+
+func adminRoles(ctx context.Context) []string {
+	roles, _ := ctx.Value("adminRoles").([]string)
+	return roles
+}
+
+// Refuses admin requests none of whose roles grants permission, and records the refusal in the audit log
+func (s *Server) requirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if rolesPermit(adminRoles(r.Context()), permission) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			s.auditRefusal(r, permission)
+			w.WriteHeader(http.StatusForbidden)
+			returnError("Forbidden", fmt.Sprintf("None of the roles %s grants %s", strings.Join(adminRoles(r.Context()), ", "), permission), http.StatusForbidden, w, JSON)
+		})
+	}
+}
+
+func (s *Server) auditRefusal(r *http.Request, permission string) {
+	ctx, cancel := NewHandlerContext(r.Context(), s.config.RequestTimeout)
+	defer cancel()
+	ctx = withAdmin(ctx, adminActor(r.Context()), adminRoles(r.Context()))
+	err := appendAuditEntry(ctx, s.store, "deny", "permission", permission, nil, map[string]string{"method": r.Method, "path": r.URL.Path})
+	if err != nil {
+		s.logger.Error(ctx, "Unable to record refused admin request", "error", err)
+	}
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/db"
+	"payment-gateway/db/memory"
+	"payment-gateway/internal/publisher"
+	"payment-gateway/internal/services"
+	"strings"
+	"testing"
+)
+
+func TestRolesPermit(t *testing.T) {
+	for _, c := range []struct {
+		roles      []string
+		permission string
+		permitted  bool
+	}{
+		{[]string{RoleViewer}, PermissionReferenceRead, true},
+		{[]string{RoleViewer}, PermissionReferenceWrite, false},
+		{[]string{RoleViewer}, PermissionMerchantTransactionsRead, false},
+		{[]string{RoleViewer, RoleFinance}, PermissionMerchantTransactionsRead, true},
+		{[]string{RoleOps}, PermissionReferenceWrite, true},
+		{[]string{RoleOps}, PermissionAPIKeysRead, false},
+		{[]string{RoleRisk}, PermissionMerchantsWrite, false},
+		{[]string{RoleAdmin}, PermissionAPIKeysWrite, true},
+		{nil, PermissionReferenceRead, false},
+	} {
+		if rolesPermit(c.roles, c.permission) != c.permitted {
+			t.Errorf("%v %s: expected permitted %v", c.roles, c.permission, c.permitted)
+		}
+	}
+}
+
+func TestPrincipalRoles(t *testing.T) {
+	legacy := principalRoles(Principal{Scopes: []string{ScopeAdmin}})
+	if len(legacy) != 1 || legacy[0] != RoleAdmin {
+		t.Errorf("Expected a key with the admin scope to have the admin role, got %v", legacy)
+	}
+	if roles := principalRoles(Principal{Scopes: []string{ScopeTransactionsSettle}}); len(roles) != 0 {
+		t.Errorf("Expected a gateway key without roles, got %v", roles)
+	}
+}
+
+// The admin routes only let the roles through that grant their permission, whether the role comes from an admin
+// token or an operator key.
+func TestAdminRoutesRequirePermission(t *testing.T) {
+	s, store := newAuthTestServer(t, Config{AdminTokens: []AdminToken{
+		{Actor: "val", Token: "viewer-token", Roles: []string{RoleViewer}},
+		{Actor: "fran", Token: "finance-token", Roles: []string{RoleFinance}},
+		{Actor: "otto", Token: "ops-token", Roles: []string{RoleOps}},
+	}})
+	issue := func(merchantID int, roles []string, scopes ...string) string {
+		issued, err := s.issueAPIKey(merchantID, "test", scopes, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		issued.Key.Roles = roles
+		if err := store.APIKeys().CreateAPIKey(context.Background(), &issued.Key); err != nil {
+			t.Fatal(err)
+		}
+		return issued.Token
+	}
+	riskKey := issue(0, []string{RoleRisk})
+	adminKey := issue(0, nil, ScopeAdmin)
+	gatewayKey := issue(0, nil, ScopeTransactionsSettle)
+	merchantKey := issue(db.DefaultMerchantID, nil, ScopeTransactionsRead)
+
+	for name, c := range map[string]struct {
+		token, method, path string
+		code                int
+	}{
+		"viewer reads config":            {"viewer-token", http.MethodGet, "/admin/runtime-config", http.StatusOK},
+		"viewer reads transactions":      {"viewer-token", http.MethodGet, "/admin/merchants/1/transactions", http.StatusForbidden},
+		"finance reads transactions":     {"finance-token", http.MethodGet, "/admin/merchants/1/transactions", http.StatusOK},
+		"finance creates merchant":       {"finance-token", http.MethodPost, "/admin/merchants", http.StatusForbidden},
+		"viewer creates gateway":         {"viewer-token", http.MethodPost, "/admin/gateways", http.StatusForbidden},
+		"ops creates gateway":            {"ops-token", http.MethodPost, "/admin/gateways", http.StatusBadRequest},
+		"ops lists keys":                 {"ops-token", http.MethodGet, "/admin/api-keys", http.StatusForbidden},
+		"risk key reads transactions":    {riskKey, http.MethodGet, "/admin/merchants/1/transactions", http.StatusOK},
+		"admin scope reads transactions": {adminKey, http.MethodGet, "/admin/merchants/1/transactions", http.StatusOK},
+		"gateway key reads config":       {gatewayKey, http.MethodGet, "/admin/runtime-config", http.StatusForbidden},
+		"merchant key reads config":      {merchantKey, http.MethodGet, "/admin/runtime-config", http.StatusForbidden},
+		"unknown token reads config":     {"nobody", http.MethodGet, "/admin/runtime-config", http.StatusUnauthorized},
+		"finance reads config":           {"finance-token", http.MethodGet, "/admin/runtime-config", http.StatusOK},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(c.method, c.path, strings.NewReader("{}"))
+			req.Header.Set("Content-Type", "text/plain")
+			req.Header.Set("Authorization", "Bearer "+c.token)
+			s.ServeHTTP(rr, req)
+			if code := responseStatus(t, rr); code != c.code {
+				t.Errorf("Expected %d, got %d: %s", c.code, code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestRefusedAdminRequestsAreAudited(t *testing.T) {
+	store := memory.NewStore()
+	cipher, _ := services.NewAESCipher(make([]byte, 32))
+	s, err := NewServer(Options{
+		Store:     store,
+		Publisher: publisher.NewMemory(),
+		Cipher:    cipher,
+		Config:    Config{AdminTokens: []AdminToken{{Actor: "val", Token: "viewer-token", Roles: []string{RoleViewer}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/admin/merchants", strings.NewReader(`{"name": "Brand"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer viewer-token")
+	s.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	entries, err := store.AuditLog().GetAuditEntries(context.Background(), db.AuditFilter{Action: "deny"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Actor != "val" || entries[0].EntityID != PermissionMerchantsWrite || len(entries[0].Roles) != 1 ||
+		string(entries[0].After) != `{"method":"POST","path":"/admin/merchants"}` || entries[0].RequestID == "" {
+		t.Errorf("Expected the refusal to be recorded, got %+v", entries)
+	}
+}
@@ -0,0 +1,261 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"payment-gateway/db"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/services"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+)
+
+// Refunds and chargebacks give back part or all of a successful deposit and stay linked to it. Refunds are
+// requested by the merchant and published to the deposit's gateway like any transaction, chargebacks are reported
+// by the gateway when the user disputes the deposit and aren't published. Both start SENT and are settled through
+// their own callback: a refund once the gateway paid it out, a chargeback once the dispute is decided, success
+// meaning the money went back to the user. Together those not failed never add up to more than the deposit, the
+// deposit is locked while a new one is checked against it.
+
+// Gives back part or all of a successful deposit through the gateway that took it
+func (s *Server) RefundPostHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	contentType := ctx.Value("contentType").(ContentType)
+	request := ctx.Value("request").(models.RefundRequest)
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		returnError("ID not a number", "", http.StatusBadRequest, w, contentType)
+		return
+	}
+	deposit, reqErr := s.reversibleDeposit(ctx, id, request.Amount)
+	if reqErr != nil {
+		returnError(reqErr.Message, reqErr.DetailedMessage, reqErr.StatusCode, w, contentType)
+		return
+	}
+
+	// deposits made before transactions recorded their currency are refunded in the one the request names
+	currency := deposit.Currency
+	switch {
+	case currency == "" && request.Currency == "":
+		returnError("Currency required", "The deposit doesn't record its currency", http.StatusBadRequest, w, contentType)
+		return
+	case currency == "":
+		currency = request.Currency
+	case request.Currency != "" && !strings.EqualFold(request.Currency, currency):
+		returnError("Invalid currency", "The deposit was made in "+currency, http.StatusBadRequest, w, contentType)
+		return
+	}
+
+	gateway, err := s.store.Gateways().GetGateway(ctx, deposit.GatewayID)
+	if err != nil {
+		returnError("unable to get gateway", err.Error(), http.StatusInternalServerError, w, contentType)
+		return
+	}
+	// the refund goes out in the format the deposit did. pain.001 only carries payouts, a deposit published before
+	// formats were recorded or as ISO 20022 is refunded in another format of the gateway.
+	dataFormat, ok := deposit.DataFormat, true
+	if dataFormat == "" || dataFormat == iso20022Format {
+		dataFormat, ok = outboundFormat(gateway, "", db.REFUND)
+	}
+	if !ok {
+		returnError("Refund not supported", "The deposit's gateway only takes ISO 20022, which carries withdrawals", http.StatusBadRequest, w, contentType)
+		return
+	}
+
+	txReq := models.TransactionRequest{
+		Type:                  "refund",
+		Amount:                request.Amount,
+		UserID:                deposit.UserID,
+		CountryID:             deposit.CountryID,
+		Currency:              currency,
+		GatewayID:             deposit.GatewayID,
+		OriginalTransactionID: deposit.ID,
+	}
+
+	// a refund over what is left of the deposit isn't retried
+	var refused *requestError
+	if err := s.retry(func() error {
+		err := s.createRefund(ctx, deposit, &txReq, dataFormat)
+		if errors.As(err, &refused) {
+			return nil
+		}
+		return err
+	}); err != nil {
+		s.logger.Error(ctx, "Unable to create transaction", "type", txReq.Type, "original_id", deposit.ID, "gateway_id", gateway.ID, "error", err)
+		returnError("unable to create transaction", err.Error(), http.StatusInternalServerError, w, contentType)
+		return
+	}
+	if refused != nil {
+		returnError(refused.Message, refused.DetailedMessage, refused.StatusCode, w, contentType)
+		return
+	}
+	s.logTransactionCreated(ctx, &txReq)
+	s.countTransaction(ctx, txReq.Type, string(db.SENT), gateway.ID, deposit.CountryID, currency)
+
+	returnTransaction(ctx, http.StatusCreated, w, contentType, s.store, strconv.Itoa(txReq.TransactionID), db.REFUND)
+}
+
+// Records the refund and publishes it in dataFormat, rolled back when publishing fails. Returns a
+// *requestError when the deposit has less than the refund left.
+func (s *Server) createRefund(ctx context.Context, deposit db.Transaction, txReq *models.TransactionRequest, dataFormat string) error {
+	return s.store.InTx(ctx, func(tx db.Store) error {
+		if err := checkReversal(ctx, tx, deposit, txReq.Amount); err != nil {
+			return err
+		}
+
+		transaction := db.Transaction{
+			MerchantID: deposit.MerchantID,
+			Amount:     txReq.Amount,
+			Type:       db.REFUND,
+			UserID:     txReq.UserID,
+			CountryID:  txReq.CountryID,
+			Currency:   txReq.Currency,
+			OriginalID: deposit.ID,
+			Status:     db.SENT,
+			GatewayID:  txReq.GatewayID,
+			DataFormat: dataFormat,
+			CreatedBy:  createdBy(ctx),
+		}
+		if err := tx.Transactions().CreateTransaction(ctx, &transaction); err != nil {
+			return err
+		}
+		txReq.TransactionID = transaction.ID
+
+		if err := s.publishTransaction(ctx, txReq, dataFormat); err != nil {
+			return err
+		}
+		return auditTransactionCreated(ctx, tx, transaction)
+	})
+}
+
+// Takes a gateway's report that the user disputed a deposit and opens a chargeback for the disputed amount
+func (s *Server) ChargebackPostHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	contentType := ctx.Value("contentType").(ContentType)
+	request := ctx.Value("request").(models.ChargebackRequest)
+
+	deposit, reqErr := s.reversibleDeposit(ctx, request.TransactionID, request.Amount)
+	if reqErr != nil {
+		returnError(reqErr.Message, reqErr.DetailedMessage, reqErr.StatusCode, w, contentType)
+		return
+	}
+
+	transaction := db.Transaction{
+		MerchantID: deposit.MerchantID,
+		Amount:     request.Amount,
+		Type:       db.CHARGEBACK,
+		UserID:     deposit.UserID,
+		CountryID:  deposit.CountryID,
+		Currency:   deposit.Currency,
+		OriginalID: deposit.ID,
+		Status:     db.SENT,
+		GatewayID:  deposit.GatewayID,
+		CreatedBy:  createdBy(ctx),
+	}
+
+	var refused *requestError
+	if err := s.retry(func() error {
+		transaction.ID = 0
+		err := s.store.InTx(ctx, func(tx db.Store) error {
+			if err := checkReversal(ctx, tx, deposit, transaction.Amount); err != nil {
+				return err
+			}
+			if err := tx.Transactions().CreateTransaction(ctx, &transaction); err != nil {
+				return err
+			}
+			created := createdTransaction(transaction)
+			created["reason"] = request.Reason
+			return appendAuditEntry(ctx, tx, "create", "transaction", strconv.Itoa(transaction.ID), nil, created)
+		})
+		if errors.As(err, &refused) {
+			return nil
+		}
+		return err
+	}); err != nil {
+		s.logger.Error(ctx, "Unable to create transaction", "type", transaction.Type, "original_id", deposit.ID, "gateway_id", deposit.GatewayID, "error", err)
+		returnError("unable to create transaction", err.Error(), http.StatusInternalServerError, w, contentType)
+		return
+	}
+	if refused != nil {
+		returnError(refused.Message, refused.DetailedMessage, refused.StatusCode, w, contentType)
+		return
+	}
+	s.logger.Info(ctx, "Chargeback opened", "transaction_id", transaction.ID, "original_id", deposit.ID, "amount", transaction.Amount,
+		"gateway_id", deposit.GatewayID, "reason", request.Reason)
+	s.countTransaction(ctx, string(db.CHARGEBACK), string(db.SENT), deposit.GatewayID, deposit.CountryID, deposit.Currency)
+
+	returnTransaction(ctx, http.StatusCreated, w, contentType, s.store, strconv.Itoa(transaction.ID), db.CHARGEBACK)
+}
+
+// Looks up the deposit a refund or chargeback of amount is for and checks it can be given back. checkReversal
+// checks again once the deposit is locked.
+func (s *Server) reversibleDeposit(ctx context.Context, depositID int, amount decimal.Decimal) (db.Transaction, *requestError) {
+	deposit, err := s.store.Transactions().GetTransaction(ctx, merchantID(ctx), depositID, db.DEPOSIT)
+	if errors.Is(err, db.ErrNotFound) {
+		return db.Transaction{}, &requestError{StatusCode: http.StatusNotFound, Message: "Transaction not found", DetailedMessage: err.Error()}
+	}
+	if err != nil {
+		return db.Transaction{}, &requestError{StatusCode: http.StatusInternalServerError, Message: "unable to get transaction", DetailedMessage: err.Error()}
+	}
+	if deposit.Status != db.SUCCESS {
+		return db.Transaction{}, &requestError{StatusCode: http.StatusBadRequest, Message: "Deposit not settled", DetailedMessage: "Only successful deposits can be given back"}
+	}
+
+	//Validate the amount requested is no more than 2 decimal places and non negative (or less an 0.01)
+	if !services.CurrencyAmountIsValid(amount) {
+		return db.Transaction{}, &requestError{StatusCode: http.StatusBadRequest, Message: "Invalid amount", DetailedMessage: "Amount must be not be more than 2 decimal places"}
+	}
+	if amount.GreaterThan(deposit.Amount) {
+		return db.Transaction{}, &requestError{StatusCode: http.StatusBadRequest, Message: "Amount exceeds the deposit", DetailedMessage: fmt.Sprintf("The deposit was %s", deposit.Amount)}
+	}
+	return deposit, nil
+}
+
+// Checks through store, the store of the DB transaction recording it, that the deposit is still successful and
+// giving back amount keeps its refunds and chargebacks within its amount. The deposit is read again once
+// GetReversedAmount locked it, deposit is what the handler looked up before.
+func checkReversal(ctx context.Context, store db.Store, deposit db.Transaction, amount decimal.Decimal) error {
+	reversed, err := store.Transactions().GetReversedAmount(ctx, deposit.ID, db.REFUND, db.CHARGEBACK)
+	if err != nil {
+		return err
+	}
+	current, err := store.Transactions().GetTransaction(ctx, db.AllMerchants, deposit.ID, db.DEPOSIT)
+	if err != nil {
+		return err
+	}
+	if current.Status != db.SUCCESS {
+		return &requestError{StatusCode: http.StatusBadRequest, Message: "Deposit not settled", DetailedMessage: "Only successful deposits can be given back"}
+	}
+	if left := current.Amount.Sub(reversed); amount.GreaterThan(left) {
+		return &requestError{StatusCode: http.StatusBadRequest, Message: "Amount exceeds the deposit", DetailedMessage: fmt.Sprintf("%s of the deposit is left to give back", left)}
+	}
+	return nil
+}
+
+func (s *Server) RefundPutHandler(w http.ResponseWriter, r *http.Request) {
+	request := r.Context().Value("request").(models.RefundPutRequest)
+	s.settleHandler(w, r, db.REFUND, request.TransactionID, request.Status)
+}
+
+func (s *Server) ChargebackPutHandler(w http.ResponseWriter, r *http.Request) {
+	request := r.Context().Value("request").(models.ChargebackPutRequest)
+	s.settleHandler(w, r, db.CHARGEBACK, request.TransactionID, request.Status)
+}
+
+func (s *Server) RefundGetHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := NewHandlerContext(r.Context(), s.config.RequestTimeout)
+	defer cancel()
+	returnTransaction(ctx, http.StatusOK, w, responseContentType(r), s.store, mux.Vars(r)["id"], db.REFUND)
+}
+
+func (s *Server) ChargebackGetHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := NewHandlerContext(r.Context(), s.config.RequestTimeout)
+	defer cancel()
+	returnTransaction(ctx, http.StatusOK, w, responseContentType(r), s.store, mux.Vars(r)["id"], db.CHARGEBACK)
+}
@@ -0,0 +1,230 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"payment-gateway/db"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/services"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// creates a deposit of 20 USD through the API and settles it
+func (c *confirmationTest) settledDeposit(t *testing.T) int {
+	t.Helper()
+	id := c.deposit(t)
+	if code := responseStatus(t, c.settle(t, "/deposit", id, "success")); code != http.StatusOK {
+		t.Fatalf("Expected the deposit to be settled, got %d", code)
+	}
+	return id
+}
+
+func (c *confirmationTest) refund(t *testing.T, depositID int, amount string) (int, int) {
+	t.Helper()
+	rr := c.post(t, "/deposit/"+strconv.Itoa(depositID)+"/refund", `{"amount": `+amount+`}`)
+	var response models.APIResponse[db.Transaction]
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("unable to decode %s: %v", rr.Body.String(), err)
+	}
+	return response.StatusCode, response.Data.ID
+}
+
+func TestPartialRefundsUpToTheDeposit(t *testing.T) {
+	c := newConfirmationTest(t)
+	deposit := c.settledDeposit(t)
+
+	code, first := c.refund(t, deposit, "12.50")
+	if code != http.StatusCreated {
+		t.Fatalf("Expected the refund to be created, got %d", code)
+	}
+	refund, err := c.store.GetTransaction(context.Background(), db.DefaultMerchantID, first, db.REFUND)
+	if err != nil || refund.OriginalID != deposit || refund.Status != db.SENT || refund.Currency != "USD" || refund.GatewayID != 1 || refund.UserID != 1 || refund.DataFormat != "application/json" {
+		t.Errorf("Expected a SENT refund of the deposit through its gateway, got %+v (%v)", refund, err)
+	}
+
+	messages := c.pub.Messages()
+	if len(messages) != 2 || messages[1].Key != strconv.Itoa(first) || messages[1].Topic != "transactions.json" {
+		t.Fatalf("Expected the refund to be published to the deposit's gateway, got %+v", messages)
+	}
+	var published models.TransactionRequestEncrypted
+	if err := json.Unmarshal(messages[1].Value, &published); err != nil {
+		t.Fatal(err)
+	}
+	cipher, _ := services.NewAESCipher(make([]byte, 32))
+	if typ, _ := cipher.Decrypt(published.Type); typ != "refund" || published.OriginalTransactionID != deposit {
+		t.Errorf("Expected a refund of deposit %d, got %s of %d", deposit, typ, published.OriginalTransactionID)
+	}
+
+	rr := c.post(t, "/deposit/"+strconv.Itoa(deposit)+"/refund", `{"amount": 7.51}`)
+	if code := responseStatus(t, rr); code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "7.5 of the deposit is left") {
+		t.Errorf("Expected the refunds to stay within the deposit, got %d: %s", code, rr.Body.String())
+	}
+	if code, _ := c.refund(t, deposit, "7.50"); code != http.StatusCreated {
+		t.Errorf("Expected the rest of the deposit to be refunded, got %d", code)
+	}
+	if code, _ := c.refund(t, deposit, "0.01"); code != http.StatusBadRequest {
+		t.Errorf("Expected nothing left to refund, got %d", code)
+	}
+
+	// a refund the gateway couldn't pay out no longer counts
+	if code := responseStatus(t, c.settle(t, "/refund", first, "failed")); code != http.StatusOK {
+		t.Fatalf("Expected the refund to be settled, got %d", code)
+	}
+	if code, _ := c.refund(t, deposit, "12.50"); code != http.StatusCreated {
+		t.Errorf("Expected the failed refund to be given back again, got %d", code)
+	}
+	if code := responseStatus(t, c.settle(t, "/refund", first, "success")); code != http.StatusBadRequest {
+		t.Errorf("Expected the failed refund to stay failed, got %d", code)
+	}
+
+	entries, _ := c.store.AuditLog().GetAuditEntries(context.Background(), db.AuditFilter{Action: "create", EntityID: strconv.Itoa(first)})
+	if len(entries) != 1 || !strings.Contains(string(entries[0].After), `"original_id":`+strconv.Itoa(deposit)) {
+		t.Errorf("Expected the refund to be audited with its deposit, got %+v", entries)
+	}
+}
+
+func TestOnlySettledDepositsAreRefunded(t *testing.T) {
+	c := newConfirmationTest(t)
+	pending := c.deposit(t)
+
+	if code, _ := c.refund(t, pending, "5"); code != http.StatusBadRequest {
+		t.Errorf("Expected a pending deposit not to be refunded, got %d", code)
+	}
+	if code, _ := c.refund(t, 999, "5"); code != http.StatusNotFound {
+		t.Errorf("Expected a missing deposit not to be found, got %d", code)
+	}
+
+	deposit := c.settledDeposit(t)
+	for body, want := range map[string]int{
+		`{"amount": 20.01}`:                http.StatusBadRequest,
+		`{"amount": 0}`:                    http.StatusBadRequest,
+		`{"amount": 1.001}`:                http.StatusBadRequest,
+		`{"amount": 5, "currency": "EUR"}`: http.StatusBadRequest,
+		`{"amount": 5, "currency": "usd"}`: http.StatusCreated,
+	} {
+		if code := responseStatus(t, c.post(t, "/deposit/"+strconv.Itoa(deposit)+"/refund", body)); code != want {
+			t.Errorf("%s: expected %d, got %d", body, want, code)
+		}
+	}
+}
+
+func TestReversalRechecksTheLockedDeposit(t *testing.T) {
+	c := newConfirmationTest(t)
+	ctx := context.Background()
+	id := c.settledDeposit(t)
+	deposit, _ := c.store.GetTransaction(ctx, db.DefaultMerchantID, id, db.DEPOSIT)
+
+	// the deposit was looked up as successful, then changed before the reversal locked it
+	if err := c.store.UpdateTransactionStatus(ctx, db.AllMerchants, id, db.DEPOSIT, db.SUCCESS, db.FAILED); err != nil {
+		t.Fatal(err)
+	}
+	var refused *requestError
+	err := c.store.InTx(ctx, func(tx db.Store) error {
+		return checkReversal(ctx, tx, deposit, decimal.NewFromInt(5))
+	})
+	if !errors.As(err, &refused) || refused.Message != "Deposit not settled" {
+		t.Errorf("Expected the reversal to be refused, got %v", err)
+	}
+}
+
+func TestFailedPublishRecordsNoRefund(t *testing.T) {
+	c := newConfirmationTest(t)
+	deposit := c.settledDeposit(t)
+
+	c.pub.FailWith(errors.New("broker down"))
+	if code, _ := c.refund(t, deposit, "20"); code != http.StatusInternalServerError {
+		t.Errorf("Expected the refund to fail, got %d", code)
+	}
+	if transactions, _ := c.store.GetTransactions(context.Background(), db.AllMerchants); len(transactions) != 1 {
+		t.Errorf("Expected only the deposit to be recorded, got %+v", transactions)
+	}
+
+	c.pub.FailWith(nil)
+	if code, _ := c.refund(t, deposit, "20"); code != http.StatusCreated {
+		t.Errorf("Expected the whole deposit to be refunded once publishing works, got %d", code)
+	}
+}
+
+func TestRefundsAvoidISO20022(t *testing.T) {
+	c := newConfirmationTest(t)
+	ctx := context.Background()
+	bank := db.Gateway{Name: "Bank", DataFormatSupported: iso20022Format}
+	both := db.Gateway{Name: "Bank and XML", DataFormatSupported: iso20022Format, DataFormats: []string{"application/xml"}}
+	c.store.CreateGateway(ctx, &bank)
+	c.store.CreateGateway(ctx, &both)
+
+	// deposits made before they were kept away from ISO 20022 gateways
+	deposits := map[int]int{}
+	for _, gateway := range []db.Gateway{bank, both} {
+		deposit := db.Transaction{MerchantID: db.DefaultMerchantID, Amount: decimal.NewFromInt(20), Type: db.DEPOSIT, Status: db.SUCCESS,
+			UserID: 1, GatewayID: gateway.ID, CountryID: 1, Currency: "USD", DataFormat: iso20022Format}
+		c.store.CreateTransaction(ctx, &deposit)
+		deposits[gateway.ID] = deposit.ID
+	}
+
+	rr := c.post(t, "/deposit/"+strconv.Itoa(deposits[bank.ID])+"/refund", `{"amount": 5}`)
+	if code := responseStatus(t, rr); code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "Refund not supported") {
+		t.Errorf("Expected the refund to be refused up front, got %d: %s", code, rr.Body.String())
+	}
+	if messages := c.pub.Messages(); len(messages) != 0 {
+		t.Errorf("Expected nothing published, got %+v", messages)
+	}
+
+	if code, _ := c.refund(t, deposits[both.ID], "5"); code != http.StatusCreated {
+		t.Fatalf("Expected the refund to go out in the gateway's other format, got %d", code)
+	}
+	if messages := c.pub.Messages(); len(messages) != 1 || messages[0].DataFormat != "application/xml" {
+		t.Errorf("Expected the refund published as XML, got %+v", messages)
+	}
+}
+
+func TestChargebackDisputesADeposit(t *testing.T) {
+	c := newConfirmationTest(t)
+	deposit := c.settledDeposit(t)
+	if code, _ := c.refund(t, deposit, "5"); code != http.StatusCreated {
+		t.Fatalf("Expected the refund to be created, got %d", code)
+	}
+	published := len(c.pub.Messages())
+
+	rr := c.post(t, "/chargeback", `{"transaction_id": `+strconv.Itoa(deposit)+`, "amount": 15.01, "reason": "fraud"}`)
+	if code := responseStatus(t, rr); code != http.StatusBadRequest {
+		t.Errorf("Expected the chargeback to stay within what wasn't refunded, got %d: %s", code, rr.Body.String())
+	}
+
+	rr = c.post(t, "/chargeback", `{"transaction_id": `+strconv.Itoa(deposit)+`, "amount": 15, "reason": "fraud"}`)
+	var response models.APIResponse[db.Transaction]
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || response.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected the chargeback to be opened, got %s", rr.Body.String())
+	}
+	chargeback := response.Data
+	if chargeback.Type != db.CHARGEBACK || chargeback.Status != db.SENT || chargeback.OriginalID != deposit || chargeback.GatewayID != 1 {
+		t.Errorf("Unexpected chargeback %+v", chargeback)
+	}
+	if messages := c.pub.Messages(); len(messages) != published {
+		t.Errorf("Expected nothing published for the gateway's own chargeback, got %+v", messages[published:])
+	}
+	entries, _ := c.store.AuditLog().GetAuditEntries(context.Background(), db.AuditFilter{Action: "create", EntityID: strconv.Itoa(chargeback.ID)})
+	if len(entries) != 1 || !strings.Contains(string(entries[0].After), `"reason":"fraud"`) {
+		t.Errorf("Expected the chargeback to be audited with its reason, got %+v", entries)
+	}
+
+	if code, _ := c.refund(t, deposit, "0.01"); code != http.StatusBadRequest {
+		t.Errorf("Expected the open chargeback to count against refunds, got %d", code)
+	}
+
+	if code := responseStatus(t, c.settle(t, "/chargeback", chargeback.ID, "success")); code != http.StatusOK {
+		t.Errorf("Expected the chargeback to be settled, got %d", code)
+	}
+	if code := responseStatus(t, c.settle(t, "/refund", chargeback.ID, "success")); code != http.StatusNotFound {
+		t.Errorf("Expected the chargeback not to be found as a refund, got %d", code)
+	}
+	if code := responseStatus(t, c.post(t, "/chargeback", `{"transaction_id": 999, "amount": 1}`)); code != http.StatusNotFound {
+		t.Errorf("Expected a chargeback of a missing deposit to be refused, got %d", code)
+	}
+}
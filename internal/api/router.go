@@ -3,21 +3,53 @@ package api
 import (
 	"net/http"
 	"payment-gateway/internal/models"
-	"time"
+	"payment-gateway/internal/services"
 
 	"github.com/gorilla/mux"
 )
 
-func SetupRouter() *mux.Router {
+func (s *Server) routes() *mux.Router {
 	router := mux.NewRouter()
+	router.Use(s.instrument)
 
-	router.Handle("/withdrawal", BodyParseAndTimeout[models.WithdrawalRequest](time.Second*5)(http.HandlerFunc(WithdrawalPostHandler))).Methods(http.MethodPost)
-	router.Handle("/withdrawal", BodyParseAndTimeout[models.WithdrawalPutRequest](time.Second*5)(http.HandlerFunc(WithdrawalPutHandler))).Methods(http.MethodPut)
-	router.Handle("/withdrawal/{id}", http.HandlerFunc(WithdrawalGetHandler)).Methods(http.MethodGet)
+	router.Handle("/metrics", s.metrics.Handler()).Methods(http.MethodGet)
 
-	router.Handle("/deposit", BodyParseAndTimeout[models.DepositRequest](time.Second*5)(http.HandlerFunc(DepositPostHandler))).Methods(http.MethodPost)
-	router.Handle("/deposit", BodyParseAndTimeout[models.DepositPutRequest](time.Second*5)(http.HandlerFunc(DepositPutHandler))).Methods(http.MethodPut)
-	router.Handle("/deposit/{id}", http.HandlerFunc(DepositGetHandler)).Methods(http.MethodGet)
+	router.Handle("/healthz", http.HandlerFunc(s.HealthzHandler)).Methods(http.MethodGet)
+	router.Handle("/readyz", http.HandlerFunc(s.ReadyzHandler)).Methods(http.MethodGet)
+	router.Handle("/status", http.HandlerFunc(s.StatusHandler)).Methods(http.MethodGet)
+
+	// merchants create and read transactions, gateways settle them. Each request counts against the budgets of its
+	// client IP and API key.
+	scoped := func(scope string, handler http.Handler) http.Handler {
+		return s.limitByIP(s.requireScope(scope)(s.limitByKey(handler)))
+	}
+	router.Handle("/withdrawal", scoped(ScopeWithdrawalCreate, BodyParseAndTimeout[models.WithdrawalRequest](s.config.RequestTimeout)(s.limitByUser(http.HandlerFunc(s.WithdrawalPostHandler))))).Methods(http.MethodPost)
+	router.Handle("/withdrawal", scoped(ScopeTransactionsSettle, BodyParseAndTimeout[models.WithdrawalPutRequest](s.config.RequestTimeout)(http.HandlerFunc(s.WithdrawalPutHandler)))).Methods(http.MethodPut)
+	router.Handle("/withdrawal/{id}", scoped(ScopeTransactionsRead, http.HandlerFunc(s.WithdrawalGetHandler))).Methods(http.MethodGet)
+	router.Handle("/withdrawal/{id}/cancel", scoped(ScopeWithdrawalCreate, http.HandlerFunc(s.WithdrawalCancelHandler))).Methods(http.MethodPost)
+	router.Handle("/withdrawal/{id}/confirm", scoped(ScopeWithdrawalCreate, BodyParseAndTimeout[models.WithdrawalConfirmRequest](s.config.RequestTimeout)(http.HandlerFunc(s.WithdrawalConfirmHandler)))).Methods(http.MethodPost)
+	router.Handle("/withdrawal/status-report", scoped(ScopeTransactionsSettle, BodyParseAndTimeout[services.Pain002Document](s.config.RequestTimeout)(http.HandlerFunc(s.WithdrawalStatusReportHandler)))).Methods(http.MethodPost)
+
+	router.Handle("/withdrawals/batch", scoped(ScopeWithdrawalCreate, http.HandlerFunc(s.WithdrawalBatchPostHandler))).Methods(http.MethodPost)
+	router.Handle("/withdrawals/batch/{id}", scoped(ScopeTransactionsRead, http.HandlerFunc(s.WithdrawalBatchGetHandler))).Methods(http.MethodGet)
+	router.Handle("/withdrawals/batch/{id}/result", scoped(ScopeTransactionsRead, http.HandlerFunc(s.WithdrawalBatchResultHandler))).Methods(http.MethodGet)
+
+	router.Handle("/deposit", scoped(ScopeDepositCreate, BodyParseAndTimeout[models.DepositRequest](s.config.RequestTimeout)(s.limitByUser(http.HandlerFunc(s.DepositPostHandler))))).Methods(http.MethodPost)
+	router.Handle("/deposit", scoped(ScopeTransactionsSettle, BodyParseAndTimeout[models.DepositPutRequest](s.config.RequestTimeout)(http.HandlerFunc(s.DepositPutHandler)))).Methods(http.MethodPut)
+	router.Handle("/deposit/{id}/cancel", scoped(ScopeDepositCreate, http.HandlerFunc(s.DepositCancelHandler))).Methods(http.MethodPost)
+	router.Handle("/deposit/{id}", scoped(ScopeTransactionsRead, http.HandlerFunc(s.DepositGetHandler))).Methods(http.MethodGet)
+	router.Handle("/deposit/{id}/refund", scoped(ScopeRefundCreate, BodyParseAndTimeout[models.RefundRequest](s.config.RequestTimeout)(http.HandlerFunc(s.RefundPostHandler)))).Methods(http.MethodPost)
+
+	router.Handle("/refund", scoped(ScopeTransactionsSettle, BodyParseAndTimeout[models.RefundPutRequest](s.config.RequestTimeout)(http.HandlerFunc(s.RefundPutHandler)))).Methods(http.MethodPut)
+	router.Handle("/refund/{id}", scoped(ScopeTransactionsRead, http.HandlerFunc(s.RefundGetHandler))).Methods(http.MethodGet)
+
+	router.Handle("/chargeback", scoped(ScopeTransactionsSettle, BodyParseAndTimeout[models.ChargebackRequest](s.config.RequestTimeout)(http.HandlerFunc(s.ChargebackPostHandler)))).Methods(http.MethodPost)
+	router.Handle("/chargeback", scoped(ScopeTransactionsSettle, BodyParseAndTimeout[models.ChargebackPutRequest](s.config.RequestTimeout)(http.HandlerFunc(s.ChargebackPutHandler)))).Methods(http.MethodPut)
+	router.Handle("/chargeback/{id}", scoped(ScopeTransactionsRead, http.HandlerFunc(s.ChargebackGetHandler))).Methods(http.MethodGet)
+
+	router.Handle("/transactions", scoped(ScopeTransactionsRead, http.HandlerFunc(s.TransactionsGetHandler))).Methods(http.MethodGet)
+
+	s.setupAdminRoutes(router)
 
 	return router
 
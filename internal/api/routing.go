@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"payment-gateway/db"
+	"payment-gateway/internal/config"
+	"strings"
+)
+
+// Picks a random gateway among the enabled ones serving the country and currency that the runtime config allows
+// the request's merchant and that takes transactions of txType in one of its formats
+func (s *Server) pickGateway(ctx context.Context, rt config.Runtime, txType db.TransactionType, countryID int, currency string) (db.Gateway, error) {
+	candidates, err := s.store.Gateways().GetGatewaysFor(ctx, countryID, currency)
+	if err != nil {
+		return db.Gateway{}, err
+	}
+
+	var allowed []string
+	if len(rt.Routing) > 0 {
+		country, err := s.store.ReferenceData().GetCountry(ctx, countryID)
+		if err != nil {
+			return db.Gateway{}, err
+		}
+		allowed = rt.AllowedGateways(merchantID(ctx), country.Code, currency)
+	}
+
+	var eligible []db.Gateway
+	for _, gateway := range candidates {
+		if rt.GatewayDisabled(gateway.Name) || (allowed != nil && !containsString(allowed, gateway.Name)) {
+			continue
+		}
+		if _, ok := outboundFormat(gateway, "", txType); !ok {
+			continue
+		}
+		eligible = append(eligible, gateway)
+	}
+	if len(eligible) == 0 {
+		return db.Gateway{}, fmt.Errorf("no gateway found for country %d and currency %s: %w", countryID, currency, db.ErrNotFound)
+	}
+	return eligible[rand.Intn(len(eligible))], nil
+}
+
+// the format of bank payout gateways, pain.001 only carries withdrawals
+const iso20022Format = "application/iso20022+xml"
+
+// Picks the format a transaction of txType is published to the gateway in, see db.Gateway.OutboundFormat. Only
+// withdrawals go out as ISO 20022, other types take another format of the gateway. false when it has none.
+func outboundFormat(gateway db.Gateway, clientFormat string, txType db.TransactionType) (string, bool) {
+	format := gateway.OutboundFormat(clientFormat)
+	if txType == db.WITHDRAWAL || format != iso20022Format {
+		return format, true
+	}
+	for _, format := range gateway.DataFormats {
+		if format != iso20022Format {
+			return format, true
+		}
+	}
+	return "", false
+}
+
+// Checks that the gateways, countries and merchants a runtime config names exist, so a typo can't silently stop
+// routing
+func (s *Server) CheckRuntime(rt config.Runtime) error {
+	ctx, cancel := NewHandlerContext(context.Background(), s.config.RequestTimeout)
+	defer cancel()
+
+	gateways, err := s.store.Gateways().GetGateways(ctx)
+	if err != nil {
+		return err
+	}
+	countries, err := s.store.ReferenceData().GetCountries(ctx)
+	if err != nil {
+		return err
+	}
+	gatewayNames := map[string]bool{}
+	for _, gateway := range gateways {
+		gatewayNames[gateway.Name] = true
+	}
+	countryCodes := map[string]bool{}
+	for _, country := range countries {
+		countryCodes[country.Code] = true
+	}
+	merchants, err := s.store.Merchants().GetMerchants(ctx)
+	if err != nil {
+		return err
+	}
+	merchantIDs := map[int]bool{}
+	for _, merchant := range merchants {
+		merchantIDs[merchant.ID] = true
+	}
+
+	var problems []string
+	for i, rule := range rt.Routing {
+		if rule.MerchantID != 0 && !merchantIDs[rule.MerchantID] {
+			problems = append(problems, fmt.Sprintf("routing[%d] names unknown merchant %d", i, rule.MerchantID))
+		}
+		if rule.Country != "" && !countryCodes[rule.Country] {
+			problems = append(problems, fmt.Sprintf("routing[%d] names unknown country %s", i, rule.Country))
+		}
+		for _, name := range rule.Gateways {
+			if !gatewayNames[name] {
+				problems = append(problems, fmt.Sprintf("routing[%d] names unknown gateway %q", i, name))
+			}
+		}
+	}
+	for i, limit := range rt.Limits {
+		if limit.MerchantID != 0 && !merchantIDs[limit.MerchantID] {
+			problems = append(problems, fmt.Sprintf("limits[%d] names unknown merchant %d", i, limit.MerchantID))
+		}
+	}
+	for _, name := range rt.DisabledGateways {
+		if !gatewayNames[name] {
+			problems = append(problems, fmt.Sprintf("disabled_gateways names unknown gateway %q", name))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid runtime configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
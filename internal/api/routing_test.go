@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/db"
+	"payment-gateway/db/memory"
+	"payment-gateway/internal/config"
+	"payment-gateway/internal/publisher"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func newRoutingStore(t *testing.T) (*memory.Store, db.Country, []db.Gateway) {
+	t.Helper()
+	ctx := context.Background()
+	store := memory.NewStore()
+	currency := db.Currency{Symbol: "USD"}
+	country := db.Country{Name: "United States", Code: "US"}
+	store.CreateCurrency(ctx, &currency)
+	store.CreateCountry(ctx, &country)
+	store.AddCountryCurrency(ctx, country.ID, currency.ID)
+	gateways := []db.Gateway{
+		{Name: "Gateway 1", DataFormatSupported: "application/json"},
+		{Name: "Gateway 2", DataFormatSupported: "application/json"},
+	}
+	for i := range gateways {
+		store.CreateGateway(ctx, &gateways[i])
+		store.AddGatewayCountry(ctx, gateways[i].ID, country.ID)
+	}
+	user := db.User{MerchantID: db.DefaultMerchantID, Username: "johnsmith", Email: "john.smith@example.com", CountryID: country.ID}
+	store.CreateUser(ctx, &user)
+	return store, country, gateways
+}
+
+func TestPickGatewayFollowsRuntimeConfig(t *testing.T) {
+	store, country, gateways := newRoutingStore(t)
+	s := newTestServer(t, store, publisher.NewMemory())
+	ctx := context.Background()
+
+	routed := config.Runtime{Routing: []config.RoutingRule{{Country: "US", Gateways: []string{"Gateway 2"}}}}
+	disabled := config.Runtime{DisabledGateways: []string{"Gateway 1"}}
+	for name, rt := range map[string]config.Runtime{"routing": routed, "disabled": disabled} {
+		for i := 0; i < 10; i++ {
+			gateway, err := s.pickGateway(ctx, rt, db.DEPOSIT, country.ID, "USD")
+			if err != nil || gateway.ID != gateways[1].ID {
+				t.Fatalf("%s: expected Gateway 2, got %+v (%v)", name, gateway, err)
+			}
+		}
+	}
+
+	none := config.Runtime{DisabledGateways: []string{"Gateway 2"}, Routing: routed.Routing}
+	if _, err := s.pickGateway(ctx, none, db.DEPOSIT, country.ID, "USD"); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("Expected no gateway when the only allowed one is disabled, got %v", err)
+	}
+}
+
+func TestPickGatewayFollowsMerchantRules(t *testing.T) {
+	store, country, gateways := newRoutingStore(t)
+	s := newTestServer(t, store, publisher.NewMemory())
+	ctx := context.Background()
+	merchant := db.Merchant{Name: "Brand"}
+	store.CreateMerchant(ctx, &merchant)
+
+	rt := config.Runtime{Routing: []config.RoutingRule{
+		{Country: "US", Gateways: []string{"Gateway 2"}},
+		{MerchantID: merchant.ID, Country: "US", Gateways: []string{"Gateway 1"}},
+	}}
+	for i := 0; i < 10; i++ {
+		if gateway, err := s.pickGateway(ctx, rt, db.DEPOSIT, country.ID, "USD"); err != nil || gateway.ID != gateways[1].ID {
+			t.Fatalf("Expected the shared rule for the default merchant, got %+v (%v)", gateway, err)
+		}
+		merchantCtx := withPrincipal(ctx, Principal{ID: "key_brand", MerchantID: merchant.ID})
+		if gateway, err := s.pickGateway(merchantCtx, rt, db.DEPOSIT, country.ID, "USD"); err != nil || gateway.ID != gateways[0].ID {
+			t.Fatalf("Expected the merchant's own rule, got %+v (%v)", gateway, err)
+		}
+	}
+}
+
+func TestDepositsAvoidISO20022OnlyGateways(t *testing.T) {
+	store, country, gateways := newRoutingStore(t)
+	ctx := context.Background()
+	bank := db.Gateway{Name: "Bank", DataFormatSupported: iso20022Format}
+	both := db.Gateway{Name: "Bank and JSON", DataFormatSupported: iso20022Format, DataFormats: []string{"application/json"}}
+	for _, gateway := range []*db.Gateway{&bank, &both} {
+		store.CreateGateway(ctx, gateway)
+		store.AddGatewayCountry(ctx, gateway.ID, country.ID)
+	}
+	s := newTestServer(t, store, publisher.NewMemory())
+
+	rt := config.Runtime{DisabledGateways: []string{gateways[0].Name, gateways[1].Name}}
+	for i := 0; i < 10; i++ {
+		gateway, err := s.pickGateway(ctx, rt, db.DEPOSIT, country.ID, "USD")
+		if err != nil || gateway.ID != both.ID {
+			t.Fatalf("Expected the gateway also taking JSON, got %+v (%v)", gateway, err)
+		}
+		if format, _ := outboundFormat(gateway, "application/xml", db.DEPOSIT); format != "application/json" {
+			t.Fatalf("Expected the deposit to go out as JSON, got %s", format)
+		}
+	}
+	if format, _ := outboundFormat(both, "application/xml", db.WITHDRAWAL); format != iso20022Format {
+		t.Errorf("Expected withdrawals in the gateway's own format, got %s", format)
+	}
+
+	rt.DisabledGateways = append(rt.DisabledGateways, both.Name)
+	if _, err := s.pickGateway(ctx, rt, db.DEPOSIT, country.ID, "USD"); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("Expected no gateway for deposits, got %v", err)
+	}
+	if gateway, err := s.pickGateway(ctx, rt, db.WITHDRAWAL, country.ID, "USD"); err != nil || gateway.ID != bank.ID {
+		t.Errorf("Expected withdrawals to reach the bank, got %+v (%v)", gateway, err)
+	}
+}
+
+func TestCheckRuntimeRejectsUnknownNames(t *testing.T) {
+	store, _, _ := newRoutingStore(t)
+	s := newTestServer(t, store, publisher.NewMemory())
+
+	if err := s.CheckRuntime(config.Runtime{Routing: []config.RoutingRule{{Country: "US", Gateways: []string{"Gateway 1"}}}}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	err := s.CheckRuntime(config.Runtime{
+		Routing:          []config.RoutingRule{{Country: "FR", Gateways: []string{"Gateway 3"}}},
+		Limits:           []config.Limit{{MerchantID: 42}},
+		DisabledGateways: []string{"Gateway 4"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "FR") || !strings.Contains(err.Error(), "Gateway 3") || !strings.Contains(err.Error(), "Gateway 4") || !strings.Contains(err.Error(), "merchant 42") {
+		t.Errorf("Expected every unknown name to be reported, got %v", err)
+	}
+}
+
+func TestDepositOutsideLimitsIsRejected(t *testing.T) {
+	store, _, _ := newRoutingStore(t)
+	pub := publisher.NewMemory()
+	s := newTestServer(t, store, pub)
+	s.runtime.Store(config.Runtime{Limits: []config.Limit{{Type: "deposit", Currency: "USD", Max: decimal.NewFromInt(100)}}}, "test")
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/deposit", strings.NewReader(`{"amount": 150, "user_id": 1, "currency": "USD"}`))
+	req.Header.Set("Content-Type", "application/json")
+	s.ServeHTTP(rr, req)
+
+	if !strings.Contains(rr.Body.String(), "Amount outside limits") {
+		t.Errorf("unexpected response %s", rr.Body.String())
+	}
+	if len(pub.Messages()) != 0 {
+		t.Errorf("Expected nothing to be published")
+	}
+}
@@ -0,0 +1,271 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"payment-gateway/db"
+	"payment-gateway/internal/config"
+	"payment-gateway/internal/logging"
+	"payment-gateway/internal/metrics"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/notify"
+	"payment-gateway/internal/publisher"
+	"payment-gateway/internal/ratelimit"
+	"payment-gateway/internal/services"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sony/gobreaker"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Everything the payment API depends on. Nothing is read from the environment or package globals, so the gateway
+// can be mounted inside another binary or a test with whatever implementations suit it.
+type Options struct {
+	// Postgres, needed by the admin API and withdrawal batches. Optional when Store is set, those endpoints then
+	// answer with an error.
+	DB *sql.DB
+	// defaults to a PostgresStore over DB, traced with Tracer
+	Store     db.Store
+	Publisher publisher.Publisher
+	// encrypts the messages published to gateways
+	Cipher services.Cipher
+	// defaults to time.Now
+	Clock func() time.Time
+	// routing, limits and disabled gateways, swapped by a config.Watcher while the server runs. Defaults to none.
+	Runtime *config.Live
+	// the migrations the schema has to be at for readiness, none skips the check
+	Migrations []db.Migration
+	// defaults to a new set of metrics
+	Metrics *metrics.Metrics
+	// defaults to JSON on stderr at info level, masking fields with logging.DefaultPolicy
+	Logger *logging.Logger
+	// where request, database and publish spans go, defaults to recording nothing
+	Tracer trace.TracerProvider
+	// keeps the rate limit budgets, defaults to buckets in memory
+	Limiter ratelimit.Limiter
+	// delivers the codes confirming withdrawals, defaults to writing them to Logger
+	Notifier notify.Notifier
+	Config   Config
+}
+
+type Config struct {
+	// bearer tokens accepted by the admin API next to API keys with the admin scope
+	AdminTokens []AdminToken
+	// accept requests without an API key outside the admin API, they create transactions without a creator
+	AllowAnonymous bool
+	// how far the timestamp of a signed request may be from the server's clock, defaults to 5m
+	SignatureTolerance time.Duration
+	// how long a rotated API key keeps working next to its replacement, none revokes it straight away
+	KeyRotationGrace time.Duration
+	// the account withdrawals paid out through ISO 20022 gateways are debited from
+	Debtor models.Beneficiary
+	// deadline of the regular API requests, defaults to 5s
+	RequestTimeout time.Duration
+	// deadline of withdrawal batch uploads and result downloads, defaults to 30s
+	BatchRequestTimeout time.Duration
+	// attempts at creating and publishing a transaction, defaults to 3 starting with a 1s backoff
+	Retry services.RetryPolicy
+	// the circuit breaker publishing goes through, defaults to one request every 5s while half-open
+	Breaker services.BreakerSettings
+	// budgets of the payment API per API key, user and client IP, none by default
+	RateLimits RateLimits
+	// number of workers processing withdrawal batches, defaults to 4
+	BatchWorkers int
+	// how often idle batch workers look for new jobs, defaults to 5s
+	BatchPollInterval time.Duration
+	// how long a single batch row may take including the retries, defaults to 30s
+	BatchRowTimeout time.Duration
+	// a job processing without progress for this long belongs to an instance that died, defaults to 5m
+	StaleBatchJobAge time.Duration
+	// create single withdrawals in DRAFT until the user confirms them with a one-time code
+	ConfirmWithdrawals bool
+	// how long the code is valid, defaults to 15m
+	ConfirmationTTL time.Duration
+	// wrong codes accepted before the withdrawal expires, defaults to 5
+	ConfirmationAttempts int
+	// how often expired drafts are looked for, defaults to 1m
+	DraftSweepInterval time.Duration
+}
+
+// fills in the defaults of the settings left zero
+func (c Config) withDefaults() Config {
+	if c.RequestTimeout <= 0 {
+		c.RequestTimeout = 5 * time.Second
+	}
+	if c.BatchRequestTimeout <= 0 {
+		c.BatchRequestTimeout = 30 * time.Second
+	}
+	if c.SignatureTolerance <= 0 {
+		c.SignatureTolerance = 5 * time.Minute
+	}
+	if c.Retry.Attempts <= 0 {
+		c.Retry = services.RetryPolicy{Attempts: 3, Backoff: time.Second}
+	}
+	if c.Breaker.MaxRequests == 0 {
+		c.Breaker = services.BreakerSettings{MaxRequests: 1, Interval: 5 * time.Second, Timeout: 3 * time.Second}
+	}
+	if c.BatchWorkers <= 0 {
+		c.BatchWorkers = 4
+	}
+	if c.BatchPollInterval <= 0 {
+		c.BatchPollInterval = 5 * time.Second
+	}
+	if c.BatchRowTimeout <= 0 {
+		c.BatchRowTimeout = 30 * time.Second
+	}
+	if c.StaleBatchJobAge <= 0 {
+		c.StaleBatchJobAge = 5 * time.Minute
+	}
+	if c.ConfirmationTTL <= 0 {
+		c.ConfirmationTTL = 15 * time.Minute
+	}
+	if c.ConfirmationAttempts <= 0 {
+		c.ConfirmationAttempts = 5
+	}
+	if c.DraftSweepInterval <= 0 {
+		c.DraftSweepInterval = time.Minute
+	}
+	return c
+}
+
+type Server struct {
+	db       *sql.DB
+	store    db.Store
+	pub      publisher.Publisher
+	cipher   services.Cipher
+	clock    func() time.Time
+	runtime  *config.Live
+	config   Config
+	metrics  *metrics.Metrics
+	logger   *logging.Logger
+	tracer   trace.Tracer
+	limiter  ratelimit.Limiter
+	notifier notify.Notifier
+	breaker  *gobreaker.CircuitBreaker
+	router   *mux.Router
+	handler  http.Handler
+	batches  *batchProcessor
+
+	migrations []db.Migration
+	health     healthTracker
+	startedAt  time.Time
+	// set once a shutdown starts, readiness fails from then on
+	draining atomic.Bool
+}
+
+func NewServer(opts Options) (*Server, error) {
+	if opts.Tracer == nil {
+		opts.Tracer = noop.NewTracerProvider()
+	}
+	if opts.Store == nil {
+		if opts.DB == nil {
+			return nil, fmt.Errorf("a DB or Store is required")
+		}
+		opts.Store = db.NewPostgresStore(opts.DB).WithTracer(opts.Tracer)
+	}
+	if opts.Publisher == nil {
+		return nil, fmt.Errorf("a Publisher is required")
+	}
+	if opts.Cipher == nil {
+		return nil, fmt.Errorf("a Cipher is required")
+	}
+	if opts.Clock == nil {
+		opts.Clock = time.Now
+	}
+	if opts.Runtime == nil {
+		opts.Runtime = config.NewLive(config.Runtime{}, "default")
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = metrics.New()
+	}
+	if opts.Logger == nil {
+		opts.Logger = logging.New(os.Stderr, logging.LevelInfo, logging.DefaultPolicy())
+	}
+	if opts.Limiter == nil {
+		opts.Limiter = ratelimit.NewMemory(opts.Clock)
+	}
+	if opts.Notifier == nil && opts.Config.ConfirmWithdrawals {
+		return nil, fmt.Errorf("a Notifier is required to confirm withdrawals")
+	}
+	opts.Config = opts.Config.withDefaults()
+
+	s := &Server{
+		db:       opts.DB,
+		store:    opts.Store,
+		pub:      opts.Publisher,
+		cipher:   opts.Cipher,
+		clock:    opts.Clock,
+		runtime:  opts.Runtime,
+		config:   opts.Config,
+		metrics:  opts.Metrics,
+		logger:   opts.Logger,
+		tracer:   opts.Tracer.Tracer("payment-gateway/internal/api"),
+		limiter:  opts.Limiter,
+		notifier: opts.Notifier,
+		batches:  &batchProcessor{wake: make(chan struct{}, 1)},
+
+		migrations: opts.Migrations,
+		startedAt:  opts.Clock(),
+	}
+
+	breaker := opts.Config.Breaker
+	breaker.OnStateChange = s.metrics.SetBreakerState
+	s.breaker = services.NewCircuitBreaker("KafkaPublisher", breaker)
+	s.metrics.SetBreakerState(s.breaker.Name(), s.breaker.State(), s.breaker.State())
+
+	s.router = s.routes()
+	// outside the router so unmatched routes get a request ID too
+	s.handler = RequestID(s.router)
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Starts the withdrawal batch workers and, with confirmations enabled, the sweeper expiring drafts. The returned
+// channel is closed once all of them have stopped after ctx is cancelled. Batches need Postgres, without a DB no
+// batch workers are started. With nothing started the channel is closed straight away.
+func (s *Server) Start(ctx context.Context) <-chan struct{} {
+	var wg sync.WaitGroup
+	if s.db != nil {
+		batches := s.startBatchProcessor(ctx, s.config.BatchWorkers, s.config.BatchPollInterval)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-batches
+		}()
+	}
+	if s.config.ConfirmWithdrawals {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runDraftExpiry(ctx, s.config.DraftSweepInterval)
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// retries operation with the configured policy through the publishing circuit breaker
+func (s *Server) retry(operation func() error) error {
+	policy := s.config.Retry
+	policy.OnAttempt = s.metrics.CountRetryAttempt
+	return services.RetryOperation(operation, policy, s.breaker)
+}
+
+func (s *Server) encodeOptions() services.EncodeOptions {
+	return services.EncodeOptions{Cipher: s.cipher, Debtor: s.config.Debtor, Now: s.clock()}
+}
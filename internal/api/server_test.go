@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/db"
+	"payment-gateway/db/memory"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/publisher"
+	"payment-gateway/internal/services"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T, store db.Store, pub publisher.Publisher) *Server {
+	t.Helper()
+	cipher, err := services.NewAESCipher(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServer(Options{Store: store, Publisher: pub, Cipher: cipher, Config: Config{AllowAnonymous: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestNewServerRequiresDependencies(t *testing.T) {
+	cipher, _ := services.NewAESCipher(make([]byte, 32))
+	for name, opts := range map[string]Options{
+		"store":     {Publisher: publisher.NewMemory(), Cipher: cipher},
+		"publisher": {Store: memory.NewStore(), Cipher: cipher},
+		"cipher":    {Store: memory.NewStore(), Publisher: publisher.NewMemory()},
+		"notifier":  {Store: memory.NewStore(), Publisher: publisher.NewMemory(), Cipher: cipher, Config: Config{ConfirmWithdrawals: true}},
+	} {
+		if _, err := NewServer(opts); err == nil {
+			t.Errorf("Expected a server without a %s to be rejected", name)
+		}
+	}
+}
+
+func TestServerHandlesDepositEndToEnd(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	currency := db.Currency{Symbol: "USD"}
+	country := db.Country{Name: "United States", Code: "US"}
+	gateway := db.Gateway{Name: "Gateway 1", DataFormatSupported: "application/json"}
+	store.CreateCurrency(ctx, &currency)
+	store.CreateCountry(ctx, &country)
+	store.CreateGateway(ctx, &gateway)
+	store.AddCountryCurrency(ctx, country.ID, currency.ID)
+	store.AddGatewayCountry(ctx, gateway.ID, country.ID)
+	user := db.User{MerchantID: db.DefaultMerchantID, Username: "johnsmith", Email: "john.smith@example.com", CountryID: country.ID}
+	store.CreateUser(ctx, &user)
+
+	pub := publisher.NewMemory()
+	s := newTestServer(t, store, pub)
+	s.clock = func() time.Time { return time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC) }
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/deposit", strings.NewReader(`{"amount": 20, "user_id": 1, "currency": "USD"}`))
+	req.Header.Set("Content-Type", "application/json")
+	s.ServeHTTP(rr, req)
+
+	var response models.APIResponse[db.Transaction]
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusCreated || response.Data.Status != db.SENT || response.Data.GatewayID != gateway.ID {
+		t.Fatalf("unexpected response %+v", response)
+	}
+
+	messages := pub.Messages()
+	if len(messages) != 1 || messages[0].Topic != "transactions.json" {
+		t.Fatalf("Expected the deposit to be published once, got %+v", messages)
+	}
+	var published models.TransactionRequestEncrypted
+	if err := json.Unmarshal(messages[0].Value, &published); err != nil {
+		t.Fatal(err)
+	}
+	if amount, err := s.cipher.Decrypt(published.Amount); err != nil || amount != "20" {
+		t.Errorf("Expected the published amount to decrypt to 20, got %q (%v)", amount, err)
+	}
+}
+
+func TestServerWithoutDBRejectsBatches(t *testing.T) {
+	s := newTestServer(t, memory.NewStore(), publisher.NewMemory())
+	if _, ok := <-s.Start(context.Background()); ok {
+		t.Errorf("Expected no batch workers to start without a DB")
+	}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/withdrawals/batch", strings.NewReader(`[{"amount": 20, "user_id": 1, "currency": "USD"}]`))
+	req.Header.Set("Content-Type", "application/json")
+	s.ServeHTTP(rr, req)
+	if !strings.Contains(rr.Body.String(), "unable to connect to DB") {
+		t.Errorf("unexpected response %s", rr.Body.String())
+	}
+}
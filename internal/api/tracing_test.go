@@ -0,0 +1,69 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/internal/publisher"
+	"payment-gateway/internal/services"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceFollowsDepositThroughCallback(t *testing.T) {
+	store, _, _ := newRoutingStore(t)
+	pub := publisher.NewMemory()
+	spans := tracetest.NewSpanRecorder()
+	cipher, _ := services.NewAESCipher(make([]byte, 32))
+	s, err := NewServer(Options{
+		Store:     store,
+		Publisher: pub,
+		Cipher:    cipher,
+		Config:    Config{AllowAnonymous: true},
+		Tracer:    sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/deposit", strings.NewReader(`{"amount": 20, "user_id": 1, "currency": "USD"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	s.ServeHTTP(rr, req)
+
+	messages := pub.Messages()
+	if len(messages) != 1 || !strings.HasPrefix(messages[0].Headers["traceparent"], "00-"+traceID+"-") {
+		t.Fatalf("Expected the published message to carry the trace, got %+v", messages)
+	}
+
+	// the gateway calls back with the traceparent it received along with the message
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPut, "/deposit", strings.NewReader(fmt.Sprintf(`{"transaction_id": %s, "status": "success"}`, messages[0].Key)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", messages[0].Headers["traceparent"])
+	s.ServeHTTP(rr, req)
+
+	byName := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range spans.Ended() {
+		if span.SpanContext().TraceID().String() != traceID {
+			t.Errorf("Expected span %s to be part of the client's trace, got trace %s", span.Name(), span.SpanContext().TraceID())
+		}
+		byName[span.Name()] = span
+	}
+	deposit, publish, callback := byName["POST /deposit"], byName["publish transactions.json"], byName["PUT /deposit"]
+	if deposit == nil || publish == nil || callback == nil {
+		t.Fatalf("Expected spans for the deposit, the publish and the callback, got %v", byName)
+	}
+	if publish.Parent().SpanID() != deposit.SpanContext().SpanID() || publish.SpanKind() != trace.SpanKindProducer {
+		t.Errorf("Expected the publish to be a producer span under the deposit")
+	}
+	if callback.Parent().SpanID() != publish.SpanContext().SpanID() || !callback.Parent().IsRemote() {
+		t.Errorf("Expected the callback to continue from the published message")
+	}
+}
@@ -0,0 +1,585 @@
+// Package config holds every setting of the payment gateway. Values start from Defaults and are overridden by the
+// config file, then environment variables, then command line flags. Each field is tagged with its name in the
+// file (yaml), the environment variable setting it (env) and whether it is a secret that must not be printed.
+package config
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"payment-gateway/internal/ratelimit"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Server                 ServerConfig                 `yaml:"server"`
+	Database               DatabaseConfig               `yaml:"database"`
+	Publisher              PublisherConfig              `yaml:"publisher"`
+	Security               SecurityConfig               `yaml:"security"`
+	RateLimit              RateLimitConfig              `yaml:"rate_limit"`
+	Retry                  RetryConfig                  `yaml:"retry"`
+	Breaker                BreakerConfig                `yaml:"breaker"`
+	Batch                  BatchConfig                  `yaml:"batch"`
+	WithdrawalConfirmation WithdrawalConfirmationConfig `yaml:"withdrawal_confirmation"`
+	ISO20022               ISO20022Config               `yaml:"iso20022"`
+	Runtime                RuntimeConfig                `yaml:"runtime"`
+	Logging                LoggingConfig                `yaml:"logging"`
+	Tracing                TracingConfig                `yaml:"tracing"`
+}
+
+type ServerConfig struct {
+	Port int `yaml:"port" env:"PORT"`
+	// deadline of the regular API requests
+	RequestTimeout time.Duration `yaml:"request_timeout" env:"REQUEST_TIMEOUT"`
+	// deadline of withdrawal batch uploads and result downloads
+	BatchRequestTimeout time.Duration `yaml:"batch_request_timeout" env:"BATCH_REQUEST_TIMEOUT"`
+	// how long reading a whole request may take
+	ReadTimeout time.Duration `yaml:"read_timeout" env:"SERVER_READ_TIMEOUT"`
+	// how long handling a request and writing the response may take, above the request timeouts
+	WriteTimeout time.Duration `yaml:"write_timeout" env:"SERVER_WRITE_TIMEOUT"`
+	// how long an idle keep-alive connection is kept open
+	IdleTimeout time.Duration `yaml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT"`
+	// how long readiness fails before the server stops accepting connections, so load balancers notice first
+	ShutdownDelay time.Duration `yaml:"shutdown_delay" env:"SERVER_SHUTDOWN_DELAY"`
+	// how long in-flight requests and batch rows get to finish once the server stops accepting connections
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SERVER_SHUTDOWN_TIMEOUT"`
+}
+
+type DatabaseConfig struct {
+	Host     string `yaml:"host" env:"DB_HOST"`
+	Port     int    `yaml:"port" env:"DB_PORT"`
+	User     string `yaml:"user" env:"DB_USER"`
+	Password string `yaml:"password" env:"DB_PASSWORD" secret:"true"`
+	Name     string `yaml:"name" env:"DB_NAME"`
+	SSLMode  string `yaml:"sslmode" env:"DB_SSLMODE"`
+	// attempts at reaching the database on startup
+	ConnectAttempts int `yaml:"connect_attempts" env:"DB_CONNECT_ATTEMPTS"`
+}
+
+type PublisherConfig struct {
+	// kafka or file, which appends transactions to File as NDJSON so the service can run without a broker
+	Type              string        `yaml:"type" env:"PUBLISHER"`
+	KafkaBrokerURL    string        `yaml:"kafka_broker_url" env:"KAFKA_BROKER_URL"`
+	KafkaBatchTimeout time.Duration `yaml:"kafka_batch_timeout" env:"KAFKA_BATCH_TIMEOUT"`
+	File              string        `yaml:"file" env:"PUBLISHER_FILE"`
+}
+
+type SecurityConfig struct {
+	// hex encoded AES key the messages published to gateways are encrypted with
+	AESKey string `yaml:"aes_key" env:"AES_ENCRYPTION_CIPHER" secret:"true"`
+	// comma separated actor:token pairs accepted by the admin API, each optionally followed by :role+role
+	AdminTokens string `yaml:"admin_tokens" env:"ADMIN_API_TOKENS" secret:"true"`
+	// accept requests without an API key outside the admin API, for local development
+	AllowAnonymous bool `yaml:"allow_anonymous" env:"AUTH_ALLOW_ANONYMOUS"`
+	// how far the timestamp of a signed request may be from the server's clock
+	SignatureTolerance time.Duration `yaml:"signature_tolerance" env:"AUTH_SIGNATURE_TOLERANCE"`
+	// how long a rotated API key keeps working next to its replacement
+	KeyRotationGrace time.Duration `yaml:"key_rotation_grace" env:"AUTH_KEY_ROTATION_GRACE"`
+}
+
+// Budgets of the payment API, each requests/period such as 600/1m and empty for none. Reads are GET requests,
+// writes everything else.
+type RateLimitConfig struct {
+	// memory keeps the budgets in each instance, redis shares them between instances
+	Backend string `yaml:"backend" env:"RATE_LIMIT_BACKEND"`
+	// redis://[:password@]host:port/db of the redis backend
+	RedisURL  string `yaml:"redis_url" env:"REDIS_URL" secret:"true"`
+	KeyReads  string `yaml:"key_reads" env:"RATE_LIMIT_KEY_READS"`
+	KeyWrites string `yaml:"key_writes" env:"RATE_LIMIT_KEY_WRITES"`
+	// deposits and withdrawals per user of a merchant
+	UserWrites string `yaml:"user_writes" env:"RATE_LIMIT_USER_WRITES"`
+	IPReads    string `yaml:"ip_reads" env:"RATE_LIMIT_IP_READS"`
+	IPWrites   string `yaml:"ip_writes" env:"RATE_LIMIT_IP_WRITES"`
+	// take the client IP from X-Forwarded-For, only behind a proxy setting it
+	TrustForwardedFor bool `yaml:"trust_forwarded_for" env:"RATE_LIMIT_TRUST_FORWARDED_FOR"`
+}
+
+type RetryConfig struct {
+	// attempts at creating and publishing a transaction
+	Attempts int `yaml:"attempts" env:"RETRY_ATTEMPTS"`
+	// wait after the first failed attempt, doubled after each one after it
+	Backoff time.Duration `yaml:"backoff" env:"RETRY_BACKOFF"`
+}
+
+type BreakerConfig struct {
+	// requests let through while half-open
+	MaxRequests uint32 `yaml:"max_requests" env:"BREAKER_MAX_REQUESTS"`
+	// how often the failure counts are cleared while closed
+	Interval time.Duration `yaml:"interval" env:"BREAKER_INTERVAL"`
+	// how long the breaker stays open before trying again
+	Timeout time.Duration `yaml:"timeout" env:"BREAKER_TIMEOUT"`
+}
+
+type BatchConfig struct {
+	Workers      int           `yaml:"workers" env:"BATCH_WORKERS"`
+	PollInterval time.Duration `yaml:"poll_interval" env:"BATCH_POLL_INTERVAL"`
+	// how long a single row may take, including the retries
+	RowTimeout time.Duration `yaml:"row_timeout" env:"BATCH_ROW_TIMEOUT"`
+	// a job processing without progress for this long is handed to another worker
+	StaleJobAge time.Duration `yaml:"stale_job_age" env:"BATCH_STALE_JOB_AGE"`
+}
+
+// Withdrawals created through POST /withdrawal wait in DRAFT until the user confirms them with a one-time code
+type WithdrawalConfirmationConfig struct {
+	// off publishes withdrawals straight away
+	Enabled bool `yaml:"enabled" env:"WITHDRAWAL_CONFIRMATION_ENABLED"`
+	// how long the code is valid, unconfirmed withdrawals expire after it
+	TTL time.Duration `yaml:"ttl" env:"WITHDRAWAL_CONFIRMATION_TTL"`
+	// wrong codes accepted before the withdrawal expires
+	MaxAttempts int `yaml:"max_attempts" env:"WITHDRAWAL_CONFIRMATION_MAX_ATTEMPTS"`
+	// how often expired withdrawals are looked for
+	SweepInterval time.Duration `yaml:"sweep_interval" env:"WITHDRAWAL_CONFIRMATION_SWEEP_INTERVAL"`
+	// how codes reach the user: webhook, posting them to the webhook set on the merchant, or log for local
+	// development, which writes them to stderr in plain text
+	Notifier string `yaml:"notifier" env:"WITHDRAWAL_CONFIRMATION_NOTIFIER"`
+}
+
+// the account withdrawals paid out through ISO 20022 gateways are debited from
+type ISO20022Config struct {
+	DebtorName string `yaml:"debtor_name" env:"ISO20022_DEBTOR_NAME"`
+	DebtorIBAN string `yaml:"debtor_iban" env:"ISO20022_DEBTOR_IBAN"`
+	DebtorBIC  string `yaml:"debtor_bic" env:"ISO20022_DEBTOR_BIC"`
+}
+
+// where the routing, limits and disabled gateways are read from, see Runtime
+type RuntimeConfig struct {
+	// YAML file reloaded on SIGHUP or when it changes, empty means no routing rules, limits or disabled gateways
+	File string `yaml:"file" env:"RUNTIME_CONFIG_FILE"`
+	// how often the file is checked for changes
+	PollInterval time.Duration `yaml:"poll_interval" env:"RUNTIME_CONFIG_POLL_INTERVAL"`
+}
+
+type LoggingConfig struct {
+	// debug, info, warn or error
+	Level string `yaml:"level" env:"LOG_LEVEL"`
+}
+
+type TracingConfig struct {
+	// none, otlp or file
+	Exporter string `yaml:"exporter" env:"TRACING_EXPORTER"`
+	// host:port of the OTLP/HTTP collector
+	OTLPEndpoint string `yaml:"otlp_endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	// send to the collector over plain HTTP, as to a collector running next to the service
+	OTLPInsecure bool `yaml:"otlp_insecure" env:"OTEL_EXPORTER_OTLP_INSECURE"`
+	// spans are appended to this file as JSON by the file exporter
+	File string `yaml:"file" env:"TRACING_FILE"`
+	// share of the traces started here that are recorded, between 0 and 1
+	SampleRatio float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+	ServiceName string  `yaml:"service_name" env:"OTEL_SERVICE_NAME"`
+}
+
+func Defaults() Config {
+	return Config{
+		Server: ServerConfig{
+			Port:                8080,
+			RequestTimeout:      5 * time.Second,
+			BatchRequestTimeout: 30 * time.Second,
+			ReadTimeout:         10 * time.Second,
+			WriteTimeout:        60 * time.Second,
+			IdleTimeout:         120 * time.Second,
+			ShutdownDelay:       5 * time.Second,
+			ShutdownTimeout:     30 * time.Second,
+		},
+		Database: DatabaseConfig{
+			Host:            "localhost",
+			Port:            5432,
+			SSLMode:         "disable",
+			ConnectAttempts: 5,
+		},
+		Publisher: PublisherConfig{
+			Type:              "kafka",
+			KafkaBrokerURL:    "kafka:9092",
+			KafkaBatchTimeout: 10 * time.Millisecond,
+			File:              "transactions.ndjson",
+		},
+		Security: SecurityConfig{
+			SignatureTolerance: 5 * time.Minute,
+			KeyRotationGrace:   24 * time.Hour,
+		},
+		RateLimit: RateLimitConfig{
+			Backend:    "memory",
+			KeyReads:   "600/1m",
+			KeyWrites:  "120/1m",
+			UserWrites: "10/1m",
+			IPReads:    "600/1m",
+			IPWrites:   "120/1m",
+		},
+		Retry: RetryConfig{
+			Attempts: 3,
+			Backoff:  time.Second,
+		},
+		Breaker: BreakerConfig{
+			MaxRequests: 1,
+			Interval:    5 * time.Second,
+			Timeout:     3 * time.Second,
+		},
+		Batch: BatchConfig{
+			Workers:      4,
+			PollInterval: 5 * time.Second,
+			RowTimeout:   30 * time.Second,
+			StaleJobAge:  5 * time.Minute,
+		},
+		WithdrawalConfirmation: WithdrawalConfirmationConfig{
+			TTL:           15 * time.Minute,
+			MaxAttempts:   5,
+			SweepInterval: time.Minute,
+			Notifier:      "webhook",
+		},
+		Runtime: RuntimeConfig{
+			PollInterval: 10 * time.Second,
+		},
+		Logging: LoggingConfig{
+			Level: "info",
+		},
+		Tracing: TracingConfig{
+			Exporter:     "none",
+			OTLPEndpoint: "localhost:4318",
+			OTLPInsecure: true,
+			File:         "traces.json",
+			SampleRatio:  1,
+			ServiceName:  "payment-gateway",
+		},
+	}
+}
+
+// Builds the configuration from the defaults, the file, the environment and args, in increasing order of
+// precedence. The file is named by the -config flag or CONFIG_FILE. Every setting has a flag named after its path
+// in the file, such as -server.port. The result is not validated.
+func Load(args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	cfg := Defaults()
+
+	flags, setFlags, err := parseFlags(args)
+	if err != nil {
+		return Config{}, err
+	}
+
+	path, _ := lookupEnv("CONFIG_FILE")
+	if f := flags.Lookup("config"); f.Value.String() != "" {
+		path = f.Value.String()
+	}
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, field := range fields(&cfg) {
+		if field.env == "" {
+			continue
+		}
+		if value, ok := lookupEnv(field.env); ok && value != "" {
+			if err := field.set(value); err != nil {
+				return Config{}, fmt.Errorf("invalid %s: %v", field.env, err)
+			}
+		}
+	}
+
+	for _, field := range fields(&cfg) {
+		if value, ok := setFlags[field.path]; ok {
+			if err := field.set(value); err != nil {
+				return Config{}, fmt.Errorf("invalid -%s: %v", field.path, err)
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+func loadFile(path string, cfg *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %v", err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return nil
+}
+
+// Checks every section and reports all problems at once
+func (c Config) Validate() error {
+	var problems []string
+	problems = append(problems, c.Server.problems()...)
+	problems = append(problems, c.Database.problems()...)
+	problems = append(problems, c.Publisher.problems()...)
+	problems = append(problems, c.Security.problems()...)
+	problems = append(problems, c.RateLimit.problems()...)
+	problems = append(problems, c.Retry.problems()...)
+	problems = append(problems, c.Breaker.problems()...)
+	problems = append(problems, c.Batch.problems()...)
+	problems = append(problems, c.WithdrawalConfirmation.problems()...)
+	problems = append(problems, c.ISO20022.problems()...)
+	problems = append(problems, c.Runtime.problems()...)
+	problems = append(problems, c.Logging.problems()...)
+	problems = append(problems, c.Tracing.problems()...)
+	return asError(problems)
+}
+
+func (c ServerConfig) problems() []string {
+	var problems []string
+	if c.Port < 1 || c.Port > 65535 {
+		problems = append(problems, "server.port must be between 1 and 65535")
+	}
+	if c.RequestTimeout <= 0 {
+		problems = append(problems, "server.request_timeout must be positive")
+	}
+	if c.BatchRequestTimeout <= 0 {
+		problems = append(problems, "server.batch_request_timeout must be positive")
+	}
+	if c.ReadTimeout <= 0 {
+		problems = append(problems, "server.read_timeout must be positive")
+	}
+	if c.WriteTimeout <= c.RequestTimeout || c.WriteTimeout <= c.BatchRequestTimeout {
+		problems = append(problems, "server.write_timeout must be above server.request_timeout and server.batch_request_timeout")
+	}
+	if c.IdleTimeout <= 0 {
+		problems = append(problems, "server.idle_timeout must be positive")
+	}
+	if c.ShutdownDelay < 0 {
+		problems = append(problems, "server.shutdown_delay must not be negative")
+	}
+	if c.ShutdownTimeout <= 0 {
+		problems = append(problems, "server.shutdown_timeout must be positive")
+	}
+	return problems
+}
+
+// the database settings are validated on their own by the commands that only need the database
+func (c DatabaseConfig) Validate() error {
+	return asError(c.problems())
+}
+
+func (c DatabaseConfig) problems() []string {
+	var problems []string
+	if c.Host == "" {
+		problems = append(problems, "database.host (DB_HOST) is required")
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		problems = append(problems, "database.port must be between 1 and 65535")
+	}
+	if c.User == "" {
+		problems = append(problems, "database.user (DB_USER) is required")
+	}
+	if c.Name == "" {
+		problems = append(problems, "database.name (DB_NAME) is required")
+	}
+	if c.ConnectAttempts < 1 {
+		problems = append(problems, "database.connect_attempts must be at least 1")
+	}
+	return problems
+}
+
+func (c DatabaseConfig) URL() string {
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.User, c.Password),
+		Host:     fmt.Sprintf("%s:%d", c.Host, c.Port),
+		Path:     "/" + c.Name,
+		RawQuery: url.Values{"sslmode": {c.SSLMode}}.Encode(),
+	}
+	return u.String()
+}
+
+func (c PublisherConfig) problems() []string {
+	switch c.Type {
+	case "kafka":
+		if c.KafkaBrokerURL == "" {
+			return []string{"publisher.kafka_broker_url (KAFKA_BROKER_URL) is required for the kafka publisher"}
+		}
+		if c.KafkaBatchTimeout <= 0 {
+			return []string{"publisher.kafka_batch_timeout must be positive"}
+		}
+	case "file":
+		if c.File == "" {
+			return []string{"publisher.file (PUBLISHER_FILE) is required for the file publisher"}
+		}
+	default:
+		return []string{fmt.Sprintf("publisher.type (PUBLISHER) must be kafka or file, got %q", c.Type)}
+	}
+	return nil
+}
+
+func (c SecurityConfig) problems() []string {
+	var problems []string
+	if c.AESKey == "" {
+		problems = append(problems, "security.aes_key (AES_ENCRYPTION_CIPHER) is required")
+	} else if key, err := hex.DecodeString(c.AESKey); err != nil {
+		problems = append(problems, "security.aes_key (AES_ENCRYPTION_CIPHER) must be hex encoded")
+	} else if n := len(key); n != 16 && n != 24 && n != 32 {
+		problems = append(problems, fmt.Sprintf("security.aes_key (AES_ENCRYPTION_CIPHER) must be 16, 24 or 32 bytes, got %d", n))
+	}
+	if c.SignatureTolerance <= 0 {
+		problems = append(problems, "security.signature_tolerance must be positive")
+	}
+	if c.KeyRotationGrace < 0 {
+		problems = append(problems, "security.key_rotation_grace must not be negative")
+	}
+	return problems
+}
+
+func (c RateLimitConfig) problems() []string {
+	var problems []string
+	switch c.Backend {
+	case "memory":
+	case "redis":
+		if c.RedisURL == "" {
+			problems = append(problems, "rate_limit.redis_url (REDIS_URL) is required by the redis backend")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("rate_limit.backend (RATE_LIMIT_BACKEND) must be memory or redis, got %q", c.Backend))
+	}
+	for _, budget := range []struct{ name, value string }{
+		{"key_reads", c.KeyReads}, {"key_writes", c.KeyWrites}, {"user_writes", c.UserWrites}, {"ip_reads", c.IPReads}, {"ip_writes", c.IPWrites},
+	} {
+		if _, err := ratelimit.ParseLimit(budget.value); err != nil {
+			problems = append(problems, fmt.Sprintf("rate_limit.%s: %v", budget.name, err))
+		}
+	}
+	return problems
+}
+
+func (c RetryConfig) problems() []string {
+	var problems []string
+	if c.Attempts < 1 {
+		problems = append(problems, "retry.attempts must be at least 1")
+	}
+	if c.Backoff < 0 {
+		problems = append(problems, "retry.backoff must not be negative")
+	}
+	return problems
+}
+
+func (c BreakerConfig) problems() []string {
+	var problems []string
+	if c.MaxRequests < 1 {
+		problems = append(problems, "breaker.max_requests must be at least 1")
+	}
+	if c.Interval < 0 {
+		problems = append(problems, "breaker.interval must not be negative")
+	}
+	if c.Timeout <= 0 {
+		problems = append(problems, "breaker.timeout must be positive")
+	}
+	return problems
+}
+
+func (c BatchConfig) problems() []string {
+	var problems []string
+	if c.Workers < 1 {
+		problems = append(problems, "batch.workers must be at least 1")
+	}
+	if c.PollInterval <= 0 {
+		problems = append(problems, "batch.poll_interval must be positive")
+	}
+	if c.RowTimeout <= 0 {
+		problems = append(problems, "batch.row_timeout must be positive")
+	}
+	if c.StaleJobAge <= c.RowTimeout {
+		problems = append(problems, "batch.stale_job_age must be longer than batch.row_timeout")
+	}
+	return problems
+}
+
+func (c WithdrawalConfirmationConfig) problems() []string {
+	var problems []string
+	if c.TTL <= 0 {
+		problems = append(problems, "withdrawal_confirmation.ttl must be positive")
+	}
+	if c.MaxAttempts < 1 {
+		problems = append(problems, "withdrawal_confirmation.max_attempts must be at least 1")
+	}
+	if c.SweepInterval <= 0 {
+		problems = append(problems, "withdrawal_confirmation.sweep_interval must be positive")
+	}
+	if !c.Enabled {
+		return problems
+	}
+	if c.Notifier != "webhook" && c.Notifier != "log" {
+		problems = append(problems, fmt.Sprintf("withdrawal_confirmation.notifier must be webhook or log, got %q", c.Notifier))
+	}
+	return problems
+}
+
+func (c ISO20022Config) problems() []string {
+	set := 0
+	for _, value := range []string{c.DebtorName, c.DebtorIBAN, c.DebtorBIC} {
+		if value != "" {
+			set++
+		}
+	}
+	if set != 0 && set != 3 {
+		return []string{"iso20022.debtor_name, debtor_iban and debtor_bic must be set together"}
+	}
+	return nil
+}
+
+func (c RuntimeConfig) problems() []string {
+	if c.PollInterval <= 0 {
+		return []string{"runtime.poll_interval must be positive"}
+	}
+	return nil
+}
+
+func (c LoggingConfig) problems() []string {
+	switch c.Level {
+	case "debug", "info", "warn", "error":
+		return nil
+	}
+	return []string{fmt.Sprintf("logging.level must be debug, info, warn or error, got %q", c.Level)}
+}
+
+func (c TracingConfig) problems() []string {
+	var problems []string
+	switch c.Exporter {
+	case "none":
+	case "otlp":
+		if c.OTLPEndpoint == "" {
+			problems = append(problems, "tracing.otlp_endpoint (OTEL_EXPORTER_OTLP_ENDPOINT) is required by the otlp exporter")
+		}
+	case "file":
+		if c.File == "" {
+			problems = append(problems, "tracing.file (TRACING_FILE) is required by the file exporter")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("tracing.exporter must be none, otlp or file, got %q", c.Exporter))
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		problems = append(problems, "tracing.sample_ratio must be between 0 and 1")
+	}
+	if c.ServiceName == "" {
+		problems = append(problems, "tracing.service_name is required")
+	}
+	return problems
+}
+
+func asError(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+}
+
+// The configuration as YAML with the secrets replaced, for logging
+func (c Config) Redacted() string {
+	redacted := c
+	for _, field := range fields(&redacted) {
+		if field.secret && field.value.String() != "" {
+			field.value.SetString("[REDACTED]")
+		}
+	}
+	out, err := yaml.Marshal(redacted)
+	if err != nil {
+		return fmt.Sprintf("unable to print configuration: %v", err)
+	}
+	return string(out)
+}
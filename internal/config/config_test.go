@@ -0,0 +1,137 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testKey = "0e2a2eaee2c6135346e52c5836e78dc8a26ff5f03da3179c59bd4e5c118c6b23"
+
+func env(values map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := values[name]
+		return value, ok
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, `
+server:
+  port: 9000
+  request_timeout: 2s
+database:
+  host: file-host
+  user: file-user
+retry:
+  attempts: 7
+`)
+	cfg, err := Load([]string{"-config", path, "-server.port", "9100", "-breaker.max_requests", "4"}, env(map[string]string{
+		"PORT":    "9050",
+		"DB_HOST": "env-host",
+		"DB_NAME": "payments",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Server.Port != 9100 {
+		t.Errorf("Expected the flag to win, got port %d", cfg.Server.Port)
+	}
+	if cfg.Database.Host != "env-host" || cfg.Database.User != "file-user" || cfg.Database.Name != "payments" {
+		t.Errorf("Expected env to override the file, got %+v", cfg.Database)
+	}
+	if cfg.Server.RequestTimeout != 2*time.Second || cfg.Retry.Attempts != 7 || cfg.Breaker.MaxRequests != 4 {
+		t.Errorf("unexpected settings %+v %+v %+v", cfg.Server, cfg.Retry, cfg.Breaker)
+	}
+	if cfg.Batch.Workers != 4 || cfg.Publisher.Type != "kafka" {
+		t.Errorf("Expected unset settings to keep their defaults, got %+v %+v", cfg.Batch, cfg.Publisher)
+	}
+}
+
+func TestLoadConfigFileFromEnv(t *testing.T) {
+	path := writeFile(t, "batch:\n  workers: 2\n")
+	cfg, err := Load(nil, env(map[string]string{"CONFIG_FILE": path}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Batch.Workers != 2 {
+		t.Errorf("Expected the file named by CONFIG_FILE to be read, got %d workers", cfg.Batch.Workers)
+	}
+}
+
+func TestLoadRejectsBadInput(t *testing.T) {
+	if _, err := Load([]string{"-config", writeFile(t, "server:\n  prot: 80\n")}, env(nil)); err == nil || !strings.Contains(err.Error(), "prot") {
+		t.Errorf("Expected a misspelt setting to be rejected, got %v", err)
+	}
+	if _, err := Load(nil, env(map[string]string{"RETRY_BACKOFF": "soon"})); err == nil || !strings.Contains(err.Error(), "RETRY_BACKOFF") {
+		t.Errorf("Expected an invalid duration to be rejected, got %v", err)
+	}
+	if _, err := Load([]string{"-server.port", "eighty"}, env(nil)); err == nil || !strings.Contains(err.Error(), "-server.port") {
+		t.Errorf("Expected an invalid flag to be rejected, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Defaults()
+	cfg.Database.User, cfg.Database.Name = "user", "payments"
+	cfg.Security.AESKey = testKey
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected the defaults with credentials to be valid, got %v", err)
+	}
+
+	cfg.Server.Port = 0
+	cfg.Server.WriteTimeout = cfg.Server.BatchRequestTimeout
+	cfg.Publisher.Type = "rabbitmq"
+	cfg.Security.AESKey = "0e2a"
+	cfg.ISO20022.DebtorName = "Payment Gateway Ltd"
+	cfg.RateLimit.Backend = "redis"
+	cfg.RateLimit.UserWrites = "10 per minute"
+	cfg.WithdrawalConfirmation.Enabled = true
+	cfg.WithdrawalConfirmation.Notifier = "sms"
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected the configuration to be invalid")
+	}
+	for _, expected := range []string{"server.port", "server.write_timeout", "publisher.type", "security.aes_key", "iso20022.debtor_name", "rate_limit.redis_url", "rate_limit.user_writes", "withdrawal_confirmation.notifier"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %s to be reported, got %v", expected, err)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Defaults()
+	cfg.Database.Password = "hunter2"
+	cfg.Security.AESKey = testKey
+	out := cfg.Redacted()
+	if strings.Contains(out, "hunter2") || strings.Contains(out, testKey) {
+		t.Errorf("Expected secrets to be redacted:\n%s", out)
+	}
+	if !strings.Contains(out, "[REDACTED]") || !strings.Contains(out, "request_timeout: 5s") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+	if cfg.Database.Password != "hunter2" {
+		t.Errorf("Expected the configuration itself to be left alone")
+	}
+}
+
+func TestExampleMatchesDefaults(t *testing.T) {
+	cfg, err := Load([]string{"-config", "../../config.example.yaml"}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg != Defaults() {
+		t.Errorf("config.example.yaml is out of date:\n%s", cfg.Redacted())
+	}
+}
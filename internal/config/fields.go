@@ -0,0 +1,125 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// a setting, addressed by its path in the file such as server.port
+type field struct {
+	path   string
+	env    string
+	secret bool
+	usage  string
+	value  reflect.Value
+}
+
+// walks the settable leaves of cfg
+func fields(cfg *Config) []field {
+	var out []field
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			path := f.Tag.Get("yaml")
+			if prefix != "" {
+				path = prefix + "." + path
+			}
+			if f.Type.Kind() == reflect.Struct {
+				walk(path, v.Field(i))
+				continue
+			}
+			usage := "sets " + path
+			if env := f.Tag.Get("env"); env != "" {
+				usage += ", overrides " + env
+			}
+			out = append(out, field{
+				path:   path,
+				env:    f.Tag.Get("env"),
+				secret: f.Tag.Get("secret") == "true",
+				usage:  usage,
+				value:  v.Field(i),
+			})
+		}
+	}
+	walk("", reflect.ValueOf(cfg).Elem())
+	return out
+}
+
+func (f field) set(value string) error {
+	switch {
+	case f.value.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(int64(d))
+	case f.value.Kind() == reflect.String:
+		f.value.SetString(value)
+	case f.value.Kind() == reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(int64(n))
+	case f.value.Kind() == reflect.Uint32:
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return err
+		}
+		f.value.SetUint(n)
+	case f.value.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		f.value.SetBool(b)
+	case f.value.Kind() == reflect.Float64:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		f.value.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported setting type %s", f.value.Type())
+	}
+	return nil
+}
+
+// records the raw value of a flag, applied once the file and environment are loaded
+type flagValue struct {
+	value string
+}
+
+func (v *flagValue) String() string     { return v.value }
+func (v *flagValue) Set(s string) error { v.value = s; return nil }
+
+// Defines -config and a flag for every setting. Returns the flags that were given on the command line.
+func parseFlags(args []string) (*flag.FlagSet, map[string]string, error) {
+	flags := flag.NewFlagSet("payment-gateway", flag.ContinueOnError)
+	flags.String("config", "", "YAML config file, overrides CONFIG_FILE")
+
+	var cfg Config
+	for _, field := range fields(&cfg) {
+		flags.Var(&flagValue{}, field.path, field.usage)
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, nil, fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+
+	set := map[string]string{}
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = f.Value.String()
+	})
+	return flags, set, nil
+}
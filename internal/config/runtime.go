@@ -0,0 +1,174 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/shopspring/decimal"
+	"gopkg.in/yaml.v3"
+)
+
+// The part of the configuration that can change while the service runs: how transactions are routed to
+// gateways, the amounts they are limited to and gateways switched off without touching the database. It is read
+// from its own file and reloaded by a Watcher.
+type Runtime struct {
+	// The first rule matching a transaction decides which gateways it may be sent to. Rules for the transaction's
+	// merchant are looked at before those for every merchant.
+	Routing []RoutingRule `yaml:"routing" json:"routing"`
+	// every limit matching a transaction applies, those for its merchant and those for every merchant
+	Limits []Limit `yaml:"limits" json:"limits"`
+	// names of gateways no transaction is sent to, whatever the routing says
+	DisabledGateways []string `yaml:"disabled_gateways" json:"disabled_gateways"`
+}
+
+type RoutingRule struct {
+	// the merchant the rule is for, 0 matches every merchant
+	MerchantID int `yaml:"merchant_id" json:"merchant_id,omitempty"`
+	// ISO 3166 alpha-2 code, empty matches every country
+	Country string `yaml:"country" json:"country,omitempty"`
+	// ISO 4217 code, empty matches every currency
+	Currency string `yaml:"currency" json:"currency,omitempty"`
+	// names of the gateways matching transactions may be sent to
+	Gateways []string `yaml:"gateways" json:"gateways"`
+}
+
+type Limit struct {
+	// the merchant the limit is for, 0 matches every merchant
+	MerchantID int `yaml:"merchant_id" json:"merchant_id,omitempty"`
+	// deposit or withdrawal, empty matches both
+	Type string `yaml:"type" json:"type,omitempty"`
+	// ISO 4217 code, empty matches every currency
+	Currency string          `yaml:"currency" json:"currency,omitempty"`
+	Min      decimal.Decimal `yaml:"min" json:"min"`
+	// zero means no maximum
+	Max decimal.Decimal `yaml:"max" json:"max"`
+}
+
+var (
+	runtimeCountryPattern  = regexp.MustCompile(`^[A-Z]{2}$`)
+	runtimeCurrencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+)
+
+func LoadRuntime(path string) (Runtime, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Runtime{}, fmt.Errorf("failed to open runtime config: %v", err)
+	}
+	defer f.Close()
+
+	var rt Runtime
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&rt); err != nil && !errors.Is(err, io.EOF) {
+		return Runtime{}, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if err := rt.Validate(); err != nil {
+		return Runtime{}, err
+	}
+	return rt, nil
+}
+
+func (rt Runtime) Validate() error {
+	var problems []string
+	seen := map[string]bool{}
+	for i, rule := range rt.Routing {
+		if rule.MerchantID < 0 {
+			problems = append(problems, fmt.Sprintf("routing[%d].merchant_id must not be negative", i))
+		}
+		if rule.Country != "" && !runtimeCountryPattern.MatchString(rule.Country) {
+			problems = append(problems, fmt.Sprintf("routing[%d].country must be an ISO 3166 alpha-2 code, got %q", i, rule.Country))
+		}
+		if rule.Currency != "" && !runtimeCurrencyPattern.MatchString(rule.Currency) {
+			problems = append(problems, fmt.Sprintf("routing[%d].currency must be an ISO 4217 code, got %q", i, rule.Currency))
+		}
+		if len(rule.Gateways) == 0 {
+			problems = append(problems, fmt.Sprintf("routing[%d].gateways must name at least one gateway", i))
+		}
+		key := fmt.Sprintf("%d/%s/%s", rule.MerchantID, rule.Country, rule.Currency)
+		if seen[key] {
+			problems = append(problems, fmt.Sprintf("routing[%d] matches the same transactions as an earlier rule", i))
+		}
+		seen[key] = true
+	}
+	for i, limit := range rt.Limits {
+		if limit.MerchantID < 0 {
+			problems = append(problems, fmt.Sprintf("limits[%d].merchant_id must not be negative", i))
+		}
+		if limit.Type != "" && limit.Type != "deposit" && limit.Type != "withdrawal" {
+			problems = append(problems, fmt.Sprintf("limits[%d].type must be deposit or withdrawal, got %q", i, limit.Type))
+		}
+		if limit.Currency != "" && !runtimeCurrencyPattern.MatchString(limit.Currency) {
+			problems = append(problems, fmt.Sprintf("limits[%d].currency must be an ISO 4217 code, got %q", i, limit.Currency))
+		}
+		if limit.Min.IsNegative() || limit.Max.IsNegative() {
+			problems = append(problems, fmt.Sprintf("limits[%d] must not be negative", i))
+		}
+		if !limit.Max.IsZero() && limit.Max.LessThan(limit.Min) {
+			problems = append(problems, fmt.Sprintf("limits[%d].max must not be below min", i))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid runtime configuration:\n  %s", strings.Join(problems, "\n  "))
+}
+
+// Identifies the content of the configuration, two configurations with the same settings have the same version
+func (rt Runtime) Version() string {
+	out, err := yaml.Marshal(rt)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(out)
+	return hex.EncodeToString(sum[:6])
+}
+
+// Returns the gateways a transaction of the merchant in the country and currency may be routed to, or nil when no
+// rule restricts it
+func (rt Runtime) AllowedGateways(merchantID int, countryCode, currency string) []string {
+	// the first shared rule only applies when none of the merchant's own rules match
+	var shared []string
+	for _, rule := range rt.Routing {
+		if (rule.Country != "" && rule.Country != countryCode) || (rule.Currency != "" && rule.Currency != currency) {
+			continue
+		}
+		if rule.MerchantID == merchantID {
+			return rule.Gateways
+		}
+		if rule.MerchantID == 0 && shared == nil {
+			shared = rule.Gateways
+		}
+	}
+	return shared
+}
+
+func (rt Runtime) GatewayDisabled(name string) bool {
+	for _, disabled := range rt.DisabledGateways {
+		if disabled == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks amount against every limit matching a transaction of the merchant, the error says which one it breaks
+func (rt Runtime) CheckLimit(merchantID int, txType, currency string, amount decimal.Decimal) error {
+	for _, limit := range rt.Limits {
+		if (limit.MerchantID != 0 && limit.MerchantID != merchantID) || (limit.Type != "" && limit.Type != txType) || (limit.Currency != "" && limit.Currency != currency) {
+			continue
+		}
+		if amount.LessThan(limit.Min) {
+			return fmt.Errorf("the minimum %s amount in %s is %s", txType, currency, limit.Min)
+		}
+		if !limit.Max.IsZero() && amount.GreaterThan(limit.Max) {
+			return fmt.Errorf("the maximum %s amount in %s is %s", txType, currency, limit.Max)
+		}
+	}
+	return nil
+}
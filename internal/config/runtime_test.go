@@ -0,0 +1,179 @@
+package config
+
+import (
+	"context"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestLoadRuntimeExample(t *testing.T) {
+	rt, err := LoadRuntime("../../runtime.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gateways := rt.AllowedGateways(1, "AE", "AED"); len(gateways) != 1 || gateways[0] != "Gateway 2" {
+		t.Errorf("unexpected gateways %v", gateways)
+	}
+	if gateways := rt.AllowedGateways(1, "US", "USD"); gateways != nil {
+		t.Errorf("Expected no restriction without a matching rule, got %v", gateways)
+	}
+
+	for _, c := range []struct {
+		txType, currency, amount string
+		ok                       bool
+	}{
+		{"withdrawal", "USD", "10000", true},
+		{"withdrawal", "USD", "10000.01", false},
+		{"withdrawal", "USD", "0.50", false},
+		{"deposit", "USD", "50000", true},
+		{"deposit", "AED", "4.99", false},
+		{"deposit", "AED", "1000000", true},
+	} {
+		err := rt.CheckLimit(1, c.txType, c.currency, decimal.RequireFromString(c.amount))
+		if (err == nil) != c.ok {
+			t.Errorf("%s of %s %s: expected ok %v, got %v", c.txType, c.amount, c.currency, c.ok, err)
+		}
+	}
+}
+
+func TestRuntimeMerchantRules(t *testing.T) {
+	rt := Runtime{
+		Routing: []RoutingRule{
+			{Country: "AE", Gateways: []string{"Shared"}},
+			{MerchantID: 2, Country: "AE", Gateways: []string{"Own"}},
+			{MerchantID: 3, Gateways: []string{"Other"}},
+		},
+		Limits: []Limit{
+			{Currency: "USD", Max: decimal.NewFromInt(100)},
+			{MerchantID: 2, Currency: "USD", Max: decimal.NewFromInt(10)},
+		},
+	}
+	if err := rt.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		merchantID int
+		country    string
+		expected   string
+	}{
+		{1, "AE", "Shared"},
+		// the merchant's own rule wins over the shared one listed before it
+		{2, "AE", "Own"},
+		{3, "AE", "Other"},
+		{1, "US", ""},
+		{3, "US", "Other"},
+	} {
+		gateways := rt.AllowedGateways(c.merchantID, c.country, "AED")
+		if (c.expected == "" && gateways != nil) || (c.expected != "" && (len(gateways) != 1 || gateways[0] != c.expected)) {
+			t.Errorf("merchant %d in %s: expected %q, got %v", c.merchantID, c.country, c.expected, gateways)
+		}
+	}
+
+	if err := rt.CheckLimit(1, "deposit", "USD", decimal.NewFromInt(50)); err != nil {
+		t.Errorf("Expected only the shared limit to apply to merchant 1, got %v", err)
+	}
+	if err := rt.CheckLimit(2, "deposit", "USD", decimal.NewFromInt(50)); err == nil {
+		t.Errorf("Expected merchant 2's own limit to apply")
+	}
+	if err := rt.CheckLimit(3, "deposit", "USD", decimal.NewFromInt(101)); err == nil {
+		t.Errorf("Expected the shared limit to apply to merchant 3")
+	}
+}
+
+func TestRuntimeValidate(t *testing.T) {
+	rt := Runtime{
+		Routing: []RoutingRule{{Country: "uae", Gateways: []string{"Gateway 1"}}, {Currency: "USD"}, {Currency: "USD", Gateways: []string{"Gateway 1"}}},
+		Limits:  []Limit{{Type: "refund"}, {Min: decimal.NewFromInt(10), Max: decimal.NewFromInt(5)}},
+	}
+	err := rt.Validate()
+	if err == nil {
+		t.Fatal("Expected the runtime config to be invalid")
+	}
+	for _, expected := range []string{"routing[0].country", "routing[1].gateways", "routing[2] matches", "limits[0].type", "limits[1].max"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %s to be reported, got %v", expected, err)
+		}
+	}
+}
+
+func TestRuntimeVersion(t *testing.T) {
+	a := Runtime{DisabledGateways: []string{"Gateway 1"}}
+	b := Runtime{DisabledGateways: []string{"Gateway 1"}}
+	c := Runtime{DisabledGateways: []string{"Gateway 2"}}
+	if a.Version() != b.Version() || a.Version() == c.Version() || a.Version() == "" {
+		t.Errorf("Expected the version to follow the content, got %s %s %s", a.Version(), b.Version(), c.Version())
+	}
+}
+
+func TestWatcherReload(t *testing.T) {
+	path := writeFile(t, "disabled_gateways: [\"Gateway 1\"]\n")
+	live := NewLive(Runtime{}, "default")
+	w := &Watcher{Path: path, Interval: time.Hour, Live: live}
+
+	if err := w.Reload("test"); err != nil {
+		t.Fatal(err)
+	}
+	loaded := live.Current()
+	if loaded.Source != path || !loaded.Runtime.GatewayDisabled("Gateway 1") {
+		t.Fatalf("Expected the file to be loaded, got %+v", loaded)
+	}
+
+	if err := os.WriteFile(path, []byte("limits:\n  - type: refund\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Reload("test"); err == nil {
+		t.Errorf("Expected an invalid file to be rejected")
+	}
+	if live.Current() != loaded {
+		t.Errorf("Expected the configuration in effect to be kept after a rejected reload")
+	}
+
+	if err := os.WriteFile(path, []byte("disabled_gateways: [\"Gateway 9\"]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	w.Check = func(rt Runtime) error {
+		if rt.GatewayDisabled("Gateway 9") {
+			return os.ErrNotExist
+		}
+		return nil
+	}
+	if err := w.Reload("test"); err == nil || live.Current() != loaded {
+		t.Errorf("Expected a reload failing Check to be rejected")
+	}
+}
+
+func TestWatcherReloadsOnSignal(t *testing.T) {
+	path := writeFile(t, "")
+	live := NewLive(Runtime{}, "default")
+	w := &Watcher{Path: path, Interval: time.Hour, Live: live}
+	if err := w.Reload("start"); err != nil {
+		t.Fatal(err)
+	}
+
+	signals := make(chan os.Signal)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx, signals)
+		close(done)
+	}()
+
+	if err := os.WriteFile(path, []byte("disabled_gateways: [\"Gateway 1\"]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	signals <- syscall.SIGHUP
+	// the watcher takes the next signal only after it has handled the first one
+	signals <- syscall.SIGHUP
+	cancel()
+	<-done
+
+	if !live.Current().Runtime.GatewayDisabled("Gateway 1") {
+		t.Errorf("Expected SIGHUP to reload the file, got %+v", live.Current())
+	}
+}
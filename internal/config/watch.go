@@ -0,0 +1,104 @@
+package config
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// The runtime configuration in effect at some point
+type Snapshot struct {
+	Runtime  Runtime   `json:"config"`
+	Version  string    `json:"version"`
+	Source   string    `json:"source"`
+	LoadedAt time.Time `json:"loaded_at"`
+}
+
+// Holds the runtime configuration in effect. Readers take a Snapshot and keep using it for the rest of the request,
+// a reload swaps in a new one so nobody sees routing from one version and limits from another.
+type Live struct {
+	current atomic.Pointer[Snapshot]
+}
+
+func NewLive(rt Runtime, source string) *Live {
+	l := &Live{}
+	l.Store(rt, source)
+	return l
+}
+
+func (l *Live) Current() *Snapshot {
+	return l.current.Load()
+}
+
+func (l *Live) Store(rt Runtime, source string) *Snapshot {
+	snapshot := &Snapshot{Runtime: rt, Version: rt.Version(), Source: source, LoadedAt: time.Now()}
+	l.current.Store(snapshot)
+	return snapshot
+}
+
+// Reloads the runtime configuration from Path into Live when the file changes or a signal arrives
+type Watcher struct {
+	Path string
+	// how often the file is checked for changes
+	Interval time.Duration
+	Live     *Live
+	// further checks a new configuration has to pass, such as the gateways it names existing
+	Check func(Runtime) error
+
+	modTime time.Time
+	size    int64
+}
+
+// Loads the file and swaps it in if it is valid. An invalid file leaves the configuration in effect untouched.
+func (w *Watcher) Reload(trigger string) error {
+	if info, err := os.Stat(w.Path); err == nil {
+		w.modTime, w.size = info.ModTime(), info.Size()
+	}
+
+	rt, err := LoadRuntime(w.Path)
+	if err == nil && w.Check != nil {
+		err = w.Check(rt)
+	}
+	if err != nil {
+		log.Printf("Rejected runtime config reload on %s, keeping version %s: %v", trigger, w.Live.Current().Version, err)
+		return err
+	}
+
+	previous := w.Live.Current()
+	if rt.Version() == previous.Version {
+		return nil
+	}
+	current := w.Live.Store(rt, w.Path)
+	log.Printf("Runtime config reloaded on %s: version %s -> %s, %d routing rule(s), %d limit(s), %d disabled gateway(s)",
+		trigger, previous.Version, current.Version, len(rt.Routing), len(rt.Limits), len(rt.DisabledGateways))
+	return nil
+}
+
+func (w *Watcher) changed() bool {
+	info, err := os.Stat(w.Path)
+	if err != nil {
+		return false
+	}
+	return !info.ModTime().Equal(w.modTime) || info.Size() != w.size
+}
+
+// Watches until ctx is cancelled. Every value received on signals, such as SIGHUP, forces a reload.
+func (w *Watcher) Run(ctx context.Context, signals <-chan os.Signal) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-signals:
+			w.Reload(sig.String())
+		case <-ticker.C:
+			if w.changed() {
+				w.Reload("file change")
+			}
+		}
+	}
+}
@@ -3,77 +3,97 @@ package kafka
 import (
 	"context"
 	"fmt"
-	"log"
-	"os"
+	"payment-gateway/internal/logging"
+	"payment-gateway/internal/publisher"
+	"sort"
 	"time"
 
 	"github.com/segmentio/kafka-go"
 )
 
-var writer *kafka.Writer
-
-// Initialize the Kafka writer
-func init() {
-	kafkaURL := os.Getenv("KAFKA_BROKER_URL")
-	if kafkaURL == "" {
-		kafkaURL = "kafka:9092"
-	}
+// Publishes transactions to Kafka, one topic per data format
+type Publisher struct {
+	brokerURL string
+	writer    *kafka.Writer
+	logger    *logging.Logger
+}
 
-	writer = &kafka.Writer{
-		Addr:                   kafka.TCP(kafkaURL),
+// Creates the Kafka writer. Nothing is dialled until the first message is published. Messages are sent in
+// batches flushed at least every batchTimeout.
+func NewPublisher(brokerURL string, batchTimeout time.Duration, logger *logging.Logger) *Publisher {
+	writer := &kafka.Writer{
+		Addr:                   kafka.TCP(brokerURL),
 		Balancer:               &kafka.LeastBytes{},
 		AllowAutoTopicCreation: true,
-		BatchTimeout:           10 * time.Millisecond,
+		BatchTimeout:           batchTimeout,
 	}
 
-	log.Println("Kafka writer initialized successfully.")
-}
-
-// returns the appropriate Kafka topic based on the data format.
-func GetTopic(dataFormat string) (string, error) {
-	switch dataFormat {
-	case "application/json":
-		return "transactions.json", nil
-	case "text/xml":
-		return "transactions.soap", nil
-	case "application/xml":
-		return "transactions.soap", nil
-	default:
-		return "", fmt.Errorf("unsupported data format: %s", dataFormat)
-	}
+	logger.Info(context.Background(), "Kafka writer initialized", "broker", brokerURL)
+	return &Publisher{brokerURL: brokerURL, writer: writer, logger: logger}
 }
 
 // publishes a message to the Kafka topic
-func PublishTransaction(ctx context.Context, transactionID string, message []byte, dataFormat string) error {
-	if writer == nil {
-		log.Println("Kafka writer is nil, cannot publish to Kafka.")
-		return fmt.Errorf("Kafka writer is not initialized")
-	}
-
-	topic, err := GetTopic(dataFormat)
+func (p *Publisher) PublishTransaction(ctx context.Context, transactionID string, message []byte, dataFormat string) error {
+	topic, err := publisher.Topic(dataFormat)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("Publishing message to Kafka topic: %s...", topic)
+	if err := publisher.RegisterSchema(topic); err != nil {
+		return err
+	}
 
 	kafkaMessage := kafka.Message{
 		Key:   []byte(transactionID),
 		Value: message,
 		Topic: topic,
 	}
+	headers := publisher.HeadersFrom(ctx)
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		kafkaMessage.Headers = append(kafkaMessage.Headers, kafka.Header{Key: key, Value: []byte(headers[key])})
+	}
 
-	err = writer.WriteMessages(ctx, kafkaMessage)
-	if err != nil {
-		log.Printf("Error publishing to Kafka: %v", err)
+	if err := p.writer.WriteMessages(ctx, kafkaMessage); err != nil {
+		p.logger.Error(ctx, "Error publishing to Kafka", "topic", topic, "transaction_id", transactionID, "error", err)
 		return err
 	}
 
-	log.Println("Message successfully published to Kafka on topic " + string(topic))
+	p.logger.Debug(ctx, "Message published to Kafka", "topic", topic, "transaction_id", transactionID)
+	return nil
+}
+
+// Connects to the broker and asks it for the cluster metadata, the writer itself only dials when it has messages
+func (p *Publisher) Ping(ctx context.Context) error {
+	conn, err := kafka.DialContext(ctx, "tcp", p.brokerURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %v", p.brokerURL, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Brokers(); err != nil {
+		return fmt.Errorf("failed to read the brokers from %s: %v", p.brokerURL, err)
+	}
 	return nil
 }
 
 // Close the writer when the system shut down
-func Close() error {
-	return writer.Close()
+func (p *Publisher) Close() error {
+	return p.writer.Close()
+}
+
+// Restores the request ID and trace context a message was published with, so consumers continue the trace of the
+// request that produced it
+func ContextFromMessage(ctx context.Context, msg kafka.Message) context.Context {
+	headers := publisher.Headers{}
+	for _, header := range msg.Headers {
+		headers[header.Key] = string(header.Value)
+	}
+	return publisher.ContextFromHeaders(ctx, headers)
 }
@@ -0,0 +1,40 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// The header a request ID is taken from and returned in, also set on the messages published for the request
+const RequestIDHeader = "X-Request-ID"
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, "requestID", id)
+}
+
+// Returns the ID of the request ctx belongs to, empty outside of requests
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value("requestID").(string)
+	return id
+}
+
+func NewRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Accepts IDs from clients and upstream proxies only if they are short and plain, so they can't inject anything
+// into logs or headers
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,163 @@
+// Package logging writes structured JSON log lines. Every line carries the ID of the request it belongs to and the
+// trace it is part of, and fields holding personal or financial data are masked according to a Policy before they
+// are written.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+func ParseLevel(value string) (Level, error) {
+	for _, level := range []Level{LevelDebug, LevelInfo, LevelWarn, LevelError} {
+		if strings.EqualFold(value, level.String()) {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", value)
+}
+
+// Writes one JSON object per line. The fields of a line are given as alternating keys and values, as in
+// logger.Info(ctx, "transaction created", "transaction_id", 42, "amount", amount).
+type Logger struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	level  Level
+	policy Policy
+	clock  func() time.Time
+	fields []any
+}
+
+func New(out io.Writer, level Level, policy Policy) *Logger {
+	return &Logger{mu: &sync.Mutex{}, out: out, level: level, policy: policy, clock: time.Now}
+}
+
+// Discards everything, for tests
+func Discard() *Logger {
+	return New(io.Discard, LevelError+1, nil)
+}
+
+// Returns a logger adding the fields to every line it writes
+func (l *Logger) With(keyvals ...any) *Logger {
+	child := *l
+	child.fields = append(append([]any(nil), l.fields...), keyvals...)
+	return &child
+}
+
+func (l *Logger) Debug(ctx context.Context, msg string, keyvals ...any) {
+	l.log(ctx, LevelDebug, msg, keyvals)
+}
+
+func (l *Logger) Info(ctx context.Context, msg string, keyvals ...any) {
+	l.log(ctx, LevelInfo, msg, keyvals)
+}
+
+func (l *Logger) Warn(ctx context.Context, msg string, keyvals ...any) {
+	l.log(ctx, LevelWarn, msg, keyvals)
+}
+
+func (l *Logger) Error(ctx context.Context, msg string, keyvals ...any) {
+	l.log(ctx, LevelError, msg, keyvals)
+}
+
+func (l *Logger) log(ctx context.Context, level Level, msg string, keyvals []any) {
+	if level < l.level {
+		return
+	}
+
+	var line bytes.Buffer
+	line.WriteByte('{')
+	writeField(&line, "time", l.clock().UTC().Format(time.RFC3339Nano), true)
+	writeField(&line, "level", level.String(), false)
+	writeField(&line, "msg", msg, false)
+	if id := RequestID(ctx); id != "" {
+		writeField(&line, "request_id", id, false)
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		writeField(&line, "trace_id", span.TraceID().String(), false)
+		writeField(&line, "span_id", span.SpanID().String(), false)
+	}
+	for _, fields := range [][]any{l.fields, keyvals} {
+		for i := 0; i < len(fields); i += 2 {
+			key, ok := fields[i].(string)
+			if !ok {
+				key = fmt.Sprint(fields[i])
+			}
+			var value any = "!MISSING"
+			if i+1 < len(fields) {
+				value = fields[i+1]
+			}
+			writeField(&line, key, l.policy.apply(key, value), false)
+		}
+	}
+	line.WriteString("}\n")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(line.Bytes())
+}
+
+func writeField(line *bytes.Buffer, key string, value any, first bool) {
+	if !first {
+		line.WriteByte(',')
+	}
+	encodedKey, _ := json.Marshal(key)
+	line.Write(encodedKey)
+	line.WriteByte(':')
+
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(value))
+	}
+	line.Write(encoded)
+}
+
+// Returns a writer turning every line written to it into an info line, for log.SetOutput so the lines of the
+// standard logger are structured too
+func (l *Logger) StdWriter() io.Writer {
+	return stdWriter{l}
+}
+
+type stdWriter struct {
+	logger *Logger
+}
+
+func (w stdWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		w.logger.Info(context.Background(), line)
+	}
+	return len(p), nil
+}
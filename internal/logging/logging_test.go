@@ -0,0 +1,105 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+)
+
+func decodeLines(t *testing.T, out *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var fields map[string]any
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("Expected a JSON line, got %q: %v", line, err)
+		}
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+func TestLoggerWritesJSONWithRequestID(t *testing.T) {
+	var out bytes.Buffer
+	logger := New(&out, LevelInfo, DefaultPolicy()).With("component", "test")
+	ctx := WithRequestID(context.Background(), "abc-123")
+
+	logger.Debug(ctx, "hidden")
+	logger.Error(ctx, "Unable to publish", "transaction_id", 42, "error", errors.New("broker unreachable"), "dangling")
+
+	lines := decodeLines(t, &out)
+	if len(lines) != 1 {
+		t.Fatalf("Expected debug lines to be filtered out, got %v", lines)
+	}
+	line := lines[0]
+	for key, expected := range map[string]any{
+		"level": "error", "msg": "Unable to publish", "request_id": "abc-123", "component": "test",
+		"transaction_id": float64(42), "error": "broker unreachable", "dangling": "!MISSING",
+	} {
+		if line[key] != expected {
+			t.Errorf("Expected %s to be %v, got %v", key, expected, line[key])
+		}
+	}
+	if _, ok := line["time"]; !ok {
+		t.Errorf("Expected a time, got %v", line)
+	}
+}
+
+func TestLoggerMasksFields(t *testing.T) {
+	var out bytes.Buffer
+	logger := New(&out, LevelInfo, DefaultPolicy())
+	logger.Info(context.Background(), "Transaction created", "user_id", 1234567, "email", "john.smith@example.com",
+		"amount", "250.00", "iban", "GB82WEST12345698765432", "currency", "USD", "code", "482913")
+
+	line := decodeLines(t, &out)[0]
+	for key, expected := range map[string]any{
+		"user_id": "*****67", "email": "j***@example.com", "amount": "[REDACTED]",
+		"iban": "******************5432", "currency": "USD", "code": "[REDACTED]",
+	} {
+		if line[key] != expected {
+			t.Errorf("Expected %s to be %v, got %v", key, expected, line[key])
+		}
+	}
+	if strings.Contains(out.String(), "250.00") || strings.Contains(out.String(), "john.smith") {
+		t.Errorf("Expected the sensitive values not to be logged, got %s", out.String())
+	}
+}
+
+func TestMaskPartialHidesShortValues(t *testing.T) {
+	if masked := MaskPartial(2)("42"); masked != "**" {
+		t.Errorf("Expected a short ID to be masked entirely, got %s", masked)
+	}
+	if masked := MaskEmail("not-an-email"); masked != "[REDACTED]" {
+		t.Errorf("unexpected %s", masked)
+	}
+}
+
+func TestStdWriter(t *testing.T) {
+	var out bytes.Buffer
+	std := log.New(New(&out, LevelInfo, nil).StdWriter(), "", 0)
+	std.Printf("Applied migration %d", 1)
+
+	line := decodeLines(t, &out)[0]
+	if line["msg"] != "Applied migration 1" || line["level"] != "info" {
+		t.Errorf("unexpected line %v", line)
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	for id, valid := range map[string]bool{
+		"abc-123":                true,
+		NewRequestID():           true,
+		"":                       false,
+		"id\nwith newline":       false,
+		strings.Repeat("a", 129): false,
+		`"quoted"`:               false,
+	} {
+		if ValidRequestID(id) != valid {
+			t.Errorf("Expected %q valid to be %v", id, valid)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Masks the value of a field before it is logged
+type Mask func(value string) string
+
+// Maps field names onto how their values are masked. Fields not in the policy are logged as they are.
+type Policy map[string]Mask
+
+// Masks what identifies users or reveals how much they move: user IDs, emails, amounts and bank details, and the
+// one-time codes confirming withdrawals
+func DefaultPolicy() Policy {
+	return Policy{
+		"code":             MaskAll,
+		"user_id":          MaskPartial(2),
+		"email":            MaskEmail,
+		"amount":           MaskAll,
+		"iban":             MaskPartial(4),
+		"beneficiary_name": MaskAll,
+	}
+}
+
+func (p Policy) apply(key string, value any) any {
+	mask, ok := p[key]
+	if !ok || value == nil {
+		return value
+	}
+	return mask(fmt.Sprint(value))
+}
+
+func MaskAll(value string) string {
+	return "[REDACTED]"
+}
+
+// Keeps the last n characters so values can still be told apart, masks everything when the value is too short
+// for that to hide anything
+func MaskPartial(n int) Mask {
+	return func(value string) string {
+		if len(value) <= 2*n {
+			return strings.Repeat("*", len(value))
+		}
+		return strings.Repeat("*", len(value)-n) + value[len(value)-n:]
+	}
+}
+
+// Keeps the first character of the local part and the domain, john.smith@example.com becomes j***@example.com
+func MaskEmail(value string) string {
+	at := strings.LastIndex(value, "@")
+	if at < 1 {
+		return MaskAll(value)
+	}
+	return value[:1] + "***" + value[at:]
+}
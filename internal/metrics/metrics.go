@@ -0,0 +1,132 @@
+// Package metrics holds the Prometheus metrics of the payment pipeline. Every Metrics has its own registry so
+// servers created in tests don't share counters or collide on registration.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sony/gobreaker"
+)
+
+const namespace = "payment_gateway"
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	transactions    *prometheus.CounterVec
+	publishDuration *prometheus.HistogramVec
+	publishErrors   *prometheus.CounterVec
+	retryAttempts   *prometheus.CounterVec
+	breakerState    *prometheus.GaugeVec
+	rateLimited     *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests handled, by route and the status code of the response.",
+		}, []string{"method", "route", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to handle HTTP requests, by route and the status code of the response.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "code"}),
+		transactions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "transactions_total",
+			Help:      "Transactions created (SENT) and settled (SUCCESS or FAILED).",
+		}, []string{"type", "status", "gateway", "country", "currency"}),
+		publishDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "publish_duration_seconds",
+			Help:      "Time taken to publish a transaction to its gateway, failed attempts included.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"data_format"}),
+		publishErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "publish_errors_total",
+			Help:      "Failed attempts at publishing a transaction to its gateway.",
+		}, []string{"data_format"}),
+		retryAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "retry_attempts_total",
+			Help:      "Attempts made by retried operations, by attempt number and outcome.",
+		}, []string{"attempt", "outcome"}),
+		breakerState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "circuit_breaker_state",
+			Help:      "1 for the state each circuit breaker is in, 0 for the others.",
+		}, []string{"name", "state"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_limited_requests_total",
+			Help:      "Requests refused with 429, by the budget they exceeded.",
+		}, []string{"budget"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.requestDuration, m.transactions, m.publishDuration, m.publishErrors, m.retryAttempts, m.breakerState, m.rateLimited,
+	)
+	return m
+}
+
+// Serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// route is the path template, such as /deposit/{id}, so IDs don't each get their own series
+func (m *Metrics) ObserveRequest(method, route string, code int, duration time.Duration) {
+	labels := prometheus.Labels{"method": method, "route": route, "code": strconv.Itoa(code)}
+	m.requests.With(labels).Inc()
+	m.requestDuration.With(labels).Observe(duration.Seconds())
+}
+
+func (m *Metrics) CountTransaction(txType, status, gateway, country, currency string) {
+	m.transactions.WithLabelValues(txType, status, gateway, country, currency).Inc()
+}
+
+func (m *Metrics) ObservePublish(dataFormat string, duration time.Duration, err error) {
+	m.publishDuration.WithLabelValues(dataFormat).Observe(duration.Seconds())
+	if err != nil {
+		m.publishErrors.WithLabelValues(dataFormat).Inc()
+	}
+}
+
+// Matches services.RetryPolicy.OnAttempt
+func (m *Metrics) CountRetryAttempt(attempt int, err error) {
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	m.retryAttempts.WithLabelValues(strconv.Itoa(attempt), outcome).Inc()
+}
+
+// Matches services.BreakerSettings.OnStateChange
+func (m *Metrics) SetBreakerState(name string, from, to gobreaker.State) {
+	for _, state := range []gobreaker.State{gobreaker.StateClosed, gobreaker.StateHalfOpen, gobreaker.StateOpen} {
+		value := 0.0
+		if state == to {
+			value = 1
+		}
+		m.breakerState.WithLabelValues(name, state.String()).Set(value)
+	}
+}
+
+// budget is the one the request exceeded, such as key_writes
+func (m *Metrics) CountRateLimited(budget string) {
+	m.rateLimited.WithLabelValues(budget).Inc()
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	decimal "github.com/shopspring/decimal"
@@ -9,21 +10,34 @@ import (
 // a standard request structure for the transactions
 type TransactionRequest struct {
 	TransactionID int             `json:"transaction_id" xml:"transaction_id"`
-	Type          string          `json:"type" xml:"type"` // deposit or withdrawal
+	Type          string          `json:"type" xml:"type"` // deposit, withdrawal or refund
 	Amount        decimal.Decimal `json:"amount" xml:"amount"`
 	UserID        int             `json:"user_id" xml:"user_id"`
 	CountryID     int             `json:"country_id" xml:"country_id"`
 	Currency      string          `json:"currency" xml:"currency"`
 	GatewayID     int             `json:"gateway_id" xml:"gateway_id"`
+	Beneficiary   *Beneficiary    `json:"beneficiary,omitempty" xml:"beneficiary,omitempty"` // withdrawals only
+	// the deposit a refund gives back, refunds only
+	OriginalTransactionID int `json:"original_transaction_id,omitempty" xml:"original_transaction_id,omitempty"`
+}
+
+// the account a withdrawal is paid out to, required by bank payout gateways
+type Beneficiary struct {
+	Name    string `json:"name" xml:"name"`
+	IBAN    string `json:"iban" xml:"iban"`
+	BIC     string `json:"bic,omitempty" xml:"bic,omitempty"`
+	Country string `json:"country,omitempty" xml:"country,omitempty"` // ISO 3166 alpha-2
 }
 
 type TransactionRequestEncrypted struct {
-	Type      string `json:"type" xml:"type"` // deposit or withdrawal
+	Type      string `json:"type" xml:"type"` // deposit, withdrawal or refund
 	Amount    string `json:"amount" xml:"amount"`
 	UserID    string `json:"user_id" xml:"user_id"`
 	CountryID string `json:"country_id" xml:"country_id"`
 	Currency  string `json:"currency" xml:"currency"`
 	GatewayID int    `json:"gateway_id" xml:"gateway_id"`
+	// not encrypted, the gateway matches the refund to the deposit it received earlier
+	OriginalTransactionID int `json:"original_transaction_id,omitempty" xml:"original_transaction_id,omitempty"`
 }
 
 type Error struct {
@@ -36,12 +50,15 @@ type APIResponse[T any] struct {
 	StatusCode int    `json:"status_code" xml:"status_code"`
 	Data       T      `json:"data,omitempty" xml:"data,omitempty"`
 	Error      *Error `json:"error,omitempty" xml:"error,omitempty"`
+	// the X-Request-ID of the request, to quote when reporting a problem
+	RequestID string `json:"request_id,omitempty" xml:"request_id,omitempty"`
 }
 
 type WithdrawalRequest struct {
-	Amount   decimal.Decimal `json:"amount" xml:"amount"`
-	UserID   int             `json:"user_id" xml:"user_id"`
-	Currency string          `json:"currency" xml:"currency"`
+	Amount      decimal.Decimal `json:"amount" xml:"amount"`
+	UserID      int             `json:"user_id" xml:"user_id"`
+	Currency    string          `json:"currency" xml:"currency"`
+	Beneficiary *Beneficiary    `json:"beneficiary,omitempty" xml:"beneficiary,omitempty"`
 }
 
 type DepositRequest struct {
@@ -60,6 +77,47 @@ type DepositPutRequest struct {
 	Status        string `json:"status" xml:"status"`
 }
 
+// gives back part or all of a successful deposit
+type RefundRequest struct {
+	Amount decimal.Decimal `json:"amount" xml:"amount"`
+	// defaults to the deposit's, only required for deposits made before transactions recorded their currency
+	Currency string `json:"currency,omitempty" xml:"currency,omitempty"`
+}
+
+type RefundPutRequest struct {
+	TransactionID int    `json:"transaction_id" xml:"transaction_id"`
+	Status        string `json:"status" xml:"status"`
+}
+
+// a gateway reporting that the user disputed a deposit
+type ChargebackRequest struct {
+	// the disputed deposit
+	TransactionID int             `json:"transaction_id" xml:"transaction_id"`
+	Amount        decimal.Decimal `json:"amount" xml:"amount"`
+	Reason        string          `json:"reason,omitempty" xml:"reason,omitempty"`
+}
+
+// The outcome of a disputed deposit. success when the chargeback stands and the money goes back to the user, failed
+// when the dispute was decided for the merchant.
+type ChargebackPutRequest struct {
+	TransactionID int    `json:"transaction_id" xml:"transaction_id"`
+	Status        string `json:"status" xml:"status"`
+}
+
+// the one-time code confirming a withdrawal in DRAFT
+type WithdrawalConfirmRequest struct {
+	Code string `json:"code" xml:"code"`
+}
+
+// a withdrawal waiting for its confirmation code, sent to the user out of band
+type WithdrawalChallengeResponse struct {
+	TransactionID int       `json:"transaction_id" xml:"transaction_id"`
+	Status        string    `json:"status" xml:"status"`
+	ExpiresAt     time.Time `json:"expires_at" xml:"expires_at"`
+	// where the code is posted
+	ConfirmURL string `json:"confirm_url" xml:"confirm_url"`
+}
+
 type WithdrawalResponse struct {
 	TransactionID int       `json:"transaction_id" xml:"transaction_id"`
 	Created       time.Time `json:"created" xml:"created"`
@@ -71,3 +129,144 @@ type DepositResponse struct {
 	Created       time.Time `json:"created" xml:"created"`
 	Status        string    `json:"status" xml:"status"`
 }
+
+// emitted whenever a transaction changes status
+type TransactionStatusEvent struct {
+	TransactionID int    `json:"transaction_id" xml:"transaction_id"`
+	Type          string `json:"type" xml:"type"`
+	Status        string `json:"status" xml:"status"`
+	GatewayID     int    `json:"gateway_id" xml:"gateway_id"`
+}
+
+// the outcome of applying one entry of a gateway status report
+type StatusReportResult struct {
+	TransactionID int    `json:"transaction_id" xml:"transaction_id"`
+	Status        string `json:"status,omitempty" xml:"status,omitempty"`
+	Error         string `json:"error,omitempty" xml:"error,omitempty"`
+}
+
+// an asynchronous batch of transactions and its progress
+type BatchJobResponse struct {
+	BatchID       int             `json:"batch_id" xml:"batch_id"`
+	Status        string          `json:"status" xml:"status"`
+	TotalRows     int             `json:"total_rows" xml:"total_rows"`
+	ProcessedRows int             `json:"processed_rows" xml:"processed_rows"`
+	SucceededRows int             `json:"succeeded_rows" xml:"succeeded_rows"`
+	FailedRows    int             `json:"failed_rows" xml:"failed_rows"`
+	Created       time.Time       `json:"created" xml:"created"`
+	Completed     *time.Time      `json:"completed,omitempty" xml:"completed,omitempty"`
+	ResultURL     string          `json:"result_url" xml:"result_url"`
+	Errors        []BatchRowError `json:"errors,omitempty" xml:"errors>error,omitempty"`
+}
+
+type BatchRowError struct {
+	Row    int    `json:"row" xml:"row"`
+	Status string `json:"status" xml:"status"`
+	Error  string `json:"error" xml:"error"`
+}
+
+type AdminGatewayRequest struct {
+	Name                string   `json:"name" xml:"name"`
+	DataFormatSupported string   `json:"data_format_supported" xml:"data_format_supported"`
+	DataFormats         []string `json:"data_formats,omitempty" xml:"data_formats>data_format,omitempty"`
+}
+
+type AdminCountryRequest struct {
+	Name string `json:"name" xml:"name"`
+	Code string `json:"code" xml:"code"` // ISO 3166 alpha-2
+}
+
+type AdminCurrencyRequest struct {
+	Symbol string `json:"symbol" xml:"symbol"` // ISO 4217
+}
+
+type AdminMerchantRequest struct {
+	Name string `json:"name" xml:"name"`
+}
+
+type AdminMerchantWebhookRequest struct {
+	// where the codes confirming the merchant's withdrawals are posted, empty to remove the webhook
+	WebhookURL string `json:"webhook_url" xml:"webhook_url"`
+}
+
+// The webhook of a merchant. WebhookSecret signs what is posted to it and is only returned when the webhook is
+// set, it can't be read back later.
+type MerchantWebhookResponse struct {
+	MerchantID    int    `json:"merchant_id" xml:"merchant_id"`
+	WebhookURL    string `json:"webhook_url" xml:"webhook_url"`
+	WebhookSecret string `json:"webhook_secret,omitempty" xml:"webhook_secret,omitempty"`
+}
+
+type AdminAPIKeyRequest struct {
+	// the merchant the key acts for, omitted for operator keys with only the admin and transactions:settle scopes
+	// and roles
+	MerchantID int `json:"merchant_id,omitempty" xml:"merchant_id,omitempty"`
+	// who the key is for, shown in listings
+	Name   string   `json:"name" xml:"name"`
+	Scopes []string `json:"scopes" xml:"scopes>scope"`
+	// what an operator key may do in the admin API, e.g. viewer or finance
+	Roles []string `json:"roles,omitempty" xml:"roles>role,omitempty"`
+	// the key signs its requests with a signing secret instead of sending its secret along
+	Signed bool `json:"signed" xml:"signed"`
+}
+
+// An API key as listed by the admin API. Key and SigningSecret are only returned when the key is created or
+// rotated, they can't be read back later.
+type APIKeyResponse struct {
+	ID            string     `json:"id" xml:"id"`
+	MerchantID    int        `json:"merchant_id,omitempty" xml:"merchant_id,omitempty"`
+	Name          string     `json:"name" xml:"name"`
+	Scopes        []string   `json:"scopes" xml:"scopes>scope"`
+	Roles         []string   `json:"roles,omitempty" xml:"roles>role,omitempty"`
+	Signed        bool       `json:"signed" xml:"signed"`
+	CreatedAt     time.Time  `json:"created_at" xml:"created_at"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty" xml:"revoked_at,omitempty"`
+	Key           string     `json:"key,omitempty" xml:"key,omitempty"`
+	SigningSecret string     `json:"signing_secret,omitempty" xml:"signing_secret,omitempty"`
+}
+
+// An entry of the audit log. Before and After are the JSON recorded with it.
+type AuditEntryResponse struct {
+	ID        int             `json:"id" xml:"id"`
+	Actor     string          `json:"actor" xml:"actor"`
+	Roles     []string        `json:"roles,omitempty" xml:"roles>role,omitempty"`
+	Action    string          `json:"action" xml:"action"`
+	Entity    string          `json:"entity" xml:"entity"`
+	EntityID  string          `json:"entity_id" xml:"entity_id"`
+	RequestID string          `json:"request_id,omitempty" xml:"request_id,omitempty"`
+	Before    json.RawMessage `json:"before,omitempty" xml:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty" xml:"after,omitempty"`
+	CreatedAt time.Time       `json:"created_at" xml:"created_at"`
+	PrevHash  string          `json:"prev_hash" xml:"prev_hash"`
+	Hash      string          `json:"hash" xml:"hash"`
+}
+
+// A page of the audit log. NextAfterID is the after_id of the next page, 0 on the last one.
+type AuditEntriesResponse struct {
+	Entries     []AuditEntryResponse `json:"entries" xml:"entries>entry"`
+	NextAfterID int                  `json:"next_after_id,omitempty" xml:"next_after_id,omitempty"`
+}
+
+// the outcome of the latest check of something the service depends on, and the latest failure
+type DependencyStatus struct {
+	Name        string     `json:"name" xml:"name"`
+	Healthy     bool       `json:"healthy" xml:"healthy"`
+	LatencyMS   float64    `json:"latency_ms" xml:"latency_ms"`
+	Error       string     `json:"error,omitempty" xml:"error,omitempty"`
+	CheckedAt   time.Time  `json:"checked_at" xml:"checked_at"`
+	LastError   string     `json:"last_error,omitempty" xml:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty" xml:"last_error_at,omitempty"`
+}
+
+type ReadinessResponse struct {
+	Status       string             `json:"status" xml:"status"` // ready, not ready or shutting down
+	Dependencies []DependencyStatus `json:"dependencies,omitempty" xml:"dependencies>dependency,omitempty"`
+}
+
+type StatusResponse struct {
+	Status               string             `json:"status" xml:"status"`
+	StartedAt            time.Time          `json:"started_at" xml:"started_at"`
+	UptimeSeconds        int64              `json:"uptime_seconds" xml:"uptime_seconds"`
+	RuntimeConfigVersion string             `json:"runtime_config_version" xml:"runtime_config_version"`
+	Dependencies         []DependencyStatus `json:"dependencies" xml:"dependencies>dependency"`
+}
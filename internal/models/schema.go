@@ -0,0 +1,8 @@
+package models
+
+import _ "embed"
+
+// the protobuf schema for the messages published on the transactions.proto topic
+//
+//go:embed transactions.proto
+var TransactionsProtoSchema string
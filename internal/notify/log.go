@@ -0,0 +1,25 @@
+package notify
+
+import (
+	"context"
+	"io"
+	"payment-gateway/internal/logging"
+)
+
+// Logs the codes instead of delivering them, anyone reading the log can confirm withdrawals. For local
+// development only: the service's own logger masks the codes, this one writes them out.
+type Log struct {
+	logger *logging.Logger
+}
+
+func NewLog(out io.Writer) *Log {
+	policy := logging.DefaultPolicy()
+	delete(policy, "code")
+	return &Log{logger: logging.New(out, logging.LevelInfo, policy)}
+}
+
+func (l *Log) Send(ctx context.Context, challenge Challenge) error {
+	l.logger.Info(ctx, "Withdrawal confirmation code", "transaction_id", challenge.TransactionID, "user_id", challenge.UserID,
+		"code", challenge.Code, "expires_at", challenge.ExpiresAt)
+	return nil
+}
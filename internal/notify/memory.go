@@ -0,0 +1,41 @@
+package notify
+
+import (
+	"context"
+	"sync"
+)
+
+// Records the challenges instead of delivering them
+type Memory struct {
+	mu         sync.Mutex
+	challenges []Challenge
+	err        error
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) Send(ctx context.Context, challenge Challenge) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.challenges = append(m.challenges, challenge)
+	return nil
+}
+
+// Makes every following send fail with err, or succeed again when err is nil
+func (m *Memory) FailWith(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = err
+}
+
+// Returns a copy of the challenges sent so far, oldest first
+func (m *Memory) Challenges() []Challenge {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Challenge(nil), m.challenges...)
+}
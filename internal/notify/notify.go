@@ -0,0 +1,22 @@
+// Package notify delivers the one-time codes confirming withdrawals to the users making them. Webhook hands them to
+// a service that reaches the user, e.g. by SMS, Log writes them to the service's log for local development and
+// Memory records them for tests.
+package notify
+
+import (
+	"context"
+	"time"
+)
+
+// A code the user has to send back to confirm a withdrawal before it expires
+type Challenge struct {
+	TransactionID int       `json:"transaction_id"`
+	MerchantID    int       `json:"merchant_id"`
+	UserID        int       `json:"user_id"`
+	Code          string    `json:"code"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+type Notifier interface {
+	Send(ctx context.Context, challenge Challenge) error
+}
@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"payment-gateway/internal/logging"
+	"testing"
+	"time"
+)
+
+func TestWebhookPostsChallenge(t *testing.T) {
+	var got Challenge
+	var requestID string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID = r.Header.Get(logging.RequestIDHeader)
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" || r.URL.Path != "/merchant-2" ||
+			r.Header.Get(SignatureHeader) != Sign("secret-2", r.Header.Get(TimestampHeader), body) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.Unmarshal(body, &got)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	endpoints := func(ctx context.Context, merchantID int) (Endpoint, error) {
+		return Endpoint{URL: fmt.Sprintf("%s/merchant-%d", server.URL, merchantID), Secret: fmt.Sprintf("secret-%d", merchantID)}, nil
+	}
+
+	challenge := Challenge{TransactionID: 7, MerchantID: 2, UserID: 3, Code: "123456", ExpiresAt: time.Date(2024, 3, 1, 10, 15, 0, 0, time.UTC)}
+	ctx := logging.WithRequestID(context.Background(), "req-1")
+	if err := NewWebhook(endpoints, 0).Send(ctx, challenge); err != nil {
+		t.Fatal(err)
+	}
+	if got != challenge || requestID != "req-1" {
+		t.Errorf("Expected the challenge with the request ID, got %+v and %q", got, requestID)
+	}
+}
+
+func TestWebhookFailsOnErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	endpoints := func(ctx context.Context, merchantID int) (Endpoint, error) {
+		return Endpoint{URL: server.URL, Secret: "secret"}, nil
+	}
+
+	if err := NewWebhook(endpoints, time.Second).Send(context.Background(), Challenge{TransactionID: 1}); err == nil {
+		t.Errorf("Expected an error when the webhook refuses the challenge")
+	}
+}
+
+func TestWebhookFailsWithoutMerchantWebhook(t *testing.T) {
+	endpoints := func(ctx context.Context, merchantID int) (Endpoint, error) {
+		if merchantID == 1 {
+			return Endpoint{}, nil
+		}
+		return Endpoint{}, errors.New("no such merchant")
+	}
+
+	for _, merchantID := range []int{1, 2} {
+		if err := NewWebhook(endpoints, time.Second).Send(context.Background(), Challenge{MerchantID: merchantID}); err == nil {
+			t.Errorf("Expected an error delivering a challenge of merchant %d", merchantID)
+		}
+	}
+}
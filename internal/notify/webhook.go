@@ -0,0 +1,91 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"payment-gateway/internal/logging"
+	"strconv"
+	"time"
+)
+
+// Headers authenticating what Webhook posts, the same way the API authenticates signed requests
+const (
+	TimestampHeader = "X-Timestamp"
+	SignatureHeader = "X-Signature"
+)
+
+// Where the challenges of a merchant are posted and the secret signing them
+type Endpoint struct {
+	URL    string
+	Secret string
+}
+
+// Looks up the endpoint of a merchant
+type Endpoints func(ctx context.Context, merchantID int) (Endpoint, error)
+
+// POSTs each challenge as JSON to the webhook of the challenge's merchant, which has to answer with a 2xx status.
+// The request carries the ID of the request the challenge was made in, the unix time it was sent at and the hex
+// HMAC-SHA256 of the time, a newline and the body, keyed with the merchant's secret.
+type Webhook struct {
+	endpoints Endpoints
+	client    *http.Client
+}
+
+// timeout bounds each delivery, defaults to 5s
+func NewWebhook(endpoints Endpoints, timeout time.Duration) *Webhook {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &Webhook{endpoints: endpoints, client: &http.Client{Timeout: timeout}}
+}
+
+// The signature of a body sent at timestamp, for receivers to compare with the SignatureHeader
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (h *Webhook) Send(ctx context.Context, challenge Challenge) error {
+	endpoint, err := h.endpoints(ctx, challenge.MerchantID)
+	if err != nil {
+		return fmt.Errorf("failed to find the webhook of merchant %d: %v", challenge.MerchantID, err)
+	}
+	if endpoint.URL == "" || endpoint.Secret == "" {
+		return fmt.Errorf("failed to deliver challenge: merchant %d has no webhook", challenge.MerchantID)
+	}
+	body, err := json.Marshal(challenge)
+	if err != nil {
+		return fmt.Errorf("failed to encode challenge: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to deliver challenge: %v", err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(endpoint.Secret, timestamp, body))
+	if id := logging.RequestID(ctx); id != "" {
+		req.Header.Set(logging.RequestIDHeader, id)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to deliver challenge: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("failed to deliver challenge: webhook answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Appends published messages to a file, one JSON object per line. The value is base64 encoded.
+type File struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewFile(path string) (*File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %v", path, err)
+	}
+	return &File{file: file}, nil
+}
+
+func (f *File) PublishTransaction(ctx context.Context, transactionID string, message []byte, dataFormat string) error {
+	msg, err := newMessage(ctx, transactionID, message, dataFormat)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// a single write per line keeps lines whole when several processes append to the same file
+	if _, err := f.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("unable to write message: %v", err)
+	}
+	return nil
+}
+
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
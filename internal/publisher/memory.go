@@ -0,0 +1,63 @@
+package publisher
+
+import (
+	"context"
+	"sync"
+)
+
+// Records published messages instead of sending them anywhere
+type Memory struct {
+	mu       sync.Mutex
+	messages []Message
+	err      error
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) PublishTransaction(ctx context.Context, transactionID string, message []byte, dataFormat string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+
+	msg, err := newMessage(ctx, transactionID, message, dataFormat)
+	if err != nil {
+		return err
+	}
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Fails with the error set by FailWith, as if the broker was unreachable
+func (m *Memory) Ping(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+// Makes every following publish fail with err, or succeed again when err is nil
+func (m *Memory) FailWith(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = err
+}
+
+// Returns a copy of the messages published so far, oldest first
+func (m *Memory) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+func (m *Memory) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
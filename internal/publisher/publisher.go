@@ -0,0 +1,124 @@
+// Package publisher defines how encoded transactions leave the service. The Kafka implementation lives in
+// internal/kafka; Memory records messages for tests and File writes them to an NDJSON file so the service can
+// run without a broker.
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"payment-gateway/internal/logging"
+	"payment-gateway/internal/models"
+	"payment-gateway/internal/schemaregistry"
+	"payment-gateway/internal/tracing"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+type Publisher interface {
+	// Publishes an encoded and encrypted transaction to the topic for its data format, keyed by transaction ID
+	PublishTransaction(ctx context.Context, transactionID string, message []byte, dataFormat string) error
+	Close() error
+}
+
+// Implemented by publishers that depend on something that can become unreachable, checked by readiness
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// A published message as recorded by Memory and File
+type Message struct {
+	Topic       string    `json:"topic"`
+	Key         string    `json:"key"`
+	DataFormat  string    `json:"data_format"`
+	Headers     Headers   `json:"headers,omitempty"`
+	Value       []byte    `json:"value"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// returns the appropriate topic based on the data format.
+func Topic(dataFormat string) (string, error) {
+	switch dataFormat {
+	case "application/json":
+		return "transactions.json", nil
+	case "text/xml":
+		return "transactions.soap", nil
+	case "application/xml":
+		return "transactions.soap", nil
+	case "application/x-protobuf":
+		return "transactions.proto", nil
+	case "application/iso20022+xml":
+		return "transactions.iso20022", nil
+	default:
+		return "", fmt.Errorf("unsupported data format: %s", dataFormat)
+	}
+}
+
+// registers the schema the producer writes with for topics that carry a binary format so consumers can check theirs
+func RegisterSchema(topic string) error {
+	if topic != "transactions.proto" {
+		return nil
+	}
+	if _, err := schemaregistry.Default.Register(schemaregistry.SubjectForTopic(topic), models.TransactionsProtoSchema); err != nil {
+		return fmt.Errorf("unable to register schema for topic %s: %w", topic, err)
+	}
+	return nil
+}
+
+// Resolves the topic for a message and registers its schema, shared by every implementation
+func newMessage(ctx context.Context, transactionID string, message []byte, dataFormat string) (Message, error) {
+	topic, err := Topic(dataFormat)
+	if err != nil {
+		return Message{}, err
+	}
+	if err := RegisterSchema(topic); err != nil {
+		return Message{}, err
+	}
+	return Message{
+		Topic:       topic,
+		Key:         transactionID,
+		DataFormat:  dataFormat,
+		Headers:     HeadersFrom(ctx),
+		Value:       append([]byte(nil), message...),
+		PublishedAt: time.Now(),
+	}, nil
+}
+
+// The headers sent along with a message so consumers can tie it to the request that published it
+type Headers map[string]string
+
+// Names what a message other than a transaction carries. Messages without it are transactions.
+const MessageTypeHeader = "X-Message-Type"
+
+// The message type of a request to drop a transaction published before, see models.TransactionStatusEvent
+const MessageTypeCancellation = "cancellation"
+
+// Returns a context whose messages are sent with typ in the MessageTypeHeader
+func WithMessageType(ctx context.Context, typ string) context.Context {
+	return context.WithValue(ctx, "messageType", typ)
+}
+
+// Returns the request ID, the message type and the W3C traceparent of the span ctx is in, if any
+func HeadersFrom(ctx context.Context) Headers {
+	headers := Headers{}
+	if id := logging.RequestID(ctx); id != "" {
+		headers[logging.RequestIDHeader] = id
+	}
+	if typ, _ := ctx.Value("messageType").(string); typ != "" {
+		headers[MessageTypeHeader] = typ
+	}
+	tracing.Propagator.Inject(ctx, propagation.MapCarrier(headers))
+	if len(headers) == 0 {
+		return nil
+	}
+	return headers
+}
+
+// Restores the request ID and the remote span carried by headers, for consumers to continue the trace from the
+// message they received
+func ContextFromHeaders(ctx context.Context, headers Headers) context.Context {
+	if id := headers[logging.RequestIDHeader]; logging.ValidRequestID(id) {
+		ctx = logging.WithRequestID(ctx, id)
+	}
+	return tracing.Propagator.Extract(ctx, propagation.MapCarrier(headers))
+}
@@ -0,0 +1,110 @@
+package publisher
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"payment-gateway/internal/logging"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTopic(t *testing.T) {
+	for format, expected := range map[string]string{
+		"application/json":         "transactions.json",
+		"text/xml":                 "transactions.soap",
+		"application/xml":          "transactions.soap",
+		"application/x-protobuf":   "transactions.proto",
+		"application/iso20022+xml": "transactions.iso20022",
+	} {
+		if topic, err := Topic(format); err != nil || topic != expected {
+			t.Errorf("%s: expected %s, got %s and error %v", format, expected, topic, err)
+		}
+	}
+	if _, err := Topic("text/csv"); err == nil {
+		t.Errorf("Expected an unsupported format to be rejected")
+	}
+}
+
+func TestMemory(t *testing.T) {
+	pub := NewMemory()
+	if err := pub.PublishTransaction(context.Background(), "1", []byte("encrypted"), "application/json"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pub.PublishTransaction(context.Background(), "2", []byte("encrypted"), "text/csv"); err == nil {
+		t.Errorf("Expected an unsupported format to be rejected")
+	}
+
+	failure := errors.New("broker down")
+	pub.FailWith(failure)
+	if err := pub.PublishTransaction(context.Background(), "3", []byte("encrypted"), "application/json"); !errors.Is(err, failure) {
+		t.Errorf("Expected the injected failure, got %v", err)
+	}
+
+	messages := pub.Messages()
+	if len(messages) != 1 || messages[0].Topic != "transactions.json" || messages[0].Key != "1" || string(messages[0].Value) != "encrypted" {
+		t.Errorf("Unexpected messages %+v", messages)
+	}
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.ndjson")
+	pub, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"1", "2"} {
+		if err := pub.PublishTransaction(context.Background(), key, []byte{0x00, 0xff}, "application/x-protobuf"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pub.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var messages []Message
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var msg Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			t.Fatalf("Line is not JSON: %v", err)
+		}
+		messages = append(messages, msg)
+	}
+	if len(messages) != 2 || messages[1].Key != "2" || messages[1].Topic != "transactions.proto" || string(messages[1].Value) != "\x00\xff" {
+		t.Errorf("Unexpected messages %+v", messages)
+	}
+}
+
+func TestHeadersRoundTrip(t *testing.T) {
+	parent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(logging.WithRequestID(context.Background(), "request-1"), parent)
+
+	headers := HeadersFrom(ctx)
+	if headers[logging.RequestIDHeader] != "request-1" || headers["traceparent"] == "" {
+		t.Fatalf("Expected the request ID and traceparent, got %v", headers)
+	}
+
+	restored := ContextFromHeaders(context.Background(), headers)
+	span := trace.SpanContextFromContext(restored)
+	if logging.RequestID(restored) != "request-1" || span.TraceID() != parent.TraceID() || span.SpanID() != parent.SpanID() || !span.IsRemote() {
+		t.Errorf("Expected the request ID and span to be restored, got %q and %+v", logging.RequestID(restored), span)
+	}
+	if headers := HeadersFrom(context.Background()); headers != nil {
+		t.Errorf("Expected no headers outside of requests, got %v", headers)
+	}
+}
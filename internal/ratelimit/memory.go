@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Keeps the buckets in the process, so each instance of the service enforces the budgets on its own
+type Memory struct {
+	clock func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+	// when the bucket is full again and can be forgotten
+	fullAt time.Time
+}
+
+// how often buckets that filled up again are dropped
+const sweepInterval = time.Minute
+
+// clock defaults to time.Now
+func NewMemory(clock func() time.Time) *Memory {
+	if clock == nil {
+		clock = time.Now
+	}
+	return &Memory{clock: clock, buckets: map[string]*bucket{}, lastSweep: clock()}
+}
+
+func (m *Memory) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	if limit.Unlimited() {
+		return Result{Allowed: true}, nil
+	}
+	now := m.clock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep(now)
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Requests), at: now}
+		m.buckets[key] = b
+	}
+	tokens, allowed := take(limit, b.tokens, b.at, now)
+	res := result(limit, tokens, allowed)
+	b.tokens, b.at, b.fullAt = tokens, now, now.Add(res.Reset)
+	return res, nil
+}
+
+// drops the buckets that are full by now, a new one starts full as well
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	for key, b := range m.buckets {
+		if !now.Before(b.fullAt) {
+			delete(m.buckets, key)
+		}
+	}
+	m.lastSweep = now
+}
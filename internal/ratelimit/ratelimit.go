@@ -0,0 +1,97 @@
+// Package ratelimit implements token buckets keyed by whoever a budget applies to, such as an API key or a client
+// IP. Memory keeps the buckets in the process; Redis keeps them in Redis so the instances of the service share them.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A budget of Requests per Period. The bucket holds up to Requests tokens and refills evenly over Period, so a
+// client may burst up to Requests at once and then keep to Requests per Period.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// true for the zero Limit, which isn't enforced
+func (l Limit) Unlimited() bool {
+	return l.Requests <= 0 || l.Period <= 0
+}
+
+// tokens added per second
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// Parses requests/period such as 600/1m. An empty value is no limit.
+func ParseLimit(value string) (Limit, error) {
+	if value == "" {
+		return Limit{}, nil
+	}
+	requests, period, ok := strings.Cut(value, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("%q is not requests/period such as 600/1m", value)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 1 {
+		return Limit{}, fmt.Errorf("%q does not start with a positive number of requests", value)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("%q does not end with a positive period", value)
+	}
+	return Limit{Requests: n, Period: d}, nil
+}
+
+// The outcome of taking a token from a bucket
+type Result struct {
+	Allowed bool
+	// the size of the bucket
+	Limit int
+	// whole tokens left in the bucket
+	Remaining int
+	// how long until the next token, set when the request was refused
+	RetryAfter time.Duration
+	// how long until the bucket is full again
+	Reset time.Duration
+}
+
+type Limiter interface {
+	// Takes a token from key's bucket, which is sized and refilled according to limit. Unlimited limits allow
+	// everything.
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// the result of the backends having left tokens in the bucket after allowing the request or not
+func result(limit Limit, tokens float64, allowed bool) Result {
+	res := Result{
+		Allowed:   allowed,
+		Limit:     limit.Requests,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     seconds((float64(limit.Requests) - tokens) / limit.rate()),
+	}
+	if !allowed {
+		res.RetryAfter = seconds((1 - tokens) / limit.rate())
+	}
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
+
+// refills a bucket that had tokens at last up to now and takes a token if there is one
+func take(limit Limit, tokens float64, last, now time.Time) (float64, bool) {
+	if elapsed := now.Sub(last).Seconds(); elapsed > 0 {
+		tokens = math.Min(float64(limit.Requests), tokens+elapsed*limit.rate())
+	}
+	if tokens < 1 {
+		return tokens, false
+	}
+	return tokens - 1, true
+}
@@ -0,0 +1,118 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestParseLimit(t *testing.T) {
+	if limit, err := ParseLimit("600/1m"); err != nil || limit != (Limit{Requests: 600, Period: time.Minute}) {
+		t.Errorf("Expected 600 per minute, got %+v and error %v", limit, err)
+	}
+	if limit, err := ParseLimit(""); err != nil || !limit.Unlimited() {
+		t.Errorf("Expected no limit, got %+v and error %v", limit, err)
+	}
+	for _, value := range []string{"600", "0/1m", "x/1m", "10/0s", "10/soon"} {
+		if _, err := ParseLimit(value); err == nil {
+			t.Errorf("%s: expected an error", value)
+		}
+	}
+}
+
+// Both backends take tokens the same way
+func TestLimiters(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	for name, newLimiter := range map[string]func(clock func() time.Time) Limiter{
+		"Memory": func(clock func() time.Time) Limiter { return NewMemory(clock) },
+		"Redis":  func(clock func() time.Time) Limiter { return NewRedis(client, "ratelimit:", clock) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			testLimiter(t, newLimiter)
+		})
+	}
+}
+
+func testLimiter(t *testing.T, newLimiter func(clock func() time.Time) Limiter) {
+	ctx := context.Background()
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	limiter := newLimiter(func() time.Time { return now })
+	limit := Limit{Requests: 3, Period: 3 * time.Second}
+
+	for i := 2; i >= 0; i-- {
+		res, err := limiter.Allow(ctx, "key:a", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Allowed || res.Remaining != i || res.Limit != 3 {
+			t.Fatalf("Expected the burst to be allowed with %d left, got %+v", i, res)
+		}
+	}
+	res, err := limiter.Allow(ctx, "key:a", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed || res.RetryAfter != time.Second || res.Reset != 3*time.Second {
+		t.Errorf("Expected a refusal until the next token in 1s, got %+v", res)
+	}
+
+	if res, _ := limiter.Allow(ctx, "key:b", limit); !res.Allowed {
+		t.Errorf("Expected another key to have its own bucket, got %+v", res)
+	}
+
+	// a token comes back every second
+	now = now.Add(1500 * time.Millisecond)
+	if res, _ := limiter.Allow(ctx, "key:a", limit); !res.Allowed || res.Remaining != 0 {
+		t.Errorf("Expected the refilled token to be allowed, got %+v", res)
+	}
+	if res, _ := limiter.Allow(ctx, "key:a", limit); res.Allowed || res.RetryAfter != 500*time.Millisecond {
+		t.Errorf("Expected the next token in 500ms, got %+v", res)
+	}
+
+	// refilling stops at the size of the bucket
+	now = now.Add(time.Hour)
+	if res, _ := limiter.Allow(ctx, "key:a", limit); !res.Allowed || res.Remaining != 2 {
+		t.Errorf("Expected a full bucket, got %+v", res)
+	}
+
+	if res, err := limiter.Allow(ctx, "key:a", Limit{}); err != nil || !res.Allowed {
+		t.Errorf("Expected no limit to allow everything, got %+v and error %v", res, err)
+	}
+}
+
+func TestMemoryForgetsFullBuckets(t *testing.T) {
+	now := time.Now()
+	limiter := NewMemory(func() time.Time { return now })
+	limiter.Allow(context.Background(), "ip:1", Limit{Requests: 10, Period: time.Second})
+
+	now = now.Add(2 * sweepInterval)
+	limiter.Allow(context.Background(), "ip:2", Limit{Requests: 10, Period: time.Second})
+	if _, ok := limiter.buckets["ip:1"]; ok || len(limiter.buckets) != 1 {
+		t.Errorf("Expected the full bucket to be dropped, got %v", limiter.buckets)
+	}
+}
+
+func TestRedisBucketsExpire(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	limiter := NewRedis(client, "ratelimit:", nil)
+	if _, err := limiter.Allow(context.Background(), "ip:1", Limit{Requests: 10, Period: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := server.TTL("ratelimit:ip:1"); ttl <= 0 || ttl > time.Minute+time.Second {
+		t.Errorf("Expected the bucket to expire once full, got a TTL of %s", ttl)
+	}
+
+	server.Close()
+	if _, err := limiter.Allow(context.Background(), "ip:1", Limit{Requests: 10, Period: time.Minute}); err == nil {
+		t.Errorf("Expected an error without Redis")
+	}
+}
@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Keeps each bucket in a Redis hash, updated by a script so concurrent requests on any instance take tokens one
+// at a time. A bucket expires once it would be full again. The time comes from the instance's clock, so the
+// instances' clocks should be in sync.
+type Redis struct {
+	client redis.Cmdable
+	prefix string
+	clock  func() time.Time
+}
+
+// Refills the bucket KEYS[1] holding up to ARGV[1] tokens at ARGV[2] tokens per millisecond up to the time ARGV[3]
+// in milliseconds, and takes a token if there is one. Returns whether it did and the tokens left, as a string as
+// Redis would truncate a number.
+var takeScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'at')
+local tokens = tonumber(bucket[1])
+local at = tonumber(bucket[2])
+if tokens == nil or at == nil then
+	tokens = capacity
+	at = now
+end
+if now > at then
+	tokens = math.min(capacity, tokens + (now - at) * rate)
+end
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'at', tostring(now))
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return {allowed, tostring(tokens)}
+`)
+
+// Keys are prefixed with prefix, such as "ratelimit:". clock defaults to time.Now.
+func NewRedis(client redis.Cmdable, prefix string, clock func() time.Time) *Redis {
+	if clock == nil {
+		clock = time.Now
+	}
+	return &Redis{client: client, prefix: prefix, clock: clock}
+}
+
+func (r *Redis) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	if limit.Unlimited() {
+		return Result{Allowed: true}, nil
+	}
+	now := r.clock().UnixMilli()
+	ratePerMilli := strconv.FormatFloat(limit.rate()/1000, 'g', -1, 64)
+	// an empty bucket is full again after a period, the extra second covers the rounding
+	ttl := (limit.Period + time.Second).Milliseconds()
+
+	reply, err := takeScript.Run(ctx, r.client, []string{r.prefix + key}, limit.Requests, ratePerMilli, now, ttl).Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to take a token for %s: %v", key, err)
+	}
+	if len(reply) != 2 {
+		return Result{}, fmt.Errorf("failed to take a token for %s: unexpected reply %v", key, reply)
+	}
+	allowed, _ := reply[0].(int64)
+	remaining, _ := reply[1].(string)
+	tokens, err := strconv.ParseFloat(remaining, 64)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to take a token for %s: unexpected tokens %q", key, remaining)
+	}
+	return result(limit, tokens, allowed == 1), nil
+}
@@ -0,0 +1,145 @@
+package refdata
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"payment-gateway/internal/services"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Declarative description of the reference data driving routing: which currencies exist, which currencies each
+// country accepts and which countries each gateway serves. Countries and gateways can only reference entries
+// declared in the same file.
+
+var (
+	countryCodePattern    = regexp.MustCompile(`^[A-Z]{2}$`)
+	currencySymbolPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+)
+
+type File struct {
+	Currencies []CurrencySpec `yaml:"currencies" json:"currencies"`
+	Countries  []CountrySpec  `yaml:"countries" json:"countries"`
+	Gateways   []GatewaySpec  `yaml:"gateways" json:"gateways"`
+}
+
+type CurrencySpec struct {
+	Symbol  string `yaml:"symbol" json:"symbol"`
+	Enabled *bool  `yaml:"enabled" json:"enabled"`
+}
+
+type CountrySpec struct {
+	Code       string   `yaml:"code" json:"code"`
+	Name       string   `yaml:"name" json:"name"`
+	Currencies []string `yaml:"currencies" json:"currencies"`
+	Enabled    *bool    `yaml:"enabled" json:"enabled"`
+}
+
+type GatewaySpec struct {
+	Name string `yaml:"name" json:"name"`
+	// the gateway's preferred format, used when it does not support the client's format
+	DataFormat string `yaml:"data_format" json:"data_format"`
+	// additional formats the gateway accepts
+	DataFormats []string `yaml:"data_formats" json:"data_formats"`
+	Countries   []string `yaml:"countries" json:"countries"`
+	Enabled     *bool    `yaml:"enabled" json:"enabled"`
+}
+
+// entries are enabled unless the file says otherwise
+func enabled(b *bool) bool {
+	return b == nil || *b
+}
+
+// Reads a sync file. Files ending in .json are decoded as JSON, everything else as YAML. Unknown fields are
+// rejected so typos don't silently drop configuration.
+func Load(path string) (File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return File{}, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	var file File
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&file)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&file)
+	}
+	if err != nil {
+		return File{}, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	if err := file.Validate(); err != nil {
+		return File{}, fmt.Errorf("invalid %s: %v", path, err)
+	}
+	return file, nil
+}
+
+// Checks the file is internally consistent: codes are well formed, nothing is declared twice and every
+// reference points at an entry declared in the file
+func (f File) Validate() error {
+	currencies := map[string]bool{}
+	for _, currency := range f.Currencies {
+		if !currencySymbolPattern.MatchString(currency.Symbol) {
+			return fmt.Errorf("currency %q: symbol must be an ISO 4217 code such as USD", currency.Symbol)
+		}
+		if currencies[currency.Symbol] {
+			return fmt.Errorf("currency %s is declared twice", currency.Symbol)
+		}
+		currencies[currency.Symbol] = true
+	}
+
+	countries := map[string]bool{}
+	names := map[string]bool{}
+	for _, country := range f.Countries {
+		if !countryCodePattern.MatchString(country.Code) {
+			return fmt.Errorf("country %q: code must be an ISO 3166 alpha-2 code such as AE", country.Code)
+		}
+		if strings.TrimSpace(country.Name) == "" {
+			return fmt.Errorf("country %s has no name", country.Code)
+		}
+		if countries[country.Code] {
+			return fmt.Errorf("country %s is declared twice", country.Code)
+		}
+		if names[country.Name] {
+			return fmt.Errorf("country name %q is used twice", country.Name)
+		}
+		countries[country.Code] = true
+		names[country.Name] = true
+		for _, symbol := range country.Currencies {
+			if !currencies[symbol] {
+				return fmt.Errorf("country %s references undeclared currency %s", country.Code, symbol)
+			}
+		}
+	}
+
+	gateways := map[string]bool{}
+	for _, gateway := range f.Gateways {
+		if name := strings.TrimSpace(gateway.Name); name == "" || len(name) > 255 {
+			return fmt.Errorf("gateway %q: name must be between 1 and 255 characters", gateway.Name)
+		}
+		if gateways[gateway.Name] {
+			return fmt.Errorf("gateway %q is declared twice", gateway.Name)
+		}
+		gateways[gateway.Name] = true
+		for _, format := range append([]string{gateway.DataFormat}, gateway.DataFormats...) {
+			if !services.IsSupportedDataFormat(format) {
+				return fmt.Errorf("gateway %q: %q is not one of %s", gateway.Name, format, strings.Join(services.SupportedDataFormats, ", "))
+			}
+		}
+		for _, code := range gateway.Countries {
+			if !countries[code] {
+				return fmt.Errorf("gateway %q references undeclared country %s", gateway.Name, code)
+			}
+		}
+	}
+	return nil
+}
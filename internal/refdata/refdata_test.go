@@ -0,0 +1,93 @@
+package refdata
+
+import (
+	"os"
+	"path/filepath"
+	"payment-gateway/db"
+	"strings"
+	"testing"
+)
+
+func TestLoadExampleFile(t *testing.T) {
+	file, err := Load(filepath.Join("..", "..", "db", "reference-data.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Currencies) != 3 || len(file.Countries) != 2 || len(file.Gateways) != 2 {
+		t.Errorf("Unexpected file contents %+v", file)
+	}
+}
+
+func TestLoadJSONRejectsUnknownFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reference-data.json")
+	if err := os.WriteFile(path, []byte(`{"currencies": [{"symbol": "USD", "symbl": "EUR"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Errorf("Expected unknown field to be rejected")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for name, file := range map[string]File{
+		"undeclared currency": {Countries: []CountrySpec{{Code: "AE", Name: "United Arab Emirates", Currencies: []string{"AED"}}}},
+		"undeclared country":  {Gateways: []GatewaySpec{{Name: "Gateway 1", DataFormat: "application/json", Countries: []string{"AE"}}}},
+		"bad format":          {Gateways: []GatewaySpec{{Name: "Gateway 1", DataFormat: "text/csv"}}},
+		"duplicate currency":  {Currencies: []CurrencySpec{{Symbol: "USD"}, {Symbol: "USD"}}},
+		"bad country code":    {Countries: []CountrySpec{{Code: "ARE", Name: "United Arab Emirates"}}},
+	} {
+		if err := file.Validate(); err == nil {
+			t.Errorf("%s: expected file to be invalid", name)
+		}
+	}
+}
+
+func planStrings(changes []Change) []string {
+	var out []string
+	for _, change := range changes {
+		out = append(out, change.String())
+	}
+	return out
+}
+
+func TestPlan(t *testing.T) {
+	disabled := false
+	file := File{
+		Currencies: []CurrencySpec{{Symbol: "USD"}, {Symbol: "AED"}},
+		Countries:  []CountrySpec{{Code: "AE", Name: "United Arab Emirates", Currencies: []string{"AED", "USD"}}},
+		Gateways: []GatewaySpec{
+			{Name: "Gateway 1", DataFormat: "application/json", DataFormats: []string{"application/x-protobuf"}, Countries: []string{"AE"}},
+			{Name: "Gateway 3", DataFormat: "application/xml", Enabled: &disabled},
+		},
+	}
+	state := State{
+		Currencies: map[string]db.Currency{"USD": {ID: 1, Symbol: "USD", Enabled: true}, "EUR": {ID: 3, Symbol: "EUR", Enabled: true}},
+		Countries:  map[string]db.Country{"AE": {ID: 1, Name: "UAE", Code: "AE", Enabled: true}},
+		Gateways: map[string]db.Gateway{
+			"Gateway 1": {ID: 1, Name: "Gateway 1", DataFormatSupported: "application/json", DataFormats: []string{"application/json"}, Enabled: true},
+			"Gateway 2": {ID: 2, Name: "Gateway 2", DataFormatSupported: "application/xml", DataFormats: []string{"application/xml"}, Enabled: true},
+		},
+		CountryCurrencies: map[string]map[string]bool{"AE": {"USD": true, "EUR": true}},
+		GatewayCountries:  map[string]map[string]bool{"Gateway 1": {"AE": true}, "Gateway 2": {"AE": true}},
+	}
+
+	expected := []string{
+		"+ create currency AED",
+		"+ create gateway Gateway 3: application/xml",
+		`~ update country AE: name "UAE" -> "United Arab Emirates"`,
+		"~ update gateway Gateway 1: data formats [application/json] -> [application/json, application/x-protobuf]",
+		"+ map country currency AE AED",
+	}
+	if got := planStrings(Plan(file, state, false)); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected plan without prune:\n%s", strings.Join(got, "\n"))
+	}
+
+	expected = append(expected,
+		"- unmap country currency AE EUR",
+		"- delete gateway Gateway 2",
+		"- delete currency EUR",
+	)
+	if got := planStrings(Plan(file, state, true)); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected plan with prune:\n%s", strings.Join(got, "\n"))
+	}
+}
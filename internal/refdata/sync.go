@@ -0,0 +1,410 @@
+package refdata
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"payment-gateway/db"
+	"sort"
+	"strings"
+)
+
+type Op string
+
+const (
+	CREATE Op = "create"
+	UPDATE Op = "update"
+	DELETE Op = "delete"
+	MAP    Op = "map"
+	UNMAP  Op = "unmap"
+)
+
+// A single change needed to bring the DB in line with the file
+type Change struct {
+	Op     Op
+	Entity string
+	Key    string
+	Detail string
+
+	apply func(ctx context.Context, tx db.Execer, ids *idIndex) error
+}
+
+func (c Change) String() string {
+	prefix := map[Op]string{CREATE: "+", UPDATE: "~", DELETE: "-", MAP: "+", UNMAP: "-"}[c.Op]
+	s := fmt.Sprintf("%s %s %s %s", prefix, c.Op, c.Entity, c.Key)
+	if c.Detail != "" {
+		s += ": " + c.Detail
+	}
+	return s
+}
+
+// The reference data currently in the DB, keyed the same way as the sync file
+type State struct {
+	Currencies map[string]db.Currency
+	Countries  map[string]db.Country
+	Gateways   map[string]db.Gateway
+	// currency symbols by country code
+	CountryCurrencies map[string]map[string]bool
+	// country codes by gateway name
+	GatewayCountries map[string]map[string]bool
+}
+
+func LoadState(ctx context.Context, tx db.Execer) (State, error) {
+	state := State{
+		Currencies:        map[string]db.Currency{},
+		Countries:         map[string]db.Country{},
+		Gateways:          map[string]db.Gateway{},
+		CountryCurrencies: map[string]map[string]bool{},
+		GatewayCountries:  map[string]map[string]bool{},
+	}
+
+	currencies, err := db.GetCurrencies(ctx, tx)
+	if err != nil {
+		return State{}, err
+	}
+	symbols := map[int]string{}
+	for _, currency := range currencies {
+		state.Currencies[currency.Symbol] = currency
+		symbols[currency.ID] = currency.Symbol
+	}
+
+	countries, err := db.GetCountries(ctx, tx)
+	if err != nil {
+		return State{}, err
+	}
+	codes := map[int]string{}
+	for _, country := range countries {
+		state.Countries[country.Code] = country
+		codes[country.ID] = country.Code
+	}
+
+	gateways, err := db.GetGateways(ctx, tx)
+	if err != nil {
+		return State{}, err
+	}
+	names := map[int]string{}
+	for _, gateway := range gateways {
+		gateway, err := db.GetGateway(ctx, tx, gateway.ID)
+		if err != nil {
+			return State{}, err
+		}
+		state.Gateways[gateway.Name] = gateway
+		names[gateway.ID] = gateway.Name
+	}
+
+	countryCurrencies, err := db.GetCountryCurrencyMappings(ctx, tx)
+	if err != nil {
+		return State{}, err
+	}
+	for countryID, currencyIDs := range countryCurrencies {
+		state.CountryCurrencies[codes[countryID]] = map[string]bool{}
+		for _, currencyID := range currencyIDs {
+			state.CountryCurrencies[codes[countryID]][symbols[currencyID]] = true
+		}
+	}
+
+	gatewayCountries, err := db.GetGatewayCountryMappings(ctx, tx)
+	if err != nil {
+		return State{}, err
+	}
+	for gatewayID, countryIDs := range gatewayCountries {
+		state.GatewayCountries[names[gatewayID]] = map[string]bool{}
+		for _, countryID := range countryIDs {
+			state.GatewayCountries[names[gatewayID]][codes[countryID]] = true
+		}
+	}
+	return state, nil
+}
+
+// Resolves keys from the file to DB IDs, including those of rows created earlier in the same sync
+type idIndex struct {
+	currencies map[string]int
+	countries  map[string]int
+	gateways   map[string]int
+}
+
+func newIDIndex(state State) *idIndex {
+	ids := &idIndex{currencies: map[string]int{}, countries: map[string]int{}, gateways: map[string]int{}}
+	for symbol, currency := range state.Currencies {
+		ids.currencies[symbol] = currency.ID
+	}
+	for code, country := range state.Countries {
+		ids.countries[code] = country.ID
+	}
+	for name, gateway := range state.Gateways {
+		ids.gateways[name] = gateway.ID
+	}
+	return ids
+}
+
+// Works out the changes needed to turn state into file. Without prune nothing is removed: rows and mappings
+// missing from the file are left alone. Changes are ordered so that rows exist before they are mapped and
+// mappings are removed before the rows they point at.
+func Plan(file File, state State, prune bool) []Change {
+	var creates, updates, mappings, unmaps, deletes []Change
+
+	for _, spec := range file.Currencies {
+		spec := spec
+		current, ok := state.Currencies[spec.Symbol]
+		if !ok {
+			creates = append(creates, Change{Op: CREATE, Entity: "currency", Key: spec.Symbol, apply: func(ctx context.Context, tx db.Execer, ids *idIndex) error {
+				currency := db.Currency{Symbol: spec.Symbol}
+				if err := db.CreateCurrency(ctx, tx, &currency); err != nil {
+					return err
+				}
+				ids.currencies[spec.Symbol] = currency.ID
+				if !enabled(spec.Enabled) {
+					return db.SetCurrencyEnabled(ctx, tx, currency.ID, false)
+				}
+				return nil
+			}})
+			continue
+		}
+		if current.Enabled != enabled(spec.Enabled) {
+			updates = append(updates, Change{Op: UPDATE, Entity: "currency", Key: spec.Symbol, Detail: enabledDetail(enabled(spec.Enabled)), apply: func(ctx context.Context, tx db.Execer, ids *idIndex) error {
+				return db.SetCurrencyEnabled(ctx, tx, current.ID, enabled(spec.Enabled))
+			}})
+		}
+	}
+
+	for _, spec := range file.Countries {
+		spec := spec
+		current, ok := state.Countries[spec.Code]
+		if !ok {
+			creates = append(creates, Change{Op: CREATE, Entity: "country", Key: spec.Code, Detail: spec.Name, apply: func(ctx context.Context, tx db.Execer, ids *idIndex) error {
+				country := db.Country{Name: spec.Name, Code: spec.Code}
+				if err := db.CreateCountry(ctx, tx, &country); err != nil {
+					return err
+				}
+				ids.countries[spec.Code] = country.ID
+				if !enabled(spec.Enabled) {
+					return db.SetCountryEnabled(ctx, tx, country.ID, false)
+				}
+				return nil
+			}})
+		} else {
+			if current.Name != spec.Name {
+				updates = append(updates, Change{Op: UPDATE, Entity: "country", Key: spec.Code, Detail: fmt.Sprintf("name %q -> %q", current.Name, spec.Name), apply: func(ctx context.Context, tx db.Execer, ids *idIndex) error {
+					country := current
+					country.Name = spec.Name
+					return db.UpdateCountry(ctx, tx, &country)
+				}})
+			}
+			if current.Enabled != enabled(spec.Enabled) {
+				updates = append(updates, Change{Op: UPDATE, Entity: "country", Key: spec.Code, Detail: enabledDetail(enabled(spec.Enabled)), apply: func(ctx context.Context, tx db.Execer, ids *idIndex) error {
+					return db.SetCountryEnabled(ctx, tx, current.ID, enabled(spec.Enabled))
+				}})
+			}
+		}
+
+		desired := map[string]bool{}
+		for _, symbol := range spec.Currencies {
+			symbol := symbol
+			desired[symbol] = true
+			if state.CountryCurrencies[spec.Code][symbol] {
+				continue
+			}
+			mappings = append(mappings, Change{Op: MAP, Entity: "country currency", Key: spec.Code + " " + symbol, apply: func(ctx context.Context, tx db.Execer, ids *idIndex) error {
+				return db.AddCountryCurrency(ctx, tx, ids.countries[spec.Code], ids.currencies[symbol])
+			}})
+		}
+		if prune {
+			for _, symbol := range sortedKeys(state.CountryCurrencies[spec.Code]) {
+				symbol := symbol
+				if desired[symbol] {
+					continue
+				}
+				unmaps = append(unmaps, Change{Op: UNMAP, Entity: "country currency", Key: spec.Code + " " + symbol, apply: func(ctx context.Context, tx db.Execer, ids *idIndex) error {
+					return db.RemoveCountryCurrency(ctx, tx, ids.countries[spec.Code], ids.currencies[symbol])
+				}})
+			}
+		}
+	}
+
+	for _, spec := range file.Gateways {
+		spec := spec
+		formats := normalizeFormats(spec.DataFormat, spec.DataFormats)
+		current, ok := state.Gateways[spec.Name]
+		if !ok {
+			creates = append(creates, Change{Op: CREATE, Entity: "gateway", Key: spec.Name, Detail: strings.Join(formats, ", "), apply: func(ctx context.Context, tx db.Execer, ids *idIndex) error {
+				gateway := db.Gateway{Name: spec.Name, DataFormatSupported: spec.DataFormat, DataFormats: spec.DataFormats}
+				if err := db.CreateGateway(ctx, tx, &gateway); err != nil {
+					return err
+				}
+				ids.gateways[spec.Name] = gateway.ID
+				if !enabled(spec.Enabled) {
+					return db.SetGatewayEnabled(ctx, tx, gateway.ID, false)
+				}
+				return nil
+			}})
+		} else {
+			currentFormats := normalizeFormats(current.DataFormatSupported, current.DataFormats)
+			if strings.Join(currentFormats, ",") != strings.Join(formats, ",") {
+				updates = append(updates, Change{Op: UPDATE, Entity: "gateway", Key: spec.Name, Detail: fmt.Sprintf("data formats [%s] -> [%s]", strings.Join(currentFormats, ", "), strings.Join(formats, ", ")), apply: func(ctx context.Context, tx db.Execer, ids *idIndex) error {
+					gateway := current
+					gateway.DataFormatSupported = spec.DataFormat
+					gateway.DataFormats = spec.DataFormats
+					return db.UpdateGateway(ctx, tx, &gateway)
+				}})
+			}
+			if current.Enabled != enabled(spec.Enabled) {
+				updates = append(updates, Change{Op: UPDATE, Entity: "gateway", Key: spec.Name, Detail: enabledDetail(enabled(spec.Enabled)), apply: func(ctx context.Context, tx db.Execer, ids *idIndex) error {
+					return db.SetGatewayEnabled(ctx, tx, current.ID, enabled(spec.Enabled))
+				}})
+			}
+		}
+
+		desired := map[string]bool{}
+		for _, code := range spec.Countries {
+			code := code
+			desired[code] = true
+			if state.GatewayCountries[spec.Name][code] {
+				continue
+			}
+			mappings = append(mappings, Change{Op: MAP, Entity: "gateway country", Key: fmt.Sprintf("%q %s", spec.Name, code), apply: func(ctx context.Context, tx db.Execer, ids *idIndex) error {
+				return db.AddGatewayCountry(ctx, tx, ids.gateways[spec.Name], ids.countries[code])
+			}})
+		}
+		if prune {
+			for _, code := range sortedKeys(state.GatewayCountries[spec.Name]) {
+				code := code
+				if desired[code] {
+					continue
+				}
+				unmaps = append(unmaps, Change{Op: UNMAP, Entity: "gateway country", Key: fmt.Sprintf("%q %s", spec.Name, code), apply: func(ctx context.Context, tx db.Execer, ids *idIndex) error {
+					return db.RemoveGatewayCountry(ctx, tx, ids.gateways[spec.Name], ids.countries[code])
+				}})
+			}
+		}
+	}
+
+	if prune {
+		// deleting a row removes its mappings with it, so they aren't listed separately
+		inFile := map[string]bool{}
+		for _, spec := range file.Gateways {
+			inFile[spec.Name] = true
+		}
+		for _, name := range sortedKeys(state.Gateways) {
+			if inFile[name] {
+				continue
+			}
+			gateway := state.Gateways[name]
+			deletes = append(deletes, Change{Op: DELETE, Entity: "gateway", Key: name, apply: func(ctx context.Context, tx db.Execer, ids *idIndex) error {
+				return db.DeleteGateway(ctx, tx, gateway.ID)
+			}})
+		}
+
+		inFile = map[string]bool{}
+		for _, spec := range file.Countries {
+			inFile[spec.Code] = true
+		}
+		for _, code := range sortedKeys(state.Countries) {
+			if inFile[code] {
+				continue
+			}
+			country := state.Countries[code]
+			deletes = append(deletes, Change{Op: DELETE, Entity: "country", Key: code, Detail: country.Name, apply: func(ctx context.Context, tx db.Execer, ids *idIndex) error {
+				return db.DeleteCountry(ctx, tx, country.ID)
+			}})
+		}
+
+		inFile = map[string]bool{}
+		for _, spec := range file.Currencies {
+			inFile[spec.Symbol] = true
+		}
+		for _, symbol := range sortedKeys(state.Currencies) {
+			if inFile[symbol] {
+				continue
+			}
+			currency := state.Currencies[symbol]
+			deletes = append(deletes, Change{Op: DELETE, Entity: "currency", Key: symbol, apply: func(ctx context.Context, tx db.Execer, ids *idIndex) error {
+				return db.DeleteCurrency(ctx, tx, currency.ID)
+			}})
+		}
+	}
+
+	changes := append(creates, updates...)
+	changes = append(changes, mappings...)
+	changes = append(changes, unmaps...)
+	return append(changes, deletes...)
+}
+
+// Diffs the file against the DB and applies the changes in a single DB transaction, recording each one in the
+// audit log as actor. With dryRun the transaction is rolled back and only the plan is returned.
+func Sync(ctx context.Context, _db *sql.DB, file File, prune, dryRun bool, actor string) ([]Change, error) {
+	tx, err := _db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// reference data is small, locking it keeps admin API writes from interleaving with the sync
+	if _, err := tx.Exec(`LOCK TABLE gateways, countries, currencies, gateway_countries, country_currency, gateway_data_formats IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return nil, fmt.Errorf("failed to lock reference data: %v", err)
+	}
+
+	state, err := LoadState(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	changes := Plan(file, state, prune)
+	if dryRun || len(changes) == 0 {
+		return changes, nil
+	}
+
+	ids := newIDIndex(state)
+	for _, change := range changes {
+		if err := change.apply(ctx, tx, ids); err != nil {
+			return nil, fmt.Errorf("%s: %w", change, err)
+		}
+		detail, err := db.AuditJSON(change.Detail)
+		if err != nil {
+			return nil, err
+		}
+		entry := db.AuditEntry{
+			Actor:    actor,
+			Action:   "sync " + string(change.Op),
+			Entity:   change.Entity,
+			EntityID: change.Key,
+			After:    detail,
+		}
+		if err := db.AppendAuditEntry(ctx, tx, &entry); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit sync: %v", err)
+	}
+	return changes, nil
+}
+
+// Puts the preferred format first and the rest in a stable order so they can be compared
+func normalizeFormats(preferred string, formats []string) []string {
+	var rest []string
+	seen := map[string]bool{preferred: true}
+	for _, format := range formats {
+		if !seen[format] {
+			seen[format] = true
+			rest = append(rest, format)
+		}
+	}
+	sort.Strings(rest)
+	return append([]string{preferred}, rest...)
+}
+
+func enabledDetail(enabled bool) string {
+	if enabled {
+		return "enable"
+	}
+	return "disable"
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,161 @@
+package schemaregistry
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A deliberately small proto3 parser. It understands top level messages, scalar and message typed fields and
+// reserved field numbers, which is all the registry needs to reason about wire compatibility.
+
+type Field struct {
+	Name     string
+	Type     string
+	Repeated bool
+}
+
+func (f Field) String() string {
+	if f.Repeated {
+		return "repeated " + f.Type
+	}
+	return f.Type
+}
+
+type Message struct {
+	Name     string
+	Fields   map[int]Field
+	Reserved map[int]bool
+}
+
+type Schema struct {
+	Package  string
+	Messages map[string]*Message
+}
+
+var (
+	lineComment  = regexp.MustCompile(`//[^\n]*`)
+	blockComment = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	packageDecl  = regexp.MustCompile(`^package\s+([\w.]+)$`)
+	messageStart = regexp.MustCompile(`^message\s+(\w+)\s*\{$`)
+	fieldDecl    = regexp.MustCompile(`^(repeated\s+|optional\s+)?([\w.]+)\s+(\w+)\s*=\s*(\d+)(\s*\[.*\])?$`)
+	reservedDecl = regexp.MustCompile(`^reserved\s+(.+)$`)
+)
+
+// splits the source into statements on ';', '{' and '}' keeping the braces as their own statements
+func statements(source string) []string {
+	source = blockComment.ReplaceAllString(source, "")
+	source = lineComment.ReplaceAllString(source, "")
+
+	var out []string
+	var current strings.Builder
+	flush := func() {
+		if s := strings.Join(strings.Fields(current.String()), " "); s != "" {
+			out = append(out, s)
+		}
+		current.Reset()
+	}
+	for _, r := range source {
+		switch r {
+		case ';':
+			flush()
+		case '{':
+			current.WriteRune(r)
+			flush()
+		case '}':
+			flush()
+			out = append(out, "}")
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+	return out
+}
+
+func ParseProto(source string) (*Schema, error) {
+	schema := &Schema{Messages: map[string]*Message{}}
+	var current *Message
+
+	for _, stmt := range statements(source) {
+		if current == nil {
+			if m := packageDecl.FindStringSubmatch(stmt); m != nil {
+				schema.Package = m[1]
+				continue
+			}
+			if m := messageStart.FindStringSubmatch(stmt); m != nil {
+				if _, exists := schema.Messages[m[1]]; exists {
+					return nil, fmt.Errorf("message %s declared twice", m[1])
+				}
+				current = &Message{Name: m[1], Fields: map[int]Field{}, Reserved: map[int]bool{}}
+				schema.Messages[m[1]] = current
+				continue
+			}
+			if strings.HasPrefix(stmt, "syntax") || strings.HasPrefix(stmt, "option") || strings.HasPrefix(stmt, "import") {
+				continue
+			}
+			return nil, fmt.Errorf("unsupported statement %q", stmt)
+		}
+
+		if stmt == "}" {
+			current = nil
+			continue
+		}
+		if m := reservedDecl.FindStringSubmatch(stmt); m != nil {
+			if err := parseReserved(current, m[1]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if m := fieldDecl.FindStringSubmatch(stmt); m != nil {
+			num, err := strconv.Atoi(m[4])
+			if err != nil || num < 1 {
+				return nil, fmt.Errorf("invalid field number in %q", stmt)
+			}
+			if existing, ok := current.Fields[num]; ok {
+				return nil, fmt.Errorf("field number %d used by both %s and %s in message %s", num, existing.Name, m[3], current.Name)
+			}
+			current.Fields[num] = Field{
+				Name:     m[3],
+				Type:     m[2],
+				Repeated: strings.TrimSpace(m[1]) == "repeated",
+			}
+			continue
+		}
+		return nil, fmt.Errorf("unsupported statement %q in message %s", stmt, current.Name)
+	}
+
+	if current != nil {
+		return nil, fmt.Errorf("message %s is not closed", current.Name)
+	}
+	if len(schema.Messages) == 0 {
+		return nil, fmt.Errorf("schema declares no messages")
+	}
+	return schema, nil
+}
+
+// supports "reserved 2, 5 to 7;". Reserved names carry no wire meaning and are ignored.
+func parseReserved(msg *Message, spec string) error {
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if strings.HasPrefix(part, `"`) {
+			continue
+		}
+		bounds := strings.SplitN(part, " to ", 2)
+		from, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return fmt.Errorf("invalid reserved range %q in message %s", part, msg.Name)
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil || to < from {
+				return fmt.Errorf("invalid reserved range %q in message %s", part, msg.Name)
+			}
+		}
+		for n := from; n <= to; n++ {
+			msg.Reserved[n] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package schemaregistry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// A local stand-in for a Confluent style schema registry. Producers register the schema they write with
+// and consumers check the schema they read with, both against the latest version registered for a subject.
+// Subjects follow the "<topic>-value" naming convention.
+
+type Version struct {
+	Subject  string
+	Version  int
+	Checksum string
+	Source   string
+	schema   *Schema
+}
+
+type Registry struct {
+	mu       sync.RWMutex
+	subjects map[string][]Version
+}
+
+// the registry shared by the producer and any in process consumers
+var Default = New()
+
+func New() *Registry {
+	return &Registry{subjects: map[string][]Version{}}
+}
+
+func SubjectForTopic(topic string) string {
+	return topic + "-value"
+}
+
+func checksum(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(sum[:])
+}
+
+// Registers a new version of the schema for the subject. Registering the same schema twice returns the existing
+// version. A schema that is not compatible with the latest version is rejected.
+func (r *Registry) Register(subject, source string) (int, error) {
+	schema, err := ParseProto(source)
+	if err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sum := checksum(source)
+	versions := r.subjects[subject]
+	for _, v := range versions {
+		if v.Checksum == sum {
+			return v.Version, nil
+		}
+	}
+
+	if len(versions) > 0 {
+		if err := compatible(versions[len(versions)-1].schema, schema); err != nil {
+			return 0, fmt.Errorf("schema for subject %s is incompatible with version %d: %w", subject, versions[len(versions)-1].Version, err)
+		}
+	}
+
+	version := Version{
+		Subject:  subject,
+		Version:  len(versions) + 1,
+		Checksum: sum,
+		Source:   source,
+		schema:   schema,
+	}
+	r.subjects[subject] = append(versions, version)
+	return version.Version, nil
+}
+
+// Checks the schema against the latest version of the subject without registering it.
+// A subject with nothing registered yet accepts any valid schema.
+func (r *Registry) CheckCompatibility(subject, source string) error {
+	schema, err := ParseProto(source)
+	if err != nil {
+		return err
+	}
+
+	latest, err := r.Latest(subject)
+	if err != nil {
+		return nil
+	}
+	if err := compatible(latest.schema, schema); err != nil {
+		return fmt.Errorf("schema for subject %s is incompatible with version %d: %w", subject, latest.Version, err)
+	}
+	return nil
+}
+
+func (r *Registry) Latest(subject string) (Version, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	versions := r.subjects[subject]
+	if len(versions) == 0 {
+		return Version{}, fmt.Errorf("no schema registered for subject %s", subject)
+	}
+	return versions[len(versions)-1], nil
+}
+
+func (r *Registry) Subjects() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	subjects := make([]string, 0, len(r.subjects))
+	for subject := range r.subjects {
+		subjects = append(subjects, subject)
+	}
+	sort.Strings(subjects)
+	return subjects
+}
+
+// Two schemas are compatible when messages written with one can be read with the other. Every message must
+// still exist, a field number may not change type, removed field numbers must be reserved and reserved numbers
+// may not be reused.
+func compatible(old, new *Schema) error {
+	for name, oldMsg := range old.Messages {
+		newMsg, ok := new.Messages[name]
+		if !ok {
+			return fmt.Errorf("message %s was removed", name)
+		}
+
+		for num, oldField := range oldMsg.Fields {
+			newField, ok := newMsg.Fields[num]
+			if !ok {
+				if !newMsg.Reserved[num] {
+					return fmt.Errorf("field %s.%s (%d) was removed without being reserved", name, oldField.Name, num)
+				}
+				continue
+			}
+			if oldField.Type != newField.Type || oldField.Repeated != newField.Repeated {
+				return fmt.Errorf("field %s.%s (%d) changed type from %s to %s", name, oldField.Name, num, oldField, newField)
+			}
+		}
+
+		for num, newField := range newMsg.Fields {
+			if oldMsg.Reserved[num] {
+				return fmt.Errorf("field %s.%s reuses reserved number %d", name, newField.Name, num)
+			}
+		}
+	}
+	return nil
+}
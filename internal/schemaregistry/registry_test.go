@@ -0,0 +1,77 @@
+package schemaregistry
+
+import (
+	"payment-gateway/internal/models"
+	"strings"
+	"testing"
+)
+
+const v1 = `
+syntax = "proto3";
+message Event {
+  int64 id = 1;
+  string status = 2;
+}`
+
+func TestRegisterIsIdempotent(t *testing.T) {
+	r := New()
+	first, err := r.Register("events-value", v1)
+	if err != nil {
+		t.Fatalf("unable to register schema: %v", err)
+	}
+	second, err := r.Register("events-value", v1)
+	if err != nil || second != first {
+		t.Errorf("Expected version %d Received %d (%v)", first, second, err)
+	}
+}
+
+func TestCompatibility(t *testing.T) {
+	cases := []struct {
+		name   string
+		schema string
+		err    string
+	}{
+		{"new field", `message Event { int64 id = 1; string status = 2; string reason = 3; }`, ""},
+		{"renamed field", `message Event { int64 id = 1; string state = 2; }`, ""},
+		{"reserved removal", `message Event { int64 id = 1; reserved 2; }`, ""},
+		{"removed field", `message Event { int64 id = 1; }`, "removed without being reserved"},
+		{"changed type", `message Event { string id = 1; string status = 2; }`, "changed type"},
+		{"removed message", `message Other { int64 id = 1; }`, "message Event was removed"},
+	}
+
+	for _, c := range cases {
+		r := New()
+		if _, err := r.Register("events-value", v1); err != nil {
+			t.Fatalf("unable to register schema: %v", err)
+		}
+		err := r.CheckCompatibility("events-value", c.schema)
+		if c.err == "" && err != nil {
+			t.Errorf("%s: expected compatible schema, got %v", c.name, err)
+		}
+		if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%s: expected error containing %q, got %v", c.name, c.err, err)
+		}
+	}
+}
+
+func TestReservedNumbersCannotBeReused(t *testing.T) {
+	r := New()
+	if _, err := r.Register("events-value", `message Event { int64 id = 1; reserved 2 to 4; }`); err != nil {
+		t.Fatalf("unable to register schema: %v", err)
+	}
+	if _, err := r.Register("events-value", `message Event { int64 id = 1; string status = 3; }`); err == nil {
+		t.Errorf("expected reuse of a reserved number to be rejected")
+	}
+}
+
+func TestTransactionsSchemaParses(t *testing.T) {
+	schema, err := ParseProto(models.TransactionsProtoSchema)
+	if err != nil {
+		t.Fatalf("unable to parse transactions.proto: %v", err)
+	}
+	for _, name := range []string{"TransactionRequestEncrypted", "TransactionStatusEvent"} {
+		if _, ok := schema.Messages[name]; !ok {
+			t.Errorf("Expected message %s in transactions.proto", name)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"payment-gateway/internal/models"
+	"time"
 )
 
 // decodes the incoming request based on content type
@@ -24,30 +25,61 @@ func DecodeRequest[T any](r *http.Request, request *T) error {
 	}
 }
 
-func TransactionRequestToEncrypted(txReq *models.TransactionRequest) (*models.TransactionRequestEncrypted, error) {
+// every format a gateway can receive messages in
+var SupportedDataFormats = []string{"application/json", "text/xml", "application/xml", "application/x-protobuf", "application/iso20022+xml"}
+
+func IsSupportedDataFormat(format string) bool {
+	for _, supported := range SupportedDataFormats {
+		if format == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// text/xml and application/xml are the same format as far as encoding is concerned
+func SameDataFormat(a, b string) bool {
+	normalize := func(format string) string {
+		if format == "text/xml" {
+			return "application/xml"
+		}
+		return format
+	}
+	return normalize(a) == normalize(b)
+}
+
+// what encoding a transaction for a gateway needs besides the transaction itself
+type EncodeOptions struct {
+	Cipher Cipher
+	// the account withdrawals are paid from, only needed for ISO 20022
+	Debtor models.Beneficiary
+	Now    time.Time
+}
+
+func TransactionRequestToEncrypted(c Cipher, txReq *models.TransactionRequest) (*models.TransactionRequestEncrypted, error) {
 	tx := *txReq
 
-	currency, err := Encrypt([]byte(tx.Currency))
+	currency, err := c.Encrypt([]byte(tx.Currency))
 	if err != nil {
 		return nil, err
 	}
 
-	countryid, err := Encrypt([]byte(fmt.Sprint(tx.CountryID)))
+	countryid, err := c.Encrypt([]byte(fmt.Sprint(tx.CountryID)))
 	if err != nil {
 		return nil, err
 	}
 
-	userid, err := Encrypt([]byte(fmt.Sprint(tx.UserID)))
+	userid, err := c.Encrypt([]byte(fmt.Sprint(tx.UserID)))
 	if err != nil {
 		return nil, err
 	}
 
-	amount, err := Encrypt([]byte(tx.Amount.String()))
+	amount, err := c.Encrypt([]byte(tx.Amount.String()))
 	if err != nil {
 		return nil, err
 	}
 
-	typ, err := Encrypt([]byte(tx.Type))
+	typ, err := c.Encrypt([]byte(tx.Type))
 	if err != nil {
 		return nil, err
 	}
@@ -59,11 +91,17 @@ func TransactionRequestToEncrypted(txReq *models.TransactionRequest) (*models.Tr
 		UserID:    userid,
 		CountryID: countryid,
 		Currency:  currency,
+
+		OriginalTransactionID: tx.OriginalTransactionID,
 	}, nil
 }
 
-func EncodeAndEncryptKafkaTransaction(kafkaTransacion *models.TransactionRequest, dataFormat string) ([]byte, error) {
-	encrypted, err := TransactionRequestToEncrypted(kafkaTransacion)
+func EncodeAndEncryptKafkaTransaction(kafkaTransacion *models.TransactionRequest, dataFormat string, opts EncodeOptions) ([]byte, error) {
+	if dataFormat == "application/iso20022+xml" {
+		return EncodeAndEncryptPain001(kafkaTransacion, opts)
+	}
+
+	encrypted, err := TransactionRequestToEncrypted(opts.Cipher, kafkaTransacion)
 	if err != nil {
 		return nil, err
 	}
@@ -72,6 +110,29 @@ func EncodeAndEncryptKafkaTransaction(kafkaTransacion *models.TransactionRequest
 		return json.Marshal(encrypted)
 	case "text/xml", "application/xml":
 		return xml.Marshal(encrypted)
+	case "application/x-protobuf":
+		return MarshalTransactionProto(encrypted), nil
+	default:
+		return nil, fmt.Errorf("unsupported data format")
+	}
+}
+
+// decodes a kafka transaction message produced by EncodeAndEncryptKafkaTransaction. The fields are left encrypted.
+func DecodeKafkaTransaction(message []byte, dataFormat string) (*models.TransactionRequestEncrypted, error) {
+	encrypted := &models.TransactionRequestEncrypted{}
+	switch dataFormat {
+	case "application/json":
+		if err := json.Unmarshal(message, encrypted); err != nil {
+			return nil, err
+		}
+		return encrypted, nil
+	case "text/xml", "application/xml":
+		if err := xml.Unmarshal(message, encrypted); err != nil {
+			return nil, err
+		}
+		return encrypted, nil
+	case "application/x-protobuf":
+		return UnmarshalTransactionProto(message)
 	default:
 		return nil, fmt.Errorf("unsupported data format")
 	}
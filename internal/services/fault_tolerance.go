@@ -8,30 +8,58 @@ import (
 	"github.com/sony/gobreaker"
 )
 
-// CircuitBreaker configuration for kafka publisher doing only 1 request at a time each five seconds
-var cb = gobreaker.NewCircuitBreaker(gobreaker.Settings{
-	Name:        "KafkaPublisher",
-	MaxRequests: 1,
-	Interval:    5 * time.Second,
-	Timeout:     3 * time.Second,
-})
+type RetryPolicy struct {
+	Attempts int
+	// wait after the first failed attempt, doubled after each one after it
+	Backoff time.Duration
+	// called after every attempt with its number, starting at 1, and its outcome
+	OnAttempt func(attempt int, err error)
+}
+
+type BreakerSettings struct {
+	// requests let through while half-open
+	MaxRequests uint32
+	// how often the failure counts are cleared while closed
+	Interval time.Duration
+	// how long the breaker stays open before trying again
+	Timeout time.Duration
+	// called whenever the breaker opens, half-opens or closes
+	OnStateChange func(name string, from, to gobreaker.State)
+}
 
-// publishes a transaction to Kafka using a circuit breaker
-func PublishWithCircuitBreaker(operation func() error) error {
-	_, err := cb.Execute(func() (interface{}, error) {
-		return nil, operation()
+// CircuitBreaker for the kafka publisher, by default doing only 1 request at a time each five seconds
+func NewCircuitBreaker(name string, settings BreakerSettings) *gobreaker.CircuitBreaker {
+	return gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:          name,
+		MaxRequests:   settings.MaxRequests,
+		Interval:      settings.Interval,
+		Timeout:       settings.Timeout,
+		OnStateChange: settings.OnStateChange,
 	})
-	return err
 }
 
-// Retry operation with exponential backoff
-func RetryOperation(operation func() error, maxRetries int) error {
-	for i := 0; i < maxRetries; i++ {
-		if err := PublishWithCircuitBreaker(operation); err == nil {
+// Retry operation with exponential backoff. Attempts go through cb unless it is nil.
+func RetryOperation(operation func() error, policy RetryPolicy, cb *gobreaker.CircuitBreaker) error {
+	for i := 0; i < policy.Attempts; i++ {
+		var err error
+		if cb != nil {
+			_, err = cb.Execute(func() (interface{}, error) {
+				return nil, operation()
+			})
+		} else {
+			err = operation()
+		}
+		if policy.OnAttempt != nil {
+			policy.OnAttempt(i+1, err)
+		}
+		if err == nil {
 			return nil
 		}
-		backoff := time.Duration(math.Pow(2, float64(i))) * time.Second //the 2^i was a bitwise XOR operation not an exponential backoff
+		if i == policy.Attempts-1 {
+			break
+		}
+		backoff := time.Duration(math.Pow(2, float64(i))) * policy.Backoff //the 2^i was a bitwise XOR operation not an exponential backoff
 		time.Sleep(backoff)
 	}
-	return fmt.Errorf("operation failed after %d attempts", maxRetries)
+	return fmt.Errorf("operation failed after %d attempts", policy.Attempts)
 }
@@ -0,0 +1,380 @@
+package services
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"payment-gateway/internal/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// ISO 20022 support for bank payout gateways. Withdrawals are sent as pain.001 customer credit transfer
+// initiations, one payment per message, and the banks report back with pain.002 payment status reports.
+//
+// Every identifier in the pain.001 is derived from the transaction ID so a pain.002 can be matched back to the
+// transaction at whichever level the bank reports the status: "PG-<id>" for the message and payment information
+// IDs and "<id>" for the end to end ID. Cancellations are sent as camt.055 customer payment cancellation requests
+// quoting those identifiers.
+
+// identifier prefix for pain.001 messages and payment information blocks
+const iso20022IDPrefix = "PG-"
+
+type Pain001Document struct {
+	XMLName                xml.Name          `xml:"urn:iso:std:iso:20022:tech:xsd:pain.001.001.03 Document"`
+	CustomerCreditTransfer pain001Initiation `xml:"CstmrCdtTrfInitn"`
+}
+
+type pain001Initiation struct {
+	GroupHeader        pain001GroupHeader `xml:"GrpHdr"`
+	PaymentInformation pain001PaymentInfo `xml:"PmtInf"`
+}
+
+type pain001GroupHeader struct {
+	MessageID        string          `xml:"MsgId"`
+	CreationDateTime string          `xml:"CreDtTm"`
+	NumberOfTxs      int             `xml:"NbOfTxs"`
+	ControlSum       decimal.Decimal `xml:"CtrlSum"`
+	InitiatingParty  isoParty        `xml:"InitgPty"`
+}
+
+type pain001PaymentInfo struct {
+	PaymentInfoID        string              `xml:"PmtInfId"`
+	PaymentMethod        string              `xml:"PmtMtd"`
+	NumberOfTxs          int                 `xml:"NbOfTxs"`
+	ControlSum           decimal.Decimal     `xml:"CtrlSum"`
+	RequestedExecution   string              `xml:"ReqdExctnDt"`
+	Debtor               isoParty            `xml:"Dbtr"`
+	DebtorAccount        isoAccount          `xml:"DbtrAcct"`
+	DebtorAgent          isoAgent            `xml:"DbtrAgt"`
+	ChargeBearer         string              `xml:"ChrgBr"`
+	CreditTransferTxInfo pain001CreditTxInfo `xml:"CdtTrfTxInf"`
+}
+
+type pain001CreditTxInfo struct {
+	PaymentID       isoPaymentID      `xml:"PmtId"`
+	Amount          isoAmount         `xml:"Amt"`
+	CreditorAgent   *isoAgent         `xml:"CdtrAgt,omitempty"`
+	Creditor        isoParty          `xml:"Cdtr"`
+	CreditorAccount isoAccount        `xml:"CdtrAcct"`
+	Remittance      *isoRemittanceInf `xml:"RmtInf,omitempty"`
+}
+
+type isoParty struct {
+	Name    string      `xml:"Nm"`
+	Address *isoAddress `xml:"PstlAdr,omitempty"`
+}
+
+type isoAddress struct {
+	Country string `xml:"Ctry"`
+}
+
+type isoAccount struct {
+	IBAN string `xml:"Id>IBAN"`
+}
+
+type isoAgent struct {
+	BIC string `xml:"FinInstnId>BIC"`
+}
+
+type isoPaymentID struct {
+	EndToEndID string `xml:"EndToEndId"`
+}
+
+type isoAmount struct {
+	Instructed isoInstructedAmount `xml:"InstdAmt"`
+}
+
+type isoInstructedAmount struct {
+	Currency string          `xml:"Ccy,attr"`
+	Value    decimal.Decimal `xml:",chardata"`
+}
+
+type isoRemittanceInf struct {
+	Unstructured string `xml:"Ustrd"`
+}
+
+// builds a pain.001 credit transfer initiation for a withdrawal. The transaction must already have an ID.
+func NewPain001(txReq *models.TransactionRequest, debtor models.Beneficiary, now time.Time) (*Pain001Document, error) {
+	if txReq.Type != "withdrawal" {
+		return nil, fmt.Errorf("ISO 20022 pain.001 only supports withdrawals, got %s", txReq.Type)
+	}
+	if txReq.TransactionID == 0 {
+		return nil, fmt.Errorf("ISO 20022 pain.001 requires a transaction id")
+	}
+	if txReq.Beneficiary == nil || txReq.Beneficiary.Name == "" || txReq.Beneficiary.IBAN == "" {
+		return nil, fmt.Errorf("ISO 20022 pain.001 requires a beneficiary name and IBAN")
+	}
+
+	id := iso20022IDPrefix + strconv.Itoa(txReq.TransactionID)
+	beneficiary := txReq.Beneficiary
+
+	txInfo := pain001CreditTxInfo{
+		PaymentID: isoPaymentID{EndToEndID: strconv.Itoa(txReq.TransactionID)},
+		Amount: isoAmount{Instructed: isoInstructedAmount{
+			Currency: txReq.Currency,
+			Value:    txReq.Amount.Round(2),
+		}},
+		Creditor:        isoParty{Name: beneficiary.Name},
+		CreditorAccount: isoAccount{IBAN: strings.ReplaceAll(beneficiary.IBAN, " ", "")},
+		Remittance:      &isoRemittanceInf{Unstructured: "Withdrawal " + strconv.Itoa(txReq.TransactionID)},
+	}
+	if beneficiary.BIC != "" {
+		txInfo.CreditorAgent = &isoAgent{BIC: beneficiary.BIC}
+	}
+	if beneficiary.Country != "" {
+		txInfo.Creditor.Address = &isoAddress{Country: beneficiary.Country}
+	}
+
+	return &Pain001Document{
+		CustomerCreditTransfer: pain001Initiation{
+			GroupHeader: pain001GroupHeader{
+				MessageID:        id,
+				CreationDateTime: now.UTC().Format("2006-01-02T15:04:05"),
+				NumberOfTxs:      1,
+				ControlSum:       txReq.Amount.Round(2),
+				InitiatingParty:  isoParty{Name: debtor.Name},
+			},
+			PaymentInformation: pain001PaymentInfo{
+				PaymentInfoID:        id,
+				PaymentMethod:        "TRF",
+				NumberOfTxs:          1,
+				ControlSum:           txReq.Amount.Round(2),
+				RequestedExecution:   now.UTC().Format("2006-01-02"),
+				Debtor:               isoParty{Name: debtor.Name},
+				DebtorAccount:        isoAccount{IBAN: debtor.IBAN},
+				DebtorAgent:          isoAgent{BIC: debtor.BIC},
+				ChargeBearer:         "SLEV",
+				CreditTransferTxInfo: txInfo,
+			},
+		},
+	}, nil
+}
+
+// Encodes a withdrawal as pain.001 and AES encrypts the whole document. Unlike the JSON and XML formats the
+// fields are not encrypted individually because the document has to stay schema valid for the bank.
+func EncodeAndEncryptPain001(txReq *models.TransactionRequest, opts EncodeOptions) ([]byte, error) {
+	debtor := opts.Debtor
+	if debtor.Name == "" || debtor.IBAN == "" || debtor.BIC == "" {
+		return nil, fmt.Errorf("the ISO 20022 debtor name, IBAN and BIC are not configured")
+	}
+	doc, err := NewPain001(txReq, debtor, opts.Now)
+	if err != nil {
+		return nil, err
+	}
+	body, err := xml.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := opts.Cipher.Encrypt(append([]byte(xml.Header), body...))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(encrypted), nil
+}
+
+// the pain.001 version withdrawals are sent in, quoted by cancellations
+const pain001MessageName = "pain.001.001.03"
+
+// identifier prefix for camt.055 cancellation assignments
+const iso20022CancellationPrefix = "PG-CXL-"
+
+type Camt055Document struct {
+	XMLName             xml.Name                `xml:"urn:iso:std:iso:20022:tech:xsd:camt.055.001.05 Document"`
+	CancellationRequest camt055CancellationRqst `xml:"CstmrPmtCxlReq"`
+}
+
+type camt055CancellationRqst struct {
+	Assignment camt055Assignment `xml:"Assgnmt"`
+	Underlying camt055Underlying `xml:"Undrlyg"`
+}
+
+type camt055Assignment struct {
+	ID               string   `xml:"Id"`
+	Assigner         isoParty `xml:"Assgnr>Pty"`
+	Assignee         isoAgent `xml:"Assgne>Agt"`
+	CreationDateTime string   `xml:"CreDtTm"`
+}
+
+type camt055Underlying struct {
+	OriginalPayment camt055OriginalPayment `xml:"OrgnlPmtInfAndCxl"`
+}
+
+type camt055OriginalPayment struct {
+	OriginalPaymentInfoID string             `xml:"OrgnlPmtInfId"`
+	OriginalMessageID     string             `xml:"OrgnlGrpInf>OrgnlMsgId"`
+	OriginalMessageName   string             `xml:"OrgnlGrpInf>OrgnlMsgNmId"`
+	Transaction           camt055Transaction `xml:"TxInf"`
+}
+
+type camt055Transaction struct {
+	OriginalEndToEndID string `xml:"OrgnlEndToEndId"`
+	ReasonCode         string `xml:"CxlRsnInf>Rsn>Cd"`
+}
+
+// builds a camt.055 asking the debtor's bank to cancel the pain.001 sent for the withdrawal transactionID
+func NewCamt055(transactionID int, debtor models.Beneficiary, now time.Time) *Camt055Document {
+	id := iso20022IDPrefix + strconv.Itoa(transactionID)
+	return &Camt055Document{
+		CancellationRequest: camt055CancellationRqst{
+			Assignment: camt055Assignment{
+				ID:               iso20022CancellationPrefix + strconv.Itoa(transactionID),
+				Assigner:         isoParty{Name: debtor.Name},
+				Assignee:         isoAgent{BIC: debtor.BIC},
+				CreationDateTime: now.UTC().Format("2006-01-02T15:04:05"),
+			},
+			Underlying: camt055Underlying{OriginalPayment: camt055OriginalPayment{
+				OriginalPaymentInfoID: id,
+				OriginalMessageID:     id,
+				OriginalMessageName:   pain001MessageName,
+				// requested by the customer
+				Transaction: camt055Transaction{OriginalEndToEndID: strconv.Itoa(transactionID), ReasonCode: "CUST"},
+			}},
+		},
+	}
+}
+
+// Encodes a cancellation for the gateway's dataFormat. ISO 20022 gateways get a camt.055 encrypted whole like
+// pain.001, the other formats the status event, which carries nothing to encrypt.
+func EncodeCancellation(event *models.TransactionStatusEvent, dataFormat string, opts EncodeOptions) ([]byte, error) {
+	switch dataFormat {
+	case "application/iso20022+xml":
+		debtor := opts.Debtor
+		if debtor.Name == "" || debtor.IBAN == "" || debtor.BIC == "" {
+			return nil, fmt.Errorf("the ISO 20022 debtor name, IBAN and BIC are not configured")
+		}
+		body, err := xml.Marshal(NewCamt055(event.TransactionID, debtor, opts.Now))
+		if err != nil {
+			return nil, err
+		}
+		encrypted, err := opts.Cipher.Encrypt(append([]byte(xml.Header), body...))
+		if err != nil {
+			return nil, err
+		}
+		return []byte(encrypted), nil
+	case "application/json":
+		return json.Marshal(event)
+	case "text/xml", "application/xml":
+		return xml.Marshal(event)
+	case "application/x-protobuf":
+		return MarshalStatusEventProto(event), nil
+	default:
+		return nil, fmt.Errorf("unsupported data format")
+	}
+}
+
+// A pain.002 customer payment status report. Only the fields needed to update transactions are decoded and
+// the namespace is ignored so any pain.002 version is accepted.
+type Pain002Document struct {
+	XMLName xml.Name         `xml:"Document" json:"-"`
+	Report  pain002StsReport `xml:"CstmrPmtStsRpt"`
+}
+
+type pain002StsReport struct {
+	OriginalGroup   pain002OriginalGroup     `xml:"OrgnlGrpInfAndSts"`
+	OriginalPayment []pain002OriginalPayment `xml:"OrgnlPmtInfAndSts"`
+}
+
+type pain002OriginalGroup struct {
+	OriginalMessageID string          `xml:"OrgnlMsgId"`
+	GroupStatus       string          `xml:"GrpSts"`
+	Reasons           []pain002Reason `xml:"StsRsnInf"`
+}
+
+type pain002OriginalPayment struct {
+	OriginalPaymentInfoID string            `xml:"OrgnlPmtInfId"`
+	PaymentInfoStatus     string            `xml:"PmtInfSts"`
+	Reasons               []pain002Reason   `xml:"StsRsnInf"`
+	Transactions          []pain002TxStatus `xml:"TxInfAndSts"`
+}
+
+type pain002TxStatus struct {
+	OriginalEndToEndID string          `xml:"OrgnlEndToEndId"`
+	TransactionStatus  string          `xml:"TxSts"`
+	Reasons            []pain002Reason `xml:"StsRsnInf"`
+}
+
+type pain002Reason struct {
+	Code        string `xml:"Rsn>Cd"`
+	Proprietary string `xml:"Rsn>Prtry"`
+	Info        string `xml:"AddtlInf"`
+}
+
+// the status the bank reported for one transaction
+type Pain002Status struct {
+	TransactionID int
+	Status        string // the ISO 20022 status code e.g. ACSC or RJCT
+	Reason        string
+}
+
+func ParsePain002(b []byte) (*Pain002Document, error) {
+	doc := &Pain002Document{}
+	if err := xml.Unmarshal(b, doc); err != nil {
+		return nil, fmt.Errorf("invalid pain.002 document: %w", err)
+	}
+	return doc, nil
+}
+
+func iso20022TransactionID(id string) (int, error) {
+	txid, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(id), iso20022IDPrefix))
+	if err != nil {
+		return 0, fmt.Errorf("unrecognised ISO 20022 identifier %q", id)
+	}
+	return txid, nil
+}
+
+func reasonText(reasons []pain002Reason) string {
+	var parts []string
+	for _, r := range reasons {
+		for _, part := range []string{r.Code, r.Proprietary, r.Info} {
+			if part != "" {
+				parts = append(parts, part)
+			}
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Flattens the report into one status per transaction. Transaction level statuses win, then the payment
+// information status and finally the group status for reports that reject a message outright.
+func (d *Pain002Document) Statuses() ([]Pain002Status, error) {
+	var statuses []Pain002Status
+	for _, payment := range d.Report.OriginalPayment {
+		if len(payment.Transactions) == 0 && payment.PaymentInfoStatus != "" {
+			txid, err := iso20022TransactionID(payment.OriginalPaymentInfoID)
+			if err != nil {
+				return nil, err
+			}
+			statuses = append(statuses, Pain002Status{TransactionID: txid, Status: payment.PaymentInfoStatus, Reason: reasonText(payment.Reasons)})
+			continue
+		}
+		for _, tx := range payment.Transactions {
+			txid, err := iso20022TransactionID(tx.OriginalEndToEndID)
+			if err != nil {
+				return nil, err
+			}
+			status := tx.TransactionStatus
+			if status == "" {
+				status = payment.PaymentInfoStatus
+			}
+			statuses = append(statuses, Pain002Status{TransactionID: txid, Status: status, Reason: reasonText(tx.Reasons)})
+		}
+	}
+
+	group := d.Report.OriginalGroup
+	if len(statuses) == 0 && group.GroupStatus != "" {
+		txid, err := iso20022TransactionID(group.OriginalMessageID)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, Pain002Status{TransactionID: txid, Status: group.GroupStatus, Reason: reasonText(group.Reasons)})
+	}
+
+	if len(statuses) == 0 {
+		return nil, fmt.Errorf("pain.002 report contains no statuses")
+	}
+	return statuses, nil
+}
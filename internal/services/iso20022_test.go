@@ -0,0 +1,157 @@
+package services
+
+import (
+	"encoding/xml"
+	"payment-gateway/internal/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var testDebtor = models.Beneficiary{Name: "Payment Gateway Ltd", IBAN: "AE070331234567890123456", BIC: "EBILAEAD"}
+
+func TestNewPain001(t *testing.T) {
+	doc, err := NewPain001(&models.TransactionRequest{
+		TransactionID: 42,
+		Type:          "withdrawal",
+		Amount:        decimal.NewFromFloat(125.5),
+		Currency:      "AED",
+		Beneficiary:   &models.Beneficiary{Name: "John Smith", IBAN: "AE46 0090 0000 0012 3456 789", BIC: "NBADAEAA", Country: "AE"},
+	}, testDebtor, time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("unable to build pain.001: %v", err)
+	}
+
+	b, err := xml.Marshal(doc)
+	if err != nil {
+		t.Fatalf("unable to marshal pain.001: %v", err)
+	}
+	out := string(b)
+	for _, expected := range []string{
+		`<Document xmlns="urn:iso:std:iso:20022:tech:xsd:pain.001.001.03">`,
+		`<MsgId>PG-42</MsgId>`,
+		`<CtrlSum>125.5</CtrlSum>`,
+		`<EndToEndId>42</EndToEndId>`,
+		`<InstdAmt Ccy="AED">125.5</InstdAmt>`,
+		`<CdtrAgt><FinInstnId><BIC>NBADAEAA</BIC></FinInstnId></CdtrAgt>`,
+		`<CdtrAcct><Id><IBAN>AE460090000000123456789</IBAN></Id></CdtrAcct>`,
+		`<DbtrAcct><Id><IBAN>AE070331234567890123456</IBAN></Id></DbtrAcct>`,
+		`<ReqdExctnDt>2024-03-01</ReqdExctnDt>`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected pain.001 to contain %s\nReceived %s", expected, out)
+		}
+	}
+}
+
+func TestNewPain001RejectsInvalidRequests(t *testing.T) {
+	beneficiary := &models.Beneficiary{Name: "John Smith", IBAN: "AE460090000000123456789"}
+	requests := []models.TransactionRequest{
+		{TransactionID: 1, Type: "deposit", Currency: "AED", Beneficiary: beneficiary},
+		{TransactionID: 1, Type: "withdrawal", Currency: "AED"},
+		{Type: "withdrawal", Currency: "AED", Beneficiary: beneficiary},
+	}
+	for _, req := range requests {
+		if _, err := NewPain001(&req, testDebtor, time.Now()); err == nil {
+			t.Errorf("Expected %+v to be rejected", req)
+		}
+	}
+}
+
+func TestPain002Statuses(t *testing.T) {
+	report := `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:pain.002.001.03">
+  <CstmrPmtStsRpt>
+    <GrpHdr><MsgId>BANK-1</MsgId></GrpHdr>
+    <OrgnlGrpInfAndSts><OrgnlMsgId>PG-1</OrgnlMsgId><OrgnlMsgNmId>pain.001.001.03</OrgnlMsgNmId></OrgnlGrpInfAndSts>
+    <OrgnlPmtInfAndSts>
+      <OrgnlPmtInfId>PG-1</OrgnlPmtInfId>
+      <TxInfAndSts><OrgnlEndToEndId>1</OrgnlEndToEndId><TxSts>ACSC</TxSts></TxInfAndSts>
+      <TxInfAndSts>
+        <OrgnlEndToEndId>2</OrgnlEndToEndId><TxSts>RJCT</TxSts>
+        <StsRsnInf><Rsn><Cd>AC04</Cd></Rsn><AddtlInf>Closed account</AddtlInf></StsRsnInf>
+      </TxInfAndSts>
+    </OrgnlPmtInfAndSts>
+    <OrgnlPmtInfAndSts><OrgnlPmtInfId>PG-3</OrgnlPmtInfId><PmtInfSts>PDNG</PmtInfSts></OrgnlPmtInfAndSts>
+  </CstmrPmtStsRpt>
+</Document>`
+
+	doc, err := ParsePain002([]byte(report))
+	if err != nil {
+		t.Fatalf("unable to parse pain.002: %v", err)
+	}
+	statuses, err := doc.Statuses()
+	if err != nil {
+		t.Fatalf("unable to read statuses: %v", err)
+	}
+
+	expected := []Pain002Status{
+		{TransactionID: 1, Status: "ACSC"},
+		{TransactionID: 2, Status: "RJCT", Reason: "AC04 Closed account"},
+		{TransactionID: 3, Status: "PDNG"},
+	}
+	if len(statuses) != len(expected) {
+		t.Fatalf("Expected %d statuses Received %d", len(expected), len(statuses))
+	}
+	for i := range expected {
+		if statuses[i] != expected[i] {
+			t.Errorf("Expected %+v Received %+v", expected[i], statuses[i])
+		}
+	}
+}
+
+func TestPain002GroupRejection(t *testing.T) {
+	doc, err := ParsePain002([]byte(`<Document><CstmrPmtStsRpt><OrgnlGrpInfAndSts><OrgnlMsgId>PG-7</OrgnlMsgId><GrpSts>RJCT</GrpSts></OrgnlGrpInfAndSts></CstmrPmtStsRpt></Document>`))
+	if err != nil {
+		t.Fatalf("unable to parse pain.002: %v", err)
+	}
+	statuses, err := doc.Statuses()
+	if err != nil || len(statuses) != 1 || statuses[0].TransactionID != 7 || statuses[0].Status != "RJCT" {
+		t.Errorf("Expected transaction 7 to be rejected, got %+v (%v)", statuses, err)
+	}
+}
+
+func TestEncodeCancellation(t *testing.T) {
+	cipher, _ := NewAESCipher(make([]byte, 32))
+	opts := EncodeOptions{Cipher: cipher, Debtor: testDebtor, Now: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)}
+	event := &models.TransactionStatusEvent{TransactionID: 42, Type: "WITHDRAWAL", Status: "CANCELLED", GatewayID: 3}
+
+	encrypted, err := EncodeCancellation(event, "application/iso20022+xml", opts)
+	if err != nil {
+		t.Fatalf("unable to encode camt.055: %v", err)
+	}
+	out, err := cipher.Decrypt(string(encrypted))
+	if err != nil {
+		t.Fatalf("unable to decrypt camt.055: %v", err)
+	}
+	for _, expected := range []string{
+		`<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.055.001.05">`,
+		`<Assgnmt><Id>PG-CXL-42</Id>`,
+		`<Assgne><Agt><FinInstnId><BIC>EBILAEAD</BIC></FinInstnId></Agt></Assgne>`,
+		`<OrgnlPmtInfId>PG-42</OrgnlPmtInfId>`,
+		`<OrgnlGrpInf><OrgnlMsgId>PG-42</OrgnlMsgId><OrgnlMsgNmId>pain.001.001.03</OrgnlMsgNmId></OrgnlGrpInf>`,
+		`<TxInf><OrgnlEndToEndId>42</OrgnlEndToEndId><CxlRsnInf><Rsn><Cd>CUST</Cd></Rsn></CxlRsnInf></TxInf>`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected %s in\n%s", expected, out)
+		}
+	}
+
+	b, err := EncodeCancellation(event, "application/x-protobuf", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err := UnmarshalStatusEventProto(b); err != nil || *decoded != *event {
+		t.Errorf("Expected the status event, got %+v (%v)", decoded, err)
+	}
+	if b, err := EncodeCancellation(event, "application/json", opts); err != nil || !strings.Contains(string(b), `"status":"CANCELLED"`) {
+		t.Errorf("Expected the status event as JSON, got %s (%v)", b, err)
+	}
+
+	opts.Debtor = models.Beneficiary{}
+	if _, err := EncodeCancellation(event, "application/iso20022+xml", opts); err == nil {
+		t.Error("Expected a camt.055 without a debtor to be rejected")
+	}
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"fmt"
+	"payment-gateway/internal/models"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Hand written codecs for the messages in models/transactions.proto. The field numbers below must match the schema.
+
+const (
+	txFieldType       protowire.Number = 1
+	txFieldAmount     protowire.Number = 2
+	txFieldUserID     protowire.Number = 3
+	txFieldCountryID  protowire.Number = 4
+	txFieldCurrency   protowire.Number = 5
+	txFieldGatewayID  protowire.Number = 6
+	txFieldOriginalID protowire.Number = 7
+)
+
+const (
+	evFieldTransactionID protowire.Number = 1
+	evFieldStatus        protowire.Number = 2
+	evFieldGatewayID     protowire.Number = 3
+	evFieldType          protowire.Number = 4
+)
+
+// proto3 does not put default values on the wire, so empty strings and zeros are skipped
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendInt(b []byte, num protowire.Number, v int) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(int64(v)))
+}
+
+// walks every field in a protobuf message calling field for each one. Unknown fields are skipped by the caller
+// returning false so that older consumers keep working when new fields are added to the schema.
+func consumeFields(b []byte, field func(num protowire.Number, typ protowire.Type, b []byte) (int, bool)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("invalid protobuf tag: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		n, ok := field(num, typ, b)
+		if !ok {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return fmt.Errorf("invalid protobuf field %d: %w", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func consumeString(typ protowire.Type, b []byte, v *string) (int, bool) {
+	if typ != protowire.BytesType {
+		return 0, false
+	}
+	s, n := protowire.ConsumeString(b)
+	*v = s
+	return n, true
+}
+
+func consumeInt(typ protowire.Type, b []byte, v *int) (int, bool) {
+	if typ != protowire.VarintType {
+		return 0, false
+	}
+	i, n := protowire.ConsumeVarint(b)
+	*v = int(int64(i))
+	return n, true
+}
+
+func MarshalTransactionProto(tx *models.TransactionRequestEncrypted) []byte {
+	var b []byte
+	b = appendString(b, txFieldType, tx.Type)
+	b = appendString(b, txFieldAmount, tx.Amount)
+	b = appendString(b, txFieldUserID, tx.UserID)
+	b = appendString(b, txFieldCountryID, tx.CountryID)
+	b = appendString(b, txFieldCurrency, tx.Currency)
+	b = appendInt(b, txFieldGatewayID, tx.GatewayID)
+	b = appendInt(b, txFieldOriginalID, tx.OriginalTransactionID)
+	return b
+}
+
+func UnmarshalTransactionProto(b []byte) (*models.TransactionRequestEncrypted, error) {
+	tx := &models.TransactionRequestEncrypted{}
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, bool) {
+		switch num {
+		case txFieldType:
+			return consumeString(typ, b, &tx.Type)
+		case txFieldAmount:
+			return consumeString(typ, b, &tx.Amount)
+		case txFieldUserID:
+			return consumeString(typ, b, &tx.UserID)
+		case txFieldCountryID:
+			return consumeString(typ, b, &tx.CountryID)
+		case txFieldCurrency:
+			return consumeString(typ, b, &tx.Currency)
+		case txFieldGatewayID:
+			return consumeInt(typ, b, &tx.GatewayID)
+		case txFieldOriginalID:
+			return consumeInt(typ, b, &tx.OriginalTransactionID)
+		}
+		return 0, false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+func MarshalStatusEventProto(event *models.TransactionStatusEvent) []byte {
+	var b []byte
+	b = appendInt(b, evFieldTransactionID, event.TransactionID)
+	b = appendString(b, evFieldStatus, event.Status)
+	b = appendInt(b, evFieldGatewayID, event.GatewayID)
+	b = appendString(b, evFieldType, event.Type)
+	return b
+}
+
+func UnmarshalStatusEventProto(b []byte) (*models.TransactionStatusEvent, error) {
+	event := &models.TransactionStatusEvent{}
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, bool) {
+		switch num {
+		case evFieldTransactionID:
+			return consumeInt(typ, b, &event.TransactionID)
+		case evFieldStatus:
+			return consumeString(typ, b, &event.Status)
+		case evFieldGatewayID:
+			return consumeInt(typ, b, &event.GatewayID)
+		case evFieldType:
+			return consumeString(typ, b, &event.Type)
+		}
+		return 0, false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
+}
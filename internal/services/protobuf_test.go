@@ -0,0 +1,86 @@
+package services
+
+import (
+	"payment-gateway/internal/models"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestTransactionProtoRoundTrip(t *testing.T) {
+	tx := models.TransactionRequestEncrypted{
+		Type:      "dHlwZQ==",
+		Amount:    "YW1vdW50",
+		UserID:    "dXNlcg==",
+		CountryID: "Y291bnRyeQ==",
+		Currency:  "Y3VycmVuY3k=",
+		GatewayID: 7,
+
+		OriginalTransactionID: 42,
+	}
+
+	decoded, err := UnmarshalTransactionProto(MarshalTransactionProto(&tx))
+	if err != nil {
+		t.Fatalf("unable to decode transaction: %v", err)
+	}
+	if *decoded != tx {
+		t.Errorf("Expected %+v Received %+v", tx, *decoded)
+	}
+}
+
+func TestStatusEventProtoRoundTrip(t *testing.T) {
+	event := models.TransactionStatusEvent{TransactionID: 42, Type: "deposit", Status: "SUCCESS", GatewayID: 3}
+
+	decoded, err := UnmarshalStatusEventProto(MarshalStatusEventProto(&event))
+	if err != nil {
+		t.Fatalf("unable to decode status event: %v", err)
+	}
+	if *decoded != event {
+		t.Errorf("Expected %+v Received %+v", event, *decoded)
+	}
+}
+
+func TestUnmarshalProtoSkipsUnknownFields(t *testing.T) {
+	b := MarshalStatusEventProto(&models.TransactionStatusEvent{TransactionID: 1, Status: "FAILED"})
+	b = protowire.AppendTag(b, 99, protowire.BytesType)
+	b = protowire.AppendString(b, "added in a later schema version")
+
+	decoded, err := UnmarshalStatusEventProto(b)
+	if err != nil {
+		t.Fatalf("unable to decode status event: %v", err)
+	}
+	if decoded.TransactionID != 1 || decoded.Status != "FAILED" {
+		t.Errorf("unexpected event %+v", *decoded)
+	}
+
+	if _, err := UnmarshalStatusEventProto([]byte{0x0a, 0x05, 'a'}); err == nil {
+		t.Errorf("expected truncated message to fail")
+	}
+}
+
+func TestEncodeAndDecodeKafkaTransactionProtobuf(t *testing.T) {
+	c := newTestCipher(t)
+	message, err := EncodeAndEncryptKafkaTransaction(&models.TransactionRequest{
+		Type:      "deposit",
+		Amount:    decimal.NewFromInt(20),
+		UserID:    1,
+		CountryID: 1,
+		Currency:  "USD",
+		GatewayID: 2,
+	}, "application/x-protobuf", EncodeOptions{Cipher: c})
+	if err != nil {
+		t.Fatalf("unable to encode transaction: %v", err)
+	}
+
+	encrypted, err := DecodeKafkaTransaction(message, "application/x-protobuf")
+	if err != nil {
+		t.Fatalf("unable to decode transaction: %v", err)
+	}
+	if encrypted.GatewayID != 2 {
+		t.Errorf("Expected gateway 2 Received %d", encrypted.GatewayID)
+	}
+	if currency, err := c.Decrypt(encrypted.Currency); err != nil || currency != "USD" {
+		t.Errorf("Expected USD Received %q (%v)", currency, err)
+	}
+}
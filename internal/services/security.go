@@ -8,27 +8,41 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
-	"log"
-	"os"
 )
 
-var key []byte
+// Encrypts the messages published to gateways
+type Cipher interface {
+	Encrypt(data []byte) (string, error)
+	Decrypt(ciphertextBase64 string) (string, error)
+}
+
+// AES-GCM with the nonce prepended to the ciphertext and the result base64 encoded
+type AESCipher struct {
+	key []byte
+}
 
-func init() {
-	keyString := os.Getenv("AES_ENCRYPTION_CIPHER")
-	if keyString == "" {
-		log.Fatalln("AES_ENCRYPTION_CIPHER is not set")
+// key must be 16, 24 or 32 bytes
+func NewAESCipher(key []byte) (*AESCipher, error) {
+	if _, err := aes.NewCipher(key); err != nil {
+		return nil, fmt.Errorf("invalid AES key: %w", err)
 	}
+	return &AESCipher{key: key}, nil
+}
 
-	_key, err := hex.DecodeString(keyString)
+// Takes the key hex encoded, as it is in AES_ENCRYPTION_CIPHER
+func NewAESCipherFromHex(hexKey string) (*AESCipher, error) {
+	if hexKey == "" {
+		return nil, fmt.Errorf("AES key is not set")
+	}
+	key, err := hex.DecodeString(hexKey)
 	if err != nil {
-		log.Fatalf("failed to decode key: %v", err)
+		return nil, fmt.Errorf("failed to decode key: %w", err)
 	}
-	key = _key
+	return NewAESCipher(key)
 }
 
-func Encrypt(data []byte) (string, error) {
-	block, err := aes.NewCipher(key)
+func (c *AESCipher) Encrypt(data []byte) (string, error) {
+	block, err := aes.NewCipher(c.key)
 	if err != nil {
 		return "", fmt.Errorf("failed to create cipher: %w", err)
 	}
@@ -49,7 +63,7 @@ func Encrypt(data []byte) (string, error) {
 	return base64.StdEncoding.EncodeToString(finalCiphertext), nil
 }
 
-func Decrypt(ciphertextBase64 string) (string, error) {
+func (c *AESCipher) Decrypt(ciphertextBase64 string) (string, error) {
 	ciphertext, err := base64.StdEncoding.DecodeString(ciphertextBase64)
 	if err != nil {
 		return "", fmt.Errorf("failed to decode base64 ciphertext: %w", err)
@@ -60,7 +74,7 @@ func Decrypt(ciphertextBase64 string) (string, error) {
 	}
 	nonce, ciphertext := ciphertext[:12], ciphertext[12:]
 
-	block, err := aes.NewCipher(key)
+	block, err := aes.NewCipher(c.key)
 	if err != nil {
 		return "", fmt.Errorf("failed to create cipher: %w", err)
 	}
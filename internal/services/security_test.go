@@ -5,15 +5,28 @@ import (
 	"testing"
 )
 
+func newTestCipher(t *testing.T) *AESCipher {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	c, err := NewAESCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
 func TestEncryptAndDecrypt(t *testing.T) {
+	c := newTestCipher(t)
 	plaintext := "This is a test message."
-	ciphertext, err := Encrypt([]byte(plaintext))
+	ciphertext, err := c.Encrypt([]byte(plaintext))
 	if err != nil {
 		t.Fatalf("encryption failed: %v", err)
 	}
 
 	// Decrypt the ciphertext
-	decrypted, err := Decrypt(ciphertext)
+	decrypted, err := c.Decrypt(ciphertext)
 	if err != nil {
 		t.Fatalf("decryption failed: %v", err)
 	}
@@ -25,30 +38,33 @@ func TestEncryptAndDecrypt(t *testing.T) {
 }
 
 func TestDecryptWithWrongKey(t *testing.T) {
-	// Generate two different 32-byte keys
-	key1 := make([]byte, 32)
-	key2 := make([]byte, 32)
-	if _, err := rand.Read(key1); err != nil {
-		t.Fatalf("failed to generate key1: %v", err)
-	}
-	if _, err := rand.Read(key2); err != nil {
-		t.Fatalf("failed to generate key2: %v", err)
-	}
+	// Two ciphers with different random keys
+	c1 := newTestCipher(t)
+	c2 := newTestCipher(t)
 
 	// Define plaintext
 	plaintext := "This is a test message."
 
 	// Encrypt with the first key
-	key = key1
-	ciphertext, err := Encrypt([]byte(plaintext))
+	ciphertext, err := c1.Encrypt([]byte(plaintext))
 	if err != nil {
 		t.Fatalf("encryption failed: %v", err)
 	}
 
 	// Try decrypting with the second (incorrect) key
-	key = key2
-	_, err = Decrypt(ciphertext)
+	_, err = c2.Decrypt(ciphertext)
 	if err == nil {
 		t.Errorf("expected decryption to fail with wrong key, but it succeeded")
 	}
 }
+
+func TestNewAESCipherFromHex(t *testing.T) {
+	if _, err := NewAESCipherFromHex("0e2a2eaee2c6135346e52c5836e78dc8a26ff5f03da3179c59bd4e5c118c6b23"); err != nil {
+		t.Errorf("Expected a 32 byte key to be accepted, got %v", err)
+	}
+	for _, key := range []string{"", "not hex", "0e2a2e"} {
+		if _, err := NewAESCipherFromHex(key); err == nil {
+			t.Errorf("Expected %q to be rejected", key)
+		}
+	}
+}
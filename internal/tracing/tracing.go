@@ -0,0 +1,76 @@
+// Package tracing sets up OpenTelemetry tracing. Trace context crosses process boundaries in W3C traceparent
+// headers, over HTTP and in the headers of the messages published to gateways.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"payment-gateway/internal/config"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Reads and writes the traceparent and tracestate headers
+var Propagator propagation.TextMapPropagator = propagation.TraceContext{}
+
+// Creates the tracer provider exporting spans as configured. The returned function flushes the spans still
+// buffered and stops the exporter, it is to be called on shutdown. With the none exporter nothing is recorded.
+func NewProvider(ctx context.Context, cfg config.TracingConfig) (trace.TracerProvider, func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	closeFile := func() error { return nil }
+	switch cfg.Exporter {
+	case "none":
+		return noop.NewTracerProvider(), func(context.Context) error { return nil }, nil
+	case "otlp":
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.OTLPEndpoint)}
+		if cfg.OTLPInsecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		e, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create the OTLP exporter: %v", err)
+		}
+		exporter = e
+	case "file":
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to open %s: %v", cfg.File, err)
+		}
+		e, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("failed to create the file exporter: %v", err)
+		}
+		exporter, closeFile = e, f.Close
+	default:
+		return nil, nil, fmt.Errorf("unknown trace exporter %q, expected none, otlp or file", cfg.Exporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		// traces started upstream keep the sampling decision made there
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(cfg.ServiceName))),
+	)
+	shutdown := func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closeErr := closeFile(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+	return provider, shutdown, nil
+}
+
+// Returns a context carrying only the span of parent, so work outliving the request stays in its trace
+func Detach(parent context.Context) context.Context {
+	return trace.ContextWithSpan(context.Background(), trace.SpanFromContext(parent))
+}
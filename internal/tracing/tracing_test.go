@@ -0,0 +1,48 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"payment-gateway/internal/config"
+	"strings"
+	"testing"
+)
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	provider, shutdown, err := NewProvider(context.Background(), config.TracingConfig{
+		Exporter: "file", File: path, SampleRatio: 1, ServiceName: "payment-gateway-test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := provider.Tracer("test").Start(context.Background(), "deposit")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), `"Name":"deposit"`) || !strings.Contains(string(out), "payment-gateway-test") {
+		t.Errorf("Expected the span in the file, got %s", out)
+	}
+}
+
+func TestNoneRecordsNothing(t *testing.T) {
+	provider, shutdown, err := NewProvider(context.Background(), config.TracingConfig{Exporter: "none"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown(context.Background())
+	if _, span := provider.Tracer("test").Start(context.Background(), "deposit"); span.IsRecording() {
+		t.Errorf("Expected no span to be recorded")
+	}
+
+	if _, _, err := NewProvider(context.Background(), config.TracingConfig{Exporter: "jaeger"}); err == nil {
+		t.Errorf("Expected an unknown exporter to be rejected")
+	}
+}